@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// blocklistFile persists the info hashes that were blocked at runtime via the admin endpoint, so that they're still blocked after a restart.
+// The file has the same format as the one for the "blockedInfoHashes" argument: One info hash per line.
+type blocklistFile struct {
+	path string
+	lock *sync.Mutex
+}
+
+func newBlocklistFile(path string) blocklistFile {
+	return blocklistFile{
+		path: path,
+		lock: &sync.Mutex{},
+	}
+}
+
+// load returns the persisted info hashes. A non-existing file is not an error.
+func (f blocklistFile) load() ([]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, err := os.Stat(f.path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return parseInfoHashes(f.path)
+}
+
+// add appends the info hash to the file.
+func (f blocklistFile) add(infoHash string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Couldn't open blocklist file: %v", err)
+	}
+	if _, err = file.WriteString(strings.ToUpper(infoHash) + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("Couldn't write to blocklist file: %v", err)
+	}
+	return file.Close()
+}
@@ -2,34 +2,116 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/doingodswork/deflix-stremio/pkg/cinemata"
+	"github.com/doingodswork/deflix-stremio/pkg/imdb2torrent"
 )
 
+// weightMap maps torrent site names to their trust weight.
+type weightMap map[string]float64
+
+// countMap maps torrent site names to a number, for example of results.
+type countMap map[string]int
+
 type config struct {
-	BindAddr          string        `json:"bindAddr"`
-	Port              int           `json:"port"`
-	StreamURLaddr     string        `json:"streamURLaddr"`
-	CachePath         string        `json:"cachePath"`
-	CacheMaxMB        int           `json:"cacheMaxMB"`
-	CacheAgeRD        time.Duration `json:"cacheAgeRD"`
-	CacheAgeTorrents  time.Duration `json:"cacheAgeTorrents"`
-	BaseURLyts        string        `json:"baseURLyts"`
-	BaseURLtpb        string        `json:"baseURLtpb"`
-	BaseURL1337x      string        `json:"baseURL1337x"`
-	BaseURLibit       string        `json:"baseURLibit"`
-	BaseURLrd         string        `json:"baseURLrd"`
-	LogLevel          string        `json:"logLevel"`
-	RootURL           string        `json:"rootURL"`
-	TPBretries        int           `json:"tpbRetries"`
-	ExtraHeadersRD    []string      `json:"extraHeadersRD"`
-	SocksProxyAddrTPB string        `json:"socksProxyAddrTPB"`
-	EnvPrefix         string        `json:"envPrefix"`
+	BindAddr               string        `json:"bindAddr"`
+	Port                   int           `json:"port"`
+	QualityPreference      []string      `json:"qualityPreference"`
+	RemuxPreference        string        `json:"remuxPreference"`
+	ExcludeQualities       []string      `json:"excludeQualities"`
+	ExcludeSources         []string      `json:"excludeSources"`
+	EstimateBitrate        bool          `json:"estimateBitrate"`
+	StreamURLaddr          string        `json:"streamURLaddr"`
+	CachePath              string        `json:"cachePath"`
+	CacheMaxMB             int           `json:"cacheMaxMB"`
+	CacheAgeRD             time.Duration `json:"cacheAgeRD"`
+	CacheAgeUnavailableRD  time.Duration `json:"cacheAgeUnavailableRD"`
+	CacheAgeTorrents       time.Duration `json:"cacheAgeTorrents"`
+	CacheAgeJitterTorrents time.Duration `json:"cacheAgeJitterTorrents"`
+	RefreshWindowTorrents  time.Duration `json:"refreshWindowTorrents"`
+	MinCachedTorrents      countMap      `json:"minCachedTorrents"`
+	CacheAgeCinemata       time.Duration `json:"cacheAgeCinemata"`
+	ConcurrencyCinemata    int           `json:"concurrencyCinemata"`
+	BaseURLyts             string        `json:"baseURLyts"`
+	BaseURLtpb             string        `json:"baseURLtpb"`
+	BaseURL1337x           string        `json:"baseURL1337x"`
+	BaseURLibit            string        `json:"baseURLibit"`
+	BaseURLrd              string        `json:"baseURLrd"`
+	BaseURLsolidTorrents   string        `json:"baseURLsolidTorrents"`
+	CheckSitesOnStartup    bool          `json:"checkSitesOnStartup"`
+	LogLevel               string        `json:"logLevel"`
+	MagnetsOnly            bool          `json:"magnetsOnly"`
+	AdminToken             string        `json:"-"` // Secret, so it's not logged
+	MaxIdleConnsPerHost    int           `json:"maxIdleConnsPerHost"`
+	RootURL                string        `json:"rootURL"`
+	RootMode               string        `json:"rootMode"`
+	RootMessage            string        `json:"rootMessage"`
+	DebugScrape            bool          `json:"debugScrape"`
+	DebugToken             string        `json:"-"` // Secret, so it's not logged
+	TPBretries             int           `json:"tpbRetries"`
+	RetryEmptyTPB          bool          `json:"retryEmptyTPB"`
+	RetryEmpty1337x        bool          `json:"retryEmpty1337x"`
+	RetryEmptyIbit         bool          `json:"retryEmptyIbit"`
+	TitleMatching          string        `json:"titleMatching"`
+	IncludeAllYears        bool          `json:"includeAllYears"`
+	DNSretries             int           `json:"dnsRetries"`
+	RateLimitYTS           float64       `json:"rateLimitYTS"`
+	RateLimitTPB           float64       `json:"rateLimitTPB"`
+	RateLimit1337x         float64       `json:"rateLimit1337x"`
+	Concurrency1337x       int           `json:"concurrency1337x"`
+	MaxResults1337x        int           `json:"maxResults1337x"`
+	RateLimitIbit          float64       `json:"rateLimitIbit"`
+	RateLimitSolidTorrents float64       `json:"rateLimitSolidTorrents"`
+	ScrapeBudget           int           `json:"scrapeBudget"`
+	WarmOnStartup          bool          `json:"warmOnStartup"`
+	SiteWeights            weightMap     `json:"siteWeights"`
+	CollapseTorrentsYTS    bool          `json:"collapseTorrentsYTS"`
+	MovieDetailsYTS        bool          `json:"movieDetailsYTS"`
+	DisableKeepAlives      bool          `json:"disableKeepAlives"`
+	CompressCache          bool          `json:"compressCache"`
+	MergeTrackers          bool          `json:"mergeTrackers"`
+	MaxTrackers            int           `json:"maxTrackers"`
+	AllowedTrackerHosts    []string      `json:"allowedTrackerHosts"`
+	ExcludeCam             bool          `json:"excludeCam"`
+	MinSize                int64         `json:"minSize"`
+	MaxSize                int64         `json:"maxSize"`
+	DropUnknownSize        bool          `json:"dropUnknownSize"`
+	IncludeGroups          []string      `json:"includeGroups"`
+	ExcludeGroups          []string      `json:"excludeGroups"`
+	DropUnknownGroup       bool          `json:"dropUnknownGroup"`
+	KeepUnknownQuality     bool          `json:"keepUnknownQuality"`
+	CoalesceSearches       bool          `json:"coalesceSearches"`
+	FuzzyDedup             bool          `json:"fuzzyDedup"`
+	SlowScrapeThreshold    time.Duration `json:"slowScrapeThreshold"`
+	SiteDeadline           time.Duration `json:"siteDeadline"`
+	DegradedErrRate        float64       `json:"degradedErrRate"`
+	ErrorHistorySize       int           `json:"errorHistorySize"`
+	MaxDurationIbit        time.Duration `json:"maxDurationIbit"`
+	SyncIbit               bool          `json:"syncIbit"`
+	ParallelIbitMirrors    bool          `json:"parallelIbitMirrors"`
+	ExtraHeadersRD         []string      `json:"extraHeadersRD"`
+	BlockedInfoHashes      []string      `json:"blockedInfoHashes"`
+	IdleConnTimeout        time.Duration `json:"idleConnTimeout"`
+	SocksProxyAddrTPB      string        `json:"socksProxyAddrTPB"`
+	SocksProxyUserTPB      string        `json:"-"` // From the address or an environment variable, not logged
+	SocksProxyPasswordTPB  string        `json:"-"` // From the address or an environment variable, not logged
+	EnvPrefix              string        `json:"envPrefix"`
+	ConfigFile             string        `json:"configFile"`
 }
 
 func parseConfig(ctx context.Context) config {
@@ -37,26 +119,89 @@ func parseConfig(ctx context.Context) config {
 
 	// Flags
 	var (
-		bindAddr      = flag.String("bindAddr", "localhost", `Local interface address to bind to. "localhost" only allows access from the local host. "0.0.0.0" binds to all network interfaces.`)
-		port          = flag.Int("port", 8080, "Port to listen on")
-		streamURLaddr = flag.String("streamURLaddr", "http://localhost:8080", "Address to be used in a stream URL that's delivered to Stremio and later used to redirect to RealDebrid")
-		cachePath     = flag.String("cachePath", "", "Path for loading a persisted cache on startup and persisting the current cache in regular intervals. An empty value will lead to 'os.UserCacheDir()+\"/deflix-stremio/\"'.")
+		bindAddr          = flag.String("bindAddr", "localhost", `Local interface address to bind to. "localhost" only allows access from the local host. "0.0.0.0" binds to all network interfaces.`)
+		port              = flag.Int("port", 8080, "Port to listen on")
+		qualityPreference = flag.String("qualityPreference", "2160p,1080p,720p", "Qualities from most to least preferred, separated by comma. Streams are listed in this order. Supported qualities are 720p, 1080p, 1080p 10bit, 2160p and 2160p 10bit, and unknown if keepUnknownQuality is set. A 10bit quality that's not listed is ranked like its resolution.")
+		remuxPreference   = flag.String("remuxPreference", "none", "How remux releases (untouched video of the source, but much bigger than encodes) are sorted among streams of the same quality. Can be \"none\" (sorted like other releases), \"prefer\" (listed first) or \"avoid\" (listed last).")
+		excludeQualities  = flag.String("excludeQualities", "", "Qualities to remove from the search results, separated by comma, like \"2160p\" to save bandwidth. A resolution also removes its 10bit quality. Supported qualities are 720p, 1080p, 1080p 10bit, 2160p and 2160p 10bit. Applied in addition to excludeCam and independent of qualityPreference, which only affects the order.")
+		excludeSources    = flag.String("excludeSources", "", "Release sources to remove from the search results, separated by comma, like \"webrip\" to avoid re-encoded web releases. Supported sources are web-dl, webrip, web, bluray, bdrip and hdtv. Results with unknown source are kept. YTS only reports web and bluray.")
+		estimateBitrate   = flag.Bool("estimateBitrate", false, "Estimate the average bitrate of torrents from their size and the movie's runtime, which is requested from Cinemata. A bitrate that's much lower than usual for the quality can indicate a fake.")
+		streamURLaddr     = flag.String("streamURLaddr", "http://localhost:8080", "Address to be used in a stream URL that's delivered to Stremio and later used to redirect to RealDebrid")
+		cachePath         = flag.String("cachePath", "", "Path for loading a persisted cache on startup and persisting the current cache in regular intervals. An empty value will lead to 'os.UserCacheDir()+\"/deflix-stremio/\"'.")
 		// We split this number into 5 equal sized caches à 32 MB.
 		// Note: fastcache uses 32 MB as minimum, that's why we use `5*32 MB = 160 MB` as minimum.
-		cacheMaxMB        = flag.Int("cacheMaxMB", 160, "Max number of megabytes to be used for the in-memory cache. Default (and minimum!) is 160 MB.")
-		cacheAgeRD        = flag.Duration("cacheAgeRD", 24*time.Hour, "Max age of cache entries for instant availability responses from RealDebrid. The format must be acceptable by Go's 'time.ParseDuration()', for example \"24h\".")
-		cacheAgeTorrents  = flag.Duration("cacheAgeTorrents", 24*time.Hour, "Max age of cache entries for torrents found per IMDb ID. The format must be acceptable by Go's 'time.ParseDuration()', for example \"24h\".")
-		baseURLyts        = flag.String("baseURLyts", "https://yts.mx", "Base URL for YTS")
-		baseURLtpb        = flag.String("baseURLtpb", "https://thepiratebay.org", "Base URL for TPB")
-		baseURL1337x      = flag.String("baseURL1337x", "https://1337x.to", "Base URL for 1337x")
-		baseURLibit       = flag.String("baseURLibit", "https://ibit.am", "Base URL for ibit")
-		baseURLrd         = flag.String("baseURLrd", "https://api.real-debrid.com", "Base URL for RealDebrid")
-		logLevel          = flag.String("logLevel", "debug", `Log level to show only logs with the given and more severe levels. Can be "trace", "debug", "info", "warn", "error", "fatal", "panic".`)
-		rootURL           = flag.String("rootURL", "https://www.deflix.tv", "Redirect target for the root")
-		tpbRetries        = flag.Int("tpbRetries", 0, "Number of retries in case TPB times out. Each retry will be done after the previous connection is closed.")
-		extraHeadersRD    = flag.String("extraHeadersRD", "", "Additional HTTP request headers to set for requests to RealDebrid, in a format like \"X-Foo: bar\", separated by newline characters (\"\\n\")")
-		socksProxyAddrTPB = flag.String("socksProxyAddrTPB", "", "SOCKS5 proxy address for accessing TPB, required for accessing TPB via the TOR network (where \"127.0.0.1:9050\" would be typical value)")
-		envPrefix         = flag.String("envPrefix", "", "Prefix for environment variables")
+		cacheMaxMB             = flag.Int("cacheMaxMB", 160, "Max number of megabytes to be used for the in-memory cache. Default (and minimum!) is 160 MB.")
+		cacheAgeRD             = flag.Duration("cacheAgeRD", 24*time.Hour, "Max age of cache entries for instant availability responses from RealDebrid. The format must be acceptable by Go's 'time.ParseDuration()', for example \"24h\".")
+		cacheAgeUnavailableRD  = flag.Duration("cacheAgeUnavailableRD", 5*time.Minute, "Max age of cache entries for torrents that RealDebrid reported as not instantly available. Should be short, because RealDebrid can cache a torrent at any time. A torrent that's streamed via RealDebrid is removed from this cache. 0 disables caching unavailable torrents. The format must be acceptable by Go's 'time.ParseDuration()'.")
+		cacheAgeTorrents       = flag.Duration("cacheAgeTorrents", 24*time.Hour, "Max age of cache entries for torrents found per IMDb ID. The format must be acceptable by Go's 'time.ParseDuration()', for example \"24h\".")
+		cacheAgeJitterTorrents = flag.Duration("cacheAgeJitterTorrents", 0, "Max random deviation from cacheAgeTorrents per cache entry, to avoid many entries expiring at the same time. For example \"1h\" leads to a max age of 23h-25h with the default cacheAgeTorrents. The format must be acceptable by Go's 'time.ParseDuration()'.")
+		refreshWindowTorrents  = flag.Duration("refreshWindowTorrents", 0, "Cached torrents that expire within this duration are still returned, but the torrent site is searched again in the background to refresh the cache entry. This hides the search latency for popular movies. 0 disables the refresh. The format must be acceptable by Go's 'time.ParseDuration()', for example \"1h\".")
+		minCachedTorrents      = flag.String("minCachedTorrents", "", "Min number of cached torrents per torrent site. Cached torrents of a site with fewer results are still returned, but the site is searched again in the background to refresh the cache entry, like with refreshWindowTorrents. Comma separated list of site=count pairs, for example \"YTS=2,1337x=3\". The sites are the ones of the baseURL options. Sites without count never refresh their cache entries because of the number of results.")
+		cacheAgeCinemata       = flag.Duration("cacheAgeCinemata", cinemata.DefaultCacheAge, "Max age of cache entries for movie names and years from Cinemata, which the torrent sites that are searched by title require. Movie names rarely change, so it can be much longer than cacheAgeTorrents. The format must be acceptable by Go's 'time.ParseDuration()', for example \"720h\".")
+		concurrencyCinemata    = flag.Int("concurrencyCinemata", 0, "Max number of concurrent requests to Cinemata, which 1337x and SolidTorrents need to turn IMDb IDs into movie titles. Further requests wait, so that a burst of searches, for example by warmOnStartup, doesn't overwhelm Cinemata. Cached movie titles don't count. 0 means no limit.")
+		baseURLyts             = flag.String("baseURLyts", "https://yts.mx", "Base URL for YTS. Multiple mirrors can be separated by comma, they're tried in order when a request fails. Empty to disable the site.")
+		baseURLtpb             = flag.String("baseURLtpb", "https://thepiratebay.org", "Base URL for TPB. Multiple mirrors can be separated by comma, they're tried in order when a request fails. Empty to disable the site.")
+		baseURL1337x           = flag.String("baseURL1337x", "https://1337x.to", "Base URL for 1337x. Multiple mirrors can be separated by comma, they're tried in order when a request fails. Empty to disable the site.")
+		baseURLibit            = flag.String("baseURLibit", "https://ibit.am", "Base URL for ibit. Multiple mirrors can be separated by comma, they're tried in order when a request fails. Empty to disable the site.")
+		baseURLrd              = flag.String("baseURLrd", "https://api.real-debrid.com", "Base URL for RealDebrid")
+		baseURLsolidTorrents   = flag.String("baseURLsolidTorrents", "https://solidtorrents.net", "Base URL for Solid Torrents. Multiple mirrors can be separated by comma, they're tried in order when a request fails. Empty to disable the site.")
+		checkSitesOnStartup    = flag.Bool("checkSitesOnStartup", false, "Send a request to each enabled torrent site on startup and exit if none of them can be reached, for example because all base URLs are wrong. Independent of this, the server exits if all torrent sites are disabled.")
+		logLevel               = flag.String("logLevel", "debug", `Log level to show only logs with the given and more severe levels. Can be "trace", "debug", "info", "warn", "error", "fatal", "panic".`)
+		magnetsOnly            = flag.Bool("magnetsOnly", false, "Respond with the magnet URLs of the found torrents instead of RealDebrid streams, for users who copy them manually or use a different player. The Stremio endpoints then don't require a RealDebrid API token, so the addon URL is for example \"/manifest.json\" instead of \"/{apitoken}/manifest.json\".")
+		adminToken             = flag.String("adminToken", "", "Token for the admin endpoints like \"POST /admin/block\", which must be sent in the \"Authorization\" header as \"Bearer <token>\". The admin endpoints are disabled if empty.")
+		maxIdleConnsPerHost    = flag.Int("maxIdleConnsPerHost", http.DefaultMaxIdleConnsPerHost, "Max number of idle (keep-alive) connections to keep per torrent site host. Higher values help when sending many requests to the same torrent site, like to ibit.")
+		rootURL                = flag.String("rootURL", "https://www.deflix.tv", "Redirect target for the root path when rootMode is \"redirect\"")
+		rootMode               = flag.String("rootMode", rootModeRedirect, "What the root path responds with. Can be \"redirect\" (redirect to rootURL), \"message\" (show rootMessage as plain text) or \"404\".")
+		rootMessage            = flag.String("rootMessage", "This is a Stremio addon. Add it to Stremio to use it.", "Plain text that the root path responds with when rootMode is \"message\"")
+		debugScrape            = flag.Bool("debugScrape", false, "Enables the \"GET /debug/scrape?imdbID=tt123\" endpoint, which searches all torrent sites and responds with the raw results, error and duration of each site as JSON. Meant for debugging, because each request causes a full scrape. Also see debugToken.")
+		debugToken             = flag.String("debugToken", "", "Token for the debug endpoints like \"GET /debug/scrape\", which must then be sent in the \"Authorization\" header as \"Bearer <token>\". The debug endpoints don't require a token if empty.")
+		tpbRetries             = flag.Int("tpbRetries", 0, "Number of retries in case TPB times out. Each retry will be done after the previous connection is closed.")
+		retryEmptyTPB          = flag.Bool("retryEmptyTPB", false, "Also retry the TPB search when it returns no torrents, up to tpbRetries times, because TPB sometimes responds with an empty page under load. If any attempt succeeds, its results are returned even if a later attempt fails.")
+		retryEmpty1337x        = flag.Bool("retryEmpty1337x", false, "Search 1337x a second time when it returns no torrents, but its response looks like a results page, for example a partially rendered movie page with an empty torrent table or torrent pages that all failed. Pages of movies without torrents aren't retried.")
+		retryEmptyIbit         = flag.Bool("retryEmptyIbit", false, "Search ibit a second time when it returns no torrents, but its response looks like a results page, for example a partially rendered page with an empty torrent table. Pages of movies without torrents aren't retried.")
+		titleMatching          = flag.String("titleMatching", "normalized", "How strictly the torrent titles of torrent sites that are searched by movie title (1337x and Solid Torrents) must match the movie title. Can be \"exact\" (only the separators between words can differ), \"normalized\" (same words, ignoring case and punctuation) or \"contains\" (contains the words, which leads to wrong matches for short titles like \"It\").")
+		includeAllYears        = flag.Bool("includeAllYears", false, "Include the movies of all years with a matching title on torrent sites that are searched by title (1337x and Solid Torrents), instead of only the movie of the requested year. For IMDb IDs that correspond to multiple releases, like re-releases. The search results are less likely to belong to the requested movie.")
+		dnsRetries             = flag.Int("dnsRetries", 0, "Number of retries per torrent site mirror when its host name can't be resolved, which is often only temporary with proxies or in containers. The retries are done with an exponential backoff, starting with 100ms.")
+		rateLimitYTS           = flag.Float64("rateLimitYTS", 0, "Max number of requests per second to YTS. 0 means no limit.")
+		rateLimitTPB           = flag.Float64("rateLimitTPB", 0, "Max number of requests per second to TPB. 0 means no limit.")
+		rateLimit1337x         = flag.Float64("rateLimit1337x", 0, "Max number of requests per second to 1337x. 0 means no limit.")
+		concurrency1337x       = flag.Int("concurrency1337x", 0, "Max number of torrent pages of a movie that are requested from 1337x at the same time. 0 means no limit. Other torrent sites only need one request per search, except for ibit, whose pages are always requested one after another per mirror.")
+		maxResults1337x        = flag.Int("maxResults1337x", 0, "Max number of results of a movie from 1337x. The movie's torrents are looked at in the order of their seeders, so this saves the torrent page requests of the least seeded torrents. 0 means no limit.")
+		rateLimitIbit          = flag.Float64("rateLimitIbit", 6, "Max number of requests per second to each ibit mirror. 0 means no limit. ibit responds with \"429 Too Many Requests\" to some requests when sending 10 requests per second.")
+		rateLimitSolidTorrents = flag.Float64("rateLimitSolidTorrents", 0, "Max number of requests per second to Solid Torrents. 0 means no limit.")
+		scrapeBudget           = flag.Int("scrapeBudget", 0, "Max number of requests per minute to all torrent sites together, in addition to the rate limits of the single sites, to stay below the abuse thresholds of the sites with a shared IP address. Requests wait when the budget is exhausted. How often they had to wait is logged with the hourly stats. 0 means no limit.")
+		warmOnStartup          = flag.Bool("warmOnStartup", false, "Refresh the cached torrents of previously searched movies that are expired or expire within refreshWindowTorrents in the background after startup, so that the first request for them after a restart is fast. The searched IMDb IDs are stored in the cache directory while this is enabled. The refreshes are spread out by the rate limits and scrapeBudget.")
+		siteWeights            = flag.String("siteWeights", "", "Trust weights of torrent sites for ranking results with the same quality and seeders, higher weights first. Comma separated list of site=weight pairs, for example \"YTS=2,1337x=0.5\". The sites are the ones of the baseURL options, the pseudo site \"curated\" is for seeded results. Sites without weight have a weight of 1.")
+		collapseTorrentsYTS    = flag.Bool("collapseTorrentsYTS", false, "Only keep the best torrent per quality and bit depth that YTS returns for a movie, instead of for example both the bluray and web rip for 1080p. Bluray is preferred over web, then the number of seeders is compared.")
+		movieDetailsYTS        = flag.Bool("movieDetailsYTS", false, "Use the movie details endpoint of the YTS API when its search endpoint fails or doesn't return any torrents for an IMDb ID.")
+		disableKeepAlives      = flag.Bool("disableKeepAlives", false, "Disable HTTP keep-alives for requests to torrent sites, so that each request uses a new connection.")
+		compressCache          = flag.Bool("compressCache", false, "Compress cache entries of torrent results with gzip. This costs CPU time, but reduces the memory usage and keeps big entries below fastcache's limit of 64 KB per entry.")
+		mergeTrackers          = flag.Bool("mergeTrackers", false, "Combine the trackers of torrents that were found on multiple torrent sites into a single magnet URL. Without this only the magnet URL with the most trackers is used.")
+		maxTrackers            = flag.Int("maxTrackers", 20, "Max number of trackers of a magnet URL when trackers are added to it, for example when merging the trackers of duplicate torrents or creating a magnet URL for a torrent that only has an info hash. A magnet URL's own trackers are always kept. 0 means no limit.")
+		allowedTrackerHosts    = flag.String("allowedTrackerHosts", "", "Hosts of the trackers that magnet URLs may contain, separated by comma, like \"opentrackr.org,openbittorrent.com\". Subdomains of the hosts are allowed as well. All other trackers are removed from the magnet URLs, including the ones added by mergeTrackers. Empty means all trackers are allowed.")
+		excludeCam             = flag.Bool("excludeCam", false, "Exclude cam and telesync releases, which are recorded in a movie theater and have a bad quality.")
+		minSize                = flag.String("minSize", "", "Min size of torrents, like \"300MB\". Smaller torrents are removed from the search results. Empty means no limit. Torrents with unknown size are kept, unless dropUnknownSize is set.")
+		maxSize                = flag.String("maxSize", "", "Max size of torrents, like \"30GB\". Bigger torrents are removed from the search results. Empty means no limit. Torrents with unknown size are kept, unless dropUnknownSize is set.")
+		dropUnknownSize        = flag.Bool("dropUnknownSize", false, "Remove torrents with unknown size from the search results when minSize or maxSize is set.")
+		includeGroups          = flag.String("includeGroups", "", "Release groups to keep in the search results, separated by comma, like \"YIFY,SPARKS\". Groups are compared case-insensitively. Empty means all groups are kept. Torrents with unknown group are kept, unless dropUnknownGroup is set.")
+		excludeGroups          = flag.String("excludeGroups", "", "Release groups to remove from the search results, separated by comma. Groups are compared case-insensitively. Takes precedence over includeGroups.")
+		dropUnknownGroup       = flag.Bool("dropUnknownGroup", false, "Remove torrents whose release group couldn't be determined from the search results.")
+		keepUnknownQuality     = flag.Bool("keepUnknownQuality", false, "Keep torrents whose name doesn't contain a resolution instead of dropping them. They're offered as separate stream with the quality \"unknown\", after all other qualities. Torrents with a resolution below 720p are always dropped.")
+		coalesceSearches       = flag.Bool("coalesceSearches", true, "Let concurrent requests for the same movie share a single torrent search, instead of each request searching all torrent sites. This also applies to the search on a single torrent site, for example when a cache refresh and a request for the same movie overlap. The shared search isn't aborted when the request that started it is canceled.")
+		fuzzyDedup             = flag.Bool("fuzzyDedup", false, "Collapse torrents that are probably re-uploads of the same release with a different info_hash, detected by their title, quality and a size that differs by less than about 2%. Only the torrent with the most seeders is kept. This is a heuristic, so it can collapse torrents that are actually different.")
+		slowScrapeThreshold    = flag.Duration("slowScrapeThreshold", 0, "Log a warning when searching torrents on a single torrent site takes longer than this, for example \"3s\". 0 disables the logging. The format must be acceptable by Go's 'time.ParseDuration()'.")
+		siteDeadline           = flag.Duration("siteDeadline", 30*time.Second, "Max duration of a search on a single torrent site. A search that takes longer, for example because it hangs in parsing unexpected HTML, is treated as timed out and abandoned, so that it can't block the request. Must be longer than the timeout of a single request, because a search can consist of multiple requests. 0 disables the deadline. The format must be acceptable by Go's 'time.ParseDuration()'.")
+		degradedErrRate        = flag.Float64("degradedErrRate", 0, "Log an error when the share of failed searches among the last 20 searches on a torrent site reaches this value, for example 0.5, which can indicate that the site changed its HTML or blocks us. The error is logged again after the error rate dropped below the value in the meantime. 0 disables the check.")
+		errorHistorySize       = flag.Int("errorHistorySize", 10, "Number of most recent errors that are kept per torrent site, with their time and request URL, to diagnose intermittent failures. They're part of the debug scrape response, see debugScrape. 0 disables the history.")
+		maxDurationIbit        = flag.Duration("maxDurationIbit", time.Minute, "Max duration of a search on ibit. Searches on ibit continue in the background after 1 second to fill the cache, but they block other searches on ibit due to its rate limiting. When the max duration is exceeded the search is aborted. The format must be acceptable by Go's 'time.ParseDuration()'.")
+		syncIbit               = flag.Bool("syncIbit", false, "Wait for the search on ibit like for the other torrent sites, instead of letting it continue in the background after 1 second. Only useful with a fast ibit mirror. The search is still aborted after maxDurationIbit.")
+		parallelIbitMirrors    = flag.Bool("parallelIbitMirrors", false, "Distribute the ibit torrent page requests across all configured ibit mirrors round-robin, with the rate limit applying to each mirror separately. Only useful with multiple ibit mirrors in baseURLibit.")
+		extraHeadersRD         = flag.String("extraHeadersRD", "", "Additional HTTP request headers to set for requests to RealDebrid, in a format like \"X-Foo: bar\", separated by newline characters (\"\\n\")")
+		idleConnTimeout        = flag.Duration("idleConnTimeout", 90*time.Second, "Max amount of time an idle (keep-alive) connection to a torrent site stays open. 0 means no limit. The format must be acceptable by Go's 'time.ParseDuration()'.")
+		blockedInfoHashes      = flag.String("blockedInfoHashes", "", "Info hashes of torrents to remove from all search results, separated by comma. Can also be the path to a file with one info hash per line, where lines starting with \"#\" are ignored.")
+		socksProxyAddrTPB      = flag.String("socksProxyAddrTPB", "", "SOCKS5 proxy address for accessing TPB, required for accessing TPB via the TOR network (where \"127.0.0.1:9050\" would be typical value). For proxies that require authentication the address can contain the credentials, like \"user:pass@127.0.0.1:1080\". Alternatively they can be set via the environment variables SOCKS_PROXY_USER_TPB and SOCKS_PROXY_PASSWORD_TPB, which take precedence.")
+		envPrefix              = flag.String("envPrefix", "", "Prefix for environment variables")
+		configFile             = flag.String("configFile", "", "Path to a YAML (\".yaml\" or \".yml\") or TOML (\".toml\") file with settings. The keys are the names of the command line arguments, for example \"baseURL1337x\". Command line arguments and environment variables take precedence over the file.")
 	)
 
 	flag.Parse()
@@ -66,6 +211,21 @@ func parseConfig(ctx context.Context) config {
 	}
 	result.EnvPrefix = *envPrefix
 
+	if !isArgSet(ctx, "configFile") {
+		if val, ok := os.LookupEnv(*envPrefix + "CONFIG_FILE"); ok {
+			*configFile = val
+		}
+	}
+	result.ConfigFile = *configFile
+
+	// Overwrite the default values by the ones from the config file, but only for the arguments that have not been set.
+	// The env vars are looked up afterwards, so they take precedence over the config file.
+	if *configFile != "" {
+		if err := applyConfigFile(ctx, *configFile); err != nil {
+			log.WithError(err).WithField("configFile", *configFile).Fatal("Couldn't apply config file")
+		}
+	}
+
 	// Only overwrite the values by their env var counterparts that have not been set (and that *are* set via env var).
 	var err error
 	if !isArgSet(ctx, "bindAddr") {
@@ -84,6 +244,55 @@ func parseConfig(ctx context.Context) config {
 	}
 	result.Port = *port
 
+	if !isArgSet(ctx, "qualityPreference") {
+		if val, ok := os.LookupEnv(*envPrefix + "QUALITY_PREFERENCE"); ok {
+			*qualityPreference = val
+		}
+	}
+	for _, quality := range strings.Split(*qualityPreference, ",") {
+		if quality = strings.TrimSpace(quality); quality != "" {
+			result.QualityPreference = append(result.QualityPreference, quality)
+		}
+	}
+
+	if !isArgSet(ctx, "remuxPreference") {
+		if val, ok := os.LookupEnv(*envPrefix + "REMUX_PREFERENCE"); ok {
+			*remuxPreference = val
+		}
+	}
+	result.RemuxPreference = *remuxPreference
+
+	if !isArgSet(ctx, "excludeQualities") {
+		if val, ok := os.LookupEnv(*envPrefix + "EXCLUDE_QUALITIES"); ok {
+			*excludeQualities = val
+		}
+	}
+	for _, quality := range strings.Split(*excludeQualities, ",") {
+		if quality = strings.TrimSpace(quality); quality != "" {
+			result.ExcludeQualities = append(result.ExcludeQualities, quality)
+		}
+	}
+
+	if !isArgSet(ctx, "excludeSources") {
+		if val, ok := os.LookupEnv(*envPrefix + "EXCLUDE_SOURCES"); ok {
+			*excludeSources = val
+		}
+	}
+	for _, source := range strings.Split(*excludeSources, ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			result.ExcludeSources = append(result.ExcludeSources, strings.ToLower(source))
+		}
+	}
+
+	if !isArgSet(ctx, "estimateBitrate") {
+		if val, ok := os.LookupEnv(*envPrefix + "ESTIMATE_BITRATE"); ok {
+			if *estimateBitrate, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "ESTIMATE_BITRATE").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.EstimateBitrate = *estimateBitrate
+
 	if !isArgSet(ctx, "streamURLaddr") {
 		if val, ok := os.LookupEnv(*envPrefix + "STREAM_URL_ADDR"); ok {
 			*streamURLaddr = val
@@ -116,6 +325,15 @@ func parseConfig(ctx context.Context) config {
 	}
 	result.CacheAgeRD = *cacheAgeRD
 
+	if !isArgSet(ctx, "cacheAgeUnavailableRD") {
+		if val, ok := os.LookupEnv(*envPrefix + "CACHE_AGE_UNAVAILABLE_RD"); ok {
+			if *cacheAgeUnavailableRD, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "CACHE_AGE_UNAVAILABLE_RD").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.CacheAgeUnavailableRD = *cacheAgeUnavailableRD
+
 	if !isArgSet(ctx, "cacheAgeTorrents") {
 		if val, ok := os.LookupEnv(*envPrefix + "CACHE_AGE_TORRENTS"); ok {
 			if *cacheAgeTorrents, err = time.ParseDuration(val); err != nil {
@@ -125,40 +343,113 @@ func parseConfig(ctx context.Context) config {
 	}
 	result.CacheAgeTorrents = *cacheAgeTorrents
 
+	if !isArgSet(ctx, "cacheAgeJitterTorrents") {
+		if val, ok := os.LookupEnv(*envPrefix + "CACHE_AGE_JITTER_TORRENTS"); ok {
+			if *cacheAgeJitterTorrents, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "CACHE_AGE_JITTER_TORRENTS").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.CacheAgeJitterTorrents = *cacheAgeJitterTorrents
+
+	if !isArgSet(ctx, "refreshWindowTorrents") {
+		if val, ok := os.LookupEnv(*envPrefix + "REFRESH_WINDOW_TORRENTS"); ok {
+			if *refreshWindowTorrents, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "REFRESH_WINDOW_TORRENTS").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.RefreshWindowTorrents = *refreshWindowTorrents
+
+	if !isArgSet(ctx, "minCachedTorrents") {
+		if val, ok := os.LookupEnv(*envPrefix + "MIN_CACHED_TORRENTS"); ok {
+			*minCachedTorrents = val
+		}
+	}
+	if result.MinCachedTorrents, err = parseSiteCounts(*minCachedTorrents); err != nil {
+		log.WithError(err).WithField("minCachedTorrents", *minCachedTorrents).Fatal("Couldn't parse min numbers of cached torrents")
+	}
+
+	if !isArgSet(ctx, "cacheAgeCinemata") {
+		if val, ok := os.LookupEnv(*envPrefix + "CACHE_AGE_CINEMATA"); ok {
+			if *cacheAgeCinemata, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "CACHE_AGE_CINEMATA").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.CacheAgeCinemata = *cacheAgeCinemata
+
+	if !isArgSet(ctx, "concurrencyCinemata") {
+		if val, ok := os.LookupEnv(*envPrefix + "CONCURRENCY_CINEMATA"); ok {
+			if *concurrencyCinemata, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "CONCURRENCY_CINEMATA").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.ConcurrencyCinemata = *concurrencyCinemata
+
 	if !isArgSet(ctx, "baseURLyts") {
 		if val, ok := os.LookupEnv(*envPrefix + "BASE_URL_YTS"); ok {
 			*baseURLyts = val
 		}
 	}
-	result.BaseURLyts = *baseURLyts
+	if result.BaseURLyts, err = normalizeBaseURLs(*baseURLyts); err != nil {
+		log.WithError(err).WithField("baseURLyts", *baseURLyts).Fatal("Invalid base URL")
+	}
 
 	if !isArgSet(ctx, "baseURLtpb") {
 		if val, ok := os.LookupEnv(*envPrefix + "BASE_URL_TPB"); ok {
 			*baseURLtpb = val
 		}
 	}
-	result.BaseURLtpb = *baseURLtpb
+	if result.BaseURLtpb, err = normalizeBaseURLs(*baseURLtpb); err != nil {
+		log.WithError(err).WithField("baseURLtpb", *baseURLtpb).Fatal("Invalid base URL")
+	}
 
 	if !isArgSet(ctx, "baseURL1337x") {
 		if val, ok := os.LookupEnv(*envPrefix + "BASE_URL_1337X"); ok {
 			*baseURL1337x = val
 		}
 	}
-	result.BaseURL1337x = *baseURL1337x
+	if result.BaseURL1337x, err = normalizeBaseURLs(*baseURL1337x); err != nil {
+		log.WithError(err).WithField("baseURL1337x", *baseURL1337x).Fatal("Invalid base URL")
+	}
 
 	if !isArgSet(ctx, "baseURLibit") {
 		if val, ok := os.LookupEnv(*envPrefix + "BASE_URL_IBIT"); ok {
 			*baseURLibit = val
 		}
 	}
-	result.BaseURLibit = *baseURLibit
+	if result.BaseURLibit, err = normalizeBaseURLs(*baseURLibit); err != nil {
+		log.WithError(err).WithField("baseURLibit", *baseURLibit).Fatal("Invalid base URL")
+	}
 
 	if !isArgSet(ctx, "baseURLrd") {
 		if val, ok := os.LookupEnv(*envPrefix + "BASE_URL_RD"); ok {
 			*baseURLrd = val
 		}
 	}
-	result.BaseURLrd = *baseURLrd
+	if result.BaseURLrd, err = normalizeBaseURL(*baseURLrd); err != nil {
+		log.WithError(err).WithField("baseURLrd", *baseURLrd).Fatal("Invalid base URL")
+	}
+
+	if !isArgSet(ctx, "baseURLsolidTorrents") {
+		if val, ok := os.LookupEnv(*envPrefix + "BASE_URL_SOLID_TORRENTS"); ok {
+			*baseURLsolidTorrents = val
+		}
+	}
+	if result.BaseURLsolidTorrents, err = normalizeBaseURLs(*baseURLsolidTorrents); err != nil {
+		log.WithError(err).WithField("baseURLsolidTorrents", *baseURLsolidTorrents).Fatal("Invalid base URL")
+	}
+
+	if !isArgSet(ctx, "checkSitesOnStartup") {
+		if val, ok := os.LookupEnv(*envPrefix + "CHECK_SITES_ON_STARTUP"); ok {
+			if *checkSitesOnStartup, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "CHECK_SITES_ON_STARTUP").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.CheckSitesOnStartup = *checkSitesOnStartup
 
 	if !isArgSet(ctx, "logLevel") {
 		if val, ok := os.LookupEnv(*envPrefix + "LOG_LEVEL"); ok {
@@ -167,6 +458,31 @@ func parseConfig(ctx context.Context) config {
 	}
 	result.LogLevel = *logLevel
 
+	if !isArgSet(ctx, "magnetsOnly") {
+		if val, ok := os.LookupEnv(*envPrefix + "MAGNETS_ONLY"); ok {
+			if *magnetsOnly, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "MAGNETS_ONLY").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.MagnetsOnly = *magnetsOnly
+
+	if !isArgSet(ctx, "adminToken") {
+		if val, ok := os.LookupEnv(*envPrefix + "ADMIN_TOKEN"); ok {
+			*adminToken = val
+		}
+	}
+	result.AdminToken = *adminToken
+
+	if !isArgSet(ctx, "maxIdleConnsPerHost") {
+		if val, ok := os.LookupEnv(*envPrefix + "MAX_IDLE_CONNS_PER_HOST"); ok {
+			if *maxIdleConnsPerHost, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "MAX_IDLE_CONNS_PER_HOST").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.MaxIdleConnsPerHost = *maxIdleConnsPerHost
+
 	if !isArgSet(ctx, "tpbRetries") {
 		if val, ok := os.LookupEnv(*envPrefix + "TPB_RETRIES"); ok {
 			if *tpbRetries, err = strconv.Atoi(val); err != nil {
@@ -176,6 +492,370 @@ func parseConfig(ctx context.Context) config {
 	}
 	result.TPBretries = *tpbRetries
 
+	if !isArgSet(ctx, "retryEmptyTPB") {
+		if val, ok := os.LookupEnv(*envPrefix + "RETRY_EMPTY_TPB"); ok {
+			if *retryEmptyTPB, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "RETRY_EMPTY_TPB").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.RetryEmptyTPB = *retryEmptyTPB
+
+	if !isArgSet(ctx, "retryEmpty1337x") {
+		if val, ok := os.LookupEnv(*envPrefix + "RETRY_EMPTY_1337X"); ok {
+			if *retryEmpty1337x, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "RETRY_EMPTY_1337X").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.RetryEmpty1337x = *retryEmpty1337x
+
+	if !isArgSet(ctx, "retryEmptyIbit") {
+		if val, ok := os.LookupEnv(*envPrefix + "RETRY_EMPTY_IBIT"); ok {
+			if *retryEmptyIbit, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "RETRY_EMPTY_IBIT").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.RetryEmptyIbit = *retryEmptyIbit
+
+	if !isArgSet(ctx, "titleMatching") {
+		if val, ok := os.LookupEnv(*envPrefix + "TITLE_MATCHING"); ok {
+			*titleMatching = val
+		}
+	}
+	result.TitleMatching = *titleMatching
+
+	if !isArgSet(ctx, "includeAllYears") {
+		if val, ok := os.LookupEnv(*envPrefix + "INCLUDE_ALL_YEARS"); ok {
+			if *includeAllYears, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "INCLUDE_ALL_YEARS").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.IncludeAllYears = *includeAllYears
+
+	if !isArgSet(ctx, "dnsRetries") {
+		if val, ok := os.LookupEnv(*envPrefix + "DNS_RETRIES"); ok {
+			if *dnsRetries, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "DNS_RETRIES").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.DNSretries = *dnsRetries
+
+	if !isArgSet(ctx, "rateLimitYTS") {
+		if val, ok := os.LookupEnv(*envPrefix + "RATE_LIMIT_YTS"); ok {
+			if *rateLimitYTS, err = strconv.ParseFloat(val, 64); err != nil {
+				log.WithError(err).WithField("envVar", "RATE_LIMIT_YTS").Fatal("Couldn't convert environment variable from string to float64")
+			}
+		}
+	}
+	result.RateLimitYTS = *rateLimitYTS
+
+	if !isArgSet(ctx, "rateLimitTPB") {
+		if val, ok := os.LookupEnv(*envPrefix + "RATE_LIMIT_TPB"); ok {
+			if *rateLimitTPB, err = strconv.ParseFloat(val, 64); err != nil {
+				log.WithError(err).WithField("envVar", "RATE_LIMIT_TPB").Fatal("Couldn't convert environment variable from string to float64")
+			}
+		}
+	}
+	result.RateLimitTPB = *rateLimitTPB
+
+	if !isArgSet(ctx, "rateLimit1337x") {
+		if val, ok := os.LookupEnv(*envPrefix + "RATE_LIMIT_1337X"); ok {
+			if *rateLimit1337x, err = strconv.ParseFloat(val, 64); err != nil {
+				log.WithError(err).WithField("envVar", "RATE_LIMIT_1337X").Fatal("Couldn't convert environment variable from string to float64")
+			}
+		}
+	}
+	result.RateLimit1337x = *rateLimit1337x
+
+	if !isArgSet(ctx, "concurrency1337x") {
+		if val, ok := os.LookupEnv(*envPrefix + "CONCURRENCY_1337X"); ok {
+			if *concurrency1337x, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "CONCURRENCY_1337X").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.Concurrency1337x = *concurrency1337x
+
+	if !isArgSet(ctx, "maxResults1337x") {
+		if val, ok := os.LookupEnv(*envPrefix + "MAX_RESULTS_1337X"); ok {
+			if *maxResults1337x, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "MAX_RESULTS_1337X").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.MaxResults1337x = *maxResults1337x
+
+	if !isArgSet(ctx, "rateLimitIbit") {
+		if val, ok := os.LookupEnv(*envPrefix + "RATE_LIMIT_IBIT"); ok {
+			if *rateLimitIbit, err = strconv.ParseFloat(val, 64); err != nil {
+				log.WithError(err).WithField("envVar", "RATE_LIMIT_IBIT").Fatal("Couldn't convert environment variable from string to float64")
+			}
+		}
+	}
+	result.RateLimitIbit = *rateLimitIbit
+
+	if !isArgSet(ctx, "rateLimitSolidTorrents") {
+		if val, ok := os.LookupEnv(*envPrefix + "RATE_LIMIT_SOLID_TORRENTS"); ok {
+			if *rateLimitSolidTorrents, err = strconv.ParseFloat(val, 64); err != nil {
+				log.WithError(err).WithField("envVar", "RATE_LIMIT_SOLID_TORRENTS").Fatal("Couldn't convert environment variable from string to float64")
+			}
+		}
+	}
+	result.RateLimitSolidTorrents = *rateLimitSolidTorrents
+
+	if !isArgSet(ctx, "scrapeBudget") {
+		if val, ok := os.LookupEnv(*envPrefix + "SCRAPE_BUDGET"); ok {
+			if *scrapeBudget, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "SCRAPE_BUDGET").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.ScrapeBudget = *scrapeBudget
+
+	if !isArgSet(ctx, "warmOnStartup") {
+		if val, ok := os.LookupEnv(*envPrefix + "WARM_ON_STARTUP"); ok {
+			if *warmOnStartup, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "WARM_ON_STARTUP").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.WarmOnStartup = *warmOnStartup
+
+	if !isArgSet(ctx, "siteWeights") {
+		if val, ok := os.LookupEnv(*envPrefix + "SITE_WEIGHTS"); ok {
+			*siteWeights = val
+		}
+	}
+	if result.SiteWeights, err = parseSiteWeights(*siteWeights); err != nil {
+		log.WithError(err).WithField("siteWeights", *siteWeights).Fatal("Couldn't parse site weights")
+	}
+
+	if !isArgSet(ctx, "collapseTorrentsYTS") {
+		if val, ok := os.LookupEnv(*envPrefix + "COLLAPSE_TORRENTS_YTS"); ok {
+			if *collapseTorrentsYTS, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "COLLAPSE_TORRENTS_YTS").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.CollapseTorrentsYTS = *collapseTorrentsYTS
+
+	if !isArgSet(ctx, "movieDetailsYTS") {
+		if val, ok := os.LookupEnv(*envPrefix + "MOVIE_DETAILS_YTS"); ok {
+			if *movieDetailsYTS, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "MOVIE_DETAILS_YTS").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.MovieDetailsYTS = *movieDetailsYTS
+
+	if !isArgSet(ctx, "disableKeepAlives") {
+		if val, ok := os.LookupEnv(*envPrefix + "DISABLE_KEEP_ALIVES"); ok {
+			if *disableKeepAlives, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "DISABLE_KEEP_ALIVES").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.DisableKeepAlives = *disableKeepAlives
+
+	if !isArgSet(ctx, "compressCache") {
+		if val, ok := os.LookupEnv(*envPrefix + "COMPRESS_CACHE"); ok {
+			if *compressCache, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "COMPRESS_CACHE").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.CompressCache = *compressCache
+
+	if !isArgSet(ctx, "mergeTrackers") {
+		if val, ok := os.LookupEnv(*envPrefix + "MERGE_TRACKERS"); ok {
+			if *mergeTrackers, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "MERGE_TRACKERS").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.MergeTrackers = *mergeTrackers
+
+	if !isArgSet(ctx, "maxTrackers") {
+		if val, ok := os.LookupEnv(*envPrefix + "MAX_TRACKERS"); ok {
+			if *maxTrackers, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "MAX_TRACKERS").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.MaxTrackers = *maxTrackers
+
+	if !isArgSet(ctx, "allowedTrackerHosts") {
+		if val, ok := os.LookupEnv(*envPrefix + "ALLOWED_TRACKER_HOSTS"); ok {
+			*allowedTrackerHosts = val
+		}
+	}
+	for _, host := range strings.Split(*allowedTrackerHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			result.AllowedTrackerHosts = append(result.AllowedTrackerHosts, host)
+		}
+	}
+
+	if !isArgSet(ctx, "excludeCam") {
+		if val, ok := os.LookupEnv(*envPrefix + "EXCLUDE_CAM"); ok {
+			if *excludeCam, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "EXCLUDE_CAM").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.ExcludeCam = *excludeCam
+
+	if !isArgSet(ctx, "minSize") {
+		if val, ok := os.LookupEnv(*envPrefix + "MIN_SIZE"); ok {
+			*minSize = val
+		}
+	}
+	if result.MinSize, err = parseSizeLimit(*minSize); err != nil {
+		log.WithError(err).WithField("minSize", *minSize).Fatal("Couldn't parse min size")
+	}
+
+	if !isArgSet(ctx, "maxSize") {
+		if val, ok := os.LookupEnv(*envPrefix + "MAX_SIZE"); ok {
+			*maxSize = val
+		}
+	}
+	if result.MaxSize, err = parseSizeLimit(*maxSize); err != nil {
+		log.WithError(err).WithField("maxSize", *maxSize).Fatal("Couldn't parse max size")
+	}
+
+	if !isArgSet(ctx, "dropUnknownSize") {
+		if val, ok := os.LookupEnv(*envPrefix + "DROP_UNKNOWN_SIZE"); ok {
+			if *dropUnknownSize, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "DROP_UNKNOWN_SIZE").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.DropUnknownSize = *dropUnknownSize
+
+	if !isArgSet(ctx, "includeGroups") {
+		if val, ok := os.LookupEnv(*envPrefix + "INCLUDE_GROUPS"); ok {
+			*includeGroups = val
+		}
+	}
+	for _, group := range strings.Split(*includeGroups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			result.IncludeGroups = append(result.IncludeGroups, group)
+		}
+	}
+
+	if !isArgSet(ctx, "excludeGroups") {
+		if val, ok := os.LookupEnv(*envPrefix + "EXCLUDE_GROUPS"); ok {
+			*excludeGroups = val
+		}
+	}
+	for _, group := range strings.Split(*excludeGroups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			result.ExcludeGroups = append(result.ExcludeGroups, group)
+		}
+	}
+
+	if !isArgSet(ctx, "dropUnknownGroup") {
+		if val, ok := os.LookupEnv(*envPrefix + "DROP_UNKNOWN_GROUP"); ok {
+			if *dropUnknownGroup, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "DROP_UNKNOWN_GROUP").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.DropUnknownGroup = *dropUnknownGroup
+
+	if !isArgSet(ctx, "keepUnknownQuality") {
+		if val, ok := os.LookupEnv(*envPrefix + "KEEP_UNKNOWN_QUALITY"); ok {
+			if *keepUnknownQuality, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "KEEP_UNKNOWN_QUALITY").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.KeepUnknownQuality = *keepUnknownQuality
+
+	if !isArgSet(ctx, "coalesceSearches") {
+		if val, ok := os.LookupEnv(*envPrefix + "COALESCE_SEARCHES"); ok {
+			if *coalesceSearches, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "COALESCE_SEARCHES").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.CoalesceSearches = *coalesceSearches
+
+	if !isArgSet(ctx, "fuzzyDedup") {
+		if val, ok := os.LookupEnv(*envPrefix + "FUZZY_DEDUP"); ok {
+			if *fuzzyDedup, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "FUZZY_DEDUP").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.FuzzyDedup = *fuzzyDedup
+
+	if !isArgSet(ctx, "slowScrapeThreshold") {
+		if val, ok := os.LookupEnv(*envPrefix + "SLOW_SCRAPE_THRESHOLD"); ok {
+			if *slowScrapeThreshold, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "SLOW_SCRAPE_THRESHOLD").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.SlowScrapeThreshold = *slowScrapeThreshold
+
+	if !isArgSet(ctx, "siteDeadline") {
+		if val, ok := os.LookupEnv(*envPrefix + "SITE_DEADLINE"); ok {
+			if *siteDeadline, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "SITE_DEADLINE").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.SiteDeadline = *siteDeadline
+
+	if !isArgSet(ctx, "degradedErrRate") {
+		if val, ok := os.LookupEnv(*envPrefix + "DEGRADED_ERR_RATE"); ok {
+			if *degradedErrRate, err = strconv.ParseFloat(val, 64); err != nil {
+				log.WithError(err).WithField("envVar", "DEGRADED_ERR_RATE").Fatal("Couldn't convert environment variable from string to float64")
+			}
+		}
+	}
+	result.DegradedErrRate = *degradedErrRate
+
+	if !isArgSet(ctx, "errorHistorySize") {
+		if val, ok := os.LookupEnv(*envPrefix + "ERROR_HISTORY_SIZE"); ok {
+			if *errorHistorySize, err = strconv.Atoi(val); err != nil {
+				log.WithError(err).WithField("envVar", "ERROR_HISTORY_SIZE").Fatal("Couldn't convert environment variable from string to int")
+			}
+		}
+	}
+	result.ErrorHistorySize = *errorHistorySize
+
+	if !isArgSet(ctx, "maxDurationIbit") {
+		if val, ok := os.LookupEnv(*envPrefix + "MAX_DURATION_IBIT"); ok {
+			if *maxDurationIbit, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "MAX_DURATION_IBIT").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.MaxDurationIbit = *maxDurationIbit
+
+	if !isArgSet(ctx, "syncIbit") {
+		if val, ok := os.LookupEnv(*envPrefix + "SYNC_IBIT"); ok {
+			if *syncIbit, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "SYNC_IBIT").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.SyncIbit = *syncIbit
+
+	if !isArgSet(ctx, "parallelIbitMirrors") {
+		if val, ok := os.LookupEnv(*envPrefix + "PARALLEL_IBIT_MIRRORS"); ok {
+			if *parallelIbitMirrors, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "PARALLEL_IBIT_MIRRORS").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.ParallelIbitMirrors = *parallelIbitMirrors
+
 	if !isArgSet(ctx, "rootURL") {
 		if val, ok := os.LookupEnv(*envPrefix + "ROOT_URL"); ok {
 			*rootURL = val
@@ -183,6 +863,41 @@ func parseConfig(ctx context.Context) config {
 	}
 	result.RootURL = *rootURL
 
+	if !isArgSet(ctx, "rootMode") {
+		if val, ok := os.LookupEnv(*envPrefix + "ROOT_MODE"); ok {
+			*rootMode = val
+		}
+	}
+	switch *rootMode {
+	case rootModeRedirect, rootModeMessage, rootModeNotFound:
+	default:
+		log.WithField("rootMode", *rootMode).Fatal("Unknown rootMode")
+	}
+	result.RootMode = *rootMode
+
+	if !isArgSet(ctx, "rootMessage") {
+		if val, ok := os.LookupEnv(*envPrefix + "ROOT_MESSAGE"); ok {
+			*rootMessage = val
+		}
+	}
+	result.RootMessage = *rootMessage
+
+	if !isArgSet(ctx, "debugScrape") {
+		if val, ok := os.LookupEnv(*envPrefix + "DEBUG_SCRAPE"); ok {
+			if *debugScrape, err = strconv.ParseBool(val); err != nil {
+				log.WithError(err).WithField("envVar", "DEBUG_SCRAPE").Fatal("Couldn't convert environment variable from string to bool")
+			}
+		}
+	}
+	result.DebugScrape = *debugScrape
+
+	if !isArgSet(ctx, "debugToken") {
+		if val, ok := os.LookupEnv(*envPrefix + "DEBUG_TOKEN"); ok {
+			*debugToken = val
+		}
+	}
+	result.DebugToken = *debugToken
+
 	if !isArgSet(ctx, "extraHeadersRD") {
 		if val, ok := os.LookupEnv(*envPrefix + "EXTRA_HEADERS_RD"); ok {
 			*extraHeadersRD = val
@@ -198,16 +913,259 @@ func parseConfig(ctx context.Context) config {
 		}
 	}
 
+	if !isArgSet(ctx, "idleConnTimeout") {
+		if val, ok := os.LookupEnv(*envPrefix + "IDLE_CONN_TIMEOUT"); ok {
+			if *idleConnTimeout, err = time.ParseDuration(val); err != nil {
+				log.WithError(err).WithField("envVar", "IDLE_CONN_TIMEOUT").Fatal("Couldn't convert environment variable from string to time.Duration")
+			}
+		}
+	}
+	result.IdleConnTimeout = *idleConnTimeout
+
 	if !isArgSet(ctx, "socksProxyAddrTPB") {
 		if val, ok := os.LookupEnv(*envPrefix + "SOCKS_PROXY_ADDR_TPB"); ok {
 			*socksProxyAddrTPB = val
 		}
 	}
 	result.SocksProxyAddrTPB = *socksProxyAddrTPB
+	// The credentials are moved out of the address, so that they're not logged
+	if i := strings.LastIndex(result.SocksProxyAddrTPB, "@"); i != -1 {
+		credentials := strings.SplitN(result.SocksProxyAddrTPB[:i], ":", 2)
+		result.SocksProxyUserTPB = credentials[0]
+		if len(credentials) == 2 {
+			result.SocksProxyPasswordTPB = credentials[1]
+		}
+		result.SocksProxyAddrTPB = result.SocksProxyAddrTPB[i+1:]
+	}
+	if val, ok := os.LookupEnv(*envPrefix + "SOCKS_PROXY_USER_TPB"); ok {
+		result.SocksProxyUserTPB = val
+	}
+	if val, ok := os.LookupEnv(*envPrefix + "SOCKS_PROXY_PASSWORD_TPB"); ok {
+		result.SocksProxyPasswordTPB = val
+	}
+
+	if !isArgSet(ctx, "blockedInfoHashes") {
+		if val, ok := os.LookupEnv(*envPrefix + "BLOCKED_INFO_HASHES"); ok {
+			*blockedInfoHashes = val
+		}
+	}
+	if result.BlockedInfoHashes, err = parseInfoHashes(*blockedInfoHashes); err != nil {
+		log.WithError(err).WithField("blockedInfoHashes", *blockedInfoHashes).Fatal("Couldn't parse blocked info hashes")
+	}
 
 	return result
 }
 
+// parseSizeLimit parses a size like "300MB" or "1.5 GiB" to its number of bytes. An empty string or "0" means no limit and returns 0.
+func parseSizeLimit(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	if size == "" || size == "0" {
+		return 0, nil
+	}
+	bytes := imdb2torrent.ParseSize(size)
+	if bytes == 0 {
+		return 0, fmt.Errorf("Invalid size, it must be a number with a unit like \"MB\" or \"GB\": %v", size)
+	}
+	return bytes, nil
+}
+
+// parseSiteWeights parses a comma separated list of site=weight pairs like "YTS=2,1337x=0.5". An empty string returns nil.
+func parseSiteWeights(val string) (weightMap, error) {
+	pairs, err := parseSitePairs(val)
+	if err != nil {
+		return nil, err
+	}
+	var result weightMap
+	for torrentSite, weightString := range pairs {
+		weight, err := strconv.ParseFloat(weightString, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse weight of %v: %v", torrentSite, err)
+		}
+		if result == nil {
+			result = weightMap{}
+		}
+		result[torrentSite] = weight
+	}
+	return result, nil
+}
+
+// parseSiteCounts parses a comma separated list of site=count pairs like "YTS=2,1337x=3". An empty string returns nil.
+func parseSiteCounts(val string) (countMap, error) {
+	pairs, err := parseSitePairs(val)
+	if err != nil {
+		return nil, err
+	}
+	var result countMap
+	for torrentSite, countString := range pairs {
+		count, err := strconv.Atoi(countString)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse count of %v: %v", torrentSite, err)
+		}
+		if result == nil {
+			result = countMap{}
+		}
+		result[torrentSite] = count
+	}
+	return result, nil
+}
+
+// parseSitePairs parses a comma separated list of site=value pairs like "YTS=2,1337x=3" into a map with trimmed keys and values.
+func parseSitePairs(val string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pair := range strings.Split(val, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid site value, it must be a pair like \"YTS=2\": %v", pair)
+		}
+		result[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return result, nil
+}
+
+// parseInfoHashes returns the upper case info hashes of a list that's separated by comma or newline characters.
+// If the value is a single element that's not a hex encoded info hash, it's treated as path to a file with such a list, where lines starting with "#" are ignored.
+func parseInfoHashes(val string) ([]string, error) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return nil, nil
+	}
+	if !strings.ContainsAny(val, ",\n") && !isInfoHash(val) {
+		data, err := ioutil.ReadFile(val)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read file: %v", err)
+		}
+		val = string(data)
+	}
+	var result []string
+	for _, line := range strings.Split(val, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, infoHash := range strings.Split(line, ",") {
+			infoHash = strings.TrimSpace(infoHash)
+			if infoHash == "" {
+				continue
+			}
+			if !isInfoHash(infoHash) {
+				return nil, fmt.Errorf("Invalid info hash: %v", infoHash)
+			}
+			result = append(result, strings.ToUpper(infoHash))
+		}
+	}
+	return result, nil
+}
+
+// isInfoHash returns true if the value is a hex encoded BitTorrent v1 info hash.
+func isInfoHash(val string) bool {
+	if len(val) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(val)
+	return err == nil
+}
+
+// newlineSeparatedFlags are the flags whose list elements are separated by newline characters instead of commas, because the elements can contain commas themselves.
+var newlineSeparatedFlags = map[string]bool{
+	"extraHeadersRD": true,
+}
+
+// applyConfigFile reads the YAML or TOML file and sets the values of the command line arguments that are named by its keys, except for the ones that are set via command line.
+// Setting the value via the flag.Value doesn't mark the argument as set, so env vars still overwrite the values afterwards.
+// Lists are joined with commas, like it's expected by "baseURLyts" for example, or with newline characters for the flags in newlineSeparatedFlags.
+func applyConfigFile(ctx context.Context, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Couldn't read file: %v", err)
+	}
+	settings := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		if err = yaml.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("Couldn't unmarshal YAML: %v", err)
+		}
+	case ".toml":
+		if err = toml.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("Couldn't unmarshal TOML: %v", err)
+		}
+	default:
+		return fmt.Errorf("Unsupported file extension: %v", ext)
+	}
+
+	for name, value := range settings {
+		// The env var prefix and the config file itself must be known before the file is read
+		if name == "envPrefix" || name == "configFile" {
+			return fmt.Errorf("Setting %v can't be set in the config file", name)
+		}
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("Unknown setting: %v", name)
+		}
+		if isArgSet(ctx, name) {
+			continue
+		}
+		var valString string
+		switch v := value.(type) {
+		case string:
+			valString = v
+		case []interface{}:
+			var elems []string
+			for _, elem := range v {
+				elems = append(elems, fmt.Sprint(elem))
+			}
+			separator := ","
+			if newlineSeparatedFlags[name] {
+				separator = "\n"
+			}
+			valString = strings.Join(elems, separator)
+		default:
+			valString = fmt.Sprint(v)
+		}
+		if err = f.Value.Set(valString); err != nil {
+			return fmt.Errorf("Invalid value for setting %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// normalizeBaseURL checks that the base URL is an absolute HTTP(S) URL and removes trailing slashes, because request paths are appended to it.
+func normalizeBaseURL(baseURL string) (string, error) {
+	baseURL = strings.TrimSpace(baseURL)
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't parse URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("URL %v must start with \"http://\" or \"https://\"", baseURL)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("URL %v doesn't contain a host", baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("URL %v must not contain a query or fragment", baseURL)
+	}
+	return strings.TrimRight(baseURL, "/"), nil
+}
+
+// normalizeBaseURLs is like normalizeBaseURL, but for a comma separated list of mirrors.
+// An empty list is kept, because it disables the torrent site.
+func normalizeBaseURLs(baseURLs string) (string, error) {
+	if strings.TrimSpace(baseURLs) == "" {
+		return "", nil
+	}
+	var result []string
+	for _, baseURL := range strings.Split(baseURLs, ",") {
+		baseURL, err := normalizeBaseURL(baseURL)
+		if err != nil {
+			return "", err
+		}
+		result = append(result, baseURL)
+	}
+	return strings.Join(result, ","), nil
+}
+
 // isArgSet returns true if the argument you're looking for is actually set as command line argument.
 // Pass without "-" prefix.
 func isArgSet(ctx context.Context, arg string) bool {
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyConfigFileLists(t *testing.T) {
+	// The flags are usually defined by parseConfig(), which can't be called in tests
+	for _, name := range []string{"baseURLyts", "extraHeadersRD"} {
+		if flag.Lookup(name) == nil {
+			flag.String(name, "", "")
+		}
+	}
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+	}{
+		{"YAML", "config.yaml", "baseURLyts:\n  - https://yts.example\n  - https://yts-mirror.example\nextraHeadersRD:\n  - \"X-Foo: bar\"\n  - \"X-Baz: a, b\"\n"},
+		{"TOML", "config.toml", "baseURLyts = [\"https://yts.example\", \"https://yts-mirror.example\"]\nextraHeadersRD = [\"X-Foo: bar\", \"X-Baz: a, b\"]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), tt.fileName)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Couldn't write config file: %v", err)
+			}
+			if err := applyConfigFile(context.Background(), path); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if val := flag.Lookup("baseURLyts").Value.String(); val != "https://yts.example,https://yts-mirror.example" {
+				t.Errorf("Expected comma separated mirrors, got %q", val)
+			}
+			if val := flag.Lookup("extraHeadersRD").Value.String(); val != "X-Foo: bar\nX-Baz: a, b" {
+				t.Errorf("Expected newline separated headers, got %q", val)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -56,7 +59,9 @@ func createManifestHandler(ctx context.Context, conversionClient realdebrid.Clie
 	}
 }
 
-func createStreamHandler(ctx context.Context, config config, searchClient imdb2torrent.Client, conversionClient realdebrid.Client, redirectCache *fastcache.Cache) http.HandlerFunc {
+// createStreamHandler creates a handler for Stremio stream requests. imdbIndex can be nil, then the searched IMDb IDs aren't recorded for warming the cache.
+// The debrid resolvers that the user has API tokens for are taken from the request context, see createTokenMiddleware().
+func createStreamHandler(ctx context.Context, config config, searchClient imdb2torrent.Client, redirectCache *fastcache.Cache, imdbIndex *imdbIDIndex) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		rCtx := r.Context()
 		logger := log.WithContext(rCtx)
@@ -71,128 +76,206 @@ func createStreamHandler(ctx context.Context, config config, searchClient imdb2t
 			return
 		}
 
-		torrents, err := searchClient.FindMagnets(rCtx, requestedID)
-		if err != nil {
-			logger.WithError(err).Warn("Magnet not found")
-			w.WriteHeader(http.StatusNotFound)
-			return
-		} else if len(torrents) == 0 {
+		torrents, err := searchClient.FindMagnetsOrNotFound(rCtx, requestedID)
+		// Only IMDb IDs that were searched successfully have cache entries to refresh
+		if imdbIndex != nil && (err == nil || errors.Is(err, imdb2torrent.ErrNoTorrentsFound)) {
+			if indexErr := imdbIndex.add(requestedID); indexErr != nil {
+				logger.WithError(indexErr).Warn("Couldn't add IMDb ID to index")
+			}
+		}
+		if errors.Is(err, imdb2torrent.ErrNoTorrentsFound) {
+			// Not an error of the addon, Stremio shows that there are no streams for the movie
 			logger.Info("No magnets found")
+			writeStreams(logger, w, []stremio.StreamItem{})
+			return
+		} else if err != nil {
+			logger.WithError(err).Warn("Magnet not found")
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		// Filter out the ones that are not available
-		var infoHashes []string
-		for _, torrent := range torrents {
-			infoHashes = append(infoHashes, torrent.InfoHash)
-		}
-		apiToken := rCtx.Value("apitoken").(string)
-		availableInfoHashes := conversionClient.CheckInstantAvailability(rCtx, apiToken, infoHashes...)
-		if len(availableInfoHashes) == 0 {
-			// TODO: queue for download on real-debrid, or log somewhere for an asynchronous process to go through them and queue them?
-			logger.Info("None of the found torrents are instantly available on real-debrid.com")
-			w.WriteHeader(http.StatusNotFound)
+		// Without RealDebrid there's nothing to check or convert, so the magnet URLs are the streams
+		if config.MagnetsOnly {
+			searchClient.SortResults(torrents)
+			writeStreams(logger, w, magnetStreams(torrents))
 			return
 		}
-		// https://github.com/golang/go/wiki/SliceTricks#filter-in-place
-		n := 0
-		for _, torrent := range torrents {
-			for _, availableInfoHash := range availableInfoHashes {
-				if torrent.InfoHash == availableInfoHash {
-					torrents[n] = torrent
-					n++
-					break
-				}
-			}
-		}
-		torrents = torrents[:n]
 
-		// Note: The torrents slice is guaranteed to not be empty at this point, because it already contained non-duplicate info hashes and then only unavailable ones were filtered and then a `len(availableInfoHashes) == 0` was done.
+		// RealDebrid only supports BitTorrent v1 info hashes, so v2-only torrents can't be converted
+		torrents = removeV2OnlyTorrents(logger, torrents)
+		if len(torrents) == 0 {
+			logger.Info("Only found BitTorrent v2-only torrents, which real-debrid.com doesn't support yet")
+			writeStreams(logger, w, []stremio.StreamItem{})
+			return
+		}
 
-		// Separate all torrent results into a 720p, 1080p, 1080p 10bit, 2160p and 2160p 10bit list, so we can offer the user one stream for each quality now (or maybe just for one quality if there's no torrent for the other), cache the torrents for each apiToken-imdbID-quality combination and later (at the redirect endpoint) go through the respective torrent list to turn in into a streamable video URL via RealDebrid.
-		var torrents720p []imdb2torrent.Result
-		var torrents1080p []imdb2torrent.Result
-		var torrents1080p10bit []imdb2torrent.Result
-		var torrents2160p []imdb2torrent.Result
-		var torrents2160p10bit []imdb2torrent.Result
+		// Filter out the ones that are not available, for each debrid resolver that the user has an API token for
+		var infoHashes []string
 		for _, torrent := range torrents {
-			if strings.HasPrefix(torrent.Quality, "720p") {
-				torrents720p = append(torrents720p, torrent)
-			} else if strings.HasPrefix(torrent.Quality, "1080p") && strings.Contains(torrent.Quality, "10bit") {
-				torrents1080p10bit = append(torrents1080p10bit, torrent)
-			} else if strings.HasPrefix(torrent.Quality, "1080p") {
-				torrents1080p = append(torrents1080p, torrent)
-			} else if strings.HasPrefix(torrent.Quality, "2160p") && strings.Contains(torrent.Quality, "10bit") {
-				torrents2160p10bit = append(torrents2160p10bit, torrent)
-			} else if strings.HasPrefix(torrent.Quality, "2160p") {
-				torrents2160p = append(torrents2160p, torrent)
-			} else {
-				logger.WithField("quality", torrent.Quality).Warn("Unknown quality, can't sort into one of the torrent lists")
-			}
+			infoHashes = append(infoHashes, torrent.InfoHash)
 		}
+		resolverTokens := rCtx.Value("apitokens").([]resolverToken)
+		availableInfoHashes := checkInstantAvailability(rCtx, resolverTokens, infoHashes)
 
-		// We already respond with two URLs (for both qualities, as long as we have two), but they point to our server for now.
-		// Only when the user clicks on a stream and arrives at our redirect endpoint, we go through the list of torrents for the selected quality and try to convert them into a streamable video URL via RealDebrid.
-		// There it should work for the first torrent we try, because we already checked the "instant availability" on RealDebrid here.
+		// We already respond with one URL per quality (as long as we have torrents for it) and resolver, but they point to our server for now.
+		// Only when the user clicks on a stream and arrives at our redirect endpoint, we go through the list of torrents for the selected quality and try to convert them into a streamable video URL via the stream's resolver.
+		// There it should work for the first torrent we try, because we already checked the "instant availability" here.
 		var streams []stremio.StreamItem
 		remote := false
 		if remoteIface := rCtx.Value("remote"); remoteIface != nil {
 			remote = remoteIface.(bool)
 		}
-		remoteString := strconv.FormatBool(remote)
-		requestIDPrefix := apiToken + "-" + remoteString + "-" + requestedID
-		if len(torrents720p) > 0 {
-			stream := handleTorrents(rCtx, config, requestIDPrefix+"-"+"720p", "720p", torrents720p)
-			streams = append(streams, stream)
+		for i, resolverToken := range resolverTokens {
+			if len(availableInfoHashes[i]) == 0 {
+				// TODO: queue for download on the debrid service, or log somewhere for an asynchronous process to go through them and queue them?
+				logger.WithField("resolver", resolverToken.resolver.Name()).Info("None of the found torrents are instantly available")
+				continue
+			}
+			// With multiple resolvers the users need to know which stream belongs to which of their debrid services
+			label := len(resolverTokens) > 1
+			streams = append(streams, debridStreams(rCtx, config, resolverToken, remote, requestedID, torrents, availableInfoHashes[i], label)...)
 		}
-		if len(torrents1080p) > 0 {
-			stream := handleTorrents(rCtx, config, requestIDPrefix+"-"+"1080p", "1080p", torrents1080p)
-			streams = append(streams, stream)
+		if len(streams) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
-		if len(torrents1080p10bit) > 0 {
-			stream := handleTorrents(rCtx, config, requestIDPrefix+"-"+"1080p-10bit", "1080p 10bit", torrents1080p10bit)
-			streams = append(streams, stream)
+
+		// List the streams in the operator's preferred quality order.
+		// The sorting is stable, so streams of the same quality are listed in the order of the resolvers.
+		sort.SliceStable(streams, func(i, j int) bool {
+			return searchClient.QualityRank(streams[i].Title) < searchClient.QualityRank(streams[j].Title)
+		})
+
+		writeStreams(logger, w, streams)
+	}
+}
+
+// debridStreams returns one stream per quality of the torrents whose info hashes are available on the resolver.
+// The streams point to the redirect handler, which converts the first convertible torrent of the quality via the resolver. If label is true, the stream titles contain the resolver's name.
+func debridStreams(ctx context.Context, config config, resolverToken resolverToken, remote bool, imdbID string, torrents []imdb2torrent.Result, availableInfoHashes []string, label bool) []stremio.StreamItem {
+	logger := log.WithContext(ctx).WithField("resolver", resolverToken.resolver.Name())
+
+	// Not filtered in place, because the torrents are filtered for each resolver
+	var availableTorrents []imdb2torrent.Result
+	for _, torrent := range torrents {
+		for _, availableInfoHash := range availableInfoHashes {
+			if torrent.InfoHash == availableInfoHash {
+				availableTorrents = append(availableTorrents, torrent)
+				break
+			}
 		}
-		if len(torrents2160p) > 0 {
-			stream := handleTorrents(rCtx, config, requestIDPrefix+"-"+"2160p", "2160p", torrents2160p)
-			streams = append(streams, stream)
+	}
+
+	// Separate all torrent results into a 720p, 1080p, 1080p 10bit, 2160p, 2160p 10bit and unknown quality list, so we can offer the user one stream for each quality now (or maybe just for one quality if there's no torrent for the other), cache the torrents for each resolver-apiToken-imdbID-quality combination and later (at the redirect endpoint) go through the respective torrent list to turn in into a streamable video URL via the resolver.
+	var torrents720p []imdb2torrent.Result
+	var torrents1080p []imdb2torrent.Result
+	var torrents1080p10bit []imdb2torrent.Result
+	var torrents2160p []imdb2torrent.Result
+	var torrents2160p10bit []imdb2torrent.Result
+	// Only filled if the search client keeps results with unknown quality
+	var torrentsUnknown []imdb2torrent.Result
+	for _, torrent := range availableTorrents {
+		if strings.HasPrefix(torrent.Quality, "720p") {
+			torrents720p = append(torrents720p, torrent)
+		} else if strings.HasPrefix(torrent.Quality, "1080p") && strings.Contains(torrent.Quality, "10bit") {
+			torrents1080p10bit = append(torrents1080p10bit, torrent)
+		} else if strings.HasPrefix(torrent.Quality, "1080p") {
+			torrents1080p = append(torrents1080p, torrent)
+		} else if strings.HasPrefix(torrent.Quality, "2160p") && strings.Contains(torrent.Quality, "10bit") {
+			torrents2160p10bit = append(torrents2160p10bit, torrent)
+		} else if strings.HasPrefix(torrent.Quality, "2160p") {
+			torrents2160p = append(torrents2160p, torrent)
+		} else if torrent.Quality == imdb2torrent.QualityUnknown {
+			torrentsUnknown = append(torrentsUnknown, torrent)
+		} else {
+			logger.WithField("quality", torrent.Quality).Warn("Unknown quality, can't sort into one of the torrent lists")
 		}
-		if len(torrents2160p10bit) > 0 {
-			stream := handleTorrents(rCtx, config, requestIDPrefix+"-"+"2160p-10bit", "2160p 10bit", torrents2160p10bit)
+	}
+
+	var streams []stremio.StreamItem
+	torrentLists := []struct {
+		quality  string
+		torrents []imdb2torrent.Result
+	}{
+		{"720p", torrents720p},
+		{"1080p", torrents1080p},
+		{"1080p 10bit", torrents1080p10bit},
+		{"2160p", torrents2160p},
+		{"2160p 10bit", torrents2160p10bit},
+		{imdb2torrent.QualityUnknown, torrentsUnknown},
+	}
+	for _, torrentList := range torrentLists {
+		if len(torrentList.torrents) > 0 {
+			id := StreamID{Resolver: resolverToken.resolver.Name(), APIToken: resolverToken.apiToken, Remote: remote, IMDbID: imdbID, Quality: torrentList.quality}
+			stream := handleTorrents(ctx, config, id, torrentList.torrents)
+			if label {
+				// On its own line, so that the first line is still the quality, see imdb2torrent.Client.QualityRank()
+				stream.Title += "\n[" + id.Resolver + "]"
+			}
 			streams = append(streams, stream)
 		}
+	}
+	return streams
+}
 
-		streamJSON, _ := json.Marshal(streams)
-		logger.WithField("response", fmt.Sprintf(`{"streams": %s}`, streamJSON)).Debug("Responding")
-		w.Header().Set("Content-Type", "application/json")
-		if _, err := w.Write([]byte(`{"streams": `)); err != nil {
-			logger.WithError(err).Error("Coldn't write response")
-		} else if _, err = w.Write(streamJSON); err != nil {
-			logger.WithError(err).Error("Coldn't write response")
-		} else if _, err = w.Write([]byte(`}`)); err != nil {
-			logger.WithError(err).Error("Coldn't write response")
+// removeV2OnlyTorrents removes the results of BitTorrent v2-only torrents, which don't have a v1 info hash, in place.
+func removeV2OnlyTorrents(logger *log.Entry, torrents []imdb2torrent.Result) []imdb2torrent.Result {
+	n := 0
+	for _, torrent := range torrents {
+		if torrent.InfoHash == "" {
+			logger.WithField("infoHashV2", torrent.InfoHashV2).Debug("Skipping BitTorrent v2-only torrent")
+			continue
 		}
+		torrents[n] = torrent
+		n++
+	}
+	return torrents[:n]
+}
+
+// magnetStreams returns one stream per torrent, with the torrent's magnet URL as external URL.
+// Stremio hands the magnet URL to the torrent client of the OS, and users can copy it to use it elsewhere.
+func magnetStreams(torrents []imdb2torrent.Result) []stremio.StreamItem {
+	var streams []stremio.StreamItem
+	for _, torrent := range torrents {
+		streams = append(streams, stremio.StreamItem{
+			ExternalURL: torrent.MagnetURL,
+			Title:       torrent.QualityLabel() + "\n" + torrent.Title,
+		})
 	}
+	return streams
 }
 
-func handleTorrents(ctx context.Context, config config, redirectID, quality string, torrents []imdb2torrent.Result) stremio.StreamItem {
+func writeStreams(logger *log.Entry, w http.ResponseWriter, streams []stremio.StreamItem) {
+	streamJSON, _ := json.Marshal(streams)
+	logger.WithField("response", fmt.Sprintf(`{"streams": %s}`, streamJSON)).Debug("Responding")
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write([]byte(`{"streams": `)); err != nil {
+		logger.WithError(err).Error("Coldn't write response")
+	} else if _, err = w.Write(streamJSON); err != nil {
+		logger.WithError(err).Error("Coldn't write response")
+	} else if _, err = w.Write([]byte(`}`)); err != nil {
+		logger.WithError(err).Error("Coldn't write response")
+	}
+}
+
+func handleTorrents(ctx context.Context, config config, id StreamID, torrents []imdb2torrent.Result) stremio.StreamItem {
 	logger := log.WithContext(ctx)
+	redirectID := EncodeStreamID(id)
 	stream := stremio.StreamItem{
 		URL: config.StreamURLaddr + "/redirect/" + redirectID,
 		// Stremio docs recommend to use the stream quality as title.
 		// See https://github.com/Stremio/stremio-addon-sdk/blob/ddaa3b80def8a44e553349734dd02ec9c3fea52c/docs/api/responses/stream.md#additional-properties-to-provide-information--behaviour-flags
-		Title: quality,
+		Title: id.Quality,
 	}
 	// We can only set the exact quality string if there's only one torrent.
 	// Otherwise maybe the upcoming RealDebrid conversion fails for one torrent, but works for the next, which has a slightly different quality string.
 	if len(torrents) == 1 {
-		stream.Title = torrents[0].Quality
+		stream.Title = torrents[0].QualityLabel()
 	}
 
 	// Cache for upcoming redirect request
 	fields := log.Fields{
-		"quality":    quality,
+		"quality":    id.Quality,
 		"cache":      "redirect",
 		"redirectID": redirectID,
 	}
@@ -211,7 +294,8 @@ func handleTorrents(ctx context.Context, config config, redirectID, quality stri
 	return stream
 }
 
-func createRedirectHandler(ctx context.Context, cache *fastcache.Cache, conversionClient realdebrid.Client) http.HandlerFunc {
+// createRedirectHandler creates a handler that redirects to the stream URL of the first torrent of the stream that the stream's resolver can convert.
+func createRedirectHandler(ctx context.Context, cache *fastcache.Cache, searchClient imdb2torrent.Client, resolvers []DebridResolver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		rCtx := r.Context()
 		logger := log.WithContext(rCtx)
@@ -224,12 +308,18 @@ func createRedirectHandler(ctx context.Context, cache *fastcache.Cache, conversi
 			return
 		}
 
-		idParts := strings.Split(redirectID, "-")
-		// "<apiToken>-<remote>-<imdbID>-<quality>"
-		if len(idParts) != 4 {
+		id, err := DecodeStreamID(redirectID)
+		if err != nil {
+			logger.WithError(err).WithField("redirectID", redirectID).Warn("Couldn't decode redirect ID")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		resolver, ok := findResolver(resolvers, id.Resolver)
+		if !ok {
+			logger.WithField("resolver", id.Resolver).Warn("Redirect ID has an unknown resolver")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
 		// Check cache first.
 		// Cache is important, because the video player (not Stremio!) sometimes calls this endpoint multiple times while waiting for the video stream to start!
@@ -274,22 +364,22 @@ func createRedirectHandler(ctx context.Context, cache *fastcache.Cache, conversi
 			return
 		}
 		var streamURL string
-		apiToken := idParts[0]
-		remote, err := strconv.ParseBool(idParts[1])
-		if err != nil {
-			logger.WithError(err).Error("Couldn't parse remote value")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
 		for _, torrent := range torrentList {
-			if streamURL, err = conversionClient.GetStreamURL(rCtx, torrent.MagnetURL, apiToken, remote); err != nil {
-				logger.WithError(err).Warn("Couldn't get stream URL")
+			// The torrent might have been blocked after the stream handler stored the list
+			if searchClient.IsBlocked(torrent.InfoHash) {
+				logger.WithField("infoHash", torrent.InfoHash).Debug("Skipping torrent with blocked info_hash")
+				continue
+			}
+			if streamURL, err = resolver.GetStreamURL(rCtx, torrent.MagnetURL, id.APIToken, id.Remote); err != nil {
+				logger.WithError(err).WithField("resolver", resolver.Name()).Warn("Couldn't get stream URL")
 			} else {
+				// The debrid service has the torrent now, so a cached "unavailable" would be outdated
+				resolver.InvalidateAvailability(torrent.InfoHash)
 				break
 			}
 		}
 
-		// Fill cache, even if no actual video stream was found, because it seems to be the current state on RealDebrid
+		// Fill cache, even if no actual video stream was found, because it seems to be the current state on the debrid service
 		if streamURLgob, err := newCacheEntry(rCtx, streamURL); err != nil {
 			logger.WithError(err).Error("Couldn't encode streamURL")
 		} else {
@@ -307,15 +397,120 @@ func createRedirectHandler(ctx context.Context, cache *fastcache.Cache, conversi
 	}
 }
 
+// createBlockHandler returns a handler that blocks an info hash at runtime, for example for handling DMCA takedown requests via a webhook.
+// The info hash is persisted, so it's still blocked after a restart. If an IMDb ID is passed, its cached torrent results are removed as well.
+func createBlockHandler(ctx context.Context, adminToken string, searchClient imdb2torrent.Client, blocklist blocklistFile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rCtx := r.Context()
+		logger := log.WithContext(rCtx)
+		logger.WithField("request", r).Trace("blockHandler called")
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			logger.Warn("Invalid admin token")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		infoHash := strings.ToUpper(strings.TrimSpace(r.FormValue("infoHash")))
+		if !isInfoHash(infoHash) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		logger = logger.WithField("infoHash", infoHash)
+
+		if !searchClient.IsBlocked(infoHash) {
+			if err := blocklist.add(infoHash); err != nil {
+				logger.WithError(err).Error("Couldn't persist blocked info hash")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			searchClient.Block(infoHash)
+		}
+		if imdbID := strings.TrimSpace(r.FormValue("imdbID")); imdbID != "" {
+			searchClient.Invalidate(imdbID)
+		}
+		logger.Info("Blocked info hash")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// createDebugScrapeHandler creates a handler that searches all torrent sites for the IMDb ID in the "imdbID" query parameter and responds with each site's raw results, error and duration as JSON.
+// With "bypassCache=true" the sites are searched even if there are cached results. If debugToken is empty, no token is required.
+func createDebugScrapeHandler(ctx context.Context, debugToken string, searchClient imdb2torrent.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rCtx := r.Context()
+		logger := log.WithContext(rCtx)
+		logger.WithField("request", r).Trace("debugScrapeHandler called")
+
+		if debugToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(debugToken)) != 1 {
+				logger.Warn("Invalid debug token")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		queryVals := r.URL.Query()
+		imdbID, err := imdb2torrent.CanonicalIMDbID(queryVals.Get("imdbID"))
+		if err != nil {
+			logger.WithError(err).Warn("\"/debug/scrape\" was called with an invalid IMDb ID")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		logger = logger.WithField("imdbID", imdbID)
+		if bypassCache, _ := strconv.ParseBool(queryVals.Get("bypassCache")); bypassCache {
+			rCtx = imdb2torrent.WithBypassCache(rCtx)
+		}
+
+		scrapes, err := searchClient.ScrapeSites(rCtx, imdbID)
+		if err != nil {
+			logger.WithError(err).Error("Couldn't scrape torrent sites")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resBody, err := json.Marshal(scrapes)
+		if err != nil {
+			logger.WithError(err).Error("Couldn't marshal scrape results")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(resBody); err != nil {
+			logger.WithError(err).Error("Couldn't write response")
+		}
+	}
+}
+
+// Root path behaviors, see the rootMode flag
+const (
+	rootModeRedirect = "redirect"
+	rootModeMessage  = "message"
+	rootModeNotFound = "404"
+)
+
 func createRootHandler(ctx context.Context, config config) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		rCtx := r.Context()
 		logger := log.WithContext(rCtx)
 		logger.WithField("request", r).Trace("rootHandler called")
 
-		logger.WithField("redirectLocation", config.RootURL).Debug("Responding with redirect")
-		w.Header().Set("Location", config.RootURL)
-		w.WriteHeader(http.StatusMovedPermanently)
+		switch config.RootMode {
+		case rootModeMessage:
+			logger.Debug("Responding with message")
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if _, err := w.Write([]byte(config.RootMessage)); err != nil {
+				logger.WithError(err).Error("Couldn't write response")
+			}
+		case rootModeNotFound:
+			logger.Debug("Responding with 404")
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			logger.WithField("redirectLocation", config.RootURL).Debug("Responding with redirect")
+			w.Header().Set("Location", config.RootURL)
+			w.WriteHeader(http.StatusMovedPermanently)
+		}
 	}
 }
 
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/doingodswork/deflix-stremio/pkg/imdb2torrent"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRemoveV2OnlyTorrents(t *testing.T) {
+	v1 := imdb2torrent.Result{InfoHash: "C9E15763F722F23E98A29DECDFAE341B98D53056"}
+	v2 := imdb2torrent.Result{InfoHashV2: "1220CAF1E1C30E81CB361B9EE167C4AA64228A7FA4FA9F6105232B28AD099F3A302E"}
+	hybrid := imdb2torrent.Result{InfoHash: "1111111111111111111111111111111111111111", InfoHashV2: "1220CAF1E1C30E81CB361B9EE167C4AA64228A7FA4FA9F6105232B28AD099F3A302E"}
+	tests := []struct {
+		name     string
+		torrents []imdb2torrent.Result
+		expected []imdb2torrent.Result
+	}{
+		{"v1", []imdb2torrent.Result{v1}, []imdb2torrent.Result{v1}},
+		{"v2", []imdb2torrent.Result{v2}, []imdb2torrent.Result{}},
+		{"hybrid", []imdb2torrent.Result{hybrid}, []imdb2torrent.Result{hybrid}},
+		{"mixed", []imdb2torrent.Result{v2, v1, v2, hybrid}, []imdb2torrent.Result{v1, hybrid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrents := removeV2OnlyTorrents(log.NewEntry(log.New()), tt.torrents)
+			if len(torrents) != len(tt.expected) {
+				t.Fatalf("Expected %v torrents, got %v: %+v", len(tt.expected), len(torrents), torrents)
+			}
+			for i := range torrents {
+				if torrents[i].InfoHash != tt.expected[i].InfoHash {
+					t.Errorf("Expected torrent %+v, got %+v", tt.expected[i], torrents[i])
+				}
+			}
+		})
+	}
+}
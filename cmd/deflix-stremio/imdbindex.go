@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// imdbIDIndex persists the IMDb IDs of the movies that were searched, because fastcache can't enumerate its keys.
+// It's used to warm the torrent cache on startup, see the "warmOnStartup" argument. The file has one IMDb ID per line.
+type imdbIDIndex struct {
+	path string
+	// IMDb IDs that are already in the file
+	imdbIDs map[string]struct{}
+	lock    *sync.Mutex
+}
+
+func newIMDbIDIndex(path string) imdbIDIndex {
+	return imdbIDIndex{
+		path:    path,
+		imdbIDs: map[string]struct{}{},
+		lock:    &sync.Mutex{},
+	}
+}
+
+// load returns the persisted IMDb IDs in the order in which they were added. A non-existing file is not an error.
+func (i imdbIDIndex) load() ([]string, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	file, err := os.Open(i.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't open IMDb ID index file: %v", err)
+	}
+	defer file.Close()
+	var result []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		imdbID := strings.TrimSpace(scanner.Text())
+		if _, ok := i.imdbIDs[imdbID]; ok || imdbID == "" {
+			continue
+		}
+		i.imdbIDs[imdbID] = struct{}{}
+		result = append(result, imdbID)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Couldn't read IMDb ID index file: %v", err)
+	}
+	return result, nil
+}
+
+// add appends the IMDb ID to the file, unless it's already in there.
+func (i imdbIDIndex) add(imdbID string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, ok := i.imdbIDs[imdbID]; ok {
+		return nil
+	}
+	file, err := os.OpenFile(i.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Couldn't open IMDb ID index file: %v", err)
+	}
+	if _, err = file.WriteString(imdbID + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("Couldn't write to IMDb ID index file: %v", err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("Couldn't close IMDb ID index file: %v", err)
+	}
+	i.imdbIDs[imdbID] = struct{}{}
+	return nil
+}
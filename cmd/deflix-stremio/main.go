@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -30,7 +29,7 @@ const (
 var manifest = stremio.Manifest{
 	ID:          "tv.deflix.stremio",
 	Name:        "Deflix - Debrid flicks",
-	Description: "Looks up your selected movie on YTS, The Pirate Bay, 1337x and ibit and automatically turns your selected torrent into a debrid/cached stream, for high speed and no P2P uploading (!). Currently supported providers: real-debrid.com (more coming in the future!).",
+	Description: "Looks up your selected movie on YTS, The Pirate Bay, 1337x, ibit and Solid Torrents and automatically turns your selected torrent into a debrid/cached stream, for high speed and no P2P uploading (!). Currently supported providers: real-debrid.com (more coming in the future!).",
 	Version:     version,
 
 	ResourceItems: []stremio.ResourceItem{
@@ -100,22 +99,134 @@ func main() {
 	}
 	config.CachePath += "/cache"
 	cacheMaxBytes := config.CacheMaxMB * 1000 * 1000
-	tokenCache = fastcache.LoadFromFileOrNew(config.CachePath+"/token", cacheMaxBytes/5)
-	availabilityCache = fastcache.LoadFromFileOrNew(config.CachePath+"/availability", cacheMaxBytes/5)
-	torrentCache = fastcache.LoadFromFileOrNew(config.CachePath+"/torrent", cacheMaxBytes/5)
-	redirectCache = fastcache.LoadFromFileOrNew(config.CachePath+"/redirect", cacheMaxBytes/5)
-	cinemataCache = fastcache.LoadFromFileOrNew(config.CachePath+"/cinemata", cacheMaxBytes/5)
+	// Left behind when the process was killed while persisting the caches
+	if err := removeTempCacheDirs(config.CachePath); err != nil {
+		log.WithError(err).Warn("Couldn't remove temporary cache directories")
+	}
+	tokenCache = loadCache(config.CachePath+"/token", cacheMaxBytes/5)
+	availabilityCache = loadCache(config.CachePath+"/availability", cacheMaxBytes/5)
+	torrentCache = loadCache(config.CachePath+"/torrent", cacheMaxBytes/5)
+	redirectCache = loadCache(config.CachePath+"/redirect", cacheMaxBytes/5)
+	cinemataCache = loadCache(config.CachePath+"/cinemata", cacheMaxBytes/5)
+
+	// Info hashes that were blocked at runtime via the admin endpoint
+	if err := os.MkdirAll(config.CachePath, 0755); err != nil {
+		log.WithError(err).Fatal("Couldn't create cache directory")
+	}
+	blocklist := newBlocklistFile(config.CachePath + "/blocked-info-hashes.txt")
+	persistedInfoHashes, err := blocklist.load()
+	if err != nil {
+		log.WithError(err).Fatal("Couldn't load persisted blocked info hashes")
+	}
+	config.BlockedInfoHashes = append(config.BlockedInfoHashes, persistedInfoHashes...)
+
+	// IMDb IDs of searched movies, for warming the torrent cache on the next startup
+	var imdbIndex *imdbIDIndex
+	if config.WarmOnStartup {
+		index := newIMDbIDIndex(config.CachePath + "/imdb-ids.txt")
+		imdbIndex = &index
+	}
 
 	// Create clients
 
-	searchClient, err := imdb2torrent.NewClient(mainCtx, config.BaseURLyts, config.BaseURLtpb, config.BaseURL1337x, config.BaseURLibit, config.SocksProxyAddrTPB, 5*time.Second, config.TPBretries, torrentCache, cinemataCache, config.CacheAgeTorrents)
+	searchClient, err := imdb2torrent.NewClient(mainCtx,
+		imdb2torrent.WithBaseURL("YTS", config.BaseURLyts),
+		imdb2torrent.WithBaseURL("TPB", config.BaseURLtpb),
+		imdb2torrent.WithBaseURL("1337x", config.BaseURL1337x),
+		imdb2torrent.WithBaseURL("ibit", config.BaseURLibit),
+		imdb2torrent.WithBaseURL("SolidTorrents", config.BaseURLsolidTorrents),
+		imdb2torrent.WithRateLimit("YTS", config.RateLimitYTS),
+		imdb2torrent.WithRateLimit("TPB", config.RateLimitTPB),
+		imdb2torrent.WithRateLimit("1337x", config.RateLimit1337x),
+		imdb2torrent.WithRateLimit("ibit", config.RateLimitIbit),
+		imdb2torrent.WithRateLimit("SolidTorrents", config.RateLimitSolidTorrents),
+		imdb2torrent.WithScrapeBudget(config.ScrapeBudget),
+		imdb2torrent.WithSiteWeights(config.SiteWeights),
+		imdb2torrent.WithTPBProxy(config.SocksProxyAddrTPB, config.SocksProxyUserTPB, config.SocksProxyPasswordTPB),
+		imdb2torrent.WithTimeout(5*time.Second),
+		imdb2torrent.WithSlowScrapeThreshold(config.SlowScrapeThreshold),
+		imdb2torrent.WithSiteDeadline(config.SiteDeadline),
+		imdb2torrent.WithIbit(config.MaxDurationIbit, config.SyncIbit, config.ParallelIbitMirrors),
+		imdb2torrent.WithTPBRetries(config.TPBretries, config.RetryEmptyTPB),
+		imdb2torrent.WithEmptyRetry("1337x", config.RetryEmpty1337x),
+		imdb2torrent.WithEmptyRetry("ibit", config.RetryEmptyIbit),
+		imdb2torrent.WithYTS(config.CollapseTorrentsYTS, config.MovieDetailsYTS),
+		imdb2torrent.With1337xConcurrency(config.Concurrency1337x),
+		imdb2torrent.With1337xMaxResults(config.MaxResults1337x),
+		imdb2torrent.WithTrackers(config.MergeTrackers, config.MaxTrackers),
+		imdb2torrent.WithAllowedTrackerHosts(config.AllowedTrackerHosts),
+		imdb2torrent.WithExcludedCam(config.ExcludeCam),
+		imdb2torrent.WithFuzzyDedup(config.FuzzyDedup),
+		imdb2torrent.WithTorrentCache(torrentCache, config.CacheAgeTorrents, config.CacheAgeJitterTorrents),
+		imdb2torrent.WithCinemataCache(cinemataCache, config.CacheAgeCinemata),
+		imdb2torrent.WithCinemataConcurrency(config.ConcurrencyCinemata),
+		imdb2torrent.WithRefreshWindow(config.RefreshWindowTorrents),
+		imdb2torrent.WithMinCachedResults(config.MinCachedTorrents),
+		imdb2torrent.WithCompressedCache(config.CompressCache),
+		imdb2torrent.WithDNSRetries(config.DNSretries),
+		imdb2torrent.WithConnectionPooling(config.MaxIdleConnsPerHost, config.IdleConnTimeout, config.DisableKeepAlives),
+		imdb2torrent.WithTitleMatching(config.TitleMatching),
+		imdb2torrent.WithBlockedInfoHashes(config.BlockedInfoHashes),
+		imdb2torrent.WithSizeLimits(config.MinSize, config.MaxSize, config.DropUnknownSize),
+		imdb2torrent.WithGroupFilter(config.IncludeGroups, config.ExcludeGroups, config.DropUnknownGroup),
+		imdb2torrent.WithCoalescedSearches(config.CoalesceSearches),
+		imdb2torrent.WithQualityPreference(config.QualityPreference),
+		imdb2torrent.WithRemuxPreference(config.RemuxPreference),
+		imdb2torrent.WithExcludedQualities(config.ExcludeQualities),
+		imdb2torrent.WithExcludedSources(config.ExcludeSources),
+		imdb2torrent.WithUnknownQuality(config.KeepUnknownQuality),
+		imdb2torrent.WithAllYears(config.IncludeAllYears),
+		imdb2torrent.WithDegradedErrRate(config.DegradedErrRate),
+		imdb2torrent.WithErrorHistory(config.ErrorHistorySize),
+		imdb2torrent.WithEstimatedBitrate(config.EstimateBitrate),
+	)
 	if err != nil {
 		log.WithError(err).Fatal("Couldn't create torrent search client")
 	}
-	conversionClient, err := realdebrid.NewClient(mainCtx, 5*time.Second, tokenCache, availabilityCache, config.CacheAgeRD, config.BaseURLrd, config.ExtraHeadersRD)
+	searchClient.OnSiteDegraded(func(site string, errRate float64) {
+		log.WithFields(log.Fields{"torrentSite": site, "errRate": errRate}).Error("High error rate for torrent site")
+	})
+	// Fail fast instead of responding to each request without any torrents
+	if len(searchClient.ActiveSites()) == 0 {
+		log.WithError(imdb2torrent.ErrNoActiveSites).Fatal("All torrent sites are disabled, at least one torrent site base URL must be set")
+	}
+	// Sites that need Cinemata only find torrents by the movie title, which can be the wrong movie
+	capabilities := searchClient.SearcherCapabilities()
+	imdbSites := 0
+	for _, torrentSite := range searchClient.ActiveSites() {
+		if capabilities[torrentSite].IMDbID {
+			imdbSites++
+		}
+	}
+	if imdbSites == 0 {
+		log.Warn("None of the active torrent sites can be searched by IMDb ID, so all search results depend on Cinemata and are guessed matches")
+	}
+	if config.CheckSitesOnStartup {
+		if err := searchClient.CheckSites(mainCtx); err != nil {
+			log.WithError(err).Fatal("Couldn't reach any torrent site")
+		}
+	}
+	if imdbIndex != nil {
+		imdbIDs, err := imdbIndex.load()
+		if err != nil {
+			log.WithError(err).Error("Couldn't load IMDb IDs for warming the cache")
+		}
+		// Refreshes are spread out by the rate limits, so they can take a while
+		go func() {
+			log.WithField("imdbIDCount", len(imdbIDs)).Info("Warming torrent cache...")
+			refreshed, err := searchClient.Warm(mainCtx, imdbIDs)
+			if err != nil {
+				log.WithError(err).Warn("Couldn't finish warming torrent cache")
+			}
+			log.WithField("refreshedCount", refreshed).Info("Warmed torrent cache")
+		}()
+	}
+	conversionClient, err := realdebrid.NewClient(mainCtx, 5*time.Second, tokenCache, availabilityCache, config.CacheAgeRD, config.CacheAgeUnavailableRD, config.BaseURLrd, config.ExtraHeadersRD)
 	if err != nil {
 		log.WithError(err).Fatal("Couldn't create RealDebrid client")
 	}
+	// The debrid services that users can have API tokens for, see DebridResolver
+	resolvers := []DebridResolver{conversionClient}
 
 	// Basic middleware and health endpoint
 
@@ -126,7 +237,7 @@ func main() {
 		createCorsMiddleware(mainCtx), // Stremio doesn't show stream responses when no CORS middleware is used!
 		handlers.ProxyHeaders,
 		recoveryMiddleware,
-		createLoggingMiddleware(mainCtx, cinemataCache))
+		createLoggingMiddleware(mainCtx, cinemataCache, config.CacheAgeCinemata))
 	s.HandleFunc("/health", healthHandler)
 	// Requires URL query: "?imdbid=123&apitoken=foo"
 	caches := map[string]*fastcache.Cache{
@@ -141,22 +252,44 @@ func main() {
 	// Stremio endpoints
 
 	// Use token middleware only for the Stremio endpoints
-	tokenMiddleware := createTokenMiddleware(mainCtx, conversionClient)
+	tokenMiddleware := createTokenMiddleware(mainCtx, resolvers)
 	manifestHandler := createManifestHandler(mainCtx, conversionClient)
-	streamHandler := createStreamHandler(mainCtx, config, searchClient, conversionClient, redirectCache)
-	s.HandleFunc("/{apitoken}/manifest.json", tokenMiddleware(manifestHandler).ServeHTTP)
-	s.HandleFunc("/{apitoken}/stream/{type}/{id}.json", tokenMiddleware(streamHandler).ServeHTTP)
+	streamHandler := createStreamHandler(mainCtx, config, searchClient, redirectCache, imdbIndex)
+	if config.MagnetsOnly {
+		// No RealDebrid API token required
+		s.HandleFunc("/manifest.json", manifestHandler)
+		s.HandleFunc("/stream/{type}/{id}.json", streamHandler)
+	} else {
+		s.HandleFunc("/{apitoken}/manifest.json", tokenMiddleware(manifestHandler).ServeHTTP)
+		s.HandleFunc("/{apitoken}/stream/{type}/{id}.json", tokenMiddleware(streamHandler).ServeHTTP)
+	}
 
 	// Additional endpoints
 
-	// Redirects stream URLs (previously sent to Stremio) to the actual RealDebrid stream URLs
-	s.HandleFunc("/redirect/{id}", createRedirectHandler(mainCtx, redirectCache, conversionClient))
+	// Redirects stream URLs (previously sent to Stremio) to the actual stream URLs of the debrid services
+	s.HandleFunc("/redirect/{id}", createRedirectHandler(mainCtx, redirectCache, searchClient, resolvers))
+	// Debug endpoint, which causes a full scrape of all torrent sites on each request
+	if config.DebugScrape {
+		s.HandleFunc("/debug/scrape", createDebugScrapeHandler(mainCtx, config.DebugToken, searchClient))
+	}
 	// Root redirects to website
 	s.HandleFunc("/", createRootHandler(mainCtx, config))
 
+	// Admin endpoints
+
+	if config.AdminToken != "" {
+		a := r.Methods("POST").PathPrefix("/admin").Subrouter()
+		a.Use(createTimerMiddleware(mainCtx),
+			handlers.ProxyHeaders,
+			recoveryMiddleware,
+			createLoggingMiddleware(mainCtx, cinemataCache, config.CacheAgeCinemata))
+		// Requires form values: "infoHash=123" and optionally "imdbID=tt123"
+		a.HandleFunc("/block", createBlockHandler(mainCtx, config.AdminToken, searchClient, blocklist))
+	}
+
 	srv := &http.Server{
 		Addr:    config.BindAddr + ":" + strconv.Itoa(config.Port),
-		Handler: s,
+		Handler: r,
 		// Timeouts to avoid Slowloris attacks
 		ReadTimeout:    time.Second * 5,
 		WriteTimeout:   time.Second * 15,
@@ -194,7 +327,7 @@ func main() {
 		}
 	}()
 
-	// Print cache stats every hour
+	// Print cache and scrape budget stats every hour
 	go func() {
 		// Don't run at the same time as the persistence
 		time.Sleep(time.Minute)
@@ -215,6 +348,9 @@ func main() {
 			cinemataCache.UpdateStats(&stats)
 			logCacheStats(mainCtx, stats, "cinemata")
 			stats.Reset()
+			if config.ScrapeBudget > 0 {
+				logScrapeBudgetStats(mainCtx, searchClient.ScrapeBudgetStats())
+			}
 
 			time.Sleep(time.Hour)
 		}
@@ -236,6 +372,10 @@ func main() {
 		log.WithError(err).Fatal("Error shutting down server")
 	}
 	log.Info("Server shut down")
+	// For example refreshes of cache entries
+	if err := searchClient.WaitForBackgroundTasks(ctx); err != nil {
+		log.WithError(err).Warn("Background tasks didn't finish in time")
+	}
 }
 
 func persistCache(ctx context.Context, cacheFilePath string, stoppingPtr *bool) {
@@ -245,19 +385,19 @@ func persistCache(ctx context.Context, cacheFilePath string, stoppingPtr *bool)
 	}
 
 	log.WithField("cacheFilePath", cacheFilePath).Info("Persisting caches...")
-	if err := tokenCache.SaveToFileConcurrent(cacheFilePath+"/token", runtime.NumCPU()); err != nil {
+	if err := saveCache(tokenCache, cacheFilePath+"/token"); err != nil {
 		log.WithError(err).WithField("cache", "token").Error("Couldn't save cache to file")
 	}
-	if err := availabilityCache.SaveToFileConcurrent(cacheFilePath+"/availability", runtime.NumCPU()); err != nil {
+	if err := saveCache(availabilityCache, cacheFilePath+"/availability"); err != nil {
 		log.WithError(err).WithField("cache", "availability").Error("Couldn't save cache to file")
 	}
-	if err := torrentCache.SaveToFileConcurrent(cacheFilePath+"/torrent", runtime.NumCPU()); err != nil {
+	if err := saveCache(torrentCache, cacheFilePath+"/torrent"); err != nil {
 		log.WithError(err).WithField("cache", "torrent").Error("Couldn't save cache to file")
 	}
-	if err := redirectCache.SaveToFileConcurrent(cacheFilePath+"/redirect", runtime.NumCPU()); err != nil {
+	if err := saveCache(redirectCache, cacheFilePath+"/redirect"); err != nil {
 		log.WithError(err).WithField("cache", "redirect").Error("Couldn't save cache to file")
 	}
-	if err := cinemataCache.SaveToFileConcurrent(cacheFilePath+"/cinemata", runtime.NumCPU()); err != nil {
+	if err := saveCache(cinemataCache, cacheFilePath+"/cinemata"); err != nil {
 		log.WithError(err).WithField("cache", "cinemata").Error("Couldn't save cache to file")
 	}
 	log.Info("Persisted caches")
@@ -277,6 +417,15 @@ func logCacheStats(ctx context.Context, stats fastcache.Stats, cacheName string)
 	log.WithFields(fields).Info("Cache stats")
 }
 
+func logScrapeBudgetStats(ctx context.Context, stats imdb2torrent.ScrapeBudgetStats) {
+	fields := log.Fields{
+		"Requests": stats.Requests,
+		"Delayed":  stats.Delayed,
+		"WaitTime": stats.WaitTime,
+	}
+	log.WithFields(fields).Info("Scrape budget stats")
+}
+
 func setLogLevel(cfg config) {
 	switch cfg.LogLevel {
 	case "trace":
@@ -13,7 +13,6 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/doingodswork/deflix-stremio/pkg/cinemata"
-	"github.com/doingodswork/deflix-stremio/pkg/realdebrid"
 )
 
 func createTimerMiddleware(ctx context.Context) func(http.Handler) http.Handler {
@@ -58,7 +57,9 @@ func createCorsMiddleware(ctx context.Context) func(http.Handler) http.Handler {
 
 var recoveryMiddleware = handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))
 
-func createTokenMiddleware(ctx context.Context, conversionClient realdebrid.Client) func(http.Handler) http.Handler {
+// createTokenMiddleware creates a middleware that parses and tests the API tokens of the debrid resolvers from the URL, see parseAPITokens().
+// The resolvers with the user's tokens are stored in the request context, in the order of the given resolvers.
+func createTokenMiddleware(ctx context.Context, resolvers []DebridResolver) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			rCtx := r.Context()
@@ -73,12 +74,20 @@ func createTokenMiddleware(ctx context.Context, conversionClient realdebrid.Clie
 				remote = true
 				apiToken = strings.TrimSuffix(apiToken, "-remote")
 			}
-			if err := conversionClient.TestToken(rCtx, apiToken); err != nil {
-				w.WriteHeader(http.StatusForbidden)
+			resolverTokens, err := parseAPITokens(apiToken, resolvers)
+			if err != nil {
+				log.WithContext(rCtx).WithError(err).Debug("Couldn't parse API token")
+				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
+			for _, resolverToken := range resolverTokens {
+				if err := resolverToken.resolver.TestToken(rCtx, resolverToken.apiToken); err != nil {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
 
-			rCtx = context.WithValue(rCtx, "apitoken", apiToken)
+			rCtx = context.WithValue(rCtx, "apitokens", resolverTokens)
 			rCtx = context.WithValue(rCtx, "remote", remote)
 			newReq := r.WithContext(rCtx)
 			next.ServeHTTP(w, newReq)
@@ -86,9 +95,9 @@ func createTokenMiddleware(ctx context.Context, conversionClient realdebrid.Clie
 	}
 }
 
-func createLoggingMiddleware(ctx context.Context, cinemataCache *fastcache.Cache) func(http.Handler) http.Handler {
-	// Only 1 second to allow for cache retrieval. The data should be cached from the 1337x scraper.
-	cinemataClient := cinemata.NewClient(ctx, 1*time.Second, cinemataCache)
+func createLoggingMiddleware(ctx context.Context, cinemataCache *fastcache.Cache, cacheAgeCinemata time.Duration) func(http.Handler) http.Handler {
+	// Only cache retrieval, via cinemata.WithCacheOnly(). The data should be cached from the 1337x scraper.
+	cinemataClient := cinemata.NewClient(ctx, 1*time.Second, cinemataCache, cacheAgeCinemata, 0)
 	return func(before http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			rCtx := r.Context()
@@ -106,13 +115,16 @@ func createLoggingMiddleware(ctx context.Context, cinemataCache *fastcache.Cache
 				params := mux.Vars(r)
 				if strings.Contains(r.URL.String(), "/stream/") {
 					imdbID = params["id"]
+				} else if id, err := DecodeStreamID(params["id"]); err != nil {
+					// The redirect handler already responded with an error, there's no movie to log
+					logMovie = false
 				} else {
-					redirectID := params["id"]
-					idParts := strings.Split(redirectID, "-")
-					imdbID = idParts[2]
+					imdbID = id.IMDbID
 				}
 				if imdbID != "" {
-					if movieName, movieYear, err := cinemataClient.GetMovieNameYear(rCtx, imdbID); err != nil {
+					if movieName, movieYear, err := cinemataClient.GetMovieNameYear(cinemata.WithCacheOnly(rCtx), imdbID); err == cinemata.ErrCacheMiss {
+						log.WithContext(ctx).WithField("imdbID", imdbID).Debug("Movie name and year for request logger not in cache")
+					} else if err != nil {
 						log.WithContext(ctx).WithError(err).Warn("Couldn't get movie name and year for request logger")
 					} else {
 						movie = movieName + " " + strconv.Itoa(movieYear)
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	hook := test.NewGlobal()
+	t.Cleanup(hook.Reset)
+	redirectID := EncodeStreamID(StreamID{APIToken: "ABC123", IMDbID: "tt1254207", Quality: "1080p"})
+	tests := []struct {
+		name        string
+		path        string
+		id          string
+		expectMovie bool
+	}{
+		{"stream", "/ABC123/stream/movie/tt1254207.json", "tt1254207", true},
+		{"redirect", "/redirect/" + redirectID, redirectID, true},
+		{"malformed redirect ID", "/redirect/foo", "foo", false},
+		{"redirect ID with too few parts", "/redirect/ABC123-tt1254207", "ABC123-tt1254207", false},
+		{"manifest", "/ABC123/manifest.json", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			handler := createLoggingMiddleware(context.Background(), fastcache.New(testCacheSize), time.Hour)(next)
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req = req.WithContext(context.WithValue(req.Context(), "start", time.Now()))
+			req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			entry := hook.LastEntry()
+			if entry == nil || entry.Message != "Handled request" {
+				t.Fatalf("Expected the request to be logged, got: %+v", entry)
+			}
+			if _, ok := entry.Data["movie"]; ok != tt.expectMovie {
+				t.Errorf("Expected the movie field to be logged: %v, got fields %v", tt.expectMovie, entry.Data)
+			}
+		})
+	}
+}
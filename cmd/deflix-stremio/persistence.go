@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/VictoriaMetrics/fastcache"
+	log "github.com/sirupsen/logrus"
+)
+
+// A persisted cache consists of up to three snapshots, each of them a directory as written by fastcache:
+// The current one at the file path, the previous one with the backup suffix and the one that's being written with the new suffix.
+// fastcache itself only writes to a temporary directory and then renames it, so a snapshot that exists is complete,
+// unless it was corrupted afterwards, for example by a full disk or by a crash of the machine before the data was synced.
+const (
+	cacheSnapshotSuffixNew    = ".new"
+	cacheSnapshotSuffixBackup = ".bak"
+)
+
+// saveCache persists the cache to the file path without ever removing the last good snapshot before the next one is complete.
+// The cache is first saved to a new snapshot, then the current snapshot becomes the backup and the new snapshot becomes the current one.
+// When the process is killed at any point in between, loadCache() still finds a complete snapshot.
+func saveCache(cache *fastcache.Cache, filePath string) error {
+	newPath := filePath + cacheSnapshotSuffixNew
+	if err := cache.SaveToFileConcurrent(newPath, runtime.NumCPU()); err != nil {
+		return fmt.Errorf("Couldn't save new snapshot: %v", err)
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		backupPath := filePath + cacheSnapshotSuffixBackup
+		if err := os.RemoveAll(backupPath); err != nil {
+			return fmt.Errorf("Couldn't remove old backup snapshot: %v", err)
+		}
+		if err := os.Rename(filePath, backupPath); err != nil {
+			return fmt.Errorf("Couldn't turn current snapshot into backup: %v", err)
+		}
+	}
+	if err := os.Rename(newPath, filePath); err != nil {
+		return fmt.Errorf("Couldn't turn new snapshot into current one: %v", err)
+	}
+	return nil
+}
+
+// loadCache loads the most recent snapshot of the cache that was persisted via saveCache(), with the given max size.
+// Snapshots that can't be loaded, for example because they were truncated, are skipped in favor of the next older one.
+// When no snapshot can be loaded, a new cache is returned.
+// A snapshot that's empty or was persisted with a different max size is treated like one that can't be loaded,
+// because fastcache doesn't return an error for them. For the latter, the older snapshots have the same size, so a new cache is returned as before.
+func loadCache(filePath string, maxBytes int) *fastcache.Cache {
+	logger := log.WithField("cacheFilePath", filePath)
+	for i, suffix := range cacheSnapshotOrder(filePath) {
+		cache := fastcache.LoadFromFileOrNew(filePath+suffix, maxBytes)
+		stats := fastcache.Stats{}
+		cache.UpdateStats(&stats)
+		if stats.EntriesCount > 0 {
+			if i > 0 {
+				logger.WithField("snapshot", suffix).Warn("Loaded cache from an older snapshot, because the most recent one couldn't be loaded")
+			}
+			return cache
+		}
+		cache.Reset()
+		logger.WithField("snapshot", suffix).Warn("Couldn't load cache snapshot, it's either empty, incomplete or has a different max size")
+	}
+	return fastcache.New(maxBytes)
+}
+
+// cacheSnapshotOrder returns the suffixes of the existing snapshots of the persisted cache, from the most recent to the oldest one.
+// The new snapshot only exists when the process was killed before it became the current one. Then it's more recent than the current one,
+// unless it's left over from an earlier save that failed, so their modification times decide.
+func cacheSnapshotOrder(filePath string) []string {
+	var suffixes []string
+	current, currentErr := os.Stat(filePath)
+	if currentErr == nil {
+		suffixes = append(suffixes, "")
+	}
+	if newSnapshot, err := os.Stat(filePath + cacheSnapshotSuffixNew); err == nil {
+		if currentErr == nil && newSnapshot.ModTime().After(current.ModTime()) {
+			suffixes = append([]string{cacheSnapshotSuffixNew}, suffixes...)
+		} else {
+			suffixes = append(suffixes, cacheSnapshotSuffixNew)
+		}
+	}
+	if _, err := os.Stat(filePath + cacheSnapshotSuffixBackup); err == nil {
+		suffixes = append(suffixes, cacheSnapshotSuffixBackup)
+	}
+	return suffixes
+}
+
+// removeTempCacheDirs removes the temporary directories that fastcache leaves behind when the process is killed while it saves a cache.
+func removeTempCacheDirs(dir string) error {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Couldn't read cache directory: %v", err)
+	}
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() && strings.HasPrefix(fileInfo.Name(), "fastcache.tmp.") {
+			if err := os.RemoveAll(filepath.Join(dir, fileInfo.Name())); err != nil {
+				return fmt.Errorf("Couldn't remove temporary directory: %v", err)
+			}
+		}
+	}
+	return nil
+}
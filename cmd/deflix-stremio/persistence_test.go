@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+)
+
+const testCacheSize = 32 * 1024 * 1024
+
+// tempDir creates a temporary directory that's removed when the test is finished.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "deflix-stremio-test")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeSnapshot saves a cache whose only entry contains the name of the snapshot to the path, with the given modification time.
+func writeSnapshot(t *testing.T, path, name string, modTime time.Time) {
+	t.Helper()
+	cache := fastcache.New(testCacheSize)
+	cache.Set([]byte("snapshot"), []byte(name))
+	if err := cache.SaveToFile(path); err != nil {
+		t.Fatalf("Couldn't save snapshot: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Couldn't set modification time: %v", err)
+	}
+}
+
+// truncateSnapshot truncates all files of the snapshot to half of their size, like a write that was interrupted.
+func truncateSnapshot(t *testing.T, path string) {
+	t.Helper()
+	fileInfos, err := ioutil.ReadDir(path)
+	if err != nil {
+		t.Fatalf("Couldn't read snapshot: %v", err)
+	}
+	for _, fileInfo := range fileInfos {
+		if err := os.Truncate(filepath.Join(path, fileInfo.Name()), fileInfo.Size()/2); err != nil {
+			t.Fatalf("Couldn't truncate snapshot file: %v", err)
+		}
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+	type snapshot struct {
+		suffix    string
+		modTime   time.Time
+		truncated bool
+	}
+	tests := []struct {
+		name      string
+		snapshots []snapshot
+		// Suffix of the snapshot that's expected to be loaded, or "none" for a new cache
+		expected string
+	}{
+		{"no snapshot", nil, "none"},
+		{"current", []snapshot{{"", newer, false}, {cacheSnapshotSuffixBackup, older, false}}, ""},
+		{"truncated current", []snapshot{{"", newer, true}, {cacheSnapshotSuffixBackup, older, false}}, cacheSnapshotSuffixBackup},
+		{"only backup", []snapshot{{cacheSnapshotSuffixBackup, older, false}}, cacheSnapshotSuffixBackup},
+		{"newer new snapshot", []snapshot{{"", older, false}, {cacheSnapshotSuffixNew, newer, false}}, cacheSnapshotSuffixNew},
+		{"older new snapshot", []snapshot{{"", newer, false}, {cacheSnapshotSuffixNew, older, false}}, ""},
+		{"truncated newer new snapshot", []snapshot{{"", older, false}, {cacheSnapshotSuffixNew, newer, true}}, ""},
+		{"killed before rename", []snapshot{{cacheSnapshotSuffixNew, newer, false}, {cacheSnapshotSuffixBackup, older, false}}, cacheSnapshotSuffixNew},
+		{"all truncated", []snapshot{{"", newer, true}, {cacheSnapshotSuffixNew, newer, true}, {cacheSnapshotSuffixBackup, older, true}}, "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(tempDir(t), "cache")
+			for _, s := range tt.snapshots {
+				writeSnapshot(t, filePath+s.suffix, "snapshot"+s.suffix, s.modTime)
+				if s.truncated {
+					truncateSnapshot(t, filePath+s.suffix)
+				}
+			}
+
+			cache := loadCache(filePath, testCacheSize)
+			loaded := cache.Get(nil, []byte("snapshot"))
+			if tt.expected == "none" {
+				if len(loaded) != 0 {
+					t.Errorf("Expected a new cache, got the one of %s", loaded)
+				}
+				return
+			}
+			if string(loaded) != "snapshot"+tt.expected {
+				t.Errorf("Expected the cache of snapshot%v, got %q", tt.expected, loaded)
+			}
+		})
+	}
+}
+
+func TestSaveCache(t *testing.T) {
+	filePath := filepath.Join(tempDir(t), "cache")
+	for _, name := range []string{"first", "second"} {
+		cache := fastcache.New(testCacheSize)
+		cache.Set([]byte("snapshot"), []byte(name))
+		if err := saveCache(cache, filePath); err != nil {
+			t.Fatalf("Couldn't save cache: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(filePath + cacheSnapshotSuffixNew); !os.IsNotExist(err) {
+		t.Errorf("Expected no new snapshot after saving, got: %v", err)
+	}
+	tests := []struct {
+		suffix   string
+		expected string
+	}{
+		{"", "second"},
+		{cacheSnapshotSuffixBackup, "first"},
+	}
+	for _, tt := range tests {
+		cache := fastcache.LoadFromFileOrNew(filePath+tt.suffix, testCacheSize)
+		if loaded := cache.Get(nil, []byte("snapshot")); string(loaded) != tt.expected {
+			t.Errorf("Expected snapshot %q at suffix %q, got %q", tt.expected, tt.suffix, loaded)
+		}
+	}
+}
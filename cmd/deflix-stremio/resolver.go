@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/doingodswork/deflix-stremio/pkg/realdebrid"
+)
+
+// DebridResolver is a debrid service that turns torrents into streamable video URLs.
+// Multiple resolvers can be configured, then the stream handler checks each one that the user has an API token for and responds with a stream per resolver that has the torrent cached.
+type DebridResolver interface {
+	// Name returns the short name of the debrid service, like "RD". It's used in API tokens, stream IDs and stream titles, so it must not contain dots, commas or dashes.
+	Name() string
+	TestToken(ctx context.Context, apiToken string) error
+	// CheckInstantAvailability returns the info hashes of the torrents that the debrid service has cached.
+	CheckInstantAvailability(ctx context.Context, apiToken string, infoHashes ...string) []string
+	GetStreamURL(ctx context.Context, magnetURL, apiToken string, remote bool) (string, error)
+	// InvalidateAvailability removes the cached instant availability of the info hashes, for example after a torrent was added to the debrid service.
+	InvalidateAvailability(infoHashes ...string)
+}
+
+var _ DebridResolver = realdebrid.Client{}
+
+// findResolver returns the resolver with the given name.
+// An empty name is the one of stream IDs that were created before there were multiple resolvers, which are all RealDebrid ones.
+func findResolver(resolvers []DebridResolver, name string) (DebridResolver, bool) {
+	if name == "" {
+		name = realdebrid.Name
+	}
+	for _, resolver := range resolvers {
+		if resolver.Name() == name {
+			return resolver, true
+		}
+	}
+	return nil, false
+}
+
+// parseAPITokens parses the API token from the addon URL into the API tokens per resolver name.
+// The value is either a single API token for RealDebrid, like "ABC123", or comma-separated API tokens prefixed with the resolver name and a dot, like "RD.ABC123,PM.DEF456".
+// The tokens are returned in the order of the given resolvers, so that the streams of the resolvers are always listed in the same order.
+func parseAPITokens(val string, resolvers []DebridResolver) ([]resolverToken, error) {
+	if val == "" {
+		return nil, errors.New("Empty API token")
+	}
+	if !strings.Contains(val, ".") && !strings.Contains(val, ",") {
+		val = realdebrid.Name + "." + val
+	}
+	apiTokens := map[string]string{}
+	for _, part := range strings.Split(val, ",") {
+		partParts := strings.SplitN(part, ".", 2)
+		if len(partParts) != 2 || partParts[0] == "" || partParts[1] == "" {
+			return nil, fmt.Errorf("API token isn't prefixed with a resolver name: %v", part)
+		}
+		if _, ok := findResolver(resolvers, partParts[0]); !ok {
+			return nil, fmt.Errorf("Unknown resolver: %v", partParts[0])
+		}
+		if _, ok := apiTokens[partParts[0]]; ok {
+			return nil, fmt.Errorf("Duplicate API token for resolver: %v", partParts[0])
+		}
+		apiTokens[partParts[0]] = partParts[1]
+	}
+	var result []resolverToken
+	for _, resolver := range resolvers {
+		if apiToken, ok := apiTokens[resolver.Name()]; ok {
+			result = append(result, resolverToken{resolver, apiToken})
+		}
+	}
+	return result, nil
+}
+
+// resolverToken is a resolver with the user's API token for it.
+type resolverToken struct {
+	resolver DebridResolver
+	apiToken string
+}
+
+// checkInstantAvailability checks the instant availability of the info hashes with all resolvers *in parallel*, like imdb2torrent.Client.FindMagnets() searches the torrent sites.
+// The returned slice contains the available info hashes of each resolver, in the order of the given resolvers.
+// The resolvers log their errors themselves and then report no available info hashes, so there's no error to return.
+func checkInstantAvailability(ctx context.Context, resolverTokens []resolverToken, infoHashes []string) [][]string {
+	result := make([][]string, len(resolverTokens))
+	wg := sync.WaitGroup{}
+	wg.Add(len(resolverTokens))
+	for i, rt := range resolverTokens {
+		go func(goIndex int, goResolverToken resolverToken) {
+			defer wg.Done()
+			// Each goroutine only writes its own element, so no lock is required
+			result[goIndex] = goResolverToken.resolver.CheckInstantAvailability(ctx, goResolverToken.apiToken, infoHashes...)
+		}(i, rt)
+	}
+	wg.Wait()
+	return result
+}
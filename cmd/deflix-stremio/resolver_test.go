@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/gorilla/mux"
+
+	"github.com/doingodswork/deflix-stremio/pkg/imdb2torrent"
+	"github.com/doingodswork/deflix-stremio/pkg/realdebrid"
+)
+
+// mockResolver is a debrid resolver that has the given info hashes cached and records the magnet URLs it converted.
+type mockResolver struct {
+	name        string
+	available   []string
+	lock        *sync.Mutex
+	converted   []string
+	invalidated []string
+}
+
+func newMockResolver(name string, available ...string) *mockResolver {
+	return &mockResolver{name: name, available: available, lock: &sync.Mutex{}}
+}
+
+func (r *mockResolver) Name() string {
+	return r.name
+}
+
+func (r *mockResolver) TestToken(ctx context.Context, apiToken string) error {
+	if apiToken == "invalid" {
+		return errors.New("Invalid token")
+	}
+	return nil
+}
+
+func (r *mockResolver) CheckInstantAvailability(ctx context.Context, apiToken string, infoHashes ...string) []string {
+	var result []string
+	for _, infoHash := range infoHashes {
+		for _, available := range r.available {
+			if infoHash == available {
+				result = append(result, infoHash)
+			}
+		}
+	}
+	return result
+}
+
+func (r *mockResolver) GetStreamURL(ctx context.Context, magnetURL, apiToken string, remote bool) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.converted = append(r.converted, magnetURL)
+	return "https://" + strings.ToLower(r.name) + ".example/" + apiToken, nil
+}
+
+func (r *mockResolver) InvalidateAvailability(infoHashes ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.invalidated = append(r.invalidated, infoHashes...)
+}
+
+func TestParseAPITokens(t *testing.T) {
+	rd := newMockResolver(realdebrid.Name)
+	pm := newMockResolver("PM")
+	resolvers := []DebridResolver{rd, pm}
+	tests := []struct {
+		name      string
+		val       string
+		expected  []resolverToken
+		expectErr bool
+	}{
+		{"single RealDebrid token", "ABC123", []resolverToken{{rd, "ABC123"}}, false},
+		{"prefixed token", "PM.DEF456", []resolverToken{{pm, "DEF456"}}, false},
+		{"multiple tokens", "RD.ABC123,PM.DEF456", []resolverToken{{rd, "ABC123"}, {pm, "DEF456"}}, false},
+		{"order of the resolvers", "PM.DEF456,RD.ABC123", []resolverToken{{rd, "ABC123"}, {pm, "DEF456"}}, false},
+		{"empty", "", nil, true},
+		{"unknown resolver", "AD.ABC123", nil, true},
+		{"missing prefix", "RD.ABC123,DEF456", nil, true},
+		{"empty token", "RD.", nil, true},
+		{"empty resolver", ".ABC123", nil, true},
+		{"duplicate resolver", "RD.ABC123,RD.DEF456", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolverTokens, err := parseAPITokens(tt.val, resolvers)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got: %+v", resolverTokens)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if len(resolverTokens) != len(tt.expected) {
+				t.Fatalf("Expected %v tokens, got %+v", len(tt.expected), resolverTokens)
+			}
+			for i := range resolverTokens {
+				if resolverTokens[i] != tt.expected[i] {
+					t.Errorf("Expected %+v, got %+v", tt.expected[i], resolverTokens[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckInstantAvailability(t *testing.T) {
+	infoHashes := []string{"1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"}
+	rd := newMockResolver(realdebrid.Name, infoHashes[0])
+	pm := newMockResolver("PM", infoHashes...)
+	ad := newMockResolver("AD")
+	resolverTokens := []resolverToken{{rd, "ABC123"}, {pm, "DEF456"}, {ad, "GHI789"}}
+
+	available := checkInstantAvailability(context.Background(), resolverTokens, infoHashes)
+	if len(available) != len(resolverTokens) {
+		t.Fatalf("Expected the available info hashes of %v resolvers, got %v", len(resolverTokens), len(available))
+	}
+	expected := [][]string{{infoHashes[0]}, infoHashes, nil}
+	for i := range expected {
+		if strings.Join(available[i], ",") != strings.Join(expected[i], ",") {
+			t.Errorf("Expected available info hashes %v for %v, got %v", expected[i], resolverTokens[i].resolver.Name(), available[i])
+		}
+	}
+}
+
+func TestDebridStreams(t *testing.T) {
+	redirectCache = fastcache.New(testCacheSize)
+	torrent1080p := imdb2torrent.Result{Title: "Big Buck Bunny", Quality: "1080p", InfoHash: "1111111111111111111111111111111111111111", MagnetURL: "magnet:?xt=urn:btih:1111111111111111111111111111111111111111"}
+	torrent720p := imdb2torrent.Result{Title: "Big Buck Bunny", Quality: "720p", InfoHash: "2222222222222222222222222222222222222222", MagnetURL: "magnet:?xt=urn:btih:2222222222222222222222222222222222222222"}
+	torrents := []imdb2torrent.Result{torrent1080p, torrent720p}
+	tests := []struct {
+		name           string
+		available      []string
+		label          bool
+		expectedTitles []string
+	}{
+		{"all available", []string{torrent1080p.InfoHash, torrent720p.InfoHash}, false, []string{"720p", "1080p"}},
+		{"some available", []string{torrent1080p.InfoHash}, false, []string{"1080p"}},
+		{"labeled", []string{torrent1080p.InfoHash, torrent720p.InfoHash}, true, []string{"720p\n[PM]", "1080p\n[PM]"}},
+		{"none available", nil, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolverToken := resolverToken{newMockResolver("PM"), "DEF456"}
+			streams := debridStreams(context.Background(), config{StreamURLaddr: "http://localhost:8080"}, resolverToken, false, "tt1254207", torrents, tt.available, tt.label)
+			if len(streams) != len(tt.expectedTitles) {
+				t.Fatalf("Expected %v streams, got %+v", len(tt.expectedTitles), streams)
+			}
+			for i, stream := range streams {
+				if stream.Title != tt.expectedTitles[i] {
+					t.Errorf("Expected title %q, got %q", tt.expectedTitles[i], stream.Title)
+				}
+				id, err := DecodeStreamID(strings.TrimPrefix(stream.URL, "http://localhost:8080/redirect/"))
+				if err != nil {
+					t.Fatalf("Couldn't decode stream ID: %v", err)
+				}
+				if id.Resolver != "PM" || id.APIToken != "DEF456" {
+					t.Errorf("Expected stream ID of the resolver's API token, got %+v", id)
+				}
+				if _, ok := redirectCache.HasGet(nil, []byte(EncodeStreamID(id))); !ok {
+					t.Errorf("Expected the torrents of %v to be cached for the redirect", id)
+				}
+			}
+			// The torrents are filtered for each resolver, so they must stay unchanged
+			if torrents[0].InfoHash != torrent1080p.InfoHash || torrents[1].InfoHash != torrent720p.InfoHash {
+				t.Errorf("Expected the torrents to be unchanged, got %+v", torrents)
+			}
+		})
+	}
+}
+
+func TestRedirectHandlerResolvers(t *testing.T) {
+	ctx := context.Background()
+	searchClient, err := imdb2torrent.NewClient(ctx, imdb2torrent.WithTorrentCache(fastcache.New(testCacheSize), time.Hour, 0), imdb2torrent.WithCinemataCache(fastcache.New(testCacheSize), time.Hour))
+	if err != nil {
+		t.Fatalf("Couldn't create search client: %v", err)
+	}
+	torrent := imdb2torrent.Result{Title: "Big Buck Bunny", Quality: "1080p", InfoHash: "1111111111111111111111111111111111111111", MagnetURL: "magnet:?xt=urn:btih:1111111111111111111111111111111111111111"}
+	tests := []struct {
+		name             string
+		id               StreamID
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"RealDebrid", StreamID{Resolver: realdebrid.Name, APIToken: "ABC123", IMDbID: "tt1254207", Quality: "1080p"}, http.StatusMovedPermanently, "https://rd.example/ABC123"},
+		{"other resolver", StreamID{Resolver: "PM", APIToken: "DEF456", IMDbID: "tt1254207", Quality: "1080p"}, http.StatusMovedPermanently, "https://pm.example/DEF456"},
+		{"without resolver", StreamID{APIToken: "ABC123", IMDbID: "tt1254207", Quality: "1080p"}, http.StatusMovedPermanently, "https://rd.example/ABC123"},
+		{"unknown resolver", StreamID{Resolver: "AD", APIToken: "GHI789", IMDbID: "tt1254207", Quality: "1080p"}, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := newMockResolver(realdebrid.Name)
+			pm := newMockResolver("PM")
+			cache := fastcache.New(testCacheSize)
+			redirectID := EncodeStreamID(tt.id)
+			data, err := imdb2torrent.NewCacheEntry(ctx, []imdb2torrent.Result{torrent})
+			if err != nil {
+				t.Fatalf("Couldn't create cache entry: %v", err)
+			}
+			cache.Set([]byte(redirectID), data)
+			handler := createRedirectHandler(ctx, cache, searchClient, []DebridResolver{rd, pm})
+
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/redirect/"+redirectID, nil), map[string]string{"id": redirectID})
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v", tt.expectedStatus, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.expectedLocation {
+				t.Errorf("Expected redirect to %q, got %q", tt.expectedLocation, location)
+			}
+			// Only the stream's resolver converts the torrent
+			for _, resolver := range []*mockResolver{rd, pm} {
+				expectedConversions := 0
+				if tt.expectedLocation != "" && strings.Contains(tt.expectedLocation, strings.ToLower(resolver.name)) {
+					expectedConversions = 1
+				}
+				if len(resolver.converted) != expectedConversions {
+					t.Errorf("Expected %v conversions by %v, got %v", expectedConversions, resolver.name, len(resolver.converted))
+				}
+				if len(resolver.invalidated) != expectedConversions {
+					t.Errorf("Expected %v invalidations by %v, got %v", expectedConversions, resolver.name, len(resolver.invalidated))
+				}
+			}
+		})
+	}
+}
+
+func TestTokenMiddleware(t *testing.T) {
+	rd := newMockResolver(realdebrid.Name)
+	pm := newMockResolver("PM")
+	tests := []struct {
+		name           string
+		apiToken       string
+		expectedStatus int
+		expectedTokens int
+		expectedRemote bool
+	}{
+		{"RealDebrid token", "ABC123", http.StatusOK, 1, false},
+		{"remote", "ABC123-remote", http.StatusOK, 1, true},
+		{"multiple tokens", "RD.ABC123,PM.DEF456-remote", http.StatusOK, 2, true},
+		{"invalid token", "RD.ABC123,PM.invalid", http.StatusForbidden, 0, false},
+		{"unknown resolver", "AD.ABC123", http.StatusUnauthorized, 0, false},
+		{"no token", "", http.StatusUnauthorized, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resolverTokens []resolverToken
+			var remote bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				resolverTokens = r.Context().Value("apitokens").([]resolverToken)
+				remote = r.Context().Value("remote").(bool)
+			})
+			handler := createTokenMiddleware(context.Background(), []DebridResolver{rd, pm})(next)
+
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/"+tt.apiToken+"/manifest.json", nil), map[string]string{"apitoken": tt.apiToken})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v", tt.expectedStatus, w.Code)
+			}
+			if len(resolverTokens) != tt.expectedTokens || remote != tt.expectedRemote {
+				t.Errorf("Expected %v tokens and remote %v, got %+v and %v", tt.expectedTokens, tt.expectedRemote, resolverTokens, remote)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/doingodswork/deflix-stremio/pkg/imdb2torrent"
+)
+
+// streamQualities are the qualities that the stream handler creates streams for, one stream per quality.
+var streamQualities = []string{"720p", "1080p", "1080p 10bit", "2160p", "2160p 10bit", imdb2torrent.QualityUnknown}
+
+// StreamID identifies the stream of a movie in one quality. The stream handler puts it into the URL of the stream,
+// and Stremio sends it back to the redirect handler when the user selects the stream.
+type StreamID struct {
+	// Name of the debrid resolver, see DebridResolver.Name(). Empty for IDs that were created before there were multiple resolvers, which are all RealDebrid ones.
+	Resolver string
+	APIToken string
+	Remote   bool
+	IMDbID   string
+	// Quality of the stream's torrents, like "1080p 10bit"
+	Quality string
+}
+
+// EncodeStreamID returns the ID in the format "<apiToken>-<remote>-<imdbID>-<quality>", with spaces in the quality replaced by dashes, for example "123-false-tt0111161-1080p-10bit".
+// If the ID has a resolver, the API token is prefixed with its name and a dot, for example "RD.123-false-tt0111161-1080p-10bit".
+// The format must not change, because encoded IDs are stored in the redirect cache and in the stream URLs that Stremio already has.
+// The API token and IMDb ID must not contain dashes, see DecodeStreamID().
+func EncodeStreamID(id StreamID) string {
+	apiToken := id.APIToken
+	if id.Resolver != "" {
+		apiToken = id.Resolver + "." + apiToken
+	}
+	return apiToken + "-" + strconv.FormatBool(id.Remote) + "-" + id.IMDbID + "-" + strings.Replace(id.Quality, " ", "-", -1)
+}
+
+// DecodeStreamID parses an ID that was created with EncodeStreamID().
+// Only the quality can contain dashes, so everything after the third dash is the quality.
+// An API token with a dash leads to an invalid remote value, and an IMDb ID with a dash to an invalid IMDb ID or quality, so both are detected as well.
+func DecodeStreamID(val string) (StreamID, error) {
+	idParts := strings.SplitN(val, "-", 4)
+	if len(idParts) != 4 {
+		return StreamID{}, errors.New("Stream ID doesn't consist of API token, remote, IMDb ID and quality")
+	}
+	for i, name := range []string{"API token", "remote", "IMDb ID", "quality"} {
+		if idParts[i] == "" {
+			return StreamID{}, fmt.Errorf("Stream ID has an empty %v", name)
+		}
+	}
+	// IDs without resolver were created before there were multiple resolvers
+	resolver := ""
+	apiToken := idParts[0]
+	if tokenParts := strings.SplitN(apiToken, ".", 2); len(tokenParts) == 2 {
+		if tokenParts[0] == "" || tokenParts[1] == "" {
+			return StreamID{}, errors.New("Stream ID has an empty resolver or API token")
+		}
+		resolver, apiToken = tokenParts[0], tokenParts[1]
+	}
+	remote, err := strconv.ParseBool(idParts[1])
+	if err != nil {
+		return StreamID{}, fmt.Errorf("Couldn't parse remote value: %v", err)
+	}
+	// Only checked, not canonicalized, because the redirect cache is keyed by the IMDb ID as it was requested
+	if _, err := imdb2torrent.CanonicalIMDbID(idParts[2]); err != nil {
+		return StreamID{}, fmt.Errorf("Couldn't parse IMDb ID: %v", err)
+	}
+	quality := strings.Replace(idParts[3], "-", " ", -1)
+	for _, streamQuality := range streamQualities {
+		if quality == streamQuality {
+			return StreamID{
+				Resolver: resolver,
+				APIToken: apiToken,
+				Remote:   remote,
+				IMDbID:   idParts[2],
+				Quality:  quality,
+			}, nil
+		}
+	}
+	return StreamID{}, fmt.Errorf("Stream ID has an unknown quality: %v", quality)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStreamIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      StreamID
+		encoded string
+	}{
+		{"quality", StreamID{APIToken: "123", Remote: false, IMDbID: "tt0111161", Quality: "1080p"}, "123-false-tt0111161-1080p"},
+		{"quality with space", StreamID{APIToken: "123", Remote: true, IMDbID: "tt0111161", Quality: "1080p 10bit"}, "123-true-tt0111161-1080p-10bit"},
+		{"unknown quality", StreamID{APIToken: "ABC123", Remote: false, IMDbID: "tt1254207", Quality: "unknown"}, "ABC123-false-tt1254207-unknown"},
+		{"resolver", StreamID{Resolver: "RD", APIToken: "123", Remote: false, IMDbID: "tt0111161", Quality: "1080p 10bit"}, "RD.123-false-tt0111161-1080p-10bit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := EncodeStreamID(tt.id)
+			if encoded != tt.encoded {
+				t.Errorf("Expected %q, got %q", tt.encoded, encoded)
+			}
+			decoded, err := DecodeStreamID(encoded)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if decoded != tt.id {
+				t.Errorf("Expected %+v, got %+v", tt.id, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeStreamIDMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+	}{
+		{"empty", ""},
+		{"too few parts", "123-false-tt0111161"},
+		{"only API token", "123"},
+		{"empty API token", "-false-tt0111161-1080p"},
+		{"empty remote", "123--tt0111161-1080p"},
+		{"empty IMDb ID", "123-false--1080p"},
+		{"empty quality", "123-false-tt0111161-"},
+		{"bad remote value", "123-yes-tt0111161-1080p"},
+		{"API token with dash", "12-3-false-tt0111161-1080p"},
+		{"IMDb ID with dash", "123-false-tt01-11161-1080p"},
+		{"bad IMDb ID", "123-false-foo-1080p"},
+		{"IMDb ID with dash and number", "123-false-tt0111161-1-1080p"},
+		{"unknown quality", "123-false-tt0111161-480p"},
+		{"empty resolver", ".123-false-tt0111161-1080p"},
+		{"resolver without API token", "RD.-false-tt0111161-1080p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id, err := DecodeStreamID(tt.val); err == nil {
+				t.Errorf("Expected an error, got: %+v", id)
+			}
+		})
+	}
+}
@@ -43,7 +43,7 @@ func main() {
 		}
 	}
 
-	rdClient, err := realdebrid.NewClient(ctx, 5*time.Second, nil, nil, time.Duration(0), *baseURL, extraHeaderSlice)
+	rdClient, err := realdebrid.NewClient(ctx, 5*time.Second, nil, nil, time.Duration(0), time.Duration(0), *baseURL, extraHeaderSlice)
 	if err != nil {
 		log.Fatalf("Couldn't create RD client: %v", err)
 	}
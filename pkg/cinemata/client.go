@@ -2,9 +2,11 @@ package cinemata
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -15,61 +17,142 @@ import (
 
 const baseURL = "https://v3-cinemeta.strem.io"
 
+// DefaultCacheAge is the max age of cached movies of a client that's created without one. Movie names and years rarely change, so it's long.
+const DefaultCacheAge = 30 * 24 * time.Hour
+
+// ErrCacheMiss is returned when a movie isn't in the cache and the context was created with WithCacheOnly().
+var ErrCacheMiss = errors.New("Movie not in cache")
+
 type movie struct {
 	Name string
 	Year int
+	// 0 if unknown
+	Runtime time.Duration
+	// False for entries that were cached before the runtime was requested, so that the runtime can be requested for them
+	RuntimeChecked bool
 }
 
+// runtimeRegex matches runtimes like "148 min", "2h 28min" or "2 h".
+var runtimeRegex = regexp.MustCompile(`(?i)^\s*(?:(\d+)\s*h)?\s*(?:(\d+)\s*min)?\s*$`)
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	cache      *fastcache.Cache
+	cacheAge   time.Duration
+	// Limits the number of concurrent requests to Cinemata. Nil means no limit.
+	semaphore chan struct{}
 }
 
-func NewClient(ctx context.Context, timeout time.Duration, cache *fastcache.Cache) Client {
+// NewClient creates a new Cinemata client. A cacheAge of 0 leads to DefaultCacheAge being used.
+// concurrency is the max number of requests to Cinemata at the same time, which are shared by all copies of the client. Requests beyond the limit wait until a request is finished. 0 means no limit.
+func NewClient(ctx context.Context, timeout time.Duration, cache *fastcache.Cache, cacheAge time.Duration, concurrency int) Client {
+	if cacheAge == 0 {
+		cacheAge = DefaultCacheAge
+	}
+	var semaphore chan struct{}
+	if concurrency > 0 {
+		semaphore = make(chan struct{}, concurrency)
+	}
 	return Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
-		cache: cache,
+		cache:     cache,
+		cacheAge:  cacheAge,
+		semaphore: semaphore,
 	}
 }
 
+// GetMovieNameYear returns the name and year (0 if unknown) of the movie with the given IMDb ID.
+// The cache is checked first, so Cinemata is only requested for movies that aren't cached or whose cache entry is expired.
+// With a context created with WithCacheOnly() Cinemata is never requested.
 func (c Client) GetMovieNameYear(ctx context.Context, imdbID string) (string, int, error) {
+	movie, err := c.getMovie(ctx, imdbID, false)
+	if err != nil {
+		return "", 0, err
+	}
+	return movie.Name, movie.Year, nil
+}
+
+// GetMovieRuntime returns the runtime of the movie with the given IMDb ID, or 0 if it's unknown.
+// It uses the cache like GetMovieNameYear().
+func (c Client) GetMovieRuntime(ctx context.Context, imdbID string) (time.Duration, error) {
+	movie, err := c.getMovie(ctx, imdbID, true)
+	if err != nil {
+		return 0, err
+	}
+	return movie.Runtime, nil
+}
+
+// getMovie returns the movie from the cache or, if it's not cached or the cache entry is expired, from Cinemata.
+// With needsRuntime a cache entry from before the runtime was cached counts as expired.
+func (c Client) getMovie(ctx context.Context, imdbID string, needsRuntime bool) (movie, error) {
 	logger := log.WithContext(ctx).WithField("imdbID", imdbID)
+	cacheOnly := cacheOnlyFromContext(ctx)
 
 	// Check cache first
+	var expiredMovie *movie
 	if movieGob, ok := c.cache.HasGet(nil, []byte(imdbID)); ok {
 		movie, created, err := fromCacheEntry(ctx, movieGob)
 		if err != nil {
 			logger.WithError(err).Error("Couldn't decode movie")
-		} else if time.Since(created) < (24 * time.Hour * 30) {
+		} else if time.Since(created) < c.cacheAge && (movie.RuntimeChecked || !needsRuntime) {
 			logger.Debug("Hit cache for movie, returning result")
-			return movie.Name, movie.Year, nil
+			return movie, nil
+		} else if cacheOnly {
+			logger.Debug("Hit cache for movie, entry is expired but no request is allowed, returning result")
+			return movie, nil
 		} else {
-			expiredSince := time.Since(created.Add(24 * time.Hour * 30))
+			expiredSince := time.Since(created.Add(c.cacheAge))
 			logger.WithField("expiredSince", expiredSince).Debug("Hit cache for movie, but entry is expired")
+			expiredMovie = &movie
+		}
+	}
+	if cacheOnly {
+		return movie{}, ErrCacheMiss
+	}
+
+	movie, err := c.requestMovie(ctx, logger, imdbID)
+	if err != nil {
+		// Movie names and years rarely change, so an expired entry is better than no result
+		if expiredMovie != nil {
+			logger.WithError(err).Warn("Couldn't get movie from Cinemata, returning expired cache entry")
+			return *expiredMovie, nil
 		}
+		return movie, err
 	}
+	return movie, nil
+}
 
+// requestMovie requests the movie from Cinemata and fills the cache with it.
+func (c Client) requestMovie(ctx context.Context, logger *log.Entry, imdbID string) (movie, error) {
 	reqUrl := c.baseURL + "/meta/movie/" + imdbID + ".json"
 
+	if c.semaphore != nil {
+		select {
+		case c.semaphore <- struct{}{}:
+			defer func() { <-c.semaphore }()
+		case <-ctx.Done():
+			return movie{}, fmt.Errorf("Couldn't wait for concurrency limit: %v", ctx.Err())
+		}
+	}
 	res, err := c.httpClient.Get(reqUrl)
 	if err != nil {
-		return "", 0, fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)
+		return movie{}, fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return "", 0, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+		return movie{}, fmt.Errorf("Bad GET response: %v", res.StatusCode)
 	}
 	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return "", 0, fmt.Errorf("Couldn't read response body: %v", err)
+		return movie{}, fmt.Errorf("Couldn't read response body: %v", err)
 	}
 	movieName := gjson.GetBytes(resBody, "meta.name").String()
 	if movieName == "" {
-		return "", 0, fmt.Errorf("Couldn't find movie name in Cinemata response")
+		return movie{}, fmt.Errorf("Couldn't find movie name in Cinemata response")
 	}
 	movieYear := gjson.GetBytes(resBody, "meta.year").String()
 	var movieYearInt int
@@ -80,16 +163,45 @@ func (c Client) GetMovieNameYear(ctx context.Context, imdbID string) (string, in
 		}
 	}
 
+	runtime := gjson.GetBytes(resBody, "meta.runtime").String()
+	var movieRuntime time.Duration
+	if runtime != "" {
+		movieRuntime, err = parseRuntime(runtime)
+		if err != nil {
+			logger.WithError(err).Warn("Couldn't parse runtime")
+		}
+	}
+
 	// Fill cache
-	movie := movie{
-		Name: movieName,
-		Year: movieYearInt,
+	result := movie{
+		Name:           movieName,
+		Year:           movieYearInt,
+		Runtime:        movieRuntime,
+		RuntimeChecked: true,
 	}
-	if movieGob, err := newCacheEntry(ctx, movie); err != nil {
+	if movieGob, err := newCacheEntry(ctx, result); err != nil {
 		logger.WithError(err).WithField("cache", "movie").Error("Couldn't create cache entry for movie")
 	} else {
 		c.cache.Set([]byte(imdbID), movieGob)
 	}
 
-	return movieName, movieYearInt, nil
+	return result, nil
+}
+
+// parseRuntime parses a runtime from Cinemata, like "148 min" or "2h 28min".
+func parseRuntime(runtime string) (time.Duration, error) {
+	match := runtimeRegex.FindStringSubmatch(runtime)
+	if match == nil || (match[1] == "" && match[2] == "") {
+		return 0, fmt.Errorf("Unknown runtime format: %v", runtime)
+	}
+	var result time.Duration
+	if match[1] != "" {
+		hours, _ := strconv.Atoi(match[1])
+		result += time.Duration(hours) * time.Hour
+	}
+	if match[2] != "" {
+		minutes, _ := strconv.Atoi(match[2])
+		result += time.Duration(minutes) * time.Minute
+	}
+	return result, nil
 }
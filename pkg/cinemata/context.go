@@ -0,0 +1,20 @@
+package cinemata
+
+import (
+	"context"
+)
+
+type contextKey string
+
+const cacheOnlyKey contextKey = "cacheOnly"
+
+// WithCacheOnly returns a copy of ctx which makes the client only look up movies in its cache and never send a request to Cinemata.
+// A movie that's not in the cache leads to ErrCacheMiss. Expired cache entries are still used, because movie names and years rarely change.
+func WithCacheOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheOnlyKey, true)
+}
+
+func cacheOnlyFromContext(ctx context.Context) bool {
+	cacheOnly, _ := ctx.Value(cacheOnlyKey).(bool)
+	return cacheOnly
+}
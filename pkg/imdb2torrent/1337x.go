@@ -3,8 +3,10 @@ package imdb2torrent
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/VictoriaMetrics/fastcache"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 
 	"github.com/doingodswork/deflix-stremio/pkg/cinemata"
 )
@@ -19,22 +22,47 @@ import (
 var _ MagnetSearcher = (*leetxClient)(nil)
 
 type leetxClient struct {
-	baseURL        string
+	mirrors        *mirrorList
 	httpClient     *http.Client
 	cache          *fastcache.Cache
 	cinemataClient cinemata.Client
 	cacheAge       time.Duration
+	cacheAgeJitter time.Duration
+	// Returns the current time for cache expiry. Only replaced in tests.
+	now func() time.Time
+	// Gzip cache entries
+	compressCache bool
+	// One of the TitleMatching... constants
+	titleMatching string
+	limiter       *rate.Limiter
+	// Max number of torrent pages of a movie that are requested at the same time. 0 means no limit.
+	concurrency int
+	// Max number of results of a movie, taken from the torrents with the most seeders. 0 means no limit.
+	maxResults int
+	// Also request the torrent pages of torrents without resolution in their name, because their results are kept with QualityUnknown
+	keepUnknown bool
+	// Include the movies of all years with a matching title instead of only the requested year
+	allYears bool
 }
 
-func newLeetxclient(ctx context.Context, baseURL string, timeout time.Duration, cache *fastcache.Cache, cinemataClient cinemata.Client, cacheAge time.Duration) leetxClient {
+func newLeetxclient(ctx context.Context, baseURL string, timeout time.Duration, cache *fastcache.Cache, cinemataClient cinemata.Client, cacheAge, cacheAgeJitter time.Duration, now func() time.Time, compressCache bool, titleMatching string, rateLimit float64, concurrency, maxResults int, keepUnknown, allYears bool) leetxClient {
 	return leetxClient{
-		baseURL: baseURL,
+		mirrors: newMirrorList(baseURL),
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
 		cache:          cache,
 		cinemataClient: cinemataClient,
 		cacheAge:       cacheAge,
+		cacheAgeJitter: cacheAgeJitter,
+		now:            now,
+		compressCache:  compressCache,
+		titleMatching:  titleMatching,
+		limiter:        newRateLimiter(rateLimit),
+		concurrency:    concurrency,
+		maxResults:     maxResults,
+		keepUnknown:    keepUnknown,
+		allYears:       allYears,
 	}
 }
 
@@ -50,17 +78,8 @@ func (c leetxClient) Check(ctx context.Context, imdbID string) ([]Result, error)
 
 	// Check cache first
 	cacheKey := imdbID + "-1337x"
-	if torrentsGob, ok := c.cache.HasGet(nil, []byte(cacheKey)); ok {
-		torrentList, created, err := FromCacheEntry(ctx, torrentsGob)
-		if err != nil {
-			logger.WithError(err).Error("Couldn't decode torrent results")
-		} else if time.Since(created) < (c.cacheAge) {
-			logger.WithField("torrentCount", len(torrentList)).Debug("Hit cache for torrents, returning results")
-			return torrentList, nil
-		} else {
-			expiredSince := time.Since(created.Add(c.cacheAge))
-			logger.WithField("expiredSince", expiredSince).Debug("Hit cache for torrents, but entry is expired")
-		}
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
 	}
 
 	// Get movie name
@@ -68,8 +87,34 @@ func (c leetxClient) Check(ctx context.Context, imdbID string) ([]Result, error)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get movie name via Cinemata for IMDb ID %v: %v", imdbID, err)
 	}
+
+	return c.check(ctx, logger, movieName, movieYear, cacheKey)
+}
+
+// checkTitle scrapes 1337x to find torrents for the given movie title and year (0 if unknown).
+// If no error occured, but there are just no torrents for the movie yet, an empty result and *no* error are returned.
+func (c leetxClient) checkTitle(ctx context.Context, title string, year int) ([]Result, error) {
+	logFields := log.Fields{
+		"title":       title,
+		"year":        year,
+		"torrentSite": "1337x",
+	}
+	logger := log.WithContext(ctx).WithFields(logFields)
+
+	// Check cache first
+	cacheKey := titleCacheKey(title, year) + "-1337x"
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
+	}
+
+	return c.check(ctx, logger, title, year, cacheKey)
+}
+
+// check searches 1337x with the movie name and year and fills the cache with the results.
+// If the client is configured to include all years, the year isn't part of the search and the movie pages of the first matching search result of each year are used. Otherwise only the one of the first matching search result.
+func (c leetxClient) check(ctx context.Context, logger *log.Entry, movieName string, movieYear int, cacheKey string) ([]Result, error) {
 	movieSearch := movieName
-	if movieYear != 0 {
+	if movieYear != 0 && !c.allYears {
 		movieSearch += " " + strconv.Itoa(movieYear)
 	}
 	// Use this for general searching in URL "https://1337x.to/search/foo+bar/1/"
@@ -77,71 +122,158 @@ func (c leetxClient) Check(ctx context.Context, imdbID string) ([]Result, error)
 	// Use this for *movie* searching in URL "https://1337x.to/category-search/foo%20bar/Movies/1/"
 	movieSearch = url.QueryEscape(movieSearch)
 
-	// Search on 1337x
+	// Search on 1337x.
+	// The results are sorted by seeders (via "https://1337x.to/sort-category-search/foo%20bar/Movies/seeders/desc/1/"), so that the first matching result is a healthy torrent of the movie and not some old, dead upload with a similar title.
 
-	reqUrl := c.baseURL + "/category-search/" + movieSearch + "/Movies/1/"
-	doc, err := c.getDoc(ctx, reqUrl)
+	doc, err := c.getDoc(ctx, "/sort-category-search/"+movieSearch+"/Movies/seeders/desc/1/")
 	if err != nil {
 		return nil, err
 	}
-	// Pick the first element, it's the most likely one to belong to the correct movie
-	torrentPath, ok := doc.Find(".table-list tbody td a").Next().Attr("href")
-	if !ok {
+	// Pick the first element that matches the movie title, it's the most likely one to belong to the correct movie
+	rows := doc.Find(".table-list tbody tr")
+	if rows.Length() == 0 {
 		return nil, fmt.Errorf("Couldn't find search result")
 	}
+	var matches []leetxMatch
+	matchedYears := map[int]struct{}{}
+	rows.EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		link := s.Find("td a").Next()
+		if !titleMatches(link.Text(), movieName, movieYear, c.titleMatching) {
+			return true
+		}
+		torrentPath, _ := link.Attr("href")
+		if torrentPath == "" {
+			return true
+		}
+		_, year := releaseTitleYear(link.Text())
+		if _, ok := matchedYears[year]; ok {
+			return true
+		}
+		matchedYears[year] = struct{}{}
+		matches = append(matches, leetxMatch{torrentPath, year})
+		return c.allYears
+	})
+	if len(matches) == 0 {
+		logger.WithField("titleMatching", c.titleMatching).Debug("No search result matches the movie title")
+		return nil, nil
+	}
 
+	var results []Result
+	found := false
+	for i, match := range matches {
+		matchResults, ok, err := c.moviePageResults(ctx, logger, movieName, match.torrentPath)
+		if err != nil {
+			// Only the first match is required, the other years are an addition
+			if i == 0 {
+				return nil, err
+			}
+			logger.WithError(err).WithField("year", match.year).Warn("Couldn't get torrents of additional year")
+			continue
+		}
+		found = found || ok
+		for j := range matchResults {
+			matchResults[j].Year = match.year
+		}
+		results = append(results, matchResults...)
+	}
+	// TODO: We should differentiate between "parsing went wrong" and "just no search results".
+	if !found {
+		return nil, nil
+	}
+
+	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
+	// Any actual errors would have returned earlier.
+	setCachedResults(ctx, c.cache, cacheKey, results, c.now, c.compressCache, logger)
+
+	return results, nil
+}
+
+// leetxMatch is a search result that matches the movie title, with the year of its torrent title (0 if it doesn't contain one).
+type leetxMatch struct {
+	torrentPath string
+	year        int
+}
+
+// moviePageResults goes via the torrent page of a search result to the general movie page and returns the movie's torrents.
+// ok is false if the movie page doesn't contain any torrent with a supported quality.
+func (c leetxClient) moviePageResults(ctx context.Context, logger *log.Entry, movieName, torrentPath string) (results []Result, ok bool, err error) {
 	// Go via a single search result to the general movie page
 
-	reqUrl = c.baseURL + torrentPath
-	doc, err = c.getDoc(ctx, reqUrl)
+	doc, err := c.getDoc(ctx, torrentPath)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	// Find the general movie page URL
 	movieInfoURL, ok := doc.Find(".content-row h3 a").Attr("href")
 	if !ok {
-		return nil, fmt.Errorf("Couldn't find search result")
+		return nil, false, fmt.Errorf("Couldn't find search result")
 	}
 
 	// Go through torrent pages for the movie
 
-	reqUrl = c.baseURL + movieInfoURL
-	doc, err = c.getDoc(ctx, reqUrl)
+	doc, err = c.getDoc(ctx, movieInfoURL)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	var torrentPageURLs []string
-	// Go through elements
-	doc.Find(".table-list tbody tr").Each(func(i int, s *goquery.Selection) {
+	var torrentPagePaths []string
+	rows := doc.Find(".table-list tbody tr")
+	// A movie page only exists for movies with torrents, so an empty table means the page wasn't rendered completely
+	if rows.Length() == 0 && doc.Find(".table-list").Length() > 0 {
+		markEmptyResultsPage(ctx)
+	}
+	// Go through elements, the ones with the most seeders first.
+	// The movie page doesn't support sorting, but it has the seeders of each torrent.
+	for _, s := range leetxRowsBySeeders(rows) {
+		if c.maxResults > 0 && len(results)+len(torrentPagePaths) >= c.maxResults {
+			logger.WithField("maxResults", c.maxResults).Debug("Reached max results, skipping the torrents with fewer seeders")
+			break
+		}
 		linkText := s.Find("a").Next().Text()
-		if strings.Contains(linkText, "720p") || strings.Contains(linkText, "1080p") || strings.Contains(linkText, "2160p") {
+		quality, ok := parseQualityOrUnknown(linkText)
+		if ok && (quality != QualityUnknown || c.keepUnknown) {
+			// Some mirrors have the magnet URL in the row already, which saves the request of the torrent page
+			if magnet, ok := s.Find(`a[href^="magnet:"]`).Attr("href"); ok {
+				if result, ok := leetxResult(logger, movieName, magnet); ok {
+					// For example "<td class="coll-2 seeds">12</td>" and "<td class="coll-4 size">1.4 GB<span class="seeds">12</span></td>"
+					// Only the cell's first text node, because the span's text would be appended to the unit
+					result.Size = ParseSize(s.Find("td.size").Contents().First().Text())
+					result.Seeders = leetxRowSeeders(s)
+					results = append(results, result)
+					continue
+				}
+			}
 			torrentLink, ok := s.Find("a").Next().Attr("href")
 			if !ok || torrentLink == "" {
 				logger.Warn("Couldn't find link to the torrent page, did the HTML change?")
-				return
+				continue
 			}
-			torrentPageURLs = append(torrentPageURLs, c.baseURL+torrentLink)
+			torrentPagePaths = append(torrentPagePaths, torrentLink)
 		}
-	})
-	// TODO: We should differentiate between "parsing went wrong" and "just no search results".
-	if len(torrentPageURLs) == 0 {
-		return nil, nil
+	}
+	if len(results) > 0 {
+		logger.WithField("torrentCount", len(results)).Debug("Found magnet URLs on the movie page")
+	}
+	if len(results) == 0 && len(torrentPagePaths) == 0 {
+		return nil, false, nil
 	}
 
-	// Visit each torrent page *in parallel* and get the magnet URL
-
-	resultChan := make(chan Result, len(torrentPageURLs))
+	// Visit each torrent page without magnet URL on the movie page *in parallel* and get the magnet URL.
+	// The number of concurrent requests is limited by the semaphore, if configured.
 
-	for _, torrentPageURL := range torrentPageURLs {
-		// Use configured base URL, which could be a proxy that we want to go through
-		torrentPageURL, err = replaceURL(torrentPageURL, c.baseURL)
-		if err != nil {
-			logger.WithError(err).Warn("Couldn't replace URL which was retrieved from an HTML link")
-			continue
-		}
+	resultChan := make(chan Result, len(torrentPagePaths))
+	var semaphore chan struct{}
+	if c.concurrency > 0 {
+		semaphore = make(chan struct{}, c.concurrency)
+	}
 
-		go func(goTorrentPageURL string) {
-			doc, err = c.getDoc(ctx, goTorrentPageURL)
+	for _, torrentPagePath := range torrentPagePaths {
+		// The path is requested from the configured base URL, which could be a proxy that we want to go through
+		go func(goTorrentPagePath string) {
+			if semaphore != nil {
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }()
+			}
+			doc, err := c.getDoc(ctx, goTorrentPagePath)
 			if err != nil {
 				resultChan <- Result{}
 				return
@@ -153,89 +285,50 @@ func (c leetxClient) Check(ctx context.Context, imdbID string) ([]Result, error)
 				return
 			}
 
-			title := movieName
-
-			quality := ""
-			if strings.Contains(magnet, "720p") {
-				quality = "720p"
-			} else if strings.Contains(magnet, "1080p") {
-				quality = "1080p"
-			} else if strings.Contains(magnet, "2160p") {
-				quality = "2160p"
-			} else {
-				// This should never be the case, because it was previously checked during scraping
-				resultChan <- Result{}
-				return
-			}
-
-			if strings.Contains(magnet, "10bit") {
-				quality += " 10bit"
-			}
-
-			// https://en.wikipedia.org/wiki/Pirated_movie_release_types
-			if strings.Contains(magnet, "HDCam") {
-				quality += (" (⚠️cam)")
-			}
-
-			// We should mark 1337x movies somehow, because we cannot be 100% sure it's the correct movie.
-			// The quality might later be used as title, as suggested by Stremio.
-			// (Albeit only in a specific case for a specific reason)
-			quality += "\n(⚠️guessed match)"
-
-			// look for "btih:dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c&" via regex and then cut out the hash
-			match := magnet2InfoHashRegex.Find([]byte(magnet))
-			infoHash := strings.TrimPrefix(string(match), "btih:")
-			infoHash = strings.TrimSuffix(infoHash, "&")
-			infoHash = strings.ToUpper(infoHash)
-
-			if infoHash == "" {
-				logger.WithField("magnet", magnet).Warn("Couldn't extract info_hash. Did the HTML change?")
+			result, ok := leetxResult(logger, movieName, magnet)
+			if !ok {
 				resultChan <- Result{}
 				return
 			}
-
-			result := Result{
-				Title:     title,
-				Quality:   quality,
-				InfoHash:  infoHash,
-				MagnetURL: magnet,
-			}
-			logger.WithFields(log.Fields{"title": title, "quality": quality, "infoHash": infoHash, "magnet": magnet}).Trace("Found torrent")
+			// For example "<li><strong>Total size</strong> <span>1.4 GB</span></li>"
+			doc.Find(".box-info ul.list li").Each(func(_ int, li *goquery.Selection) {
+				val := strings.TrimSpace(li.Find("span").Text())
+				switch strings.TrimSpace(li.Find("strong").Text()) {
+				case "Total size":
+					result.Size = ParseSize(val)
+				case "Seeders":
+					result.Seeders, _ = strconv.Atoi(val)
+				}
+			})
+			logger.WithFields(log.Fields{"title": result.Title, "quality": result.Quality, "infoHash": result.InfoHash, "magnet": magnet}).Trace("Found torrent")
 
 			resultChan <- result
-		}(torrentPageURL)
+		}(torrentPagePath)
 	}
 
-	var results []Result
 	// We don't use a timeout channel because the HTTP clients have a timeout so the goroutines are guaranteed to finish
-	for i := 0; i < len(torrentPageURLs); i++ {
+	for i := 0; i < len(torrentPagePaths); i++ {
 		result := <-resultChan
 		if result.MagnetURL != "" {
 			results = append(results, result)
 		}
 	}
-
-	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
-	// Any actual errors would have returned earlier.
-	if torrentsGob, err := NewCacheEntry(ctx, results); err != nil {
-		logger.WithError(err).WithField("cache", "torrent").Error("Couldn't create cache entry for torrents")
-	} else {
-		entrySize := strconv.Itoa(len(torrentsGob)/1024) + "KB"
-		if len(torrentsGob) > 64*1024 {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Warn("New cacheEntry is bigger than 64KB, which means it won't be stored in the cache when calling fastcache's Set() method. SetBig() (and GetBig()) must be used instead!")
-		} else {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Debug("Caching torrent results")
-		}
-		c.cache.Set([]byte(cacheKey), torrentsGob)
+	if len(results) == 0 {
+		// The torrent pages exist, so they probably failed only temporarily
+		markEmptyResultsPage(ctx)
 	}
 
-	return results, nil
+	return results, true, nil
 }
 
-func (c leetxClient) getDoc(ctx context.Context, url string) (*goquery.Document, error) {
-	res, err := c.httpClient.Get(url)
+// getDoc requests the path from the configured base URL (or its mirrors) and loads the HTML document.
+func (c leetxClient) getDoc(ctx context.Context, path string) (*goquery.Document, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+	}
+	res, err := c.mirrors.get(ctx, c.httpClient, path)
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't GET %v: %v", url, err)
+		return nil, fmt.Errorf("Couldn't GET %v: %v", path, err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
@@ -243,10 +336,74 @@ func (c leetxClient) getDoc(ctx context.Context, url string) (*goquery.Document,
 	}
 
 	// Load the HTML document
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(res.Body, maxBodySize))
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't load the HTML in goquery: %v", err)
 	}
 
 	return doc, nil
 }
+
+// leetxRowsBySeeders returns the rows of a 1337x torrent table, sorted by their seeders in descending order.
+// Rows without seeders keep their order after the ones with seeders.
+func leetxRowsBySeeders(rows *goquery.Selection) []*goquery.Selection {
+	sorted := make([]*goquery.Selection, 0, rows.Length())
+	rows.Each(func(_ int, s *goquery.Selection) {
+		sorted = append(sorted, s)
+	})
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return leetxRowSeeders(sorted[i]) > leetxRowSeeders(sorted[j])
+	})
+	return sorted
+}
+
+// leetxRowSeeders returns the seeders of a row of a 1337x torrent table, or 0 if the row doesn't have them.
+func leetxRowSeeders(s *goquery.Selection) int {
+	seeders, _ := strconv.Atoi(strings.TrimSpace(s.Find("td.seeds").Text()))
+	return seeders
+}
+
+// leetxResult creates a result from the magnet URL of a 1337x torrent of the movie.
+// It returns false if the magnet URL has a resolution below the supported ones or doesn't have an info hash.
+func leetxResult(logger *log.Entry, movieName, magnet string) (Result, bool) {
+	title := movieName
+
+	quality, ok := parseQualityOrUnknown(magnet)
+	if !ok {
+		// This should never be the case, because it was previously checked during scraping
+		return Result{}, false
+	}
+
+	// look for "btih:dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c&" via regex and then cut out the hash
+	match := magnet2InfoHashRegex.Find([]byte(magnet))
+	infoHash := strings.TrimPrefix(string(match), "btih:")
+	infoHash = strings.TrimSuffix(infoHash, "&")
+	infoHash = strings.ToUpper(infoHash)
+
+	// BitTorrent v2 and hybrid torrents also have a v2 info hash, v2-only torrents *only* have that
+	infoHashV2 := magnetInfoHashV2(magnet)
+	if infoHash == "" && infoHashV2 == "" {
+		logger.WithField("magnet", magnet).Warn("Couldn't extract info_hash. Did the HTML change?")
+		return Result{}, false
+	}
+
+	result := Result{
+		Title:   title,
+		Quality: quality,
+		// We should mark 1337x movies somehow, because we cannot be 100% sure it's the correct movie.
+		GuessedMatch: true,
+		Source:       parseSource(magnet),
+		ReleaseType:  parseReleaseType(magnet),
+		Remux:        parseRemux(magnet),
+		Proper:       parseProper(magnet),
+		Repack:       parseRepack(magnet),
+		InfoHash:     infoHash,
+		MagnetURL:    magnet,
+		Trackers:     magnetTrackers(magnet),
+		InfoHashV2:   infoHashV2,
+		// The title is the movie name, but the magnet URL contains the release title
+		Group:    parseReleaseGroup(magnet),
+		BitDepth: parseBitDepth(magnet),
+	}
+	return result, true
+}
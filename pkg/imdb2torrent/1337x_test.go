@@ -0,0 +1,215 @@
+package imdb2torrent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doingodswork/deflix-stremio/pkg/cinemata"
+)
+
+func newTestLeetxClient(baseURL string) leetxClient {
+	return newLeetxclient(context.Background(), baseURL, time.Second, newTestCache(), cinemata.Client{}, time.Hour, 0, testNow, false, TitleMatchingNormalized, 0, 0, 0, false, false)
+}
+
+// handleLeetxFixtures registers the search, torrent and movie pages of Big Buck Bunny.
+func handleLeetxFixtures(t *testing.T, server *fixtureServer) {
+	t.Helper()
+	server.handleFile(t, "/sort-category-search/Big+Buck+Bunny+2008/Movies/seeders/desc/1/", "1337x_search.html")
+	server.handleFile(t, "/torrent/1/Big-Buck-Bunny-2008-1080p-BluRay-x264-GRP/", "1337x_torrent.html")
+	server.handleFile(t, "/movie/1/Big-Buck-Bunny-2008/", "1337x_movie.html")
+}
+
+func TestLeetxCheckTitle(t *testing.T) {
+	server := newFixtureServer(t)
+	handleLeetxFixtures(t, server)
+	client := newTestLeetxClient(server.URL)
+
+	results, err := client.checkTitle(context.Background(), "Big Buck Bunny", 2008)
+	if err != nil {
+		t.Fatalf("checkTitle() returned an error: %v", err)
+	}
+	// The movie page is sorted by seeders, the 480p torrent is dropped
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v: %+v", len(results), results)
+	}
+	if results[0].InfoHash != "EEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEE" || results[0].Quality != "2160p" || results[0].Seeders != 100 {
+		t.Errorf("Unexpected first result: %+v", results[0])
+	}
+	if results[1].InfoHash != "DDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDD" || results[1].Quality != "1080p" || results[1].Seeders != 42 || results[1].Size != ParseSize("1.4 GB") {
+		t.Errorf("Unexpected second result: %+v", results[1])
+	}
+	for _, result := range results {
+		if !result.GuessedMatch || result.Year != 2008 {
+			t.Errorf("Expected guessed match of 2008, got %+v", result)
+		}
+	}
+	// The torrent with the magnet URL on the movie page doesn't need a request of its torrent page
+	if count := server.requestCount("/torrent/3/Big-Buck-Bunny-2008-2160p-WEBRip-x265-GRP/"); count != 0 {
+		t.Errorf("Expected no request of the torrent page with magnet URL on the movie page, got %v", count)
+	}
+}
+
+func TestLeetxCheckTitleNoMatch(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handleFile(t, "/sort-category-search/Elephants+Dream+2006/Movies/seeders/desc/1/", "1337x_search.html")
+	client := newTestLeetxClient(server.URL)
+
+	results, err := client.checkTitle(context.Background(), "Elephants Dream", 2006)
+	if err != nil {
+		t.Fatalf("checkTitle() returned an error: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected no results when no search result matches the title, got %+v", results)
+	}
+	if count := len(server.requested()); count != 1 {
+		t.Errorf("Expected only the search request, got %v requests", count)
+	}
+}
+
+// leetxMovieRow returns a row of a 1337x movie page, with a magnet link if magnet isn't empty.
+func leetxMovieRow(torrentPath, name, magnet string, seeders int) string {
+	magnetLink := ""
+	if magnet != "" {
+		magnetLink = `<a href="` + magnet + `" class="magnet"></a>`
+	}
+	return fmt.Sprintf(`<tr><td class="coll-1 name"><a href="/sub/42/0/" class="icon"></a><a href="%v">%v</a>%v</td><td class="coll-2 seeds">%v</td><td class="coll-4 size">1.4 GB<span class="seeds">%v</span></td></tr>`, torrentPath, name, magnetLink, seeders, seeders)
+}
+
+func TestLeetxMoviePageMagnets(t *testing.T) {
+	const (
+		path1080p = "/torrent/1/Big-Buck-Bunny-2008-1080p-BluRay-x264-GRP/"
+		path2160p = "/torrent/3/Big-Buck-Bunny-2008-2160p-WEBRip-x265-GRP/"
+	)
+	magnet1080p := "magnet:?xt=urn:btih:dddddddddddddddddddddddddddddddddddddddd&amp;dn=Big.Buck.Bunny.2008.1080p.BluRay.x264-GRP"
+	magnet2160p := "magnet:?xt=urn:btih:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee&amp;dn=Big.Buck.Bunny.2008.2160p.WEBRip.x265-GRP"
+	tests := []struct {
+		name string
+		rows []string
+		// Requests of the torrent pages, the one of the 1080p torrent is always requested to find the movie page
+		expectedRequests map[string]int
+	}{
+		{
+			"all magnets on the movie page",
+			[]string{leetxMovieRow(path1080p, "Big Buck Bunny 2008 1080p BluRay x264-GRP", magnet1080p, 42), leetxMovieRow(path2160p, "Big Buck Bunny 2008 2160p WEBRip x265-GRP", magnet2160p, 100)},
+			map[string]int{path1080p: 1, path2160p: 0},
+		},
+		{
+			"some magnets on the movie page",
+			[]string{leetxMovieRow(path1080p, "Big Buck Bunny 2008 1080p BluRay x264-GRP", "", 42), leetxMovieRow(path2160p, "Big Buck Bunny 2008 2160p WEBRip x265-GRP", magnet2160p, 100)},
+			map[string]int{path1080p: 2, path2160p: 0},
+		},
+		{
+			"magnet without info hash",
+			[]string{leetxMovieRow(path1080p, "Big Buck Bunny 2008 1080p BluRay x264-GRP", "magnet:?dn=Big.Buck.Bunny.2008.1080p.BluRay.x264-GRP", 42), leetxMovieRow(path2160p, "Big Buck Bunny 2008 2160p WEBRip x265-GRP", magnet2160p, 100)},
+			map[string]int{path1080p: 2, path2160p: 0},
+		},
+		{
+			"no magnets on the movie page",
+			[]string{leetxMovieRow(path1080p, "Big Buck Bunny 2008 1080p BluRay x264-GRP", "", 42), leetxMovieRow(path2160p, "Big Buck Bunny 2008 2160p WEBRip x265-GRP", "", 100)},
+			map[string]int{path1080p: 2, path2160p: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t)
+			handleLeetxFixtures(t, server)
+			server.handle(path2160p, strings.NewReplacer("1080p BluRay x264", "2160p WEBRip x265", "dddddddddddddddddddddddddddddddddddddddd", "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee", "<span>42</span>", "<span>100</span>").Replace(leetxTorrentPage(t)))
+			server.handle("/movie/1/Big-Buck-Bunny-2008/", `<html><body><table class="table-list"><tbody>`+strings.Join(tt.rows, "")+`</tbody></table></body></html>`)
+			client := newTestLeetxClient(server.URL)
+
+			results, err := client.checkTitle(context.Background(), "Big Buck Bunny", 2008)
+			if err != nil {
+				t.Fatalf("checkTitle() returned an error: %v", err)
+			}
+			infoHashes := map[string]bool{}
+			for _, result := range results {
+				infoHashes[result.InfoHash] = true
+			}
+			if len(results) != 2 || !infoHashes["DDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDD"] || !infoHashes["EEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEE"] {
+				t.Errorf("Expected the 1080p and 2160p torrents, got %+v", results)
+			}
+			for path, expected := range tt.expectedRequests {
+				if count := server.requestCount(path); count != expected {
+					t.Errorf("Expected %v requests of %v, got %v", expected, path, count)
+				}
+			}
+		})
+	}
+}
+
+// leetxTorrentPage returns the torrent page fixture of the 1080p torrent.
+func leetxTorrentPage(t *testing.T) string {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "1337x_torrent.html"))
+	if err != nil {
+		t.Fatalf("Couldn't read fixture: %v", err)
+	}
+	return string(body)
+}
+
+func TestLeetxMaxResults(t *testing.T) {
+	const (
+		path720p  = "/torrent/5/Big-Buck-Bunny-2008-720p-BluRay-x264-GRP/"
+		path1080p = "/torrent/1/Big-Buck-Bunny-2008-1080p-BluRay-x264-GRP/"
+		path2160p = "/torrent/3/Big-Buck-Bunny-2008-2160p-WEBRip-x265-GRP/"
+	)
+	const (
+		infoHash720p  = "5555555555555555555555555555555555555555"
+		infoHash1080p = "DDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDD"
+		infoHash2160p = "EEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEE"
+	)
+	// Not sorted by seeders, like on the real movie pages
+	moviePage := `<html><body><table class="table-list"><tbody>` +
+		leetxMovieRow(path1080p, "Big Buck Bunny 2008 1080p BluRay x264-GRP", "", 42) +
+		leetxMovieRow(path720p, "Big Buck Bunny 2008 720p BluRay x264-GRP", "", 7) +
+		leetxMovieRow(path2160p, "Big Buck Bunny 2008 2160p WEBRip x265-GRP", "", 100) +
+		`</tbody></table></body></html>`
+	tests := []struct {
+		name               string
+		maxResults         int
+		expectedInfoHashes []string
+		// Requests of the torrent pages, the one of the 1080p torrent is always requested to find the movie page
+		expectedRequests map[string]int
+	}{
+		{"no limit", 0, []string{infoHash720p, infoHash1080p, infoHash2160p}, map[string]int{path720p: 1, path1080p: 2, path2160p: 1}},
+		{"most seeded", 1, []string{infoHash2160p}, map[string]int{path720p: 0, path1080p: 1, path2160p: 1}},
+		{"two most seeded", 2, []string{infoHash1080p, infoHash2160p}, map[string]int{path720p: 0, path1080p: 2, path2160p: 1}},
+		{"more than available", 5, []string{infoHash720p, infoHash1080p, infoHash2160p}, map[string]int{path720p: 1, path1080p: 2, path2160p: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t)
+			handleLeetxFixtures(t, server)
+			torrentPage := leetxTorrentPage(t)
+			server.handle(path720p, strings.NewReplacer("1080p", "720p", "dddddddddddddddddddddddddddddddddddddddd", strings.ToLower(infoHash720p), "<span>42</span>", "<span>7</span>").Replace(torrentPage))
+			server.handle(path2160p, strings.NewReplacer("1080p BluRay x264", "2160p WEBRip x265", "dddddddddddddddddddddddddddddddddddddddd", strings.ToLower(infoHash2160p), "<span>42</span>", "<span>100</span>").Replace(torrentPage))
+			server.handle("/movie/1/Big-Buck-Bunny-2008/", moviePage)
+			client := newLeetxclient(context.Background(), server.URL, time.Second, newTestCache(), cinemata.Client{}, time.Hour, 0, testNow, false, TitleMatchingNormalized, 0, 0, tt.maxResults, false, false)
+
+			results, err := client.checkTitle(context.Background(), "Big Buck Bunny", 2008)
+			if err != nil {
+				t.Fatalf("checkTitle() returned an error: %v", err)
+			}
+			// The torrent pages are requested in parallel, so the order of the results isn't fixed
+			var infoHashes []string
+			for _, result := range results {
+				infoHashes = append(infoHashes, result.InfoHash)
+			}
+			sort.Strings(infoHashes)
+			if strings.Join(infoHashes, ",") != strings.Join(tt.expectedInfoHashes, ",") {
+				t.Errorf("Expected info hashes %v, got %v", tt.expectedInfoHashes, infoHashes)
+			}
+			for path, expected := range tt.expectedRequests {
+				if count := server.requestCount(path); count != expected {
+					t.Errorf("Expected %v requests of %v, got %v", expected, path, count)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package imdb2torrent
+
+import (
+	"context"
+	"sync"
+)
+
+// backgroundTasks keeps track of the tasks that the client runs in the background, like refreshing cache entries, so that they can be waited for when shutting down.
+// Only one task per key runs at a time.
+type backgroundTasks struct {
+	lock    *sync.Mutex
+	wg      *sync.WaitGroup
+	running map[string]struct{}
+	// No new tasks are started once the client is shutting down
+	stopped bool
+}
+
+func newBackgroundTasks() *backgroundTasks {
+	return &backgroundTasks{
+		lock:    &sync.Mutex{},
+		wg:      &sync.WaitGroup{},
+		running: map[string]struct{}{},
+	}
+}
+
+// start runs the task in its own goroutine and returns true, or returns false if a task with the same key is still running or the tasks were stopped.
+func (t *backgroundTasks) start(key string, task func()) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.stopped {
+		return false
+	}
+	if _, ok := t.running[key]; ok {
+		return false
+	}
+	t.running[key] = struct{}{}
+	t.wg.Add(1)
+	go func() {
+		defer func() {
+			t.lock.Lock()
+			delete(t.running, key)
+			t.lock.Unlock()
+			t.wg.Done()
+		}()
+		task()
+	}()
+	return true
+}
+
+// WaitForBackgroundTasks prevents new background tasks from being started and waits until the running ones are finished or the context is done.
+// It's meant to be called when shutting down, so that for example cache entries that are being refreshed are written before the caches are persisted.
+func (c Client) WaitForBackgroundTasks(ctx context.Context) error {
+	c.background.lock.Lock()
+	c.background.stopped = true
+	c.background.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.background.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package imdb2torrent
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// scrapeBudget limits the requests to all torrent sites together, in addition to the rate limits of the single sites.
+// It protects the shared egress IP from exceeding the abuse thresholds of the sites when many users search at the same time.
+type scrapeBudget struct {
+	// Accessed atomically, so they're first for 64-bit alignment on 32-bit platforms
+	requests uint64
+	delayed  uint64
+	waitTime int64
+	// nil means no limit
+	limiter *rate.Limiter
+}
+
+// newScrapeBudget creates a scrapeBudget with the given number of requests per minute. 0 means no limit.
+func newScrapeBudget(requestsPerMinute int) *scrapeBudget {
+	var b scrapeBudget
+	if requestsPerMinute > 0 {
+		b.limiter = rate.NewLimiter(rate.Limit(float64(requestsPerMinute)/60), 1)
+	}
+	return &b
+}
+
+// wait blocks until a request is allowed by the budget or the context is done.
+// It returns an error if the context is done before, or if its deadline would be exceeded before the request is allowed.
+func (b *scrapeBudget) wait(ctx context.Context) error {
+	if b == nil || b.limiter == nil {
+		return nil
+	}
+	atomic.AddUint64(&b.requests, 1)
+	if b.limiter.Allow() {
+		return nil
+	}
+	atomic.AddUint64(&b.delayed, 1)
+	start := time.Now()
+	err := b.limiter.Wait(ctx)
+	atomic.AddInt64(&b.waitTime, int64(time.Since(start)))
+	if err != nil {
+		return fmt.Errorf("Couldn't wait for scrape budget: %v", err)
+	}
+	return nil
+}
+
+// ScrapeBudgetStats are the cumulative stats of the scrape budget, see WithScrapeBudget().
+type ScrapeBudgetStats struct {
+	// Number of requests to torrent sites that went through the budget
+	Requests uint64
+	// Number of requests that had to wait because the budget was exhausted.
+	// A high share of Requests means that the budget is saturated and slows down searches.
+	Delayed uint64
+	// Total time that requests waited for the budget
+	WaitTime time.Duration
+}
+
+// ScrapeBudgetStats returns the stats of the scrape budget since the client was created. They're all 0 if the client doesn't have a budget.
+func (c Client) ScrapeBudgetStats() ScrapeBudgetStats {
+	return ScrapeBudgetStats{
+		Requests: atomic.LoadUint64(&c.budget.requests),
+		Delayed:  atomic.LoadUint64(&c.budget.delayed),
+		WaitTime: time.Duration(atomic.LoadInt64(&c.budget.waitTime)),
+	}
+}
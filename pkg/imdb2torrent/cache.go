@@ -2,10 +2,20 @@ package imdb2torrent
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/gob"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type cacheEntry struct {
@@ -13,27 +23,154 @@ type cacheEntry struct {
 	Results []Result
 }
 
+// gzipHeader is the start of all gzip compressed data.
+// A gob-encoded cacheEntry can't start with it, because the second byte of a gob stream belongs to a type ID, which is never encoded as 0x8b.
+var gzipHeader = []byte{0x1f, 0x8b}
+
 // NewCacheEntry turns data into a single cacheEntry and returns the cacheEntry's gob-encoded bytes.
 func NewCacheEntry(ctx context.Context, data []Result) ([]byte, error) {
+	return newCacheEntry(ctx, data, time.Now(), false)
+}
+
+// newCacheEntry is like NewCacheEntry, but with the given creation time and optional gzip compression of the gob-encoded bytes.
+// Compression costs CPU time, but keeps more entries below fastcache's limit of 64 KB for Set().
+func newCacheEntry(ctx context.Context, data []Result, created time.Time, compress bool) ([]byte, error) {
 	entry := cacheEntry{
-		Created: time.Now(),
+		Created: created,
 		Results: data,
 	}
 	writer := bytes.Buffer{}
-	encoder := gob.NewEncoder(&writer)
+	var encoder *gob.Encoder
+	var gzipWriter *gzip.Writer
+	if compress {
+		gzipWriter = gzip.NewWriter(&writer)
+		encoder = gob.NewEncoder(gzipWriter)
+	} else {
+		encoder = gob.NewEncoder(&writer)
+	}
 	if err := encoder.Encode(entry); err != nil {
 		return nil, fmt.Errorf("Couldn't encode cacheEntry: %v", err)
 	}
+	if compress {
+		if err := gzipWriter.Close(); err != nil {
+			return nil, fmt.Errorf("Couldn't compress cacheEntry: %v", err)
+		}
+	}
 	return writer.Bytes(), nil
 }
 
 // FromCacheEntry turns data via gob-decoding into a cacheEntry and returns its results and creation time.
+// Compressed data is detected by its gzip header and decompressed first.
 func FromCacheEntry(ctx context.Context, data []byte) ([]Result, time.Time, error) {
-	reader := bytes.NewReader(data)
+	var reader io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, gzipHeader) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("Couldn't decompress cacheEntry: %v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
 	decoder := gob.NewDecoder(reader)
 	var entry cacheEntry
 	if err := decoder.Decode(&entry); err != nil {
 		return nil, time.Time{}, fmt.Errorf("Couldn't decode cacheEntry: %v", err)
 	}
+	// Entries that were cached before results had a bit depth only have it in the quality.
+	// The same goes for the annotations of the quality, like "(web)", which older entries don't have in their own fields yet.
+	for i := range entry.Results {
+		if entry.Results[i].BitDepth == 0 {
+			entry.Results[i].BitDepth = parseBitDepth(entry.Results[i].Quality)
+		}
+		entry.Results[i] = normalizeQuality(entry.Results[i])
+	}
 	return entry.Results, entry.Created, nil
 }
+
+// getCachedResults returns the cached results for the given key and true, or nil and false if there's no valid entry or the context was created with WithBypassCache().
+// now returns the current time, which is time.Now except in tests that need to fast-forward time.
+// The max age of each entry is shifted by an offset in the range of [-jitter, +jitter] so that entries that were cached at the same time (for example when the cache was warmed) don't expire at the same time.
+func getCachedResults(ctx context.Context, cache *fastcache.Cache, cacheKey string, cacheAge, jitter time.Duration, now func() time.Time, logger *log.Entry) (results []Result, cacheHit bool) {
+	// The span of the torrent site's search, which is a no-op if tracing is disabled
+	defer func() {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cacheHit", cacheHit))
+	}()
+	if bypassCacheFromContext(ctx) {
+		logger.Debug("Bypassing cache for torrents")
+		return nil, false
+	}
+	torrentsGob, ok := cache.HasGet(nil, []byte(cacheKey))
+	if !ok {
+		return nil, false
+	}
+	torrentList, created, err := FromCacheEntry(ctx, torrentsGob)
+	if err != nil {
+		logger.WithError(err).Error("Couldn't decode torrent results")
+		return nil, false
+	}
+	maxAge := cacheAge + jitterOffset(cacheKey, created, jitter)
+	if age := now().Sub(created); age >= maxAge {
+		expiredSince := age - maxAge
+		logger.WithField("expiredSince", expiredSince).Debug("Hit cache for torrents, but entry is expired")
+		return nil, false
+	}
+	if marker := staleMarkerFromContext(ctx); marker != nil {
+		if now().Sub(created) >= maxAge-marker.refreshWindow {
+			logger.Debug("Hit cache for torrents, but entry expires soon")
+			marker.stale = true
+		} else if len(torrentList) < marker.minResults {
+			logger.WithField("minResults", marker.minResults).Debug("Hit cache for torrents, but entry has too few results")
+			marker.stale = true
+		}
+	}
+	logger.WithField("torrentCount", len(torrentList)).Debug("Hit cache for torrents, returning results")
+	return torrentList, true
+}
+
+// cacheEntryNeedsRefresh returns true if there's a cache entry for the key that's expired or expires within the refresh window, with the same max age as in getCachedResults().
+// Entries that can't be decoded need a refresh as well.
+func cacheEntryNeedsRefresh(ctx context.Context, cache *fastcache.Cache, cacheKey string, cacheAge, jitter, refreshWindow time.Duration, now func() time.Time) bool {
+	torrentsGob, ok := cache.HasGet(nil, []byte(cacheKey))
+	if !ok {
+		return false
+	}
+	_, created, err := FromCacheEntry(ctx, torrentsGob)
+	if err != nil {
+		return true
+	}
+	maxAge := cacheAge + jitterOffset(cacheKey, created, jitter)
+	return now().Sub(created) >= maxAge-refreshWindow
+}
+
+// setCachedResults fills the cache with the given results, using now() as creation time.
+func setCachedResults(ctx context.Context, cache *fastcache.Cache, cacheKey string, results []Result, now func() time.Time, compress bool, logger *log.Entry) {
+	torrentsGob, err := newCacheEntry(ctx, results, now(), compress)
+	if err != nil {
+		logger.WithError(err).WithField("cache", "torrent").Error("Couldn't create cache entry for torrents")
+		return
+	}
+	entrySize := strconv.Itoa(len(torrentsGob)/1024) + "KB"
+	if len(torrentsGob) > 64*1024 {
+		logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Warn("New cacheEntry is bigger than 64KB, which means it won't be stored in the cache when calling fastcache's Set() method. SetBig() (and GetBig()) must be used instead!")
+	} else {
+		logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Debug("Caching torrent results")
+	}
+	cache.Set([]byte(cacheKey), torrentsGob)
+}
+
+// titleCacheKey returns a cache key prefix for a search by movie title and year, which is the same for different spellings of the title regarding case and whitespace.
+func titleCacheKey(title string, year int) string {
+	normalizedTitle := strings.ToLower(strings.Join(strings.Fields(title), " "))
+	return "title:" + normalizedTitle + "-" + strconv.Itoa(year)
+}
+
+// jitterOffset returns a pseudo-random offset in the range of [-jitter, +jitter].
+// It's derived from the cache key and the entry's creation time, so it stays the same for each read of the same entry.
+func jitterOffset(cacheKey string, created time.Time, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(cacheKey + strconv.FormatInt(created.UnixNano(), 10)))
+	return time.Duration(h.Sum64()%uint64(2*jitter+1)) - jitter
+}
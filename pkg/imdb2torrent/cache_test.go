@@ -0,0 +1,131 @@
+package imdb2torrent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// largeResultSet returns results like the ones of a popular movie, with realistic titles and magnet URLs.
+func largeResultSet(count int) []Result {
+	trackers := []string{"udp://tracker.opentrackr.org:1337/announce", "udp://open.stealth.si:80/announce", "udp://tracker.torrent.eu.org:451/announce"}
+	results := make([]Result, count)
+	for i := range results {
+		infoHash := fmt.Sprintf("%040X", i)
+		title := fmt.Sprintf("Big.Buck.Bunny.2008.1080p.BluRay.x264-GROUP%v", i%20)
+		results[i] = Result{
+			Title:     title,
+			Quality:   "1080p",
+			Source:    SourceBluRay,
+			InfoHash:  infoHash,
+			MagnetURL: BuildMagnet(infoHash, title, trackers),
+			Trackers:  trackers,
+			Group:     fmt.Sprintf("GROUP%v", i%20),
+			Size:      int64(1024*1024*1024 + i),
+			Seeders:   i,
+			BitDepth:  8,
+		}
+	}
+	return results
+}
+
+func TestCacheEntryRoundTrip(t *testing.T) {
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		results  []Result
+		compress bool
+	}{
+		{"no results", nil, false},
+		{"no results compressed", nil, true},
+		{"results", largeResultSet(3), false},
+		{"results compressed", largeResultSet(3), true},
+		{"large result set", largeResultSet(500), false},
+		{"large result set compressed", largeResultSet(500), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := newCacheEntry(context.Background(), tt.results, created, tt.compress)
+			if err != nil {
+				t.Fatalf("Couldn't create cache entry: %v", err)
+			}
+			if compressed := bytes.HasPrefix(data, gzipHeader); compressed != tt.compress {
+				t.Errorf("Expected compressed to be %v, got %v", tt.compress, compressed)
+			}
+
+			results, decodedCreated, err := FromCacheEntry(context.Background(), data)
+			if err != nil {
+				t.Fatalf("Couldn't decode cache entry: %v", err)
+			}
+			if !decodedCreated.Equal(created) {
+				t.Errorf("Expected creation time %v, got %v", created, decodedCreated)
+			}
+			if len(results) != len(tt.results) {
+				t.Fatalf("Expected %v results, got %v", len(tt.results), len(results))
+			}
+			for i := range results {
+				if results[i].InfoHash != tt.results[i].InfoHash || results[i].MagnetURL != tt.results[i].MagnetURL || results[i].Size != tt.results[i].Size {
+					t.Errorf("Expected result %+v, got %+v", tt.results[i], results[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFromCacheEntryInvalid(t *testing.T) {
+	compressed, err := newCacheEntry(context.Background(), largeResultSet(10), time.Now(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"garbage", []byte("not a cache entry")},
+		{"gzip header only", gzipHeader},
+		{"truncated compressed entry", compressed[:len(compressed)/2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := FromCacheEntry(context.Background(), tt.data); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+// TestCompressedLargeEntryFits checks that a result set that's too big for fastcache's Set() when uncompressed is cached when compressed.
+func TestCompressedLargeEntryFits(t *testing.T) {
+	results := largeResultSet(300)
+	tests := []struct {
+		compress    bool
+		expectCache bool
+	}{
+		{false, false},
+		{true, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("compress=%v", tt.compress), func(t *testing.T) {
+			data, err := newCacheEntry(context.Background(), results, testNow(), tt.compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fits := len(data) <= 64*1024; fits != tt.expectCache {
+				t.Errorf("Expected entry of %v bytes to fit into 64KB: %v", len(data), tt.expectCache)
+			}
+
+			cache := newTestCache()
+			setCachedResults(context.Background(), cache, "tt1254207-YTS", results, testNow, tt.compress, testLogger())
+			cached, ok := getCachedResults(context.Background(), cache, "tt1254207-YTS", time.Hour, 0, testNow, testLogger())
+			if ok != tt.expectCache {
+				t.Fatalf("Expected cache hit to be %v, got %v", tt.expectCache, ok)
+			}
+			if ok && len(cached) != len(results) {
+				t.Errorf("Expected %v cached results, got %v", len(results), len(cached))
+			}
+		})
+	}
+}
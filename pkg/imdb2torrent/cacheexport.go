@@ -0,0 +1,86 @@
+package imdb2torrent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ExportedCacheEntry is a single line of the newline-delimited JSON that ExportCache() writes and ImportCache() reads.
+type ExportedCacheEntry struct {
+	ImdbID  string    `json:"imdbID"`
+	Site    string    `json:"site"`
+	Created time.Time `json:"created"`
+	Results []Result  `json:"results"`
+}
+
+// ExportCache writes the cached results of all torrent sites and the curated results (see SeedResults()) for the given IMDb IDs to w, as newline-delimited JSON of ExportedCacheEntry objects.
+// fastcache can't enumerate its keys, so the IMDb IDs must be provided by the caller.
+// IMDb IDs without cached results for a site are skipped. Expired entries are exported as well, because their creation time is part of the export.
+func (c Client) ExportCache(ctx context.Context, w io.Writer, imdbIDs []string) error {
+	// Curated results are exported as well, so that they survive a restore
+	torrentSites := []string{curatedSite}
+	for torrentSite := range c.GetMagnetSearchers() {
+		torrentSites = append(torrentSites, torrentSite)
+	}
+	sort.Strings(torrentSites)
+
+	encoder := json.NewEncoder(w)
+	for _, imdbID := range imdbIDs {
+		for _, torrentSite := range torrentSites {
+			torrentsGob, ok := c.cache.HasGet(nil, []byte(imdbID+"-"+torrentSite))
+			if !ok {
+				continue
+			}
+			results, created, err := FromCacheEntry(ctx, torrentsGob)
+			if err != nil {
+				return fmt.Errorf("Couldn't decode cached results of %v for IMDb ID %v: %v", torrentSite, imdbID, err)
+			}
+			entry := ExportedCacheEntry{
+				ImdbID:  imdbID,
+				Site:    torrentSite,
+				Created: created,
+				Results: results,
+			}
+			if err = encoder.Encode(entry); err != nil {
+				return fmt.Errorf("Couldn't write cache entry: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ImportCache reads newline-delimited JSON of ExportedCacheEntry objects (as written by ExportCache()) from r and fills the cache with them.
+// The creation time of each entry is kept, so restored entries expire like the original ones.
+// Entries with an unknown torrent site lead to an error, but the entries that were read before are already in the cache.
+func (c Client) ImportCache(ctx context.Context, r io.Reader) error {
+	torrentSites := c.GetMagnetSearchers()
+	scanner := bufio.NewScanner(r)
+	// Lines can be longer than the scanner's default max of 64 KB
+	scanner.Buffer(nil, 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry ExportedCacheEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("Couldn't unmarshal line %v: %v", lineNo, err)
+		}
+		if _, ok := torrentSites[entry.Site]; !ok && entry.Site != curatedSite {
+			return fmt.Errorf("Unknown torrent site in line %v: %v", lineNo, entry.Site)
+		}
+		torrentsGob, err := newCacheEntry(ctx, entry.Results, entry.Created, c.compressCache)
+		if err != nil {
+			return fmt.Errorf("Couldn't create cache entry for line %v: %v", lineNo, err)
+		}
+		c.cache.Set([]byte(entry.ImdbID+"-"+entry.Site), torrentsGob)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Couldn't read cache entries: %v", err)
+	}
+	return nil
+}
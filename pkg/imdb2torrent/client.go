@@ -3,223 +3,1461 @@ package imdb2torrent
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
 	"net/url"
 	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/doingodswork/deflix-stremio/pkg/cinemata"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 )
 
+// The regexes only match up to the next delimiter, so that they can't span over the rest of a big page body when the delimiter is missing.
+// Go's regexp package runs in linear time, so there's no catastrophic backtracking, but the scanned input is still bounded by maxBodySize.
 var (
-	magnet2InfoHashRegex = regexp.MustCompile(`btih:.+?&`)     // The "?" makes the ".+" non-greedy
-	regexMagnet          = regexp.MustCompile(`'magnet:?.+?'`) // The "?" makes the ".+" non-greedy
+	magnet2InfoHashRegex = regexp.MustCompile(`btih:[^&]+&`)
+	regexMagnet          = regexp.MustCompile(`'magnet:\?[^'\n]+'`)
 )
 
+// maxBodySize is the max number of bytes that are read from a response body of a torrent site.
+// Regular pages are much smaller, so this only limits the work for broken or malicious responses.
+const maxBodySize = 5 * 1024 * 1024
+
+// readBody reads the response body of a torrent site up to maxBodySize bytes and returns an error if it's bigger.
+func readBody(body io.Reader) ([]byte, error) {
+	bodyBytes, err := ioutil.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bodyBytes) > maxBodySize {
+		return nil, fmt.Errorf("Response body is bigger than %v bytes", maxBodySize)
+	}
+	return bodyBytes, nil
+}
+
+// supportedQualities are the qualities of the results that FindMagnets returns.
+var supportedQualities = []string{"720p", "1080p", "1080p 10bit", "2160p", "2160p 10bit"}
+
 type MagnetSearcher interface {
 	Check(ctx context.Context, imdbID string) ([]Result, error)
 }
 
 type Client struct {
-	timeout     time.Duration
-	ytsClient   ytsClient
-	tpbClient   tpbClient
-	leetxClient leetxClient
-	ibitClient  ibitClient
-	tpbRetries  int
+	timeout             time.Duration
+	cache               *fastcache.Cache
+	httpClient          *http.Client
+	ytsClient           ytsClient
+	tpbClient           tpbClient
+	leetxClient         leetxClient
+	ibitClient          ibitClient
+	solidTorrentsClient solidTorrentsClient
+	tpbRetries          int
+	// Sites that are searched again when their response looked like a results page, but didn't lead to any torrents
+	retryEmptySites map[string]bool
+	// Combine the trackers of duplicate results from different torrent sites
+	mergeTrackers bool
+	// Max number of trackers that magnet URLs get when trackers are added to them. 0 means no limit.
+	maxTrackers int
+	// Upper case hosts of the trackers that magnet URLs may contain, including their subdomains. Empty means all trackers are allowed.
+	allowedTrackers map[string]struct{}
+	// Drop cam and telesync releases
+	excludeCam bool
+	// Collapse results that are probably re-uploads of the same release
+	fuzzyDedup bool
+	// Searches on a single site that take longer are logged. 0 means disabled.
+	slowScrapeThreshold time.Duration
+	// Max duration of an ibit search, including the part that runs in the background
+	maxDurationIbit time.Duration
+	// Wait for ibit like for the other sites instead of letting it continue in the background
+	syncIbit bool
+	// Gzip cache entries
+	compressCache bool
+	// Upper case info hashes that are removed from all results. Can be extended at runtime, so access is guarded by blockLock.
+	blockedInfoHashes map[string]struct{}
+	blockLock         *sync.RWMutex
+	// Size bounds in bytes, 0 means no limit
+	minSize int64
+	maxSize int64
+	// Drop results with unknown size when a size bound is set
+	dropUnknownSize bool
+	// Upper case release groups to keep or remove. An empty includeGroups means all groups are kept.
+	includeGroups map[string]struct{}
+	excludeGroups map[string]struct{}
+	// Drop results with unknown release group
+	dropUnknownGroup bool
+	// Qualities and resolutions to remove, like "2160p" or "1080p 10bit"
+	excludeQualities map[string]struct{}
+	// Sources to remove, like "webrip". Results with unknown source are kept.
+	excludeSources map[string]struct{}
+	// Keep results with QualityUnknown instead of removing them
+	keepUnknownQuality bool
+	// Coalesces concurrent searches for the same IMDb ID. nil if disabled.
+	searchGroup *singleflight.Group
+	// Coalesces concurrent searches for the same IMDb ID on the same torrent site. nil if disabled.
+	siteSearchGroup *singleflight.Group
+	// Creates the root spans of searches. nil if tracing is disabled.
+	tracer trace.Tracer
+	// Qualities from most to least preferred
+	qualityPreference []string
+	// One of the RemuxPreference... constants
+	remuxPreference string
+	// For the runtime of movies when estimating bitrates
+	cinemataClient cinemata.Client
+	// Set Result.EstimatedBitrate of the results of searches by IMDb ID
+	estimateBitrate bool
+	// Rolling error rates of the torrent sites
+	health *siteHealth
+	// Most recent errors of the torrent sites
+	errHistory *errorHistory
+	// Limits the requests to all torrent sites together
+	budget *scrapeBudget
+	// Trust weights of the torrent sites for sorting. Sites without weight have a weight of 1.
+	siteWeights map[string]float64
+	// Max duration of a search on a single torrent site, after which it's abandoned, in case it hangs despite the HTTP timeout. 0 means no limit.
+	siteDeadline time.Duration
+	// Max age of cached results and the range of its random offset, for finding the entries that Warm() refreshes
+	cacheAge       time.Duration
+	cacheAgeJitter time.Duration
+	// Cached results that expire within this window are returned, but refreshed in the background. 0 means disabled.
+	refreshWindow time.Duration
+	// Cached results of a site with fewer results than its min are returned, but refreshed in the background like with the refresh window
+	minCachedResults map[string]int
+	background       *backgroundTasks
 }
 
-func NewClient(ctx context.Context, baseURLyts, baseURLtpb, baseURL1337x, baseURLibit string, socksProxyAddrTPB string, timeout time.Duration, tpbRetries int, torrentCache *fastcache.Cache, cinemataCache *fastcache.Cache, cacheAge time.Duration) (Client, error) {
-	cinemataClient := cinemata.NewClient(ctx, timeout, cinemataCache)
-	tpbClient, err := newTPBclient(ctx, baseURLtpb, socksProxyAddrTPB, timeout, torrentCache, cacheAge)
+// NewClient creates a client that searches all supported torrent sites.
+// Without options it uses the public torrent sites with default settings and small in-memory caches, see the With... functions for what can be configured.
+// An error is returned if an option is invalid, for example an unknown torrent site name.
+func NewClient(ctx context.Context, opts ...Option) (Client, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return Client{}, fmt.Errorf("Invalid option: %v", err)
+		}
+	}
+	if o.torrentCache == nil {
+		o.torrentCache = fastcache.New(defaultCacheSize)
+	}
+	if o.cinemataCache == nil {
+		o.cinemataCache = fastcache.New(defaultCacheSize)
+	}
+
+	cinemataClient := cinemata.NewClient(ctx, o.timeout, o.cinemataCache, o.cacheAgeCinemata, o.cinemataLimit)
+	tpbClient, err := newTPBclient(ctx, o.baseURLs["TPB"], o.socksProxy.addr, o.socksProxy.user, o.socksProxy.password, o.timeout, o.torrentCache, o.cacheAge, o.cacheAgeJitter, time.Now, o.compressCache, o.retryEmptyTPB, o.rateLimits["TPB"])
 	if err != nil {
 		return Client{}, fmt.Errorf("Couldn't create TPB client: %v", err)
 	}
-	return Client{
-		timeout:     timeout,
-		ytsClient:   newYTSclient(ctx, baseURLyts, timeout, torrentCache, cacheAge),
-		tpbClient:   tpbClient,
-		leetxClient: newLeetxclient(ctx, baseURL1337x, timeout, torrentCache, cinemataClient, cacheAge),
-		ibitClient:  newIbitClient(ctx, baseURLibit, timeout, torrentCache, cacheAge),
-		tpbRetries:  tpbRetries,
-	}, nil
+	c := Client{
+		timeout: o.timeout,
+		cache:   o.torrentCache,
+		httpClient: &http.Client{
+			Timeout: o.timeout,
+		},
+		ytsClient:           newYTSclient(ctx, o.baseURLs["YTS"], o.timeout, o.torrentCache, o.cacheAge, o.cacheAgeJitter, time.Now, o.compressCache, o.collapseYTS, o.movieDetailsYTS, o.titleMatching, o.rateLimits["YTS"]),
+		tpbClient:           tpbClient,
+		leetxClient:         newLeetxclient(ctx, o.baseURLs["1337x"], o.timeout, o.torrentCache, cinemataClient, o.cacheAge, o.cacheAgeJitter, time.Now, o.compressCache, o.titleMatching, o.rateLimits["1337x"], o.concurrency1337x, o.maxResults1337x, o.unknownQuality, o.allYears),
+		ibitClient:          newIbitClient(ctx, o.baseURLs["ibit"], o.timeout, o.torrentCache, o.cacheAge, o.cacheAgeJitter, time.Now, o.compressCache, o.rateLimits["ibit"], o.parallelIbit),
+		solidTorrentsClient: newSolidTorrentsClient(ctx, o.baseURLs["SolidTorrents"], o.timeout, o.torrentCache, cinemataClient, o.cacheAge, o.cacheAgeJitter, time.Now, o.compressCache, o.titleMatching, o.rateLimits["SolidTorrents"], o.allYears),
+		tpbRetries:          o.tpbRetries,
+		retryEmptySites:     o.retryEmptySites,
+		mergeTrackers:       o.mergeTrackers,
+		maxTrackers:         o.maxTrackers,
+		allowedTrackers:     upperCaseSet(o.allowedTrackers),
+		excludeCam:          o.excludeCam,
+		fuzzyDedup:          o.fuzzyDedup,
+		slowScrapeThreshold: o.slowScrapeThresh,
+		siteDeadline:        o.siteDeadline,
+		maxDurationIbit:     o.maxDurationIbit,
+		syncIbit:            o.syncIbit,
+		compressCache:       o.compressCache,
+		blockedInfoHashes:   map[string]struct{}{},
+		blockLock:           &sync.RWMutex{},
+		minSize:             o.minSize,
+		maxSize:             o.maxSize,
+		dropUnknownSize:     o.dropUnknownSize,
+		includeGroups:       upperCaseSet(o.includeGroups),
+		excludeGroups:       upperCaseSet(o.excludeGroups),
+		dropUnknownGroup:    o.dropUnknownGroup,
+		excludeQualities:    stringSet(o.excludeQualities),
+		excludeSources:      stringSet(o.excludeSources),
+		keepUnknownQuality:  o.unknownQuality,
+		tracer:              o.tracer,
+		qualityPreference:   o.qualityPreference,
+		remuxPreference:     o.remuxPreference,
+		health:              newSiteHealth(o.degradedErrRate),
+		errHistory:          newErrorHistory(o.errHistorySize),
+		budget:              newScrapeBudget(o.scrapeBudget),
+		siteWeights:         o.siteWeights,
+		cinemataClient:      cinemataClient,
+		estimateBitrate:     o.estimateBitrate,
+		cacheAge:            o.cacheAge,
+		cacheAgeJitter:      o.cacheAgeJitter,
+		refreshWindow:       o.refreshWindow,
+		minCachedResults:    o.minCachedResults,
+		background:          newBackgroundTasks(),
+	}
+	if err := c.checkQualityPreference(); err != nil {
+		return Client{}, fmt.Errorf("Invalid option: %v", err)
+	}
+	if o.coalesceSearches {
+		c.searchGroup = &singleflight.Group{}
+		c.siteSearchGroup = &singleflight.Group{}
+	}
+	for _, infoHash := range o.blockedInfoHashes {
+		c.blockedInfoHashes[strings.ToUpper(infoHash)] = struct{}{}
+	}
+	for _, mirrors := range []*mirrorList{c.ytsClient.mirrors, c.tpbClient.mirrors, c.leetxClient.mirrors, c.ibitClient.mirrors, c.solidTorrentsClient.mirrors} {
+		mirrors.dnsRetries = o.dnsRetries
+		mirrors.budget = c.budget
+	}
+	if o.httpClient != nil {
+		c.httpClient = o.httpClient
+		c.ytsClient.httpClient = o.httpClient
+		c.tpbClient.httpClient = o.httpClient
+		c.leetxClient.httpClient = o.httpClient
+		c.ibitClient.httpClient = o.httpClient
+		c.solidTorrentsClient.httpClient = o.httpClient
+		return c, nil
+	}
+	for _, httpClient := range []*http.Client{c.httpClient, c.ytsClient.httpClient, c.tpbClient.httpClient, c.leetxClient.httpClient, c.ibitClient.httpClient, c.solidTorrentsClient.httpClient} {
+		tuneTransport(httpClient, o.maxIdleConns, o.idleConnTimeout, o.disableKeepAlives)
+	}
+	return c, nil
+}
+
+// NewClientFromParams creates a client with the parameters of NewClient() before it took options. All other settings have their defaults.
+//
+// Deprecated: Use NewClient() with options instead. This function is only kept for existing callers and won't get parameters for new settings.
+func NewClientFromParams(ctx context.Context, baseURLyts, baseURLtpb, baseURL1337x, baseURLibit string, socksProxyAddrTPB string, timeout time.Duration, tpbRetries int, torrentCache *fastcache.Cache, cinemataCache *fastcache.Cache, cacheAge time.Duration) (Client, error) {
+	return NewClient(ctx,
+		WithBaseURL("YTS", baseURLyts),
+		WithBaseURL("TPB", baseURLtpb),
+		WithBaseURL("1337x", baseURL1337x),
+		WithBaseURL("ibit", baseURLibit),
+		WithTPBProxy(socksProxyAddrTPB, "", ""),
+		WithTimeout(timeout),
+		WithTPBRetries(tpbRetries, false),
+		WithTorrentCache(torrentCache, cacheAge, 0),
+		WithCinemataCache(cinemataCache, 0),
+	)
+}
+
+// tuneTransport sets the connection pooling options on the HTTP client's transport.
+// A client without transport gets a copy of the default transport, so that the default transport itself isn't changed.
+func tuneTransport(httpClient *http.Client, maxIdleConnsPerHost int, idleConnTimeout time.Duration, disableKeepAlives bool) {
+	if httpClient.Transport == nil {
+		httpClient.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	transport.DisableKeepAlives = disableKeepAlives
 }
 
 // FindMagnets tries to find magnet URLs for the given IMDb ID.
-// It only returns 720p, 1080p, 1080p 10bit, 2160p and 2160p 10bit videos.
+// The IMDb ID is turned into its canonical form first, see CanonicalIMDbID().
+// It only returns videos with a quality that's listed by SupportedQualities().
 // It caches results once they're found.
+// Results of BitTorrent v2-only torrents are included, but have an empty InfoHash, see Result.InfoHashV2.
 // It can return an empty slice and no error if no actual error occurred (for example if torrents where found but no >=720p videos).
+// Torrent sites can be skipped for a single call by passing a context created with WithSkippedSites().
+// Additional trackers for the magnet URLs of a single call can be passed via a context created with WithRequestTrackers().
+// Trackers that aren't allowed by WithAllowedTrackerHosts() are removed from the magnet URLs after that.
+// If the client is configured to coalesce searches, concurrent calls for the same IMDb ID share a single search.
+// If the client has a tracer, the search is traced with a span per torrent site and HTTP request.
 func (c Client) FindMagnets(ctx context.Context, imdbID string) ([]Result, error) {
+	imdbID, err := CanonicalIMDbID(imdbID)
+	if err != nil {
+		return nil, err
+	}
+	return c.traced(ctx, "FindMagnets", []attribute.KeyValue{attribute.String("imdbID", imdbID)}, func(ctx context.Context) ([]Result, error) {
+		results, err := c.findMagnetsCoalesced(ctx, imdbID)
+		if err != nil {
+			return nil, err
+		}
+		c.applyTrackers(ctx, results)
+		return results, nil
+	})
+}
+
+// FindMagnetsOrNotFound is like FindMagnets(), but returns ErrNoTorrentsFound instead of an empty slice, so that callers can tell "searched and found nothing" apart from an error with a single check.
+// Other errors, like when all torrent sites failed, are returned as they are.
+func (c Client) FindMagnetsOrNotFound(ctx context.Context, imdbID string) ([]Result, error) {
+	results, err := c.FindMagnets(ctx, imdbID)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNoTorrentsFound
+	}
+	return results, nil
+}
+
+// FindMagnetsSorted is like FindMagnets(), but the results are sorted by the client's quality preference order, and results of the same quality by their number of seeders.
+func (c Client) FindMagnetsSorted(ctx context.Context, imdbID string) ([]Result, error) {
+	results, err := c.FindMagnets(ctx, imdbID)
+	if err != nil {
+		return nil, err
+	}
+	c.SortResults(results)
+	return results, nil
+}
+
+// FindMagnetsWith is like FindMagnets(), but the results are sorted by the given comparator, which returns true if a should be sorted before b.
+// Results that are equal for the comparator keep their order. See BySeeders(), ByQuality() and BySize() for building blocks, which can be combined with SortedBy().
+func (c Client) FindMagnetsWith(ctx context.Context, imdbID string, less func(a, b Result) bool) ([]Result, error) {
+	results, err := c.FindMagnets(ctx, imdbID)
+	if err != nil {
+		return nil, err
+	}
+	sortResults(results, less)
+	return results, nil
+}
+
+// findMagnetsCoalesced searches all torrent sites for the given IMDb ID, sharing the search with concurrent calls if the client is configured to do so.
+func (c Client) findMagnetsCoalesced(ctx context.Context, imdbID string) ([]Result, error) {
+	// Calls with skipped sites or bypassed cache would get different results, so they don't share the search
+	if c.searchGroup == nil || len(skippedSitesFromContext(ctx)) > 0 || bypassCacheFromContext(ctx) {
+		return c.findMagnetsByIMDbID(ctx, imdbID)
+	}
+	resChan := c.searchGroup.DoChan(imdbID, func() (interface{}, error) {
+		// Other callers can wait for the search, so it must not be canceled when the caller that started it is gone.
+		// The torrent sites' HTTP clients have a timeout, so the search doesn't run forever.
+		return c.findMagnetsByIMDbID(valueOnlyContext{ctx}, imdbID)
+	})
+	select {
+	case res := <-resChan:
+		if res.Shared {
+			log.WithContext(ctx).WithField("imdbID", imdbID).Debug("Shared torrent search with concurrent request")
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		// Each caller gets its own copy, so that filtering or sorting it in place doesn't affect the other callers
+		return append([]Result(nil), res.Val.([]Result)...), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// findMagnetsByIMDbID searches all torrent sites for the given IMDb ID, without sharing the search with concurrent calls.
+func (c Client) findMagnetsByIMDbID(ctx context.Context, imdbID string) ([]Result, error) {
 	logger := log.WithContext(ctx).WithField("imdbID", imdbID)
+	// The runtime is requested concurrently with the search, so that it usually doesn't add to the duration of the search
+	var runtimeChan chan time.Duration
+	if c.estimateBitrate {
+		runtimeChan = make(chan time.Duration, 1)
+		go func() {
+			runtime, err := c.cinemataClient.GetMovieRuntime(ctx, imdbID)
+			if err != nil {
+				logger.WithError(err).Warn("Couldn't get movie runtime from Cinemata, skipping bitrate estimation")
+			}
+			runtimeChan <- runtime
+		}()
+	}
+	sites, backgroundSite := c.imdbSiteSearches(ctx, imdbID, c.syncIbit)
+	results, err := c.findMagnets(ctx, logger, sites, backgroundSite)
+	if err != nil || runtimeChan == nil {
+		return results, err
+	}
+	setEstimatedBitrates(results, <-runtimeChan)
+	return results, nil
+}
 
-	torrentSiteCount := 3
-	resChan := make(chan []Result, torrentSiteCount)
-	errChan := make(chan error, torrentSiteCount)
+// setEstimatedBitrates sets the estimated bitrate of the results with a known size, based on the movie's runtime. A runtime of 0 means it's unknown, then nothing is set.
+func setEstimatedBitrates(results []Result, runtime time.Duration) {
+	if runtime <= 0 {
+		return
+	}
+	for i := range results {
+		if results[i].Size > 0 {
+			results[i].EstimatedBitrate = int64(float64(results[i].Size*8) / runtime.Seconds())
+		}
+	}
+}
 
-	// YTS
-	go func() {
-		logger.WithField("torrentSite", "YTS").Debug("Started searching torrents...")
-		results, err := c.ytsClient.Check(ctx, imdbID)
-		if err != nil {
-			logger.WithError(err).WithField("torrentSite", "YTS").Warn("Couldn't find torrents")
-			errChan <- err
-		} else {
-			fields := log.Fields{
-				"torrentSite":  "YTS",
-				"torrentCount": len(results),
+// imdbSiteSearches returns the searches of all torrent sites for the given IMDb ID.
+// Unless syncIbit is true, the ibit search is returned separately, to be used as background site.
+// If the client is configured to coalesce searches, the search on each site is shared with concurrent calls for the same IMDb ID.
+// Results that were added via SeedResults() are searched like an additional torrent site.
+func (c Client) imdbSiteSearches(ctx context.Context, imdbID string, syncIbit bool) ([]siteSearch, *siteSearch) {
+	sites, ibit := c.uncoalescedIMDbSiteSearches(imdbID, syncIbit)
+	for i := range sites {
+		sites[i].check = c.emptyRetryingCheck(sites[i])
+	}
+	if ibit != nil {
+		ibit.check = c.emptyRetryingCheck(*ibit)
+	}
+	if c.refreshWindow > 0 || len(c.minCachedResults) > 0 {
+		for i := range sites {
+			sites[i].check = c.revalidatingCheck(imdbID, sites[i])
+		}
+		if ibit != nil {
+			ibit.check = c.revalidatingCheck(imdbID, *ibit)
+		}
+	}
+	if c.siteSearchGroup != nil {
+		for i := range sites {
+			sites[i].check = c.coalescedCheck(ctx, imdbID, sites[i])
+		}
+		if ibit != nil {
+			ibit.check = c.coalescedCheck(ctx, imdbID, *ibit)
+		}
+	}
+	// Curated results are only read from the cache, so there's nothing to revalidate or coalesce
+	if curated, ok := c.curatedSiteSearch(imdbID); ok {
+		sites = append(sites, curated)
+	}
+	return sites, ibit
+}
+
+// coalescedCheck returns a check function that shares the search on the site with concurrent calls for the same IMDb ID.
+// The caller stops waiting when its context is done, but the shared search continues for the other callers.
+func (c Client) coalescedCheck(ctx context.Context, imdbID string, site siteSearch) func(context.Context) ([]Result, error) {
+	key := imdbID + "-" + site.torrentSite
+	// Searches that bypass the cache must not get cached results from a concurrent search
+	if bypassCacheFromContext(ctx) {
+		key += "-bypassCache"
+	}
+	return func(ctx context.Context) ([]Result, error) {
+		resChan := c.siteSearchGroup.DoChan(key, func() (results interface{}, err error) {
+			// The search runs in its own goroutine, where a panic would crash the process instead of being recovered by search()
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("Torrent search on %v panicked: %v", site.torrentSite, r)
+				}
+			}()
+			// Other callers can wait for the search, so it must not be canceled when the caller that started it is gone
+			return site.check(valueOnlyContext{ctx})
+		})
+		select {
+		case res := <-resChan:
+			if res.Err != nil {
+				return nil, res.Err
 			}
-			logger.WithFields(fields).Debug("Found torrents")
-			resChan <- results
+			// Each caller gets its own copy, so that filtering it in place doesn't affect the other callers
+			return append([]Result(nil), res.Val.([]Result)...), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-	}()
+	}
+}
 
-	// TPB
-	go func() {
-		logger.WithField("torrentSite", "TPB").Debug("Started searching torrents...")
-		results, err := c.tpbClient.checkAttempts(ctx, imdbID, 1+c.tpbRetries)
-		if err != nil {
-			logger.WithError(err).WithField("torrentSite", "TPB").Warn("Couldn't find torrents")
-			errChan <- err
-		} else {
-			fields := log.Fields{
-				"torrentSite":  "TPB",
-				"torrentCount": len(results),
+// revalidatingCheck returns a check function that refreshes the site's cache entry for the IMDb ID in the background when the returned results are from a cache entry that expires soon
+// or has fewer results than the site's min (see WithMinCachedResults()).
+func (c Client) revalidatingCheck(imdbID string, site siteSearch) func(context.Context) ([]Result, error) {
+	return func(ctx context.Context) ([]Result, error) {
+		if bypassCacheFromContext(ctx) {
+			return site.check(ctx)
+		}
+		marker := &staleMarker{refreshWindow: c.refreshWindow, minResults: c.minCachedResults[site.torrentSite]}
+		results, err := site.check(withStaleMarker(ctx, marker))
+		if err != nil || !marker.stale {
+			return results, err
+		}
+		logger := log.WithContext(ctx).WithFields(log.Fields{"imdbID": imdbID, "torrentSite": site.torrentSite})
+		started := c.background.start("refresh-"+imdbID+"-"+site.torrentSite, func() {
+			// The refresh writes the fresh results to the cache and must not be canceled when the request is finished
+			if _, err := site.check(WithBypassCache(valueOnlyContext{ctx})); err != nil {
+				logger.WithError(err).Warn("Couldn't refresh cached torrents")
+				return
 			}
-			logger.WithFields(fields).Debug("Found torrents")
-			resChan <- results
+			logger.Debug("Refreshed cached torrents")
+		})
+		if started {
+			logger.Debug("Refreshing cached torrents in the background")
 		}
-	}()
+		return results, nil
+	}
+}
 
-	// 1337x
-	go func() {
-		logger.WithField("torrentSite", "1337x").Debug("Started searching torrents...")
-		results, err := c.leetxClient.Check(ctx, imdbID)
+// emptyRetryingCheck returns a check function that searches the site a second time when the first search didn't find any torrents, but the site marked its response as results page, see emptyPageMarker.
+// If the site isn't configured to retry empty results, the site's check function is returned as it is.
+func (c Client) emptyRetryingCheck(site siteSearch) func(context.Context) ([]Result, error) {
+	if !c.retryEmptySites[site.torrentSite] {
+		return site.check
+	}
+	return func(ctx context.Context) ([]Result, error) {
+		marker := &emptyPageMarker{}
+		results, err := site.check(withEmptyPageMarker(ctx, marker))
+		if err != nil || len(results) > 0 || !marker.resultsPage {
+			return results, err
+		}
+		logger := log.WithContext(ctx).WithField("torrentSite", site.torrentSite)
+		logger.Debug("Got an empty results page, retrying...")
+		// The first search can have cached its empty results
+		retryResults, err := site.check(WithBypassCache(ctx))
 		if err != nil {
-			logger.WithError(err).WithField("torrentSite", "1337x").Warn("Couldn't find torrents")
-			errChan <- err
-		} else {
-			fields := log.Fields{
-				"torrentSite":  "1337x",
-				"torrentCount": len(results),
-			}
-			logger.WithFields(fields).Debug("Found torrents")
-			resChan <- results
+			logger.WithError(err).Warn("Couldn't retry search after empty results page")
+			return results, nil
 		}
-	}()
+		return retryResults, nil
+	}
+}
 
-	// ibit
-	// Note: An initial movie search takes long, because multiple requests need to be made, but ibit uses rate limiting, so we can't do them concurrently.
+// uncoalescedIMDbSiteSearches returns the searches of all torrent sites for the given IMDb ID like imdbSiteSearches(), but without sharing them.
+func (c Client) uncoalescedIMDbSiteSearches(imdbID string, syncIbit bool) ([]siteSearch, *siteSearch) {
+	sites := []siteSearch{
+		{"YTS", func(ctx context.Context) ([]Result, error) { return c.ytsClient.Check(ctx, imdbID) }},
+		{"TPB", func(ctx context.Context) ([]Result, error) {
+			return c.tpbClient.checkAttempts(ctx, imdbID, 1+c.tpbRetries)
+		}},
+		{"1337x", func(ctx context.Context) ([]Result, error) { return c.leetxClient.Check(ctx, imdbID) }},
+		{"SolidTorrents", func(ctx context.Context) ([]Result, error) { return c.solidTorrentsClient.Check(ctx, imdbID) }},
+	}
+	// Note: An initial movie search on ibit takes long, because multiple requests need to be made, but ibit uses rate limiting, so we can't do them concurrently.
 	// So let's treat this special: Make the request, but only wait for 1 second (in case the cache is filled), then don't cancel the operation, but let it run in the background so the cache gets filled.
 	// With the next movie search for the same IMDb ID the cache is used.
-	ibitResChan := make(chan []Result)
-	ibitErrChan := make(chan error)
-	go func() {
-		logger.WithField("torrentSite", "ibit").Debug("Started searching torrents...")
-		ibitResults, err := c.ibitClient.Check(ctx, imdbID)
+	// The search is aborted after the configured max duration though, so that a slow search doesn't take up ibit's rate limit for other searches forever.
+	// With a fast ibit mirror this can be turned off, then ibit is waited for like the other sites, bounded by the request context.
+	if syncIbit {
+		sites = append(sites, siteSearch{"ibit", func(ctx context.Context) ([]Result, error) {
+			ibitCtx, cancel := context.WithTimeout(ctx, c.maxDurationIbit)
+			defer cancel()
+			return c.ibitClient.Check(ibitCtx, imdbID)
+		}})
+		return sites, nil
+	}
+	ibit := &siteSearch{"ibit", func(ctx context.Context) ([]Result, error) {
+		ibitCtx, cancel := context.WithTimeout(valueOnlyContext{ctx}, c.maxDurationIbit)
+		defer cancel()
+		return c.ibitClient.Check(ibitCtx, imdbID)
+	}}
+
+	return sites, ibit
+}
+
+// FindMagnetsByTitle tries to find magnet URLs for the given movie title and year (0 if unknown).
+// It's meant for callers that don't know the movie's IMDb ID.
+// Only torrent sites that can be searched by title are used, see SearcherCapabilities(), so the results can be incomplete compared to FindMagnets().
+// Apart from that it behaves like FindMagnets().
+func (c Client) FindMagnetsByTitle(ctx context.Context, title string, year int) ([]Result, error) {
+	return c.traced(ctx, "FindMagnetsByTitle", []attribute.KeyValue{attribute.String("title", title), attribute.Int("year", year)}, func(ctx context.Context) ([]Result, error) {
+		results, err := c.findMagnetsByTitle(ctx, title, year)
 		if err != nil {
-			logger.WithError(err).WithField("torrentSite", "ibit").Warn("Couldn't find torrents")
-			ibitErrChan <- err
-		} else {
-			fields := log.Fields{
-				"torrentSite":  "ibit",
-				"torrentCount": len(ibitResults),
-			}
-			logger.WithFields(fields).Debug("Found torrents")
-			ibitResChan <- ibitResults
+			return nil, err
 		}
-	}()
+		c.applyTrackers(ctx, results)
+		return results, nil
+	})
+}
 
-	// Collect results from all except ibit.
-	var combinedResults []Result
-	var errs []error
-	dupRemovalRequired := false
-	for i := 0; i < torrentSiteCount; i++ {
-		// No timeout for the goroutines because their HTTP client has a timeout already
-		select {
-		case err := <-errChan:
-			errs = append(errs, err)
-		case results := <-resChan:
-			if !dupRemovalRequired && len(combinedResults) > 0 && len(results) > 0 {
-				dupRemovalRequired = true
-			}
-			combinedResults = append(combinedResults, results...)
+func (c Client) findMagnetsByTitle(ctx context.Context, title string, year int) ([]Result, error) {
+	logger := log.WithContext(ctx).WithField("title", title).WithField("year", year)
+
+	// Only the sites with the title capability, see siteCapabilities.
+	// A title search on YTS is fine because its API returns the movies' year to match against.
+	titleChecks := map[string]func(context.Context, string, int) ([]Result, error){
+		"YTS":           c.ytsClient.checkTitle,
+		"1337x":         c.leetxClient.checkTitle,
+		"SolidTorrents": c.solidTorrentsClient.checkTitle,
+	}
+	var sites []siteSearch
+	for _, torrentSite := range siteOrder {
+		titleCheck, ok := titleChecks[torrentSite]
+		if !ok || !siteCapabilities[torrentSite].Title {
+			continue
 		}
+		site := siteSearch{torrentSite, func(ctx context.Context) ([]Result, error) { return titleCheck(ctx, title, year) }}
+		site.check = c.emptyRetryingCheck(site)
+		sites = append(sites, site)
 	}
-	close(resChan)
-	close(errChan)
 
-	returnErrors := len(errs) == torrentSiteCount
+	return c.findMagnets(ctx, logger, sites, nil)
+}
 
-	// Now collect result from ibit if it's there.
-	var closeChansOk bool
-	select {
-	case err := <-ibitErrChan:
-		errs = append(errs, err)
-		closeChansOk = true
-	case results := <-ibitResChan:
-		if !dupRemovalRequired && len(combinedResults) > 0 && len(results) > 0 {
-			dupRemovalRequired = true
-		}
-		combinedResults = append(combinedResults, results...)
-		returnErrors = false
-		closeChansOk = true
-	case <-time.After(1 * time.Second):
-		logger.WithField("torrentSite", "ibit").Info("torrent search hasn't finished yet, we'll let it run in the background")
+type siteSearch struct {
+	torrentSite string
+	check       func(context.Context) ([]Result, error)
+}
+
+// findMagnets searches all given sites concurrently and combines their results.
+// If backgroundSite is not nil, its search is only waited for for 1 second and afterwards continues in the background (to fill the cache).
+func (c Client) findMagnets(ctx context.Context, logger *log.Entry, sites []siteSearch, backgroundSite *siteSearch) ([]Result, error) {
+	searched := c.searchSites(ctx, logger, sites, backgroundSite)
+
+	// A single line per search that shows which sites contributed, even when only logging at info level
+	siteCounts := map[string]int{}
+	for torrentSite, results := range searched.results {
+		siteCounts[torrentSite] = len(results)
 	}
-	if closeChansOk {
-		close(ibitErrChan)
-		close(ibitResChan)
+	summaryFields := log.Fields{
+		"siteTorrentCounts": siteCounts,
+		"erroredSites":      searched.erroredSites,
+		"backgroundSites":   searched.backgroundSites,
 	}
 
 	// Return error (only) if all torrent sites returned actual errors (and not just empty results)
-	if returnErrors {
-		errsMsg := "Couldn't find torrents on any site: "
-		for i := 1; i <= torrentSiteCount; i++ {
-			errsMsg += fmt.Sprintf("%v.: %v; ", i, errs[i-1])
+	if err := searched.err(); err != nil {
+		logger.WithFields(summaryFields).WithField("torrentCount", 0).Info("Finished torrent search")
+		return nil, err
+	}
+
+	// In the order of the sites, so that which duplicate is kept doesn't depend on which site responded first
+	resultCount := 0
+	for _, results := range searched.results {
+		resultCount += len(results)
+	}
+	combinedResults := make([]Result, 0, resultCount)
+	dupRemovalRequired := false
+	for _, torrentSite := range searched.order {
+		results := searched.results[torrentSite]
+		if !dupRemovalRequired && len(combinedResults) > 0 && len(results) > 0 {
+			dupRemovalRequired = true
 		}
-		errsMsg = strings.TrimSuffix(errsMsg, "; ")
-		return nil, fmt.Errorf(errsMsg)
+		for _, result := range results {
+			result.Site = torrentSite
+			combinedResults = append(combinedResults, result)
+		}
+	}
+
+	// Results without magnet URL are useless for the caller, but if they have an info hash, a magnet URL can be created for them
+	for i := range combinedResults {
+		combinedResults[i] = completeMagnetURL(combinedResults[i], c.maxTrackers)
 	}
 
 	// Remove duplicates.
 	// Only necessary if we got non-empty results from more than one torrent site.
 	var noDupResults []Result
 	if dupRemovalRequired {
-		infoHashes := map[string]struct{}{}
-		for _, result := range combinedResults {
-			if _, ok := infoHashes[result.InfoHash]; !ok {
-				noDupResults = append(noDupResults, result)
-				infoHashes[result.InfoHash] = struct{}{}
-			}
-		}
+		noDupResults = removeDuplicates(combinedResults, c.mergeTrackers, c.maxTrackers)
 	} else {
 		noDupResults = combinedResults
 	}
+	noDupResults = c.filterResults(logger, noDupResults)
 
 	if len(noDupResults) == 0 {
 		logger.Warn("Couldn't find ANY torrents")
 	}
+	logger.WithFields(summaryFields).WithField("torrentCount", len(noDupResults)).Info("Finished torrent search")
 
 	return noDupResults, nil
 }
 
+// siteSearchResults are the outcome of searching multiple torrent sites concurrently.
+type siteSearchResults struct {
+	// Results of each site that was searched successfully
+	results map[string][]Result
+	// Sites in the order in which they were passed, including the background site
+	order        []string
+	errs         []error
+	erroredSites []string
+	// Sites whose search wasn't waited for and continues in the background
+	backgroundSites []string
+	// How long the search of each site took until it reported results or an error, or until it was abandoned
+	durations map[string]time.Duration
+	// Number of sites that were searched, except for skipped sites and the background site
+	searchedCount int
+	// All torrent sites are disabled, so only pseudo sites like the curated results were searched
+	noActiveSites bool
+}
+
+// err returns ErrNoActiveSites if all torrent sites are disabled and there are no results of pseudo sites, or an error containing the errors of all sites if sites were searched, but none successfully. Otherwise it returns nil.
+func (r siteSearchResults) err() error {
+	if r.noActiveSites && len(r.results) == 0 {
+		return ErrNoActiveSites
+	}
+	if r.searchedCount == 0 || len(r.results) > 0 {
+		return nil
+	}
+	errsMsg := "Couldn't find torrents on any site: "
+	for i, err := range r.errs {
+		errsMsg += fmt.Sprintf("%v.: %v; ", i+1, err)
+	}
+	errsMsg = strings.TrimSuffix(errsMsg, "; ")
+	return fmt.Errorf(errsMsg)
+}
+
+// searchSites searches all given sites concurrently, except for the ones that are skipped via the context or disabled.
+// If backgroundSite is not nil, its search is only waited for for 1 second and afterwards continues in the background (to fill the cache).
+func (c Client) searchSites(ctx context.Context, logger *log.Entry, sites []siteSearch, backgroundSite *siteSearch) siteSearchResults {
+	skippedSites := skippedSitesFromContext(ctx)
+	if len(skippedSites) > 0 {
+		logger.WithField("skippedSites", skippedSites).Debug("Skipping torrent sites for this request")
+	}
+
+	searched := siteSearchResults{
+		results:       map[string][]Result{},
+		durations:     map[string]time.Duration{},
+		noActiveSites: len(c.ActiveSites()) == 0,
+	}
+	// The searches of all sites except the background site write to the results, guarded by the lock.
+	// This doesn't rely on each search reporting exactly once, like a fixed number of channel receives would.
+	// Searches that are abandoned by the watchdog can't write anymore, so that the results can be read without the lock afterwards.
+	lock := sync.Mutex{}
+	abandoned := false
+	reported := map[string]bool{}
+	start := time.Now()
+	addResults := func(torrentSite string, results []Result) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !abandoned {
+			searched.results[torrentSite] = results
+			searched.durations[torrentSite] = time.Since(start)
+			reported[torrentSite] = true
+		}
+	}
+	addErr := func(torrentSite string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !abandoned {
+			searched.errs = append(searched.errs, err)
+			searched.erroredSites = append(searched.erroredSites, torrentSite)
+			searched.durations[torrentSite] = time.Since(start)
+			reported[torrentSite] = true
+		}
+	}
+
+	siteDone := map[string]chan struct{}{}
+	for _, site := range sites {
+		if _, ok := skippedSites[site.torrentSite]; ok {
+			continue
+		}
+		// Disabled sites would only fail because they don't have a base URL
+		if c.siteDisabled(site.torrentSite) {
+			continue
+		}
+		searched.order = append(searched.order, site.torrentSite)
+		searched.searchedCount++
+		done := make(chan struct{})
+		siteDone[site.torrentSite] = done
+		go func(torrentSite string, check func(context.Context) ([]Result, error)) {
+			defer close(done)
+			c.search(ctx, logger, torrentSite, check,
+				func(results []Result) { addResults(torrentSite, results) },
+				func(err error) { addErr(torrentSite, err) })
+		}(site.torrentSite, site.check)
+	}
+
+	// Only read after backgroundDone is closed
+	var backgroundResults []Result
+	var backgroundErr error
+	var backgroundDuration time.Duration
+	backgroundDone := make(chan struct{})
+	waitForBackground := backgroundSite != nil
+	if waitForBackground {
+		if _, ok := skippedSites[backgroundSite.torrentSite]; ok {
+			waitForBackground = false
+		}
+		if c.siteDisabled(backgroundSite.torrentSite) {
+			waitForBackground = false
+		}
+	}
+	if waitForBackground {
+		searched.order = append(searched.order, backgroundSite.torrentSite)
+		go func() {
+			defer close(backgroundDone)
+			c.search(ctx, logger, backgroundSite.torrentSite, backgroundSite.check,
+				func(results []Result) { backgroundResults = results },
+				func(err error) { backgroundErr = err })
+			backgroundDuration = time.Since(start)
+		}()
+	}
+
+	// Collect results from all except the background site.
+	// Their HTTP clients have a timeout, but a search can still hang, for example in CPU-bound parsing of pathological HTML.
+	// So each search is only waited for until its watchdog deadline, and then treated as timed out and abandoned.
+	var hungSites []string
+	for _, torrentSite := range searched.order {
+		done, ok := siteDone[torrentSite]
+		if !ok {
+			continue
+		}
+		deadline := c.watchdogDeadline(torrentSite)
+		if deadline <= 0 {
+			<-done
+			continue
+		}
+		timer := time.NewTimer(time.Until(start.Add(deadline)))
+		select {
+		case <-done:
+		case <-timer.C:
+			hungSites = append(hungSites, torrentSite)
+		}
+		timer.Stop()
+	}
+	if len(hungSites) > 0 {
+		lock.Lock()
+		abandoned = true
+		for _, torrentSite := range hungSites {
+			// The search might have finished right after the deadline
+			if reported[torrentSite] {
+				continue
+			}
+			logger.WithField("torrentSite", torrentSite).Warn("Torrent search didn't finish before the watchdog deadline, abandoning it")
+			searched.errs = append(searched.errs, fmt.Errorf("Torrent search on %v didn't finish within %v", torrentSite, c.watchdogDeadline(torrentSite)))
+			searched.erroredSites = append(searched.erroredSites, torrentSite)
+			searched.durations[torrentSite] = time.Since(start)
+		}
+		lock.Unlock()
+	}
+
+	// Now collect result from the background site if it's there.
+	if waitForBackground {
+		select {
+		case <-backgroundDone:
+			searched.durations[backgroundSite.torrentSite] = backgroundDuration
+			if backgroundErr != nil {
+				searched.errs = append(searched.errs, backgroundErr)
+				searched.erroredSites = append(searched.erroredSites, backgroundSite.torrentSite)
+			} else {
+				searched.results[backgroundSite.torrentSite] = backgroundResults
+			}
+		case <-time.After(1 * time.Second):
+			logger.WithField("torrentSite", backgroundSite.torrentSite).Info("torrent search hasn't finished yet, we'll let it run in the background")
+			searched.backgroundSites = append(searched.backgroundSites, backgroundSite.torrentSite)
+		}
+	}
+
+	return searched
+}
+
+// watchdogDeadline returns the max duration of a search on the torrent site before it's abandoned, with 0 meaning no deadline.
+// The deadline of ibit is extended to its max search duration when it's searched synchronously.
+func (c Client) watchdogDeadline(torrentSite string) time.Duration {
+	if c.siteDeadline <= 0 {
+		return 0
+	}
+	if torrentSite == "ibit" && c.maxDurationIbit+c.timeout > c.siteDeadline {
+		return c.maxDurationIbit + c.timeout
+	}
+	return c.siteDeadline
+}
+
+// FindMagnetsBySite searches all torrent sites for the given IMDb ID like FindMagnets(), but returns the results of each site separately, keyed by the site name.
+// The results aren't deduplicated or filtered, so that what the sites found can be compared. Sites whose search failed or continues in the background are missing in the map.
+// Like with FindMagnets() the trackers of WithRequestTrackers() are added to the magnet URLs and the ones that aren't allowed by WithAllowedTrackerHosts() are removed.
+// Like with FindMagnets() an error is only returned if all searched sites failed.
+func (c Client) FindMagnetsBySite(ctx context.Context, imdbID string) (map[string][]Result, error) {
+	imdbID, err := CanonicalIMDbID(imdbID)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.WithContext(ctx).WithField("imdbID", imdbID)
+	sites, backgroundSite := c.imdbSiteSearches(ctx, imdbID, c.syncIbit)
+	searched := c.searchSites(ctx, logger, sites, backgroundSite)
+	if err := searched.err(); err != nil {
+		return nil, err
+	}
+	for torrentSite, results := range searched.results {
+		for i := range results {
+			results[i] = completeMagnetURL(results[i], c.maxTrackers)
+			results[i].Site = torrentSite
+		}
+		c.applyTrackers(ctx, results)
+		searched.results[torrentSite] = results
+	}
+	return searched.results, nil
+}
+
+// SiteScrape is the outcome of searching a single torrent site, as returned by ScrapeSites().
+type SiteScrape struct {
+	Site string `json:"site"`
+	// Raw results of the site, without deduplication or filtering
+	Results []Result `json:"results"`
+	// Error message if the search failed
+	Err string `json:"error,omitempty"`
+	// How long the search took. Zero if it continues in the background.
+	Duration time.Duration `json:"durationNs"`
+	// True if the search wasn't waited for and continues in the background
+	Background bool `json:"background,omitempty"`
+	// Most recent errors of the site including the one of this search, see RecentErrors()
+	RecentErrors []ErrorRecord `json:"recentErrors,omitempty"`
+}
+
+// ScrapeSites searches all torrent sites for the given IMDb ID like FindMagnetsBySite(), but also reports the error, duration and recent errors of each site.
+// It's meant for debugging which sites return what. The returned slice has one element per searched site, in the order in which the sites are configured.
+// Other than FindMagnetsBySite() it doesn't return an error if all sites failed, because the per-site errors are part of the result. Only if all sites are disabled, ErrNoActiveSites is returned.
+func (c Client) ScrapeSites(ctx context.Context, imdbID string) ([]SiteScrape, error) {
+	imdbID, err := CanonicalIMDbID(imdbID)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.WithContext(ctx).WithField("imdbID", imdbID)
+	sites, backgroundSite := c.imdbSiteSearches(ctx, imdbID, c.syncIbit)
+	searched := c.searchSites(ctx, logger, sites, backgroundSite)
+	if searched.noActiveSites {
+		return nil, ErrNoActiveSites
+	}
+
+	siteErrs := make(map[string]error, len(searched.erroredSites))
+	for i, torrentSite := range searched.erroredSites {
+		siteErrs[torrentSite] = searched.errs[i]
+	}
+	backgroundSites := make(map[string]struct{}, len(searched.backgroundSites))
+	for _, torrentSite := range searched.backgroundSites {
+		backgroundSites[torrentSite] = struct{}{}
+	}
+
+	scrapes := make([]SiteScrape, 0, len(searched.order))
+	for _, torrentSite := range searched.order {
+		scrape := SiteScrape{
+			Site:         torrentSite,
+			Results:      []Result{},
+			Duration:     searched.durations[torrentSite],
+			RecentErrors: c.RecentErrors(torrentSite),
+		}
+		if err, ok := siteErrs[torrentSite]; ok {
+			scrape.Err = err.Error()
+		} else if _, ok := backgroundSites[torrentSite]; ok {
+			scrape.Background = true
+		} else if results := searched.results[torrentSite]; results != nil {
+			for _, result := range results {
+				result.Site = torrentSite
+				scrape.Results = append(scrape.Results, completeMagnetURL(result, c.maxTrackers))
+			}
+			c.applyTrackers(ctx, scrape.Results)
+		}
+		scrapes = append(scrapes, scrape)
+	}
+	return scrapes, nil
+}
+
+// TopMovie is a movie of a torrent site's top listing, with the torrents of the movie in that listing.
+type TopMovie struct {
+	// Movie title and year as parsed from the torrent titles, so they can differ from the official ones. The year is 0 if unknown.
+	Title string
+	Year  int
+	// Torrents of the movie in the listing, in the order of the listing
+	Results []Result
+}
+
+// TopMoviesTPB returns the movies of the top 100 torrents of the given TPB category, for example TPBCategoryHDMovies, or "48h207" for the top 100 HD movies of the last 48 hours.
+// The movies are in the order of their first torrent in the listing, and results with the same movie title and year are grouped.
+// TPB's listing doesn't contain IMDb IDs, so the titles can be used with FindMagnetsByTitle() to warm the cache for popular movies.
+// Results of torrents whose title doesn't contain a supported quality are removed, like by FindMagnets(). The listing is cached like the results of a search.
+func (c Client) TopMoviesTPB(ctx context.Context, category string) ([]TopMovie, error) {
+	results, err := c.tpbClient.top(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.WithContext(ctx).WithField("category", category)
+	for i := range results {
+		results[i].Site = "TPB"
+	}
+	results = c.filterResults(logger, removeDuplicates(results, c.mergeTrackers, c.maxTrackers))
+
+	var movies []TopMovie
+	movieIndexes := map[string]int{}
+	for _, result := range results {
+		title, year := releaseTitleYear(result.Title)
+		if title == "" {
+			continue
+		}
+		key := titleCacheKey(title, year)
+		if i, ok := movieIndexes[key]; ok {
+			movies[i].Results = append(movies[i].Results, completeMagnetURL(result, c.maxTrackers))
+			continue
+		}
+		movieIndexes[key] = len(movies)
+		movies = append(movies, TopMovie{
+			Title:   title,
+			Year:    year,
+			Results: []Result{completeMagnetURL(result, c.maxTrackers)},
+		})
+	}
+	for _, movie := range movies {
+		c.applyTrackers(ctx, movie.Results)
+	}
+	return movies, nil
+}
+
+// Block adds the info hash to the blocked info hashes, so that it's removed from all following search results, including the ones from the cache.
+func (c Client) Block(infoHash string) {
+	c.blockLock.Lock()
+	defer c.blockLock.Unlock()
+	c.blockedInfoHashes[strings.ToUpper(infoHash)] = struct{}{}
+}
+
+// IsBlocked returns true if the info hash was blocked via the client configuration or Block().
+func (c Client) IsBlocked(infoHash string) bool {
+	c.blockLock.RLock()
+	defer c.blockLock.RUnlock()
+	_, ok := c.blockedInfoHashes[strings.ToUpper(infoHash)]
+	return ok
+}
+
+// filterResults removes results of blocked info hashes and unknown quality (unless they should be kept), and near duplicates, cam releases and results outside of the configured size, group and quality filters if the client is configured to do so.
+// Results of v2-only torrents are kept, it's up to the caller whether it can use them.
+func (c Client) filterResults(logger *log.Entry, noDupResults []Result) []Result {
+	n := 0
+	for _, result := range noDupResults {
+		if c.IsBlocked(result.InfoHash) {
+			logger.WithField("infoHash", result.InfoHash).Debug("Dropped torrent with blocked info_hash")
+		} else if result.Quality == QualityUnknown && !c.keepUnknownQuality {
+			logger.WithField("infoHash", result.InfoHash).Trace("Dropped torrent with unknown quality")
+		} else {
+			noDupResults[n] = result
+			n++
+		}
+	}
+	noDupResults = noDupResults[:n]
+
+	if c.fuzzyDedup {
+		resultCount := len(noDupResults)
+		noDupResults = removeNearDuplicates(noDupResults)
+		if len(noDupResults) < resultCount {
+			logger.WithField("nearDuplicateCount", resultCount-len(noDupResults)).Debug("Removed near duplicates")
+		}
+	}
+
+	if c.excludeCam {
+		// https://github.com/golang/go/wiki/SliceTricks#filter-in-place
+		n := 0
+		for _, result := range noDupResults {
+			if !isCamRelease(result) {
+				noDupResults[n] = result
+				n++
+			}
+		}
+		if n < len(noDupResults) {
+			logger.WithField("camCount", len(noDupResults)-n).Debug("Excluded cam releases")
+		}
+		noDupResults = noDupResults[:n]
+	}
+
+	if c.minSize > 0 || c.maxSize > 0 {
+		n := 0
+		for _, result := range noDupResults {
+			if c.sizeAllowed(result.Size) {
+				noDupResults[n] = result
+				n++
+			}
+		}
+		if n < len(noDupResults) {
+			logger.WithField("sizeCount", len(noDupResults)-n).Debug("Excluded torrents outside of the size bounds")
+		}
+		noDupResults = noDupResults[:n]
+	}
+
+	if len(c.includeGroups) > 0 || len(c.excludeGroups) > 0 || c.dropUnknownGroup {
+		n := 0
+		for _, result := range noDupResults {
+			if c.groupAllowed(result.Group) {
+				noDupResults[n] = result
+				n++
+			}
+		}
+		if n < len(noDupResults) {
+			logger.WithField("groupCount", len(noDupResults)-n).Debug("Excluded torrents by release group")
+		}
+		noDupResults = noDupResults[:n]
+	}
+
+	if len(c.excludeQualities) > 0 {
+		n := 0
+		for _, result := range noDupResults {
+			if !c.qualityExcluded(result.Quality) {
+				noDupResults[n] = result
+				n++
+			}
+		}
+		if n < len(noDupResults) {
+			logger.WithField("qualityCount", len(noDupResults)-n).Debug("Excluded torrents by quality")
+		}
+		noDupResults = noDupResults[:n]
+	}
+
+	if len(c.excludeSources) > 0 {
+		n := 0
+		for _, result := range noDupResults {
+			if _, ok := c.excludeSources[result.Source]; !ok {
+				noDupResults[n] = result
+				n++
+			}
+		}
+		if n < len(noDupResults) {
+			logger.WithField("sourceCount", len(noDupResults)-n).Debug("Excluded torrents by source")
+		}
+		noDupResults = noDupResults[:n]
+	}
+
+	return noDupResults
+}
+
+// qualityExcluded returns true if the quality or its resolution is one of the excluded qualities, so that for example "2160p" also excludes "2160p 10bit".
+func (c Client) qualityExcluded(quality string) bool {
+	if _, ok := c.excludeQualities[quality]; ok {
+		return true
+	}
+	resolution := strings.SplitN(quality, " ", 2)[0]
+	_, ok := c.excludeQualities[resolution]
+	return ok
+}
+
+// groupAllowed returns true if the release group passes the configured group filter.
+// Excluded groups take precedence over included ones. An unknown group (empty string) is allowed unless dropUnknownGroup is set.
+func (c Client) groupAllowed(group string) bool {
+	if group == "" {
+		return !c.dropUnknownGroup
+	}
+	group = strings.ToUpper(group)
+	if _, ok := c.excludeGroups[group]; ok {
+		return false
+	}
+	if len(c.includeGroups) == 0 {
+		return true
+	}
+	_, ok := c.includeGroups[group]
+	return ok
+}
+
+// stringSet returns the strings as set.
+func stringSet(list []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(list))
+	for _, s := range list {
+		set[s] = struct{}{}
+	}
+	return set
+}
+
+// upperCaseSet returns the strings as upper case set, for case-insensitive lookups.
+func upperCaseSet(list []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(list))
+	for _, s := range list {
+		set[strings.ToUpper(s)] = struct{}{}
+	}
+	return set
+}
+
+// sizeAllowed returns true if the size is within the configured size bounds.
+// An unknown size (0) is allowed unless dropUnknownSize is set.
+func (c Client) sizeAllowed(size int64) bool {
+	if size == 0 {
+		return !c.dropUnknownSize
+	}
+	return (c.minSize <= 0 || size >= c.minSize) && (c.maxSize <= 0 || size <= c.maxSize)
+}
+
+// SupportedQualities returns the qualities of videos that FindMagnets returns with the client's configuration, for example "1080p 10bit", in the order of the quality preference.
+// Excluded qualities are missing and QualityUnknown is included if results with an unknown quality are kept, see WithExcludedQualities() and WithUnknownQuality().
+// The returned slice is a copy, so it's safe to be modified by the caller.
+func (c Client) SupportedQualities() []string {
+	result := make([]string, 0, len(supportedQualities)+1)
+	for _, quality := range supportedQualities {
+		if !c.qualityExcluded(quality) {
+			result = append(result, quality)
+		}
+	}
+	if c.keepUnknownQuality {
+		result = append(result, QualityUnknown)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return c.QualityRank(result[i]) < c.QualityRank(result[j])
+	})
+	return result
+}
+
+// search searches torrents on a single torrent site by calling check and then calls either onResults or onErr.
+// A panic in check (for example caused by unexpected HTML) is turned into an error as well, so that a single site can't crash the whole process.
+// Searches that take longer than the configured threshold are logged with warn level.
+func (c Client) search(ctx context.Context, logger *log.Entry, torrentSite string, check func(context.Context) ([]Result, error), onResults func([]Result), onErr func(error)) {
+	ctx, span := startSpan(ctx, "Check", attribute.String("torrentSite", torrentSite))
+	// For the error history
+	last := &lastRequest{lock: &sync.Mutex{}}
+	ctx = withLastRequest(ctx, last)
+	var results []Result
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			logger.WithField("torrentSite", torrentSite).WithField("panic", r).WithField("stack", string(debug.Stack())).Error("Torrent search panicked")
+			err = fmt.Errorf("Torrent search on %v panicked: %v", torrentSite, r)
+			c.health.record(torrentSite, true)
+			c.errHistory.record(torrentSite, err, last.get())
+			onErr(err)
+		}
+		endSpan(span, len(results), err)
+	}()
+
+	logger.WithField("torrentSite", torrentSite).Debug("Started searching torrents...")
+	start := time.Now()
+	results, err = check(ctx)
+	duration := time.Since(start)
+	if c.slowScrapeThreshold > 0 && duration > c.slowScrapeThreshold {
+		fields := log.Fields{
+			"torrentSite": torrentSite,
+			"duration":    duration,
+			"threshold":   c.slowScrapeThreshold,
+		}
+		logger.WithFields(fields).Warn("Slow scrape")
+	}
+	if err != nil {
+		logger.WithError(err).WithField("torrentSite", torrentSite).Warn("Couldn't find torrents")
+		c.health.record(torrentSite, true)
+		c.errHistory.record(torrentSite, err, last.get())
+		onErr(err)
+		return
+	}
+	c.health.record(torrentSite, false)
+	fields := log.Fields{
+		"torrentSite":  torrentSite,
+		"torrentCount": len(results),
+	}
+	logger.WithFields(fields).Debug("Found torrents")
+	onResults(results)
+}
+
+// isCamRelease returns true if the result is a cam or telesync release, which are both recorded in a movie theater.
+func isCamRelease(result Result) bool {
+	return result.ReleaseType == "cam" || result.ReleaseType == "telesync"
+}
+
+// removeNearDuplicates removes results that are probably re-uploads of the same release with a different info_hash.
+// They're detected by the same normalized title, quality and a size within roughly 2%, and of those the result with the most seeders is kept.
+// Results with an unknown size are always kept, because title and quality alone are too vague.
+func removeNearDuplicates(results []Result) []Result {
+	var kept []Result
+	indexes := map[string]int{}
+	for _, result := range results {
+		key := nearDuplicateKey(result)
+		if key == "" {
+			kept = append(kept, result)
+			continue
+		}
+		if i, ok := indexes[key]; !ok {
+			indexes[key] = len(kept)
+			kept = append(kept, result)
+		} else if result.Seeders > kept[i].Seeders {
+			kept[i] = result
+		}
+	}
+	return kept
+}
+
+// nearDuplicateKey returns the key by which near duplicates are detected, or an empty string if the result's size is unknown.
+func nearDuplicateKey(result Result) string {
+	if result.Size <= 0 {
+		return ""
+	}
+	// Some torrent sites only give us the movie name as title, but the magnet URL contains the release title
+	title := result.Title
+	if magnet, err := ParseMagnet(result.MagnetURL); err == nil && magnet.DisplayName != "" {
+		title = magnet.DisplayName
+	}
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sizeBucket := int(math.Log(float64(result.Size)) / math.Log(1.02))
+	return strings.Join(words, " ") + "|" + result.Quality + "|" + result.ReleaseType + "|" + strconv.Itoa(sizeBucket)
+}
+
+// removeDuplicates removes results with the same info_hash.
+// Of duplicates the first result is kept, but with the magnet URL that contains the most trackers, because with more trackers RealDebrid is more likely to find peers for a torrent that it didn't cache yet.
+// With mergeTrackers the trackers of all duplicates are combined in the kept magnet URL, up to maxTrackers trackers.
+// The kept result gets the most specific quality of all duplicates, because some torrent sites' titles don't mention the bit depth for example.
+func removeDuplicates(results []Result, mergeTrackers bool, maxTrackers int) []Result {
+	noDupResults := make([]Result, 0, len(results))
+	indexes := make(map[string]int, len(results))
+	for _, result := range results {
+		// v2-only torrents don't have a v1 info_hash
+		key := result.InfoHash
+		if key == "" {
+			key = result.InfoHashV2
+		}
+		i, ok := indexes[key]
+		if !ok {
+			indexes[key] = len(noDupResults)
+			noDupResults = append(noDupResults, result)
+			continue
+		}
+		kept := noDupResults[i]
+		if isMoreSpecificQuality(result, kept) {
+			kept.Quality = result.Quality
+			kept.BitDepth = result.BitDepth
+		}
+		keptTrackers := magnetTrackers(kept.MagnetURL)
+		dupTrackers := magnetTrackers(result.MagnetURL)
+		if len(dupTrackers) > len(keptTrackers) {
+			kept.MagnetURL = result.MagnetURL
+			keptTrackers, dupTrackers = dupTrackers, keptTrackers
+		}
+		// The magnet URL only has to be parsed again if trackers were added
+		if mergeTrackers {
+			kept.MagnetURL = addTrackers(kept.MagnetURL, dupTrackers, maxTrackers)
+			keptTrackers = magnetTrackers(kept.MagnetURL)
+		}
+		kept.Trackers = keptTrackers
+		noDupResults[i] = kept
+	}
+	return noDupResults
+}
+
+// isMoreSpecificQuality returns true if the quality of a is more specific than the one of b, which is the case for a known quality compared to QualityUnknown, a higher bit depth or, for the same bit depth, more tokens like in "1080p 10bit" compared to "1080p".
+// For equally specific qualities it returns false, so that the first of multiple duplicates keeps its quality.
+func isMoreSpecificQuality(a, b Result) bool {
+	// Any quality is more specific than an unknown one
+	if (a.Quality == QualityUnknown) != (b.Quality == QualityUnknown) {
+		return b.Quality == QualityUnknown
+	}
+	if a.BitDepth != b.BitDepth {
+		return a.BitDepth > b.BitDepth
+	}
+	return len(strings.Fields(a.Quality)) > len(strings.Fields(b.Quality))
+}
+
+// Invalidate removes the cached results of all torrent sites for the given IMDb ID, so that the next search scrapes the sites again.
+// The cache keys are deleted with fastcache's Del(), which works because the key of each torrent site is known ("<imdbID>-<torrentSite>").
+// Results cached by FindMagnetsByTitle() are removed as well, if the movie's title and year are in the Cinemata cache, because their keys are based on the title.
+// Only the title searches with the movie's year and without year are removed, title searches with a differently spelled title stay cached until they expire.
+// Results that were added via SeedResults() aren't removed, because they aren't scraped.
+// An ibit search that's currently running in the background will fill the cache again when it finishes.
+func (c Client) Invalidate(imdbID string) {
+	if canonicalIMDbID, err := CanonicalIMDbID(imdbID); err == nil {
+		imdbID = canonicalIMDbID
+	}
+	for torrentSite := range c.GetMagnetSearchers() {
+		c.cache.Del([]byte(imdbID + "-" + torrentSite))
+	}
+	// Requesting Cinemata isn't worth it, a movie that was searched by a site that needs the title is in the Cinemata cache
+	title, year, err := c.cinemataClient.GetMovieNameYear(cinemata.WithCacheOnly(context.Background()), imdbID)
+	if err != nil {
+		return
+	}
+	for torrentSite, capabilities := range siteCapabilities {
+		if !capabilities.Title {
+			continue
+		}
+		c.cache.Del([]byte(titleCacheKey(title, year) + "-" + torrentSite))
+		c.cache.Del([]byte(titleCacheKey(title, 0) + "-" + torrentSite))
+	}
+}
+
+// RefreshAndDiff scrapes all torrent sites for the given IMDb ID again, ignoring the cache, and returns which torrents appeared and disappeared compared to the previously cached results.
+// Torrents are compared by their info_hash. The cache is updated with the fresh results.
+// Unlike FindMagnets() it waits for ibit, so that its results don't show up as removed.
+func (c Client) RefreshAndDiff(ctx context.Context, imdbID string) (added, removed []Result, err error) {
+	if imdbID, err = CanonicalIMDbID(imdbID); err != nil {
+		return nil, nil, err
+	}
+	logger := log.WithContext(ctx).WithField("imdbID", imdbID)
+
+	// Expired entries are used as well, they're still the previous state
+	var cached []Result
+	for torrentSite := range c.GetMagnetSearchers() {
+		torrentsGob, ok := c.cache.HasGet(nil, []byte(imdbID+"-"+torrentSite))
+		if !ok {
+			continue
+		}
+		results, _, err := FromCacheEntry(ctx, torrentsGob)
+		if err != nil {
+			logger.WithError(err).WithField("torrentSite", torrentSite).Warn("Couldn't decode cached torrent results, treating them as empty")
+			continue
+		}
+		cached = append(cached, results...)
+	}
+	cached = c.filterResults(logger, removeDuplicates(cached, c.mergeTrackers, c.maxTrackers))
+
+	ctx = WithBypassCache(ctx)
+	sites, _ := c.imdbSiteSearches(ctx, imdbID, true)
+	fresh, err := c.findMagnets(ctx, logger, sites, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	added, removed = diffResults(cached, fresh)
+	c.applyTrackers(ctx, added)
+	c.applyTrackers(ctx, removed)
+	return added, removed, nil
+}
+
+// diffResults returns the results that are only in newResults (added) and the ones that are only in oldResults (removed), compared by info_hash.
+func diffResults(oldResults, newResults []Result) (added, removed []Result) {
+	oldInfoHashes := map[string]struct{}{}
+	for _, result := range oldResults {
+		oldInfoHashes[result.InfoHash] = struct{}{}
+	}
+	newInfoHashes := map[string]struct{}{}
+	for _, result := range newResults {
+		newInfoHashes[result.InfoHash] = struct{}{}
+		if _, ok := oldInfoHashes[result.InfoHash]; !ok {
+			added = append(added, result)
+		}
+	}
+	for _, result := range oldResults {
+		if _, ok := newInfoHashes[result.InfoHash]; !ok {
+			removed = append(removed, result)
+		}
+	}
+	return added, removed
+}
+
 func (c Client) GetMagnetSearchers() map[string]MagnetSearcher {
 	return map[string]MagnetSearcher{
-		"YTS":   c.ytsClient,
-		"TPB":   c.tpbClient,
-		"1337x": c.leetxClient,
-		"ibit":  c.ibitClient,
+		"YTS":           c.ytsClient,
+		"TPB":           c.tpbClient,
+		"1337x":         c.leetxClient,
+		"ibit":          c.ibitClient,
+		"SolidTorrents": c.solidTorrentsClient,
 	}
 }
 
 type Result struct {
 	Title string
-	// For example "720p" or "720p (web)"
-	Quality   string
-	InfoHash  string
-	MagnetURL string
+	// Torrent site that the result was found on, for example "YTS", or "curated" for results that were added via SeedResults().
+	// For torrents that were found on multiple sites it's the first of them in the search order. Results in the torrent cache don't have it, it's set when they're returned.
+	Site string
+	// Year of the movie according to the torrent title, for example 2017, to tell movies with the same title apart, see WithAllYears().
+	// Only set by torrent sites that are searched by title. 0 if unknown.
+	Year int
+	// Canonical quality, for example "720p" or "1080p 10bit". See QualityLabel() for a version with the other annotations.
+	Quality string
+	// Source of the release, for example "web-dl", "webrip" or "bluray", see the Source... constants. Empty if unknown.
+	Source string
+	// Low quality release type, for example "cam" or "telesync". Empty for regular releases.
+	ReleaseType string
+	// The release is a remux of its source, like a Blu-ray, instead of an encode
+	Remux bool
+	// The release is marked as PROPER, which fixes another group's faulty release
+	Proper bool
+	// The release is marked as REPACK, which fixes the group's own faulty release
+	Repack bool
+	// The torrent site was searched by title instead of IMDb ID, so we cannot be 100% sure it's the correct movie
+	GuessedMatch bool
+	InfoHash     string
+	MagnetURL    string
+	// Unique trackers of the magnet URL, in the same order
+	Trackers []string
+	// BitTorrent v2 info hash (multihash) of v2 and hybrid torrents.
+	// Results of v2-only torrents have this, but an empty InfoHash, which RealDebrid doesn't support yet.
+	InfoHashV2 string
+	// Release group, for example "SPARKS" or "YIFY". Empty if unknown.
+	Group string
+	// Size of the torrent's content in bytes. 0 if unknown.
+	Size int64
+	// Number of seeders when the torrent site was scraped. 0 if unknown.
+	Seeders int
+	// Number of leechers when the torrent site was scraped. 0 if unknown.
+	Leechers int
+	// Average bitrate in bits per second, estimated from the size and the movie's runtime. 0 if unknown or if the client isn't configured to estimate it.
+	// A bitrate that's much lower than usual for the quality can indicate a fake.
+	EstimatedBitrate int64
+	// Color bit depth, 8 or 10. A bit depth of 10 is also part of the Quality, like in "1080p 10bit".
+	BitDepth int
+}
+
+// newRateLimiter returns a limiter for the given number of requests per second, with 0 meaning no limit.
+func newRateLimiter(requestsPerSecond float64) *rate.Limiter {
+	if requestsPerSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
 }
 
 func replaceURL(origURL, newBaseURL string) (string, error) {
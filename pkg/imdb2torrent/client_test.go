@@ -0,0 +1,521 @@
+package imdb2torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestClient(t *testing.T, opts ...Option) Client {
+	t.Helper()
+	opts = append([]Option{WithTorrentCache(newTestCache(), time.Hour, 0), WithCinemataCache(newTestCache(), time.Hour)}, opts...)
+	client, err := NewClient(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("Couldn't create client: %v", err)
+	}
+	return client
+}
+
+func testLogger() *log.Entry {
+	logger := log.New()
+	logger.SetLevel(log.PanicLevel)
+	return log.NewEntry(logger)
+}
+
+// mockSite returns a search of a pseudo torrent site that returns the given results or error without any request.
+func mockSite(torrentSite string, results []Result, err error) siteSearch {
+	return siteSearch{
+		torrentSite: torrentSite,
+		check: func(context.Context) ([]Result, error) {
+			return results, err
+		},
+	}
+}
+
+// panickingSite returns a search of a pseudo torrent site that panics, like a scraper that runs into unexpected HTML.
+func panickingSite(torrentSite string) siteSearch {
+	return siteSearch{
+		torrentSite: torrentSite,
+		check: func(context.Context) ([]Result, error) {
+			var doc map[string][]Result
+			doc["rows"] = nil // Assignment to nil map
+			return nil, nil
+		},
+	}
+}
+
+func mockResult(infoHash, quality string) Result {
+	return Result{
+		Title:    "Big Buck Bunny",
+		Quality:  quality,
+		InfoHash: infoHash,
+	}
+}
+
+func TestFindMagnetsPanickingSearcher(t *testing.T) {
+	results := []Result{mockResult("1111111111111111111111111111111111111111", "1080p")}
+	tests := []struct {
+		name        string
+		sites       []siteSearch
+		expectErr   bool
+		resultCount int
+	}{
+		{"single site panics", []siteSearch{panickingSite("mock1")}, true, 0},
+		{"all sites panic", []siteSearch{panickingSite("mock1"), panickingSite("mock2")}, true, 0},
+		{"other site has results", []siteSearch{panickingSite("mock1"), mockSite("mock2", results, nil)}, false, 1},
+		{"other site errors", []siteSearch{panickingSite("mock1"), mockSite("mock2", nil, errors.New("mock error"))}, true, 0},
+	}
+	client := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := client.findMagnets(context.Background(), testLogger(), tt.sites, nil)
+			if tt.expectErr && err == nil {
+				t.Fatal("Expected an error")
+			} else if !tt.expectErr && err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if tt.expectErr && !strings.Contains(err.Error(), "panicked") {
+				t.Errorf("Expected the error to mention the panic, got: %v", err)
+			}
+			if len(found) != tt.resultCount {
+				t.Errorf("Expected %v results, got %v", tt.resultCount, len(found))
+			}
+		})
+	}
+}
+
+// nilPointerSite returns a search of a pseudo torrent site that panics with a nil pointer dereference, like a scraper that expects an HTML element that's missing.
+func nilPointerSite(torrentSite string) siteSearch {
+	return siteSearch{
+		torrentSite: torrentSite,
+		check: func(context.Context) ([]Result, error) {
+			var result *Result
+			return []Result{*result}, nil
+		},
+	}
+}
+
+func TestSearchSitesPanickingBackgroundSite(t *testing.T) {
+	results := []Result{mockResult("1111111111111111111111111111111111111111", "1080p")}
+	tests := []struct {
+		name           string
+		sites          []siteSearch
+		backgroundSite siteSearch
+		expectErr      bool
+		resultCount    int
+	}{
+		{"background site panics", []siteSearch{mockSite("mock1", results, nil)}, panickingSite("mock2"), false, 1},
+		{"background site has nil pointer", []siteSearch{mockSite("mock1", results, nil)}, nilPointerSite("mock2"), false, 1},
+		{"all sites panic", []siteSearch{nilPointerSite("mock1")}, panickingSite("mock2"), true, 0},
+		{"only background site has results", []siteSearch{nilPointerSite("mock1")}, mockSite("mock2", results, nil), false, 1},
+	}
+	client := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			var searched siteSearchResults
+			go func() {
+				defer close(done)
+				searched = client.searchSites(context.Background(), testLogger(), tt.sites, &tt.backgroundSite)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Search didn't finish, a panic probably blocked the collection of the results")
+			}
+			err := searched.err()
+			if tt.expectErr && err == nil {
+				t.Error("Expected an error")
+			} else if !tt.expectErr && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+			resultCount := 0
+			for _, siteResults := range searched.results {
+				resultCount += len(siteResults)
+			}
+			if resultCount != tt.resultCount {
+				t.Errorf("Expected %v results, got %v", tt.resultCount, resultCount)
+			}
+		})
+	}
+}
+
+func TestSearchReportsOnce(t *testing.T) {
+	tests := []struct {
+		name          string
+		site          siteSearch
+		expectResults int
+		expectErrs    int
+	}{
+		{"results", mockSite("mock", []Result{mockResult("1111111111111111111111111111111111111111", "720p")}, nil), 1, 0},
+		{"empty results", mockSite("mock", nil, nil), 1, 0},
+		{"error", mockSite("mock", nil, errors.New("mock error")), 0, 1},
+		{"panic", panickingSite("mock"), 0, 1},
+		{"nil pointer", nilPointerSite("mock"), 0, 1},
+	}
+	client := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resultCalls, errCalls := 0, 0
+			client.search(context.Background(), testLogger(), tt.site.torrentSite, tt.site.check,
+				func([]Result) { resultCalls++ },
+				func(error) { errCalls++ })
+			if resultCalls != tt.expectResults || errCalls != tt.expectErrs {
+				t.Errorf("Expected %v result and %v error callbacks, got %v and %v", tt.expectResults, tt.expectErrs, resultCalls, errCalls)
+			}
+		})
+	}
+}
+
+// TestSearchSitesLateReport checks that a search which reports after it was abandoned by the watchdog neither blocks nor changes the collected results.
+func TestSearchSitesLateReport(t *testing.T) {
+	client := newTestClient(t, WithSiteDeadline(50*time.Millisecond))
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	hung := siteSearch{
+		torrentSite: "mock1",
+		check: func(context.Context) ([]Result, error) {
+			defer close(finished)
+			<-release
+			return []Result{mockResult("2222222222222222222222222222222222222222", "720p")}, nil
+		},
+	}
+	results := []Result{mockResult("1111111111111111111111111111111111111111", "1080p")}
+	sites := []siteSearch{hung, mockSite("mock2", results, nil)}
+
+	searched := client.searchSites(context.Background(), testLogger(), sites, nil)
+	close(release)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("The abandoned search didn't finish after it was released")
+	}
+
+	if len(searched.erroredSites) != 1 || searched.erroredSites[0] != "mock1" {
+		t.Errorf("Expected the hung site to be errored, got: %v", searched.erroredSites)
+	}
+	if _, ok := searched.results["mock1"]; ok {
+		t.Error("Expected no results of the abandoned site")
+	}
+	if len(searched.results["mock2"]) != 1 {
+		t.Errorf("Expected the results of the other site, got: %v", searched.results)
+	}
+}
+
+// TestSearchSitesConcurrentReports checks that many sites reporting at the same time are all collected.
+func TestSearchSitesConcurrentReports(t *testing.T) {
+	client := newTestClient(t)
+	start := &sync.WaitGroup{}
+	start.Add(1)
+	var sites []siteSearch
+	for i := 0; i < 50; i++ {
+		torrentSite := "mock" + string(rune('A'+i))
+		sites = append(sites, siteSearch{
+			torrentSite: torrentSite,
+			check: func(context.Context) ([]Result, error) {
+				start.Wait()
+				return []Result{}, nil
+			},
+		})
+	}
+	go start.Done()
+
+	searched := client.searchSites(context.Background(), testLogger(), sites, nil)
+	if len(searched.results) != len(sites) {
+		t.Errorf("Expected results of %v sites, got %v", len(sites), len(searched.results))
+	}
+	if err := searched.err(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+}
+
+func TestSupportedQualities(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{"default", nil, []string{"2160p", "2160p 10bit", "1080p", "1080p 10bit", "720p"}},
+		{"excluded resolution", []Option{WithExcludedQualities([]string{"2160p"})}, []string{"1080p", "1080p 10bit", "720p"}},
+		{"excluded 10bit quality", []Option{WithExcludedQualities([]string{"1080p 10bit"})}, []string{"2160p", "2160p 10bit", "1080p", "720p"}},
+		{"unknown quality kept", []Option{WithUnknownQuality(true)}, []string{"2160p", "2160p 10bit", "1080p", "1080p 10bit", "720p", QualityUnknown}},
+		{"preference order", []Option{WithQualityPreference([]string{"720p", "1080p 10bit", "1080p"})}, []string{"720p", "1080p 10bit", "1080p", "2160p", "2160p 10bit"}},
+		{"unknown quality preferred", []Option{WithUnknownQuality(true), WithQualityPreference([]string{"1080p", QualityUnknown})}, []string{"1080p", "1080p 10bit", QualityUnknown, "720p", "2160p", "2160p 10bit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.opts...)
+			qualities := client.SupportedQualities()
+			if strings.Join(qualities, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, qualities)
+			}
+			// The result must be a copy
+			qualities[0] = "modified"
+			if client.SupportedQualities()[0] == "modified" {
+				t.Error("Expected a copy of the supported qualities")
+			}
+		})
+	}
+}
+
+func TestQualityPreferenceValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		expectErr bool
+	}{
+		{"default", nil, false},
+		{"nil means default", []Option{WithQualityPreference(nil)}, false},
+		{"supported qualities", []Option{WithQualityPreference([]string{"1080p 10bit", "720p"})}, false},
+		{"empty", []Option{WithQualityPreference([]string{})}, true},
+		{"unsupported quality", []Option{WithQualityPreference([]string{"1080p", "480p"})}, true},
+		{"duplicate quality", []Option{WithQualityPreference([]string{"1080p", "720p", "1080p"})}, true},
+		{"excluded quality", []Option{WithExcludedQualities([]string{"2160p"}), WithQualityPreference([]string{"2160p", "1080p"})}, false},
+		{"unknown quality dropped", []Option{WithQualityPreference([]string{"1080p", QualityUnknown})}, true},
+		{"unknown quality kept", []Option{WithUnknownQuality(true), WithQualityPreference([]string{"1080p", QualityUnknown})}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithTorrentCache(newTestCache(), time.Hour, 0), WithCinemataCache(newTestCache(), time.Hour)}, tt.opts...)
+			_, err := NewClient(context.Background(), opts...)
+			if tt.expectErr && err == nil {
+				t.Error("Expected an error")
+			} else if !tt.expectErr && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+// mockResults returns results with info hashes that are unique per offset, so that sites with overlapping offsets share some of their results.
+func mockResults(offset, count int) []Result {
+	qualities := []string{"720p", "1080p", "1080p 10bit", "2160p"}
+	results := make([]Result, count)
+	for i := range results {
+		infoHash := fmt.Sprintf("%040X", offset+i)
+		results[i] = mockResult(infoHash, qualities[i%len(qualities)])
+		results[i].Seeders = i
+		results[i].MagnetURL = BuildMagnet(infoHash, results[i].Title, []string{"udp://tracker.example:1337"})
+	}
+	return results
+}
+
+func BenchmarkFindMagnets(b *testing.B) {
+	benchmarks := []struct {
+		name         string
+		siteCount    int
+		resultCount  int
+		overlapCount int
+	}{
+		{"single site", 1, 1000, 0},
+		{"no duplicates", 4, 250, 0},
+		{"half duplicates", 4, 500, 250},
+		{"only duplicates", 4, 1000, 1000},
+	}
+	client, err := NewClient(context.Background(), WithTorrentCache(newTestCache(), time.Hour, 0), WithCinemataCache(newTestCache(), time.Hour))
+	if err != nil {
+		b.Fatalf("Couldn't create client: %v", err)
+	}
+	for _, bm := range benchmarks {
+		var sites []siteSearch
+		for i := 0; i < bm.siteCount; i++ {
+			results := mockResults(i*(bm.resultCount-bm.overlapCount), bm.resultCount)
+			sites = append(sites, siteSearch{
+				torrentSite: fmt.Sprintf("mock%v", i),
+				check: func(context.Context) ([]Result, error) {
+					// A copy, like the sites return it from the cache
+					return append([]Result(nil), results...), nil
+				},
+			})
+		}
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.findMagnets(context.Background(), testLogger(), sites, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewClientFromParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseURLibit string
+		expected    []string
+	}{
+		{"all sites", "https://ibit.example", []string{"1337x", "SolidTorrents", "TPB", "YTS", "ibit"}},
+		{"disabled site", "", []string{"1337x", "SolidTorrents", "TPB", "YTS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClientFromParams(context.Background(), "https://yts.example", "https://tpb.example", "https://1337x.example", tt.baseURLibit, "", 2*time.Second, 3, newTestCache(), newTestCache(), time.Hour)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if activeSites := client.ActiveSites(); strings.Join(activeSites, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected active sites %v, got %v", tt.expected, activeSites)
+			}
+			if client.timeout != 2*time.Second || client.tpbRetries != 3 || client.cacheAge != time.Hour {
+				t.Errorf("Expected the parameters to be applied, got timeout %v, TPB retries %v and cache age %v", client.timeout, client.tpbRetries, client.cacheAge)
+			}
+			if client.ytsClient.mirrors.baseURL() != "https://yts.example" {
+				t.Errorf("Expected the YTS base URL to be applied, got %v", client.ytsClient.mirrors.baseURL())
+			}
+		})
+	}
+}
+
+func TestRemoveDuplicatesConflictingQuality(t *testing.T) {
+	const infoHash = "1111111111111111111111111111111111111111"
+	withQuality := func(quality string, bitDepth int) Result {
+		result := mockResult(infoHash, quality)
+		result.BitDepth = bitDepth
+		return result
+	}
+	tests := []struct {
+		name             string
+		first            Result
+		second           Result
+		expectedQuality  string
+		expectedBitDepth int
+	}{
+		{"10bit second", withQuality("1080p", 8), withQuality("1080p 10bit", 10), "1080p 10bit", 10},
+		{"10bit first", withQuality("1080p 10bit", 10), withQuality("1080p", 8), "1080p 10bit", 10},
+		{"unknown bit depth", withQuality("2160p", 0), withQuality("2160p 10bit", 10), "2160p 10bit", 10},
+		{"unknown quality first", withQuality(QualityUnknown, 0), withQuality("720p", 8), "720p", 8},
+		{"unknown quality second", withQuality("720p", 8), withQuality(QualityUnknown, 0), "720p", 8},
+		{"unknown quality with 10bit", withQuality(QualityUnknown, 10), withQuality("1080p", 8), "1080p", 8},
+		// Equally specific qualities can't be decided, so the first one is kept
+		{"different resolutions", withQuality("1080p", 8), withQuality("2160p", 8), "1080p", 8},
+		{"different resolutions reversed", withQuality("2160p", 8), withQuality("1080p", 8), "2160p", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := removeDuplicates([]Result{tt.first, tt.second}, false, 0)
+			if len(results) != 1 {
+				t.Fatalf("Expected 1 result, got %v", len(results))
+			}
+			if results[0].Quality != tt.expectedQuality || results[0].BitDepth != tt.expectedBitDepth {
+				t.Errorf("Expected quality %q with bit depth %v, got %q with %v", tt.expectedQuality, tt.expectedBitDepth, results[0].Quality, results[0].BitDepth)
+			}
+		})
+	}
+}
+
+func TestMagnetRegexesPathologicalInput(t *testing.T) {
+	// Big bodies without the delimiters that end a match
+	size := 1024 * 1024
+	tests := []struct {
+		name string
+		re   interface{ Find([]byte) []byte }
+		body string
+	}{
+		{"magnet without closing quote", regexMagnet, "'magnet:?" + strings.Repeat("a", size)},
+		{"repeated magnet starts", regexMagnet, strings.Repeat("'magnet:?a\n", size/11)},
+		{"info hash without ampersand", magnet2InfoHashRegex, "btih:" + strings.Repeat("a", size)},
+		{"repeated info hash starts", magnet2InfoHashRegex, strings.Repeat("btih:", size/5)},
+		{"ibit info hash without display name", magnet2InfoHashRegexIbit, "btih:" + strings.Repeat(`\x2`, size/3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			if match := tt.re.Find([]byte(tt.body)); match != nil {
+				t.Errorf("Expected no match, got %v bytes", len(match))
+			}
+			// Usually well below 100ms, but the limit must be generous for slow CI machines and the race detector
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("Expected a bounded runtime, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		expectErr bool
+	}{
+		{"empty", 0, false},
+		{"small", 1024, false},
+		{"max size", maxBodySize, false},
+		{"too big", maxBodySize + 1, true},
+		{"much too big", 2 * maxBodySize, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := readBody(strings.NewReader(strings.Repeat("a", tt.size)))
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v bytes", len(body))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if len(body) != tt.size {
+				t.Errorf("Expected %v bytes, got %v", tt.size, len(body))
+			}
+		})
+	}
+}
+
+// countingTransport counts the requests that are sent via an HTTP client.
+type countingTransport struct {
+	lock     *sync.Mutex
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	t.requests++
+	t.lock.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (t *countingTransport) count() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.requests
+}
+
+func TestCheckSitesUsesSiteClients(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handle("/", "OK")
+	client := newTestClient(t,
+		WithBaseURL("YTS", server.URL),
+		WithBaseURL("TPB", server.URL),
+		WithBaseURL("1337x", ""),
+		WithBaseURL("ibit", ""),
+		WithBaseURL("SolidTorrents", ""),
+		// One request per 1000 seconds, so the check uses up the limiter's only token
+		WithRateLimit("YTS", 0.001))
+	// TPB's HTTP client is the one that uses the SOCKS5 proxy if one is configured
+	tpbTransport := &countingTransport{lock: &sync.Mutex{}}
+	client.tpbClient.httpClient.Transport = tpbTransport
+	sharedTransport := &countingTransport{lock: &sync.Mutex{}}
+	client.httpClient.Transport = sharedTransport
+
+	if err := client.CheckSites(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if count := tpbTransport.count(); count != 1 {
+		t.Errorf("Expected 1 request via TPB's HTTP client, got %v", count)
+	}
+	if count := sharedTransport.count(); count != 0 {
+		t.Errorf("Expected no request via the shared HTTP client, got %v", count)
+	}
+	if client.ytsClient.limiter.Allow() {
+		t.Error("Expected the check to wait for YTS' rate limiter")
+	}
+}
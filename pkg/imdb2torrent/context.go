@@ -0,0 +1,148 @@
+package imdb2torrent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type contextKey string
+
+const (
+	skippedSitesKey contextKey = "skippedSites"
+	bypassCacheKey  contextKey = "bypassCache"
+	staleMarkerKey  contextKey = "staleMarker"
+	lastRequestKey  contextKey = "lastRequest"
+	trackersKey     contextKey = "requestTrackers"
+	emptyPageKey    contextKey = "emptyPageMarker"
+)
+
+// WithSkippedSites returns a copy of ctx which makes FindMagnets skip the torrent sites with the given names.
+// The names are the same as the keys of the map returned by GetMagnetSearchers(), for example "YTS".
+func WithSkippedSites(ctx context.Context, names ...string) context.Context {
+	skippedSites := map[string]struct{}{}
+	for name := range skippedSitesFromContext(ctx) {
+		skippedSites[name] = struct{}{}
+	}
+	for _, name := range names {
+		skippedSites[name] = struct{}{}
+	}
+	return context.WithValue(ctx, skippedSitesKey, skippedSites)
+}
+
+func skippedSitesFromContext(ctx context.Context) map[string]struct{} {
+	if skippedSites, ok := ctx.Value(skippedSitesKey).(map[string]struct{}); ok {
+		return skippedSites
+	}
+	return nil
+}
+
+// WithRequestTrackers returns a copy of ctx which makes FindMagnets and the other methods that return results add the given trackers to their magnet URLs, for example a private tracker of a single user.
+// They're added in addition to the trackers the magnet URLs already have, including the default trackers, but only up to the client's max trackers, see WithTrackers().
+// The trackers only apply to the request with this context. Cached results and the results of concurrent searches that share the search aren't affected.
+func WithRequestTrackers(ctx context.Context, trackers []string) context.Context {
+	requestTrackers := append([]string(nil), requestTrackersFromContext(ctx)...)
+	for _, tracker := range trackers {
+		requestTrackers = appendUnique(requestTrackers, tracker)
+	}
+	return context.WithValue(ctx, trackersKey, requestTrackers)
+}
+
+func requestTrackersFromContext(ctx context.Context) []string {
+	requestTrackers, _ := ctx.Value(trackersKey).([]string)
+	return requestTrackers
+}
+
+// WithBypassCache returns a copy of ctx which makes the torrent site searches ignore cached results.
+// The fresh results are still written to the cache.
+func WithBypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey, true)
+}
+
+func bypassCacheFromContext(ctx context.Context) bool {
+	bypassCache, _ := ctx.Value(bypassCacheKey).(bool)
+	return bypassCache
+}
+
+// staleMarker is set by getCachedResults when it returns a cache entry that expires within the refresh window or has fewer than the min number of results,
+// so that the caller can refresh the entry in the background.
+type staleMarker struct {
+	refreshWindow time.Duration
+	minResults    int
+	stale         bool
+}
+
+// withStaleMarker returns a copy of ctx with the marker, which must only be used by a single torrent site search.
+func withStaleMarker(ctx context.Context, marker *staleMarker) context.Context {
+	return context.WithValue(ctx, staleMarkerKey, marker)
+}
+
+func staleMarkerFromContext(ctx context.Context) *staleMarker {
+	marker, _ := ctx.Value(staleMarkerKey).(*staleMarker)
+	return marker
+}
+
+// emptyPageMarker is set by torrent site scrapers when a response looked like a results page, but didn't lead to any torrents,
+// for example because the table of a partially rendered page was empty, so that the caller can retry the search.
+// A page that states that there are no torrents doesn't set it.
+type emptyPageMarker struct {
+	resultsPage bool
+}
+
+// withEmptyPageMarker returns a copy of ctx with the marker, which must only be used by a single torrent site search.
+func withEmptyPageMarker(ctx context.Context, marker *emptyPageMarker) context.Context {
+	return context.WithValue(ctx, emptyPageKey, marker)
+}
+
+// markEmptyResultsPage sets the marker of the context, if it has one.
+func markEmptyResultsPage(ctx context.Context) {
+	if marker, ok := ctx.Value(emptyPageKey).(*emptyPageMarker); ok {
+		marker.resultsPage = true
+	}
+}
+
+// lastRequest keeps the URL of the last request of a torrent site search, so that it can be added to the site's error history.
+// A search can send requests concurrently, like 1337x's torrent pages, so it's guarded by a lock.
+type lastRequest struct {
+	lock *sync.Mutex
+	url  string
+}
+
+// withLastRequest returns a copy of ctx with the lastRequest, which must only be used by a single torrent site search.
+func withLastRequest(ctx context.Context, last *lastRequest) context.Context {
+	return context.WithValue(ctx, lastRequestKey, last)
+}
+
+// recordRequestURL sets the URL as the last request of the search, if the context has a lastRequest.
+func recordRequestURL(ctx context.Context, reqURL string) {
+	if last, ok := ctx.Value(lastRequestKey).(*lastRequest); ok {
+		last.lock.Lock()
+		defer last.lock.Unlock()
+		last.url = reqURL
+	}
+}
+
+// get returns the URL of the last request.
+func (l *lastRequest) get() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.url
+}
+
+// valueOnlyContext keeps the values of the wrapped context, but not its deadline and cancellation.
+// It's used for operations that continue in the background after the request that started them is finished.
+type valueOnlyContext struct {
+	context.Context
+}
+
+func (valueOnlyContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (valueOnlyContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueOnlyContext) Err() error {
+	return nil
+}
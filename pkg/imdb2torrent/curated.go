@@ -0,0 +1,137 @@
+package imdb2torrent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// curatedSite is the pseudo torrent site of the results that are added via SeedResults().
+const curatedSite = "curated"
+
+// SeedResults adds curated results for the IMDb ID, for example trusted releases from a list that an operator maintains.
+// They're stored in the torrent cache under the pseudo torrent site "curated" and combined with the results of the real torrent sites by FindMagnets(). Unlike those, they don't expire.
+// The client's filters still apply to them, so for example a blocked info hash is removed.
+//
+// Each result must have an info hash or a magnet URL to take it from. The title, quality and other properties are parsed from the magnet URL's display name if they're empty.
+// The quality must be a supported quality like "1080p", but unlike with Client.SupportedQualities() it can be an excluded one, which the filters then remove. If any result is invalid, an error is returned and none of the results are stored.
+// Results are merged with previously seeded ones for the same IMDb ID, with new results replacing old ones with the same info hash.
+//
+// The results are lost when the cache evicts them or the process exits without persisting the cache, so they should be seeded on startup.
+func (c Client) SeedResults(ctx context.Context, imdbID string, results []Result) error {
+	imdbID, err := CanonicalIMDbID(imdbID)
+	if err != nil {
+		return err
+	}
+	logger := log.WithContext(ctx).WithField("imdbID", imdbID)
+
+	validResults := make([]Result, 0, len(results))
+	for i, result := range results {
+		result, err := validateCuratedResult(result)
+		if err != nil {
+			return fmt.Errorf("Invalid result at index %v: %v", i, err)
+		}
+		validResults = append(validResults, result)
+	}
+	validResults = removeDuplicates(validResults, c.mergeTrackers, c.maxTrackers)
+
+	newInfoHashes := make(map[string]struct{}, len(validResults))
+	for _, result := range validResults {
+		newInfoHashes[result.InfoHash] = struct{}{}
+	}
+	for _, result := range c.curatedResults(ctx, imdbID) {
+		if _, ok := newInfoHashes[result.InfoHash]; !ok {
+			validResults = append(validResults, result)
+		}
+	}
+
+	logger.WithField("torrentCount", len(validResults)).Debug("Seeding curated results")
+	setCachedResults(ctx, c.cache, imdbID+"-"+curatedSite, validResults, time.Now, c.compressCache, logger)
+	return nil
+}
+
+// curatedResults returns the results that were seeded for the IMDb ID via SeedResults(), regardless of their age.
+func (c Client) curatedResults(ctx context.Context, imdbID string) []Result {
+	torrentsGob, ok := c.cache.HasGet(nil, []byte(imdbID+"-"+curatedSite))
+	if !ok {
+		return nil
+	}
+	results, _, err := FromCacheEntry(ctx, torrentsGob)
+	if err != nil {
+		log.WithContext(ctx).WithError(err).WithField("imdbID", imdbID).Error("Couldn't decode curated results")
+		return nil
+	}
+	return results
+}
+
+// curatedSiteSearch returns a search of the curated results for the IMDb ID, or false if none were seeded.
+// Without this check every search would count the pseudo site as successful, so an error wouldn't be returned anymore when all real torrent sites fail.
+func (c Client) curatedSiteSearch(imdbID string) (siteSearch, bool) {
+	if !c.cache.Has([]byte(imdbID + "-" + curatedSite)) {
+		return siteSearch{}, false
+	}
+	return siteSearch{curatedSite, func(ctx context.Context) ([]Result, error) {
+		return c.curatedResults(ctx, imdbID), nil
+	}}, true
+}
+
+// validateCuratedResult fills the empty properties of the result from its magnet URL and returns an error if it doesn't have a valid info hash or supported quality.
+func validateCuratedResult(result Result) (Result, error) {
+	if result.MagnetURL != "" {
+		magnet, err := ParseMagnet(result.MagnetURL)
+		if err != nil {
+			return Result{}, fmt.Errorf("Couldn't parse magnet URL: %v", err)
+		}
+		if result.InfoHash == "" {
+			result.InfoHash = magnet.InfoHash
+		} else if !strings.EqualFold(result.InfoHash, magnet.InfoHash) {
+			return Result{}, fmt.Errorf("Info hash %v doesn't match the one of the magnet URL: %v", result.InfoHash, magnet.InfoHash)
+		}
+		if result.Title == "" {
+			result.Title = magnet.DisplayName
+		}
+		if len(result.Trackers) == 0 {
+			result.Trackers = magnet.Trackers
+		}
+	}
+	result.InfoHash = strings.ToUpper(result.InfoHash)
+	if !isInfoHash(result.InfoHash) {
+		return Result{}, fmt.Errorf("Info hash must be 40 hex characters: %v", result.InfoHash)
+	}
+
+	if result.Quality == "" {
+		quality, ok := parseQuality(result.Title)
+		if !ok {
+			return Result{}, fmt.Errorf("Couldn't determine supported quality from title: %v", result.Title)
+		}
+		result.Quality = quality
+	}
+	if qualityIndex(result.Quality) == -1 {
+		return Result{}, fmt.Errorf("Unsupported quality: %v. Supported qualities: %v", result.Quality, strings.Join(supportedQualities, ", "))
+	}
+	if result.BitDepth == 0 {
+		result.BitDepth = parseBitDepth(result.Quality)
+	}
+	if result.Source == "" {
+		result.Source = parseSource(result.Title)
+	}
+	if result.ReleaseType == "" {
+		result.ReleaseType = parseReleaseType(result.Title)
+	}
+	if result.Group == "" {
+		result.Group = parseReleaseGroup(result.Title)
+	}
+	if !result.Remux {
+		result.Remux = parseRemux(result.Title)
+	}
+	if !result.Proper {
+		result.Proper = parseProper(result.Title)
+	}
+	if !result.Repack {
+		result.Repack = parseRepack(result.Title)
+	}
+	return result, nil
+}
@@ -0,0 +1,58 @@
+package imdb2torrent
+
+import (
+	"sync"
+	"time"
+)
+
+// ErrorRecord is a failed search on a torrent site, see Client.RecentErrors().
+type ErrorRecord struct {
+	Time time.Time `json:"time"`
+	Err  string    `json:"error"`
+	// URL of the last request of the search, which is usually the one that failed. Empty if the search failed before sending a request.
+	URL string `json:"url,omitempty"`
+}
+
+// errorHistory keeps the most recent errors of each torrent site, so that intermittent failures can be diagnosed without searching the logs.
+type errorHistory struct {
+	lock *sync.Mutex
+	// Max number of errors per torrent site. 0 means disabled.
+	size    int
+	records map[string][]ErrorRecord
+}
+
+func newErrorHistory(size int) *errorHistory {
+	return &errorHistory{
+		lock:    &sync.Mutex{},
+		size:    size,
+		records: map[string][]ErrorRecord{},
+	}
+}
+
+// record adds the error to the torrent site's history and removes the oldest one if the history is full.
+func (h *errorHistory) record(site string, err error, reqURL string) {
+	if h.size <= 0 {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	records := append(h.records[site], ErrorRecord{
+		Time: time.Now(),
+		Err:  err.Error(),
+		URL:  reqURL,
+	})
+	if len(records) > h.size {
+		records = records[len(records)-h.size:]
+	}
+	h.records[site] = records
+}
+
+// RecentErrors returns the most recent errors of searches on the torrent site, oldest first.
+// The site names are the same as the keys of the map returned by GetMagnetSearchers(), for example "YTS".
+// The number of errors is limited by the size that the client was created with, see WithErrorHistory(). Without it nil is returned.
+func (c Client) RecentErrors(site string) []ErrorRecord {
+	c.errHistory.lock.Lock()
+	defer c.errHistory.lock.Unlock()
+	return append([]ErrorRecord(nil), c.errHistory.records[site]...)
+}
@@ -0,0 +1,78 @@
+package imdb2torrent
+
+import (
+	"sync"
+)
+
+const (
+	// healthWindow is the number of most recent searches per torrent site that the error rate is calculated from
+	healthWindow = 20
+	// healthMinSearches is the number of searches on a torrent site that are required before the site can be considered degraded, so that a single failed search after startup doesn't trigger it
+	healthMinSearches = 10
+)
+
+// siteHealth keeps track of the rolling error rate of each torrent site and calls the registered callback when a site's error rate reaches the threshold.
+// The callback is only called again for the site after its error rate dropped below the threshold in the meantime.
+// A site that reaches the threshold while no callback is registered is reported to the next callback that's registered, with its next search.
+type siteHealth struct {
+	lock *sync.Mutex
+	// 0 means disabled
+	threshold float64
+	// Outcomes of the most recent searches per torrent site, true for errors
+	outcomes map[string][]bool
+	degraded map[string]bool
+	callback func(site string, errRate float64)
+}
+
+func newSiteHealth(threshold float64) *siteHealth {
+	return &siteHealth{
+		lock:      &sync.Mutex{},
+		threshold: threshold,
+		outcomes:  map[string][]bool{},
+		degraded:  map[string]bool{},
+	}
+}
+
+// OnSiteDegraded registers the callback that's called when the error rate of a torrent site's most recent searches reaches the threshold the client was created with.
+// The error rate is between 0 and 1. The callback is called in its own goroutine and replaces a previously registered one.
+// It's never called if the client was created with a threshold of 0.
+func (c Client) OnSiteDegraded(callback func(site string, errRate float64)) {
+	c.health.lock.Lock()
+	defer c.health.lock.Unlock()
+	c.health.callback = callback
+}
+
+// record adds the outcome of a search on the torrent site to its rolling window and calls the callback if the site just became degraded.
+func (h *siteHealth) record(site string, failed bool) {
+	if h.threshold <= 0 {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	outcomes := append(h.outcomes[site], failed)
+	if len(outcomes) > healthWindow {
+		outcomes = outcomes[len(outcomes)-healthWindow:]
+	}
+	h.outcomes[site] = outcomes
+	if len(outcomes) < healthMinSearches {
+		return
+	}
+
+	errCount := 0
+	for _, outcome := range outcomes {
+		if outcome {
+			errCount++
+		}
+	}
+	errRate := float64(errCount) / float64(len(outcomes))
+	if errRate < h.threshold {
+		h.degraded[site] = false
+		return
+	}
+	// Only marked as degraded when a callback was notified, so that a callback that's registered later is still called for the site
+	if !h.degraded[site] && h.callback != nil {
+		go h.callback(site, errRate)
+		h.degraded[site] = true
+	}
+}
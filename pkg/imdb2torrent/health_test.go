@@ -0,0 +1,73 @@
+package imdb2torrent
+
+import (
+	"testing"
+	"time"
+)
+
+// repeatOutcome returns count search outcomes, true for errors.
+func repeatOutcome(failed bool, count int) []bool {
+	outcomes := make([]bool, count)
+	for i := range outcomes {
+		outcomes[i] = failed
+	}
+	return outcomes
+}
+
+func TestSiteHealth(t *testing.T) {
+	var recovered []bool
+	recovered = append(recovered, repeatOutcome(true, 10)...)
+	recovered = append(recovered, repeatOutcome(false, 20)...)
+	recovered = append(recovered, repeatOutcome(true, 20)...)
+	tests := []struct {
+		name string
+		// Outcomes of the searches, true for errors
+		outcomes []bool
+		// Index of the outcome before which the callback is registered, -1 for never
+		registerAt    int
+		expectedCalls int
+	}{
+		{"healthy", repeatOutcome(false, 20), 0, 0},
+		{"too few searches", repeatOutcome(true, healthMinSearches-1), 0, 0},
+		{"degraded", repeatOutcome(true, healthMinSearches), 0, 1},
+		{"stays degraded", repeatOutcome(true, 30), 0, 1},
+		{"degraded again after recovery", recovered, 0, 2},
+		{"no callback", repeatOutcome(true, 20), -1, 0},
+		// The site became degraded before the callback was registered, so it's called with the next search
+		{"callback registered later", repeatOutcome(true, 20), 15, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := Client{health: newSiteHealth(0.5)}
+			calls := make(chan float64, len(tt.outcomes))
+			for i, failed := range tt.outcomes {
+				if i == tt.registerAt {
+					client.OnSiteDegraded(func(site string, errRate float64) {
+						if site != "mock" {
+							t.Errorf("Expected site mock, got %v", site)
+						}
+						calls <- errRate
+					})
+				}
+				client.health.record("mock", failed)
+			}
+
+			// The callback is called in its own goroutine
+			for i := 0; i < tt.expectedCalls; i++ {
+				select {
+				case errRate := <-calls:
+					if errRate < 0.5 {
+						t.Errorf("Expected an error rate of at least 0.5, got %v", errRate)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("Expected %v callback calls, got %v", tt.expectedCalls, i)
+				}
+			}
+			select {
+			case <-calls:
+				t.Errorf("Expected only %v callback calls", tt.expectedCalls)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -4,18 +4,17 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"regexp"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/VictoriaMetrics/fastcache"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 var magnet2InfoHashRegexIbit = regexp.MustCompile(`btih:.+?\\x26dn=`) // The "?" makes the ".+" non-greedy
@@ -23,32 +22,47 @@ var magnet2InfoHashRegexIbit = regexp.MustCompile(`btih:.+?\\x26dn=`) // The "?"
 var _ MagnetSearcher = (*ibitClient)(nil)
 
 type ibitClient struct {
-	baseURL    string
+	mirrors    *mirrorList
 	httpClient *http.Client
 	cache      *fastcache.Cache
-	lock       *sync.Mutex
-	cacheAge   time.Duration
+	// ibit responds with `429 Too Many Requests` when sending more than a few requests per second.
+	// One limiter per mirror, in the same order as the mirror's base URLs.
+	limiters       []*rate.Limiter
+	cacheAge       time.Duration
+	cacheAgeJitter time.Duration
+	// Returns the current time for cache expiry. Only replaced in tests.
+	now func() time.Time
+	// Gzip cache entries
+	compressCache bool
+	// Distribute the torrent page requests across all mirrors instead of sending them to the one that answered the search
+	parallelMirrors bool
 }
 
-func newIbitClient(ctx context.Context, baseURL string, timeout time.Duration, cache *fastcache.Cache, cacheAge time.Duration) ibitClient {
+func newIbitClient(ctx context.Context, baseURL string, timeout time.Duration, cache *fastcache.Cache, cacheAge, cacheAgeJitter time.Duration, now func() time.Time, compressCache bool, rateLimit float64, parallelMirrors bool) ibitClient {
+	mirrors := newMirrorList(baseURL)
+	limiters := make([]*rate.Limiter, len(mirrors.baseURLs))
+	for i := range limiters {
+		limiters[i] = newRateLimiter(rateLimit)
+	}
 	return ibitClient{
-		baseURL: baseURL,
+		mirrors: mirrors,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
-		cache:    cache,
-		lock:     &sync.Mutex{},
-		cacheAge: cacheAge,
+		cache:           cache,
+		limiters:        limiters,
+		cacheAge:        cacheAge,
+		cacheAgeJitter:  cacheAgeJitter,
+		now:             now,
+		compressCache:   compressCache,
+		parallelMirrors: parallelMirrors,
 	}
 }
 
 // Check scrapes ibit to find torrents for the given IMDb ID.
 // If no error occured, but there are just no torrents for the movie yet, an empty result and *no* error are returned.
+// When the context is done, the search is aborted, including waiting for the rate limiter.
 func (c ibitClient) Check(ctx context.Context, imdbID string) ([]Result, error) {
-	// Lock for all requests to ibit, because of rate limiting
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
 	logFields := log.Fields{
 		"imdbID":      imdbID,
 		"torrentSite": "ibit",
@@ -57,23 +71,22 @@ func (c ibitClient) Check(ctx context.Context, imdbID string) ([]Result, error)
 
 	// Check cache first
 	cacheKey := imdbID + "-ibit"
-	if torrentsGob, ok := c.cache.HasGet(nil, []byte(cacheKey)); ok {
-		torrentList, created, err := FromCacheEntry(ctx, torrentsGob)
-		if err != nil {
-			logger.WithError(err).Error("Couldn't decode torrent results")
-		} else if time.Since(created) < (c.cacheAge) {
-			logger.WithField("torrentCount", len(torrentList)).Debug("Hit cache for torrents, returning results")
-			return torrentList, nil
-		} else {
-			expiredSince := time.Since(created.Add(c.cacheAge))
-			logger.WithField("expiredSince", expiredSince).Debug("Hit cache for torrents, but entry is expired")
-		}
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
+	}
+
+	if len(c.limiters) == 0 {
+		return nil, fmt.Errorf("No base URL configured")
 	}
 
-	reqUrl := c.baseURL + "/torrent-search/" + imdbID
-	res, err := c.httpClient.Get(reqUrl)
+	reqPath := "/torrent-search/" + imdbID
+	// The search request goes to the mirror that worked last, or falls back to the next ones
+	if err := c.limiters[c.mirrors.currentIndex()].Wait(ctx); err != nil {
+		return nil, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+	}
+	res, err := c.mirrors.get(ctx, c.httpClient, reqPath)
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)
+		return nil, fmt.Errorf("Couldn't GET %v: %v", reqPath, err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
@@ -81,7 +94,7 @@ func (c ibitClient) Check(ctx context.Context, imdbID string) ([]Result, error)
 	}
 
 	// Load the HTML document
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(res.Body, maxBodySize))
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't load the HTML in goquery: %v", err)
 	}
@@ -94,132 +107,207 @@ func (c ibitClient) Check(ctx context.Context, imdbID string) ([]Result, error)
 			logger.Warn("Couldn't find link to the torrent page, did the HTML change?")
 			return
 		}
-		torrentPageURLs = append(torrentPageURLs, c.baseURL+torrentPageHref)
+		torrentPageURLs = append(torrentPageURLs, c.mirrors.baseURL()+torrentPageHref)
 	})
 	// TODO: We should differentiate between "parsing went wrong" and "just no search results".
 	if len(torrentPageURLs) == 0 {
+		// Without torrents the table isn't rendered, so an empty one means the page wasn't rendered completely
+		if doc.Find(".torrents").Length() > 0 {
+			markEmptyResultsPage(ctx)
+		}
 		return nil, nil
 	}
 
-	// Visit each torrent page *one after another* (ibit has rate limiting so concurrent requests don't work) and get the magnet URL
-
 	var results []Result
-	for _, torrentPageURL := range torrentPageURLs {
-		// Sleeping 100ms between requests still leads to some `429 Too Many Requests` responses
-		time.Sleep(150 * time.Millisecond)
+	if c.parallelMirrors && len(c.mirrors.baseURLs) > 1 {
+		results, err = c.checkTorrentPagesParallel(ctx, logger, torrentPageURLs)
+	} else {
+		// Use the mirror that answered the search, which could be a proxy that we want to go through
+		results, err = c.checkTorrentPages(ctx, logger, torrentPageURLs, c.mirrors.currentIndex())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		// The torrent pages exist, so they probably failed only temporarily
+		markEmptyResultsPage(ctx)
+	}
 
-		// Use configured base URL, which could be a proxy that we want to go through
-		torrentPageURL, err = replaceURL(torrentPageURL, c.baseURL)
-		if err != nil {
-			logger.WithError(err).Warn("Couldn't replace URL which was retrieved from an HTML link")
-			continue
-		}
+	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
+	// Any actual errors would have returned earlier.
+	setCachedResults(ctx, c.cache, cacheKey, results, c.now, c.compressCache, logger)
 
-		res, err := http.Get(torrentPageURL)
-		if err != nil {
-			continue
-		}
-		defer res.Body.Close()
-		if res.StatusCode != http.StatusOK {
-			continue
-		}
+	return results, nil
+}
 
-		// ibit puts the magnet link into the html body via JavaScript.
-		// But the JS already contains the actual value, so we take it from there.
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
+// checkTorrentPagesParallel distributes the torrent pages across all mirrors round-robin and visits them via checkTorrentPages, one goroutine per mirror.
+// Each mirror stays within its own rate limit, but the total time of the search drops with the number of mirrors.
+// The order of the results is not the order of the torrent pages.
+func (c ibitClient) checkTorrentPagesParallel(ctx context.Context, logger *log.Entry, torrentPageURLs []string) ([]Result, error) {
+	mirrorCount := len(c.mirrors.baseURLs)
+	pagesPerMirror := make([][]string, mirrorCount)
+	for i, torrentPageURL := range torrentPageURLs {
+		pagesPerMirror[i%mirrorCount] = append(pagesPerMirror[i%mirrorCount], torrentPageURL)
+	}
+
+	type mirrorResult struct {
+		results []Result
+		err     error
+	}
+	resultChan := make(chan mirrorResult, mirrorCount)
+	for mirrorIndex, mirrorPageURLs := range pagesPerMirror {
+		go func(mirrorIndex int, mirrorPageURLs []string) {
+			results, err := c.checkTorrentPages(ctx, logger.WithField("mirror", c.mirrors.baseURLs[mirrorIndex]), mirrorPageURLs, mirrorIndex)
+			resultChan <- mirrorResult{results, err}
+		}(mirrorIndex, mirrorPageURLs)
+	}
+
+	var results []Result
+	var err error
+	for i := 0; i < mirrorCount; i++ {
+		mirrorResult := <-resultChan
+		if mirrorResult.err != nil {
+			err = mirrorResult.err
 			continue
 		}
-		magnetBytes := regexMagnet.Find(body)
-		magnet := strings.Trim(string(magnetBytes), "'")
-		if magnet == "" {
-			continue
+		results = append(results, mirrorResult.results...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkTorrentPages visits the torrent pages on the mirror with the given index and gets the magnet URLs.
+// The pages are visited *one after another* (ibit has rate limiting so concurrent requests don't work).
+// The mirror's limiter is shared with other ibit searches, so concurrent searches don't exceed the rate limit either.
+func (c ibitClient) checkTorrentPages(ctx context.Context, logger *log.Entry, torrentPageURLs []string, mirrorIndex int) ([]Result, error) {
+	baseURL := c.mirrors.baseURLs[mirrorIndex]
+	limiter := c.limiters[mirrorIndex]
+
+	var results []Result
+	for i, torrentPageURL := range torrentPageURLs {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("Aborted ibit search after %v of %v torrent pages: %v", i, len(torrentPageURLs), err)
 		}
 
-		bodyReader := bytes.NewReader(body)
-		doc, err = goquery.NewDocumentFromReader(bodyReader)
+		torrentPageURL, err := replaceURL(torrentPageURL, baseURL)
 		if err != nil {
-			continue
-		}
-		title := doc.Find("#extra-info h2 a").Text()
-		if title == "" {
+			logger.WithError(err).Warn("Couldn't replace URL which was retrieved from an HTML link")
 			continue
 		}
 
-		quality := ""
-		if strings.Contains(magnet, "720p") {
-			quality = "720p"
-		} else if strings.Contains(magnet, "1080p") {
-			quality = "1080p"
-		} else if strings.Contains(magnet, "2160p") {
-			quality = "2160p"
-		} else {
+		result, ok := c.checkTorrentPage(ctx, logger, torrentPageURL)
+		if !ok {
 			continue
 		}
+		results = append(results, result)
+	}
+	return results, nil
+}
 
-		if strings.Contains(magnet, "10bit") {
-			quality += " 10bit"
-		}
+// checkTorrentPage visits the torrent page and returns the result with its magnet URL.
+// It returns false if the page couldn't be fetched or doesn't contain a magnet URL with a supported quality and info hash.
+func (c ibitClient) checkTorrentPage(ctx context.Context, logger *log.Entry, torrentPageURL string) (Result, bool) {
+	req, err := http.NewRequestWithContext(ctx, "GET", torrentPageURL, nil)
+	if err != nil {
+		return Result{}, false
+	}
+	if err := c.mirrors.budget.wait(ctx); err != nil {
+		logger.WithError(err).Warn("Couldn't request torrent page")
+		return Result{}, false
+	}
+	recordRequestURL(ctx, torrentPageURL)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return Result{}, false
+	}
+	// Closed when this function returns, which is after each page instead of after all pages of the search
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Result{}, false
+	}
 
-		// https://en.wikipedia.org/wiki/Pirated_movie_release_types
-		if strings.Contains(magnet, "HDCAM") {
-			quality += (" (⚠️cam)")
-		}
+	// ibit puts the magnet link into the html body via JavaScript.
+	// But the JS already contains the actual value, so we take it from there.
+	body, err := readBody(res.Body)
+	if err != nil {
+		return Result{}, false
+	}
+	magnetBytes := regexMagnet.Find(body)
+	magnet := strings.Trim(string(magnetBytes), "'")
+	if magnet == "" {
+		return Result{}, false
+	}
 
-		// look for "btih:dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c&" via regex and then cut out the hash
-		match := magnet2InfoHashRegex.Find([]byte(magnet))
-		infoHash := strings.TrimPrefix(string(match), "btih:")
-		infoHash = strings.TrimSuffix(infoHash, "&")
-		infoHash = strings.ToUpper(infoHash)
-		// ibit changes their HTML sometimes, let's try another way if the previous one didn't yield a result
-		if infoHash == "" {
-			match = magnet2InfoHashRegexIbit.Find([]byte(magnet))
-			infoHash = strings.TrimPrefix(string(match), "btih:")
-			infoHash = strings.TrimSuffix(infoHash, `\x26dn=`)
-			infoHash = strings.ReplaceAll(infoHash, "-", "")
-			infoHash = strings.ToUpper(infoHash)
-			// The rest of the magnet is also a bit "obfuscated" (they're using some hex characters, but not everywhere)
-			if infoHash != "" {
-				magnetTailIndex := strings.Index(magnet, `\x26tr=`)
-				if magnetTailIndex == -1 {
-					logger.WithField("magnet", magnet).Warn(`Couldn't recreate magnet URL by cutting at \x26tr=. Did the HTML change?`)
-					continue
-				}
-				magnetTail := string(([]byte(magnet))[magnetTailIndex:])
-				magnetTail = strings.ReplaceAll(magnetTail, `\x26`, "&")
-				magnet = "magnet:?xt=urn:btih:" + infoHash + "&dn=" + url.QueryEscape(title) + magnetTail
-			}
+	bodyReader := bytes.NewReader(body)
+	doc, err := goquery.NewDocumentFromReader(bodyReader)
+	if err != nil {
+		return Result{}, false
+	}
+	title := doc.Find("#extra-info h2 a").Text()
+	if title == "" {
+		// The magnet URL's display name is usually a good title as well, and it doesn't depend on the HTML
+		title = magnetDisplayName(strings.ReplaceAll(magnet, `\x26`, "&"))
+		if title == "" {
+			return Result{}, false
 		}
+		logger.WithField("title", title).Debug("Couldn't find title in the HTML, using the magnet URL's display name")
+	}
 
-		if infoHash == "" {
-			logger.WithField("magnet", magnet).Warn("Couldn't extract info_hash. Did the HTML change?")
-			continue
-		}
+	quality, ok := parseQualityOrUnknown(magnet)
+	if !ok {
+		return Result{}, false
+	}
 
-		result := Result{
-			Title:     title,
-			Quality:   quality,
-			InfoHash:  infoHash,
-			MagnetURL: magnet,
+	// look for "btih:dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c&" via regex and then cut out the hash
+	match := magnet2InfoHashRegex.Find([]byte(magnet))
+	infoHash := strings.TrimPrefix(string(match), "btih:")
+	infoHash = strings.TrimSuffix(infoHash, "&")
+	infoHash = strings.ToUpper(infoHash)
+	// ibit changes their HTML sometimes, let's try another way if the previous one didn't yield a result
+	if infoHash == "" {
+		match = magnet2InfoHashRegexIbit.Find([]byte(magnet))
+		infoHash = strings.TrimPrefix(string(match), "btih:")
+		infoHash = strings.TrimSuffix(infoHash, `\x26dn=`)
+		infoHash = strings.ReplaceAll(infoHash, "-", "")
+		infoHash = strings.ToUpper(infoHash)
+		// The rest of the magnet is also a bit "obfuscated" (they're using some hex characters, but not everywhere)
+		if infoHash != "" {
+			magnetTailIndex := strings.Index(magnet, `\x26tr=`)
+			if magnetTailIndex == -1 {
+				logger.WithField("magnet", magnet).Warn(`Couldn't recreate magnet URL by cutting at \x26tr=. Did the HTML change?`)
+				return Result{}, false
+			}
+			magnetTail := string(([]byte(magnet))[magnetTailIndex:])
+			magnetTail = strings.ReplaceAll(magnetTail, `\x26`, "&")
+			magnet = "magnet:?xt=urn:btih:" + infoHash + "&dn=" + url.QueryEscape(title) + magnetTail
 		}
-		logger.WithFields(log.Fields{"title": title, "quality": quality, "infoHash": infoHash, "magnet": magnet}).Trace("Found torrent")
+	}
 
-		results = append(results, result)
+	// BitTorrent v2 and hybrid torrents also have a v2 info hash, v2-only torrents *only* have that
+	infoHashV2 := magnetInfoHashV2(magnet)
+	if infoHash == "" && infoHashV2 == "" {
+		logger.WithField("magnet", magnet).Warn("Couldn't extract info_hash. Did the HTML change?")
+		return Result{}, false
 	}
 
-	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
-	// Any actual errors would have returned earlier.
-	if torrentsGob, err := NewCacheEntry(ctx, results); err != nil {
-		logger.WithError(err).WithField("cache", "torrent").Error("Couldn't create cache entry for torrents")
-	} else {
-		entrySize := strconv.Itoa(len(torrentsGob)/1024) + "KB"
-		if len(torrentsGob) > 64*1024 {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Warn("New cacheEntry is bigger than 64KB, which means it won't be stored in the cache when calling fastcache's Set() method. SetBig() (and GetBig()) must be used instead!")
-		} else {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Debug("Caching torrent results")
-		}
-		c.cache.Set([]byte(cacheKey), torrentsGob)
+	result := Result{
+		Title:       title,
+		Quality:     quality,
+		Source:      parseSource(magnet),
+		ReleaseType: parseReleaseType(magnet),
+		Remux:       parseRemux(magnet),
+		Proper:      parseProper(magnet),
+		Repack:      parseRepack(magnet),
+		InfoHash:    infoHash,
+		MagnetURL:   magnet,
+		Trackers:    magnetTrackers(magnet),
+		InfoHashV2:  infoHashV2,
+		Group:       parseReleaseGroup(title),
+		BitDepth:    parseBitDepth(magnet),
 	}
+	logger.WithFields(log.Fields{"title": title, "quality": quality, "infoHash": infoHash, "magnet": magnet}).Trace("Found torrent")
 
-	return results, nil
+	return result, true
 }
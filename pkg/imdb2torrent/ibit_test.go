@@ -0,0 +1,162 @@
+package imdb2torrent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestIbitClient(baseURL string) ibitClient {
+	return newIbitClient(context.Background(), baseURL, time.Second, newTestCache(), time.Hour, 0, testNow, false, 0, false)
+}
+
+func TestIbitCheck(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handleFile(t, "/torrent-search/tt1254207", "ibit_search.html")
+	server.handleFile(t, "/torrent/1/big-buck-bunny-2008-1080p", "ibit_torrent_1080p.html")
+	server.handleFile(t, "/torrent/2/big-buck-bunny-2008-720p", "ibit_torrent_720p.html")
+	client := newTestIbitClient(server.URL)
+
+	results, err := client.Check(context.Background(), "tt1254207")
+	if err != nil {
+		t.Fatalf("Check() returned an error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v: %+v", len(results), results)
+	}
+	if results[0].InfoHash != "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF" || results[0].Quality != "1080p" || results[0].Title != "Big Buck Bunny (2008)" {
+		t.Errorf("Unexpected first result: %+v", results[0])
+	}
+	if results[1].InfoHash != "0000000000000000000000000000000000000001" || results[1].Quality != "720p" || results[1].Source != SourceHDTV {
+		t.Errorf("Unexpected second result: %+v", results[1])
+	}
+}
+
+func TestIbitTitleFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		magnet   string
+		expected string
+	}{
+		{"HTML title", "Big Buck Bunny (2008)", "magnet:?xt=urn:btih:0000000000000000000000000000000000000001&dn=Big.Buck.Bunny.2008.720p.HDTV.x264-GRP", "Big Buck Bunny (2008)"},
+		{"display name", "", "magnet:?xt=urn:btih:0000000000000000000000000000000000000001&dn=Big.Buck.Bunny.2008.720p.HDTV.x264-GRP", "Big.Buck.Bunny.2008.720p.HDTV.x264-GRP"},
+		{"URL-encoded display name", "", "magnet:?xt=urn:btih:0000000000000000000000000000000000000001&dn=Big%20Buck%20Bunny%202008%20720p%20HDTV", "Big Buck Bunny 2008 720p HDTV"},
+		{"escaped ampersand", "", `magnet:?xt=urn:btih:0000000000000000000000000000000000000001\x26dn=Big.Buck.Bunny.2008.720p.HDTV.x264-GRP\x26tr=udp%3A%2F%2Ftracker.example.org%3A1337%2Fannounce`, "Big.Buck.Bunny.2008.720p.HDTV.x264-GRP"},
+		{"no display name", "", "magnet:?xt=urn:btih:0000000000000000000000000000000000000001&tr=udp%3A%2F%2Ftracker.example.org%3A1337%2Fannounce&xl=720", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extraInfo := ""
+			if tt.title != "" {
+				extraInfo = `<div id="extra-info"><h2><a href="/movie/1">` + tt.title + `</a></h2></div>`
+			}
+			server := newFixtureServer(t)
+			server.handle("/torrent-search/tt1254207", `<html><body><table class="torrents"><tr><td><a href="/torrent/2/big-buck-bunny-2008-720p">Big Buck Bunny 2008 720p</a></td></tr></table></body></html>`)
+			server.handle("/torrent/2/big-buck-bunny-2008-720p", fmt.Sprintf("<html><body>%v<script>\nvar magnetLink = '%v';\n</script></body></html>", extraInfo, tt.magnet))
+			client := newTestIbitClient(server.URL)
+
+			results, err := client.Check(context.Background(), "tt1254207")
+			if err != nil {
+				t.Fatalf("Check() returned an error: %v", err)
+			}
+			if tt.expected == "" {
+				if len(results) != 0 {
+					t.Errorf("Expected no results without any title, got %+v", results)
+				}
+				return
+			}
+			if len(results) != 1 || results[0].Title != tt.expected {
+				t.Errorf("Expected one result with title %q, got %+v", tt.expected, results)
+			}
+		})
+	}
+}
+
+// trackingTransport counts the response bodies that are currently open and the max number of simultaneously open ones.
+type trackingTransport struct {
+	lock    *sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	t.open++
+	if t.open > t.maxOpen {
+		t.maxOpen = t.open
+	}
+	t.lock.Unlock()
+	res.Body = &trackedBody{ReadCloser: res.Body, transport: t}
+	return res, nil
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	transport *trackingTransport
+	closeOnce sync.Once
+}
+
+func (b *trackedBody) Close() error {
+	b.closeOnce.Do(func() {
+		b.transport.lock.Lock()
+		b.transport.open--
+		b.transport.lock.Unlock()
+	})
+	return b.ReadCloser.Close()
+}
+
+func TestIbitCheckClosesBodies(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"torrent pages", http.StatusOK},
+		{"missing torrent pages", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const pageCount = 50
+			server := newFixtureServer(t)
+			var rows []string
+			for i := 1; i <= pageCount; i++ {
+				path := fmt.Sprintf("/torrent/%v/big-buck-bunny-2008-720p", i)
+				rows = append(rows, `<tr><td><a href="`+path+`">Big Buck Bunny 2008 720p</a></td></tr>`)
+				server.handleStatus(path, tt.status, fmt.Sprintf("<html><body><script>\nvar magnetLink = 'magnet:?xt=urn:btih:%040X&dn=Big.Buck.Bunny.2008.720p.HDTV.x264-GRP';\n</script></body></html>", i))
+			}
+			server.handle("/torrent-search/tt1254207", `<html><body><table class="torrents">`+strings.Join(rows, "")+`</table></body></html>`)
+			transport := &trackingTransport{lock: &sync.Mutex{}}
+			client := newTestIbitClient(server.URL)
+			client.httpClient.Transport = transport
+
+			results, err := client.Check(context.Background(), "tt1254207")
+			if err != nil {
+				t.Fatalf("Check() returned an error: %v", err)
+			}
+			if tt.status == http.StatusOK && len(results) != pageCount {
+				t.Errorf("Expected %v results, got %v", pageCount, len(results))
+			}
+			if count := len(server.requested()); count != pageCount+1 {
+				t.Errorf("Expected %v requests, got %v", pageCount+1, count)
+			}
+			transport.lock.Lock()
+			defer transport.lock.Unlock()
+			if transport.open != 0 {
+				t.Errorf("Expected all bodies to be closed, %v are still open", transport.open)
+			}
+			// The search page's body is open during the whole search, but only one torrent page's body at a time
+			if transport.maxOpen > 2 {
+				t.Errorf("Expected up to 2 open bodies at a time, got up to %v", transport.maxOpen)
+			}
+		})
+	}
+}
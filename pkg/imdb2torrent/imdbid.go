@@ -0,0 +1,31 @@
+package imdb2torrent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CanonicalIMDbID returns the IMDb ID in its canonical form, which is "tt" followed by the number, zero-padded to 7 digits.
+// For example "1375666", "tt1375666" and "tt01375666" all lead to "tt1375666", and "76759" to "tt0076759". Numbers with more digits, like in "tt10872600", are kept as they are.
+// Using the canonical form prevents duplicate cache entries for the same movie and requests with IDs that the torrent sites don't know.
+// It returns an error if the value isn't an IMDb title ID.
+func CanonicalIMDbID(imdbID string) (string, error) {
+	digits := strings.TrimSpace(imdbID)
+	if strings.HasPrefix(strings.ToLower(digits), "tt") {
+		digits = digits[2:]
+	}
+	if digits == "" || len(digits) > 10 {
+		return "", fmt.Errorf("Invalid IMDb ID: %v", imdbID)
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("Invalid IMDb ID: %v", imdbID)
+		}
+	}
+	number, err := strconv.Atoi(digits)
+	if err != nil || number == 0 {
+		return "", fmt.Errorf("Invalid IMDb ID: %v", imdbID)
+	}
+	return fmt.Sprintf("tt%07d", number), nil
+}
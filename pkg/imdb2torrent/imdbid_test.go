@@ -0,0 +1,46 @@
+package imdb2torrent
+
+import "testing"
+
+func TestCanonicalIMDbID(t *testing.T) {
+	tests := []struct {
+		name      string
+		imdbID    string
+		expected  string
+		expectErr bool
+	}{
+		{"canonical", "tt1375666", "tt1375666", false},
+		{"without prefix", "1375666", "tt1375666", false},
+		{"zero-padded", "tt01375666", "tt1375666", false},
+		{"upper case prefix", "TT1375666", "tt1375666", false},
+		{"surrounding whitespace", " tt1375666\n", "tt1375666", false},
+		{"short", "tt123", "tt0000123", false},
+		{"short without prefix", "1", "tt0000001", false},
+		{"eight digits", "tt10872600", "tt10872600", false},
+		{"eight digits zero-padded", "tt010872600", "tt10872600", false},
+		{"empty", "", "", true},
+		{"prefix only", "tt", "", true},
+		{"zero", "tt0000000", "", true},
+		{"letters", "tt13756a6", "", true},
+		{"negative", "-1375666", "", true},
+		{"too long", "tt12345678901", "", true},
+		{"TV episode", "tt1375666:1:2", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := CanonicalIMDbID(tt.imdbID)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %q", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
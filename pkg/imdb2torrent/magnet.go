@@ -0,0 +1,241 @@
+package imdb2torrent
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Magnet contains the parts of a magnet URL that are relevant for finding and streaming a torrent.
+type Magnet struct {
+	// Upper case hex encoded BitTorrent v1 info hash ("btih"). Empty for v2-only torrents.
+	InfoHash string
+	// Upper case hex encoded BitTorrent v2 info hash as multihash ("btmh"), for v2 and hybrid torrents. Empty for v1 torrents.
+	InfoHashV2 string
+	// Value of the "dn" parameter, can be empty
+	DisplayName string
+	// Unique trackers in the order in which they appear in the magnet URL
+	Trackers []string
+}
+
+// ParseMagnet parses the given magnet URL.
+// It's lenient regarding the escaping of parameter values, because torrent sites don't always escape them properly.
+func ParseMagnet(magnetURL string) (Magnet, error) {
+	if !strings.HasPrefix(magnetURL, "magnet:?") {
+		return Magnet{}, errors.New("Magnet URL doesn't start with \"magnet:?\"")
+	}
+	var result Magnet
+	params := strings.Split(strings.TrimPrefix(magnetURL, "magnet:?"), "&")
+	for _, param := range params {
+		keyVal := strings.SplitN(param, "=", 2)
+		if len(keyVal) != 2 {
+			continue
+		}
+		val, err := url.QueryUnescape(keyVal[1])
+		if err != nil {
+			val = keyVal[1]
+		}
+		switch keyVal[0] {
+		case "xt":
+			if strings.HasPrefix(val, "urn:btih:") && result.InfoHash == "" {
+				result.InfoHash = strings.ToUpper(strings.TrimPrefix(val, "urn:btih:"))
+			} else if strings.HasPrefix(val, "urn:btmh:") && result.InfoHashV2 == "" {
+				result.InfoHashV2 = strings.ToUpper(strings.TrimPrefix(val, "urn:btmh:"))
+			}
+		case "dn":
+			result.DisplayName = val
+		case "tr":
+			if val != "" {
+				result.Trackers = appendUnique(result.Trackers, val)
+			}
+		}
+	}
+	if result.InfoHash == "" && result.InfoHashV2 == "" {
+		return Magnet{}, errors.New("Magnet URL doesn't contain a BitTorrent info_hash")
+	}
+	return result, nil
+}
+
+// BuildMagnet creates a magnet URL for the BitTorrent v1 info hash, with the display name (if not empty) and the trackers.
+func BuildMagnet(infoHash, displayName string, trackers []string) string {
+	magnetURL := "magnet:?xt=urn:btih:" + strings.ToUpper(infoHash)
+	if displayName != "" {
+		magnetURL += "&dn=" + url.QueryEscape(displayName)
+	}
+	for _, tracker := range trackers {
+		magnetURL += "&tr=" + url.QueryEscape(tracker)
+	}
+	return magnetURL
+}
+
+// completeMagnetURL sets the magnet URL of results that only have an info hash, which some sources' APIs return instead of a magnet URL.
+// Besides the result's own trackers the default trackers are used, because without any tracker finding peers relies on DHT alone.
+// Default trackers are only added until there are maxTrackers trackers, with 0 meaning no limit.
+func completeMagnetURL(result Result, maxTrackers int) Result {
+	if result.MagnetURL != "" || result.InfoHash == "" {
+		return result
+	}
+	resultTrackers := append([]string(nil), result.Trackers...)
+	for _, tracker := range trackers {
+		if maxTrackers > 0 && len(resultTrackers) >= maxTrackers {
+			break
+		}
+		resultTrackers = appendUnique(resultTrackers, tracker)
+	}
+	result.Trackers = resultTrackers
+	result.MagnetURL = BuildMagnet(result.InfoHash, result.Title, resultTrackers)
+	return result
+}
+
+// addRequestTrackers adds the trackers of the context, see WithRequestTrackers(), to the magnet URLs of the results, until they have maxTrackers trackers. 0 means no limit.
+// The results must be the caller's own copy, but their tracker lists can be shared, so they're copied before they're changed.
+func addRequestTrackers(ctx context.Context, results []Result, maxTrackers int) {
+	requestTrackers := requestTrackersFromContext(ctx)
+	if len(requestTrackers) == 0 {
+		return
+	}
+	for i := range results {
+		if results[i].MagnetURL == "" {
+			continue
+		}
+		results[i].MagnetURL = addTrackers(results[i].MagnetURL, requestTrackers, maxTrackers)
+		resultTrackers := append([]string(nil), results[i].Trackers...)
+		for _, tracker := range requestTrackers {
+			if maxTrackers > 0 && len(resultTrackers) >= maxTrackers {
+				break
+			}
+			resultTrackers = appendUnique(resultTrackers, tracker)
+		}
+		results[i].Trackers = resultTrackers
+	}
+}
+
+// applyTrackers adds the trackers of the context to the magnet URLs of the results and removes the trackers that aren't allowed afterwards, see WithRequestTrackers() and WithAllowedTrackerHosts().
+// It's done by every method that returns results. Like in addRequestTrackers() the results must be the caller's own copy.
+func (c Client) applyTrackers(ctx context.Context, results []Result) {
+	addRequestTrackers(ctx, results, c.maxTrackers)
+	removeDisallowedTrackers(results, c.allowedTrackers)
+}
+
+// removeDisallowedTrackers removes the trackers whose host isn't in the allowed hosts from the magnet URLs of the results. An empty set allows all trackers.
+// Like in addRequestTrackers() the results must be the caller's own copy, and their tracker lists are copied before they're changed.
+func removeDisallowedTrackers(results []Result, allowedHosts map[string]struct{}) {
+	if len(allowedHosts) == 0 {
+		return
+	}
+	for i := range results {
+		if results[i].MagnetURL != "" {
+			results[i].MagnetURL = filterMagnetTrackers(results[i].MagnetURL, allowedHosts)
+		}
+		var resultTrackers []string
+		for _, tracker := range results[i].Trackers {
+			if isAllowedTracker(tracker, allowedHosts) {
+				resultTrackers = append(resultTrackers, tracker)
+			}
+		}
+		results[i].Trackers = resultTrackers
+	}
+}
+
+// filterMagnetTrackers removes the "tr" parameters with a tracker whose host isn't in the allowed hosts from the magnet URL.
+// All other parameters are kept as they are.
+func filterMagnetTrackers(magnetURL string, allowedHosts map[string]struct{}) string {
+	if !strings.HasPrefix(magnetURL, "magnet:?") {
+		return magnetURL
+	}
+	var params []string
+	for _, param := range strings.Split(strings.TrimPrefix(magnetURL, "magnet:?"), "&") {
+		if strings.HasPrefix(param, "tr=") {
+			tracker, err := url.QueryUnescape(strings.TrimPrefix(param, "tr="))
+			if err != nil {
+				tracker = strings.TrimPrefix(param, "tr=")
+			}
+			if !isAllowedTracker(tracker, allowedHosts) {
+				continue
+			}
+		}
+		params = append(params, param)
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+// isAllowedTracker returns true if the tracker URL's host is one of the allowed upper case hosts or a subdomain of one of them.
+// Trackers whose URL can't be parsed aren't allowed.
+func isAllowedTracker(tracker string, allowedHosts map[string]struct{}) bool {
+	trackerURL, err := url.Parse(tracker)
+	if err != nil {
+		return false
+	}
+	host := strings.ToUpper(trackerURL.Hostname())
+	for host != "" {
+		if _, ok := allowedHosts[host]; ok {
+			return true
+		}
+		i := strings.Index(host, ".")
+		if i == -1 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+// isInfoHash returns true if the value is a hex encoded BitTorrent v1 info hash, which has 40 characters.
+func isInfoHash(val string) bool {
+	if len(val) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(val)
+	return err == nil
+}
+
+// magnetInfoHashV2 returns the BitTorrent v2 info hash of the magnet URL, or an empty string if it doesn't contain one.
+func magnetInfoHashV2(magnetURL string) string {
+	magnet, err := ParseMagnet(magnetURL)
+	if err != nil {
+		return ""
+	}
+	return magnet.InfoHashV2
+}
+
+// addTrackers adds the given trackers to the magnet URL, unless the magnet URL already contains them.
+// Trackers are added in the given order until the magnet URL has maxTrackers trackers, with 0 meaning no limit.
+// The magnet URL's own trackers are always kept, even if there are more than maxTrackers of them.
+func addTrackers(magnetURL string, trackers []string, maxTrackers int) string {
+	existingTrackers := map[string]struct{}{}
+	if magnet, err := ParseMagnet(magnetURL); err == nil {
+		for _, tracker := range magnet.Trackers {
+			existingTrackers[tracker] = struct{}{}
+		}
+	}
+	for _, tracker := range trackers {
+		if maxTrackers > 0 && len(existingTrackers) >= maxTrackers {
+			break
+		}
+		if _, ok := existingTrackers[tracker]; !ok {
+			magnetURL += "&tr=" + url.QueryEscape(tracker)
+			existingTrackers[tracker] = struct{}{}
+		}
+	}
+	return magnetURL
+}
+
+// magnetDisplayName returns the display name of the magnet URL, or an empty string if it doesn't contain one or can't be parsed.
+func magnetDisplayName(magnetURL string) string {
+	magnet, err := ParseMagnet(magnetURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(magnet.DisplayName)
+}
+
+// magnetTrackers returns the unique trackers of the magnet URL, or nil if the magnet URL can't be parsed.
+func magnetTrackers(magnetURL string) []string {
+	magnet, err := ParseMagnet(magnetURL)
+	if err != nil {
+		return nil
+	}
+	return magnet.Trackers
+}
@@ -0,0 +1,172 @@
+package imdb2torrent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const (
+	testInfoHashV1 = "C9E15763F722F23E98A29DECDFAE341B98D53056"
+	testInfoHashV2 = "1220CAF1E1C30E81CB361B9EE167C4AA64228A7FA4FA9F6105232B28AD099F3A302E"
+)
+
+func TestParseMagnet(t *testing.T) {
+	tests := []struct {
+		name       string
+		magnetURL  string
+		expectErr  bool
+		infoHash   string
+		infoHashV2 string
+	}{
+		{"v1", "magnet:?xt=urn:btih:" + testInfoHashV1 + "&dn=Big.Buck.Bunny.2008.1080p", false, testInfoHashV1, ""},
+		{"v1 lower case", "magnet:?xt=urn:btih:" + strings.ToLower(testInfoHashV1), false, testInfoHashV1, ""},
+		{"v2", "magnet:?xt=urn:btmh:" + strings.ToLower(testInfoHashV2) + "&dn=Big.Buck.Bunny.2008.1080p", false, "", testInfoHashV2},
+		{"hybrid", "magnet:?xt=urn:btih:" + testInfoHashV1 + "&xt=urn:btmh:" + testInfoHashV2 + "&dn=Big.Buck.Bunny.2008.1080p", false, testInfoHashV1, testInfoHashV2},
+		{"hybrid with v2 first", "magnet:?xt=urn:btmh:" + testInfoHashV2 + "&xt=urn:btih:" + testInfoHashV1, false, testInfoHashV1, testInfoHashV2},
+		{"no info hash", "magnet:?dn=Big.Buck.Bunny.2008.1080p", true, "", ""},
+		{"no magnet URL", "https://example.com/?xt=urn:btih:" + testInfoHashV1, true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			magnet, err := ParseMagnet(tt.magnetURL)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got: %+v", magnet)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if magnet.InfoHash != tt.infoHash || magnet.InfoHashV2 != tt.infoHashV2 {
+				t.Errorf("Expected info hashes %q and %q, got %q and %q", tt.infoHash, tt.infoHashV2, magnet.InfoHash, magnet.InfoHashV2)
+			}
+		})
+	}
+}
+
+func TestFindMagnetsV2Only(t *testing.T) {
+	v1 := Result{Title: "Big.Buck.Bunny.2008.720p", Quality: "720p", InfoHash: testInfoHashV1, MagnetURL: "magnet:?xt=urn:btih:" + testInfoHashV1}
+	v2 := Result{Title: "Big.Buck.Bunny.2008.1080p", Quality: "1080p", InfoHashV2: testInfoHashV2, MagnetURL: "magnet:?xt=urn:btmh:" + testInfoHashV2}
+	hybrid := Result{Title: "Big.Buck.Bunny.2008.2160p", Quality: "2160p", InfoHash: "1111111111111111111111111111111111111111", InfoHashV2: "1220" + strings.Repeat("1", 64), MagnetURL: "magnet:?xt=urn:btih:1111111111111111111111111111111111111111&xt=urn:btmh:1220" + strings.Repeat("1", 64)}
+	tests := []struct {
+		name    string
+		results []Result
+	}{
+		{"v1", []Result{v1}},
+		{"v2", []Result{v2}},
+		{"hybrid", []Result{hybrid}},
+		{"all", []Result{v1, v2, hybrid}},
+	}
+	client := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sites := []siteSearch{mockSite("mock1", tt.results, nil), mockSite("mock2", tt.results, nil)}
+			results, err := client.findMagnets(context.Background(), testLogger(), sites, nil)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			// The results of both sites are duplicates, v2-only ones by their v2 info hash
+			if len(results) != len(tt.results) {
+				t.Fatalf("Expected %v results, got %v: %+v", len(tt.results), len(results), results)
+			}
+			for i, result := range results {
+				if result.InfoHash != tt.results[i].InfoHash || result.InfoHashV2 != tt.results[i].InfoHashV2 {
+					t.Errorf("Expected result %+v, got %+v", tt.results[i], result)
+				}
+			}
+		})
+	}
+}
+
+func TestAddRequestTrackers(t *testing.T) {
+	const infoHash = "1111111111111111111111111111111111111111"
+	ownTrackers := []string{"udp://a.example:1337", "udp://b.example:1337"}
+	tests := []struct {
+		name            string
+		requestTrackers []string
+		maxTrackers     int
+		expected        []string
+	}{
+		{"no request trackers", nil, 0, ownTrackers},
+		{"no limit", []string{"udp://private.example:80"}, 0, append(ownTrackers, "udp://private.example:80")},
+		{"below max", []string{"udp://private.example:80"}, 3, append(ownTrackers, "udp://private.example:80")},
+		{"up to max", []string{"udp://private.example:80", "udp://c.example:80"}, 3, append(ownTrackers, "udp://private.example:80")},
+		{"at max", []string{"udp://private.example:80"}, 2, ownTrackers},
+		{"duplicate", []string{"udp://a.example:1337"}, 0, ownTrackers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.requestTrackers != nil {
+				ctx = WithRequestTrackers(ctx, tt.requestTrackers)
+			}
+			results := []Result{{InfoHash: infoHash, MagnetURL: BuildMagnet(infoHash, "Big Buck Bunny", ownTrackers), Trackers: ownTrackers}}
+			addRequestTrackers(ctx, results, tt.maxTrackers)
+
+			if strings.Join(results[0].Trackers, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected trackers %v, got %v", tt.expected, results[0].Trackers)
+			}
+			if magnetTrackers := magnetTrackers(results[0].MagnetURL); strings.Join(magnetTrackers, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected magnet URL trackers %v, got %v", tt.expected, magnetTrackers)
+			}
+			// The tracker list can be shared with the cached result
+			if len(ownTrackers) != 2 {
+				t.Error("The original tracker list was changed")
+			}
+		})
+	}
+}
+
+func TestPublicMethodsApplyTrackers(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handleFile(t, "/api/v2/list_movies.json?query_term=tt1254207", "yts_list_movies.json")
+	server.handle("/api/v2/list_movies.json?query_term=It", ytsShortTitleMovies)
+	server.handle("/big-buck-bunny.torrent", testTorrentFile())
+	client := newTestClient(t,
+		WithBaseURL("YTS", server.URL),
+		WithBaseURL("TPB", ""),
+		WithBaseURL("1337x", ""),
+		WithBaseURL("ibit", ""),
+		WithBaseURL("SolidTorrents", ""),
+		WithAllowedTrackerHosts([]string{"private.example"}))
+	// The own and default trackers of the results aren't allowed, so only the request tracker must be left
+	expected := "udp://private.example:80"
+	ctx := WithRequestTrackers(context.Background(), []string{expected})
+
+	tests := []struct {
+		name   string
+		search func() ([]Result, error)
+	}{
+		{"FindMagnets", func() ([]Result, error) { return client.FindMagnets(ctx, "tt1254207") }},
+		{"FindMagnetsByTitle", func() ([]Result, error) { return client.FindMagnetsByTitle(ctx, "It", 2017) }},
+		{"FindMagnetsBySite", func() ([]Result, error) {
+			resultsBySite, err := client.FindMagnetsBySite(ctx, "tt1254207")
+			return resultsBySite["YTS"], err
+		}},
+		{"TorrentFileToMagnet", func() ([]Result, error) {
+			result, err := client.TorrentFileToMagnet(ctx, server.URL+"/big-buck-bunny.torrent")
+			return []Result{result}, err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := tt.search()
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if len(results) == 0 {
+				t.Fatal("Expected results, got none")
+			}
+			for _, result := range results {
+				if strings.Join(result.Trackers, ",") != expected {
+					t.Errorf("Expected trackers %v, got %v", expected, result.Trackers)
+				}
+				if magnetTrackers := magnetTrackers(result.MagnetURL); strings.Join(magnetTrackers, ",") != expected {
+					t.Errorf("Expected magnet URL trackers %v, got %v", expected, magnetTrackers)
+				}
+			}
+		})
+	}
+}
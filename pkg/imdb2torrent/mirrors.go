@@ -0,0 +1,136 @@
+package imdb2torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// mirrorList is an ordered list of base URLs of a torrent site.
+// Requests are sent to the base URL that worked last and fall back to the next one on connection errors and Cloudflare challenges.
+type mirrorList struct {
+	baseURLs []string
+	// Index of the base URL that worked last
+	current int32
+	// Number of retries per mirror when resolving its host fails, which is often only temporary with proxies or in containers
+	dnsRetries int
+	// Shared by the mirror lists of all torrent sites. nil means no limit.
+	budget *scrapeBudget
+}
+
+// newMirrorList creates a mirrorList from a comma separated list of base URLs.
+func newMirrorList(baseURLs string) *mirrorList {
+	var m mirrorList
+	for _, baseURL := range strings.Split(baseURLs, ",") {
+		baseURL = strings.TrimSpace(baseURL)
+		if baseURL != "" {
+			m.baseURLs = append(m.baseURLs, baseURL)
+		}
+	}
+	return &m
+}
+
+// baseURL returns the base URL that worked last, or the first one if no request was sent yet.
+// URLs from scraped links should be rewritten to it, so that subsequent requests go to the same mirror.
+func (m *mirrorList) baseURL() string {
+	if len(m.baseURLs) == 0 {
+		return ""
+	}
+	return m.baseURLs[atomic.LoadInt32(&m.current)]
+}
+
+// currentIndex returns the index of the base URL that worked last, or 0 if no request was sent yet.
+func (m *mirrorList) currentIndex() int {
+	return int(atomic.LoadInt32(&m.current))
+}
+
+// get sends a GET request for the path (including the query) to the base URLs one after another, starting with the one that worked last.
+// If no mirror works, the error of the last one is returned as is, so a caller can for example check it for a timeout.
+// A Cloudflare challenge of the last mirror is returned as response without error, so the caller handles the status code like any other.
+func (m *mirrorList) get(ctx context.Context, httpClient *http.Client, path string) (*http.Response, error) {
+	if len(m.baseURLs) == 0 {
+		return nil, fmt.Errorf("No base URL configured")
+	}
+	start := int(atomic.LoadInt32(&m.current))
+	var res *http.Response
+	var err error
+	for i := 0; i < len(m.baseURLs); i++ {
+		index := (start + i) % len(m.baseURLs)
+		reqURL := m.baseURLs[index] + path
+		if err = m.budget.wait(ctx); err != nil {
+			return nil, err
+		}
+		reqCtx, span := startSpan(ctx, "GET", attribute.String("url", reqURL))
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(reqCtx, "GET", reqURL, nil); err != nil {
+			span.End()
+			return nil, fmt.Errorf("Couldn't create GET request: %v", err)
+		}
+		res, err = m.do(reqCtx, httpClient, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(attribute.Int("statusCode", res.StatusCode))
+		}
+		span.End()
+		isLast := i == len(m.baseURLs)-1
+		if err == nil && (!isCloudflareChallenge(res) || isLast) {
+			atomic.StoreInt32(&m.current, int32(index))
+			return res, nil
+		}
+		if isLast {
+			break
+		}
+		logger := log.WithContext(ctx).WithField("url", reqURL)
+		if err != nil {
+			logger.WithError(err).Warn("Couldn't reach mirror, trying next one")
+		} else {
+			res.Body.Close()
+			logger.Warn("Got Cloudflare challenge from mirror, trying next one")
+		}
+	}
+	return nil, err
+}
+
+// do sends the request and retries it with exponential backoff when the host can't be resolved.
+func (m *mirrorList) do(ctx context.Context, httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	recordRequestURL(ctx, req.URL.String())
+	backoff := 100 * time.Millisecond
+	for i := 0; ; i++ {
+		res, err := httpClient.Do(req)
+		if err == nil || i >= m.dnsRetries || !isDNSError(err) {
+			return res, err
+		}
+		log.WithContext(ctx).WithError(err).WithField("url", req.URL.String()).Debug("Couldn't resolve host, retrying...")
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isDNSError returns true if the error is caused by a failed DNS lookup, like "no such host".
+func isDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// isCloudflareChallenge returns true if the response is Cloudflare's browser check or block page, which a scraper can't get past.
+func isCloudflareChallenge(res *http.Response) bool {
+	if res.StatusCode != http.StatusServiceUnavailable && res.StatusCode != http.StatusForbidden {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(res.Header.Get("Server")), "cloudflare")
+}
@@ -0,0 +1,105 @@
+package imdb2torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// failingDialer dials the fixture server for all hosts, but fails the first dials with the given error.
+type failingDialer struct {
+	lock     *sync.Mutex
+	addr     string
+	failures int
+	err      error
+	dials    int
+}
+
+func (d *failingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.lock.Lock()
+	d.dials++
+	fail := d.dials <= d.failures
+	d.lock.Unlock()
+	if fail {
+		return nil, d.err
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, d.addr)
+}
+
+func TestMirrorListDNSRetries(t *testing.T) {
+	dnsErr := &net.DNSError{Err: "no such host", Name: "torrent-site.example", IsNotFound: true}
+	refusedErr := errors.New("connection refused")
+	tests := []struct {
+		name          string
+		dnsRetries    int
+		failures      int
+		err           error
+		expectErr     bool
+		expectedDials int
+	}{
+		{"no failure", 2, 0, dnsErr, false, 1},
+		{"retried DNS failure", 2, 1, dnsErr, false, 2},
+		{"retried DNS failures", 2, 2, dnsErr, false, 3},
+		{"too many DNS failures", 2, 3, dnsErr, true, 3},
+		{"DNS retries disabled", 0, 1, dnsErr, true, 1},
+		{"other errors aren't retried", 2, 1, refusedErr, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t)
+			server.handle("/search", "results")
+			dialer := &failingDialer{
+				lock:     &sync.Mutex{},
+				addr:     strings.TrimPrefix(server.URL, "http://"),
+				failures: tt.failures,
+				err:      tt.err,
+			}
+			httpClient := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+			mirrors := newMirrorList("http://torrent-site.example")
+			mirrors.dnsRetries = tt.dnsRetries
+
+			res, err := mirrors.get(context.Background(), httpClient, "/search")
+			if tt.expectErr {
+				if err == nil {
+					res.Body.Close()
+					t.Error("Expected an error")
+				} else if tt.err == dnsErr && !isDNSError(err) {
+					t.Errorf("Expected a DNS error, got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			} else {
+				res.Body.Close()
+			}
+			if dialer.dials != tt.expectedDials {
+				t.Errorf("Expected %v dials, got %v", tt.expectedDials, dialer.dials)
+			}
+		})
+	}
+}
+
+func TestIsDNSError(t *testing.T) {
+	dnsErr := &net.DNSError{Err: "no such host", Name: "torrent-site.example", IsNotFound: true}
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"DNS error", dnsErr, true},
+		{"wrapped DNS error", fmt.Errorf("Couldn't dial: %w", &net.OpError{Op: "dial", Net: "tcp", Err: dnsErr}), true},
+		{"other error", errors.New("connection refused"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := isDNSError(tt.err); actual != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,581 @@
+package imdb2torrent
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultCacheSize is the size in bytes of the in-memory caches that a client creates when it's not given any.
+const defaultCacheSize = 32 * 1024 * 1024
+
+// DefaultSiteDeadline is the max duration of a search on a single torrent site of a client that's created without WithSiteDeadline().
+const DefaultSiteDeadline = 30 * time.Second
+
+// Option configures optional behavior of a Client, see NewClient().
+type Option func(*options) error
+
+type options struct {
+	baseURLs      map[string]string
+	rateLimits    map[string]float64
+	socksProxy    socksProxy
+	timeout       time.Duration
+	httpClient    *http.Client
+	torrentCache  *fastcache.Cache
+	cinemataCache *fastcache.Cache
+	// Max age of torrent cache entries and their random deviation
+	cacheAge          time.Duration
+	cacheAgeJitter    time.Duration
+	cacheAgeCinemata  time.Duration
+	cinemataLimit     int
+	refreshWindow     time.Duration
+	minCachedResults  map[string]int
+	compressCache     bool
+	slowScrapeThresh  time.Duration
+	siteDeadline      time.Duration
+	maxDurationIbit   time.Duration
+	syncIbit          bool
+	parallelIbit      bool
+	tpbRetries        int
+	retryEmptyTPB     bool
+	retryEmptySites   map[string]bool
+	collapseYTS       bool
+	movieDetailsYTS   bool
+	concurrency1337x  int
+	maxResults1337x   int
+	mergeTrackers     bool
+	maxTrackers       int
+	allowedTrackers   []string
+	excludeCam        bool
+	fuzzyDedup        bool
+	dnsRetries        int
+	maxIdleConns      int
+	idleConnTimeout   time.Duration
+	disableKeepAlives bool
+	titleMatching     string
+	allYears          bool
+	blockedInfoHashes []string
+	minSize           int64
+	maxSize           int64
+	dropUnknownSize   bool
+	includeGroups     []string
+	excludeGroups     []string
+	dropUnknownGroup  bool
+	excludeQualities  []string
+	excludeSources    []string
+	unknownQuality    bool
+	coalesceSearches  bool
+	qualityPreference []string
+	remuxPreference   string
+	degradedErrRate   float64
+	errHistorySize    int
+	scrapeBudget      int
+	siteWeights       map[string]float64
+	estimateBitrate   bool
+	tracer            trace.Tracer
+}
+
+type socksProxy struct {
+	addr     string
+	user     string
+	password string
+}
+
+// defaultOptions returns the options of a client that's created without any options.
+func defaultOptions() options {
+	return options{
+		baseURLs: map[string]string{
+			"YTS":           "https://yts.mx",
+			"TPB":           "https://thepiratebay.org",
+			"1337x":         "https://1337x.to",
+			"ibit":          "https://ibit.am",
+			"SolidTorrents": "https://solidtorrents.net",
+		},
+		rateLimits:        map[string]float64{},
+		retryEmptySites:   map[string]bool{},
+		timeout:           5 * time.Second,
+		cacheAge:          24 * time.Hour,
+		maxDurationIbit:   time.Minute,
+		siteDeadline:      DefaultSiteDeadline,
+		maxTrackers:       20,
+		maxIdleConns:      http.DefaultMaxIdleConnsPerHost,
+		idleConnTimeout:   90 * time.Second,
+		titleMatching:     TitleMatchingNormalized,
+		coalesceSearches:  true,
+		qualityPreference: DefaultQualityPreference,
+		remuxPreference:   RemuxPreferenceNone,
+	}
+}
+
+// checkTorrentSite returns an error if the name isn't one of the keys of the map returned by GetMagnetSearchers().
+func checkTorrentSite(torrentSite string) error {
+	switch torrentSite {
+	case "YTS", "TPB", "1337x", "ibit", "SolidTorrents":
+		return nil
+	}
+	return fmt.Errorf("Unknown torrent site: %v", torrentSite)
+}
+
+// WithBaseURL sets the base URL of the torrent site, for example "https://yts.mx" for "YTS".
+// Multiple mirrors can be separated by comma, they're tried in order when a request fails.
+// The site names are the same as the keys of the map returned by GetMagnetSearchers().
+// An empty base URL disables the site, see ActiveSites().
+func WithBaseURL(torrentSite, baseURL string) Option {
+	return func(o *options) error {
+		if err := checkTorrentSite(torrentSite); err != nil {
+			return err
+		}
+		o.baseURLs[torrentSite] = baseURL
+		return nil
+	}
+}
+
+// WithRateLimit sets the max number of requests per second to the torrent site. 0 means no limit, which is the default.
+// For ibit the limit applies to each mirror.
+func WithRateLimit(torrentSite string, requestsPerSecond float64) Option {
+	return func(o *options) error {
+		if err := checkTorrentSite(torrentSite); err != nil {
+			return err
+		}
+		o.rateLimits[torrentSite] = requestsPerSecond
+		return nil
+	}
+}
+
+// WithEmptyRetry makes the client search the torrent site a second time when the first search didn't find any torrents, but the site's response looked like a results page, for example a page with an empty torrent table.
+// This recovers from partially rendered pages, without doubling the requests for movies that have no torrents on the site. Disabled by default.
+// It's only supported for the sites that are scraped via their HTML, 1337x and ibit. For TPB see WithTPBRetries().
+func WithEmptyRetry(torrentSite string, retry bool) Option {
+	return func(o *options) error {
+		if err := checkTorrentSite(torrentSite); err != nil {
+			return err
+		}
+		if _, ok := emptyRetrySites[torrentSite]; !ok {
+			return fmt.Errorf("Retrying empty results isn't supported for %v", torrentSite)
+		}
+		o.retryEmptySites[torrentSite] = retry
+		return nil
+	}
+}
+
+// WithTPBProxy makes requests to TPB go through the SOCKS5 proxy, for example "127.0.0.1:9050" for accessing TPB via the TOR network.
+// user and password can be empty for proxies that don't require authentication.
+func WithTPBProxy(addr, user, password string) Option {
+	return func(o *options) error {
+		o.socksProxy = socksProxy{addr: addr, user: user, password: password}
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of HTTP requests to the torrent sites and Cinemata. The default is 5 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.timeout = timeout
+		return nil
+	}
+}
+
+// WithHTTPClient makes all torrent sites use the given HTTP client instead of their own ones, for example for custom transports or recording requests.
+// The client is used as it is: Its timeout isn't changed, and the SOCKS5 proxy for TPB and the connection pooling options aren't applied to it.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) error {
+		o.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithTorrentCache sets the cache for torrent results and the max age of its entries, which is 24 hours by default.
+// The max age of each entry is shifted by a random offset in the range of [-jitter, +jitter].
+// Without this option the client uses a new, small in-memory cache.
+func WithTorrentCache(cache *fastcache.Cache, cacheAge, jitter time.Duration) Option {
+	return func(o *options) error {
+		o.torrentCache = cache
+		o.cacheAge = cacheAge
+		o.cacheAgeJitter = jitter
+		return nil
+	}
+}
+
+// WithCinemataCache sets the cache for movie names and years from Cinemata and the max age of its entries, with 0 meaning cinemata.DefaultCacheAge.
+// Without this option the client uses a new, small in-memory cache.
+func WithCinemataCache(cache *fastcache.Cache, cacheAge time.Duration) Option {
+	return func(o *options) error {
+		o.cinemataCache = cache
+		o.cacheAgeCinemata = cacheAge
+		return nil
+	}
+}
+
+// WithCinemataConcurrency sets the max number of requests to Cinemata at the same time, independent of the limits of the torrent sites.
+// Searches on sites that need Cinemata (see SearcherCapabilities()) wait for a free slot when their movie isn't in the Cinemata cache, so that a burst of searches doesn't overwhelm Cinemata. 0 means no limit, which is the default.
+func WithCinemataConcurrency(concurrency int) Option {
+	return func(o *options) error {
+		if concurrency < 0 {
+			return fmt.Errorf("Cinemata concurrency must not be negative: %v", concurrency)
+		}
+		o.cinemataLimit = concurrency
+		return nil
+	}
+}
+
+// WithRefreshWindow makes the client return cached results that expire within the window, but refresh them in the background. 0 disables the refresh, which is the default.
+func WithRefreshWindow(refreshWindow time.Duration) Option {
+	return func(o *options) error {
+		o.refreshWindow = refreshWindow
+		return nil
+	}
+}
+
+// WithMinCachedResults sets the min number of results per torrent site that a cache entry must have to count as complete.
+// Entries with fewer results are still returned, but refreshed in the background, like entries that expire within the refresh window (see WithRefreshWindow()).
+// Concurrent refreshes of the same entry are prevented, but an entry that stays below the min after a refresh is refreshed again on the next search.
+// The keys are the site names of GetMagnetSearchers(). Sites without min or with a min of 0 never refresh entries because of their number of results, which is the default.
+func WithMinCachedResults(minResults map[string]int) Option {
+	return func(o *options) error {
+		for torrentSite, min := range minResults {
+			if err := checkTorrentSite(torrentSite); err != nil {
+				return err
+			}
+			if min < 0 {
+				return fmt.Errorf("Min number of cached results of %v must not be negative: %v", torrentSite, min)
+			}
+		}
+		o.minCachedResults = minResults
+		return nil
+	}
+}
+
+// WithCompressedCache makes the client gzip torrent cache entries.
+func WithCompressedCache(compress bool) Option {
+	return func(o *options) error {
+		o.compressCache = compress
+		return nil
+	}
+}
+
+// WithSlowScrapeThreshold makes the client log a warning when a search on a single torrent site takes longer than the threshold. 0 disables the logging, which is the default.
+func WithSlowScrapeThreshold(threshold time.Duration) Option {
+	return func(o *options) error {
+		o.slowScrapeThresh = threshold
+		return nil
+	}
+}
+
+// WithSiteDeadline sets the max duration of a search on a single torrent site. A search that takes longer, for example because it's stuck in parsing pathological HTML, is treated as timed out and abandoned.
+// It must be longer than the HTTP timeout, because a search can consist of multiple requests, like retries, requests to mirrors or to torrent pages.
+// The deadline of a synchronous ibit search is at least its max duration. 0 disables the deadline. The default is DefaultSiteDeadline.
+func WithSiteDeadline(deadline time.Duration) Option {
+	return func(o *options) error {
+		o.siteDeadline = deadline
+		return nil
+	}
+}
+
+// WithIbit configures how ibit is searched:
+// maxDuration is the max duration of a search, including the part that runs in the background, which is 1 minute by default.
+// With sync the search is waited for like the ones of the other sites, instead of letting it continue in the background after 1 second.
+// With parallelMirrors the torrent pages are distributed among all mirrors, which are requested in parallel.
+func WithIbit(maxDuration time.Duration, sync, parallelMirrors bool) Option {
+	return func(o *options) error {
+		o.maxDurationIbit = maxDuration
+		o.syncIbit = sync
+		o.parallelIbit = parallelMirrors
+		return nil
+	}
+}
+
+// WithTPBRetries sets the number of retries when a TPB search times out. With retryEmpty empty results are retried as well.
+func WithTPBRetries(retries int, retryEmpty bool) Option {
+	return func(o *options) error {
+		o.tpbRetries = retries
+		o.retryEmptyTPB = retryEmpty
+		return nil
+	}
+}
+
+// WithYTS configures how YTS is searched:
+// With collapseTorrents only the best torrent per quality and bit depth is kept.
+// With movieDetails the movie details endpoint is used when the search endpoint fails or doesn't find torrents for an IMDb ID.
+func WithYTS(collapseTorrents, movieDetails bool) Option {
+	return func(o *options) error {
+		o.collapseYTS = collapseTorrents
+		o.movieDetailsYTS = movieDetails
+		return nil
+	}
+}
+
+// With1337xConcurrency sets the max number of torrent pages of a movie that are requested from 1337x at the same time. 0 means no limit, which is the default.
+func With1337xConcurrency(concurrency int) Option {
+	return func(o *options) error {
+		o.concurrency1337x = concurrency
+		return nil
+	}
+}
+
+// With1337xMaxResults sets the max number of results of a movie from 1337x.
+// The movie's torrents are looked at in the order of their seeders, so this skips the torrent page requests of the least seeded torrents. 0 means no limit, which is the default.
+func With1337xMaxResults(maxResults int) Option {
+	return func(o *options) error {
+		if maxResults < 0 {
+			return fmt.Errorf("Max results of 1337x must not be negative: %v", maxResults)
+		}
+		o.maxResults1337x = maxResults
+		return nil
+	}
+}
+
+// WithTrackers configures the trackers of magnet URLs:
+// With merge the trackers of duplicate results from different torrent sites are combined.
+// maxTrackers is the max number of trackers that a magnet URL gets when trackers are added to it, 20 by default. 0 means no limit.
+func WithTrackers(merge bool, maxTrackers int) Option {
+	return func(o *options) error {
+		o.mergeTrackers = merge
+		o.maxTrackers = maxTrackers
+		return nil
+	}
+}
+
+// WithAllowedTrackerHosts makes the client remove trackers from the magnet URLs of its results, unless the tracker's host is one of the given hosts or a subdomain of one of them.
+// For example "opentrackr.org" allows "udp://tracker.opentrackr.org:1337/announce". The trackers are removed after trackers were added, for example via WithRequestTrackers().
+// An empty list allows all trackers, which is the default.
+func WithAllowedTrackerHosts(hosts []string) Option {
+	return func(o *options) error {
+		for _, host := range hosts {
+			if host == "" || strings.ContainsAny(host, ":/") {
+				return fmt.Errorf("Invalid tracker host, it must be a domain like \"opentrackr.org\": %v", host)
+			}
+		}
+		o.allowedTrackers = hosts
+		return nil
+	}
+}
+
+// WithExcludedCam makes the client drop cam and telesync releases.
+func WithExcludedCam(exclude bool) Option {
+	return func(o *options) error {
+		o.excludeCam = exclude
+		return nil
+	}
+}
+
+// WithFuzzyDedup makes the client collapse results that are probably re-uploads of the same release.
+func WithFuzzyDedup(fuzzyDedup bool) Option {
+	return func(o *options) error {
+		o.fuzzyDedup = fuzzyDedup
+		return nil
+	}
+}
+
+// WithDNSRetries sets the number of retries per torrent site mirror when its host name can't be resolved.
+func WithDNSRetries(retries int) Option {
+	return func(o *options) error {
+		o.dnsRetries = retries
+		return nil
+	}
+}
+
+// WithConnectionPooling sets the connection pooling options of the torrent sites' HTTP clients.
+// The defaults are the ones of Go's default transport.
+func WithConnectionPooling(maxIdleConnsPerHost int, idleConnTimeout time.Duration, disableKeepAlives bool) Option {
+	return func(o *options) error {
+		o.maxIdleConns = maxIdleConnsPerHost
+		o.idleConnTimeout = idleConnTimeout
+		o.disableKeepAlives = disableKeepAlives
+		return nil
+	}
+}
+
+// WithAllYears makes the torrent sites that are searched by title include the movies of all years with a matching title, instead of only the requested year.
+// It's meant for IMDb IDs that correspond to multiple releases, like re-releases or anthologies, that are listed under different years. Each result has the year of its torrent title in Result.Year, so that callers can tell them apart.
+// The default is false, then the year is part of the search and only a single movie is used.
+func WithAllYears(allYears bool) Option {
+	return func(o *options) error {
+		o.allYears = allYears
+		return nil
+	}
+}
+
+// WithTitleMatching sets how strictly torrent titles must match the movie title on torrent sites that are searched by title.
+// It must be one of the TitleMatching... constants, the default is TitleMatchingNormalized.
+func WithTitleMatching(titleMatching string) Option {
+	return func(o *options) error {
+		if err := checkTitleMatching(titleMatching); err != nil {
+			return err
+		}
+		o.titleMatching = titleMatching
+		return nil
+	}
+}
+
+// WithBlockedInfoHashes makes the client remove the torrents with the given info hashes from all results.
+func WithBlockedInfoHashes(infoHashes []string) Option {
+	return func(o *options) error {
+		o.blockedInfoHashes = infoHashes
+		return nil
+	}
+}
+
+// WithSizeLimits makes the client drop results that are smaller than minSize or bigger than maxSize bytes, with 0 meaning no limit.
+// With dropUnknownSize results with an unknown size are dropped as well when a limit is set.
+func WithSizeLimits(minSize, maxSize int64, dropUnknownSize bool) Option {
+	return func(o *options) error {
+		o.minSize = minSize
+		o.maxSize = maxSize
+		o.dropUnknownSize = dropUnknownSize
+		return nil
+	}
+}
+
+// WithGroupFilter makes the client only return results of the included release groups and never results of the excluded ones, see Result.Group.
+// Groups are compared case-insensitively, and excluded groups take precedence over included ones. An empty include list means all groups are included.
+// Results with an unknown group are kept, unless dropUnknownGroup is set.
+func WithGroupFilter(include, exclude []string, dropUnknownGroup bool) Option {
+	return func(o *options) error {
+		o.includeGroups = include
+		o.excludeGroups = exclude
+		o.dropUnknownGroup = dropUnknownGroup
+		return nil
+	}
+}
+
+// WithExcludedSources makes the client remove results with the given sources, which must be one of the Source... constants, for example "webrip" to avoid re-encoded web releases.
+// Results with unknown source are kept. Note that YTS only reports "web" and "bluray", so its web releases aren't affected by excluding "webrip".
+func WithExcludedSources(excludedSources []string) Option {
+	return func(o *options) error {
+		for _, excludedSource := range excludedSources {
+			if !isSource(excludedSource) {
+				return fmt.Errorf("Unsupported source in excluded sources: %v. Supported sources: %v", excludedSource, strings.Join(supportedSources(), ", "))
+			}
+		}
+		o.excludeSources = excludedSources
+		return nil
+	}
+}
+
+// WithExcludedQualities makes the client remove results with the given qualities, which must be supported qualities like "1080p" or "2160p 10bit".
+// A resolution like "2160p" also excludes its other qualities, like "2160p 10bit".
+// The qualities are excluded after deduplication, so a duplicate with a more specific quality decides whether a torrent is excluded. Excluded qualities don't need to be removed from the quality preference order.
+func WithExcludedQualities(qualities []string) Option {
+	return func(o *options) error {
+		for _, quality := range qualities {
+			if qualityIndex(quality) == -1 {
+				return fmt.Errorf("Unsupported quality in excluded qualities: %v. Supported qualities: %v", quality, strings.Join(supportedQualities, ", "))
+			}
+		}
+		o.excludeQualities = qualities
+		return nil
+	}
+}
+
+// WithUnknownQuality sets whether results whose title doesn't contain a resolution are kept with the quality QualityUnknown instead of being removed, which is the default.
+// Keeping them can find torrents that would be lost otherwise, but they might have a low quality. Results with resolutions below the supported ones, like "480p", are always removed.
+func WithUnknownQuality(keep bool) Option {
+	return func(o *options) error {
+		o.unknownQuality = keep
+		return nil
+	}
+}
+
+// WithCoalescedSearches sets whether concurrent searches for the same IMDb ID share a single search, which is the default.
+func WithCoalescedSearches(coalesce bool) Option {
+	return func(o *options) error {
+		o.coalesceSearches = coalesce
+		return nil
+	}
+}
+
+// WithQualityPreference sets the qualities from most to least preferred, which must be supported qualities, see Client.SupportedQualities(). Excluded qualities are allowed as well.
+// QualityUnknown is only supported if results with an unknown quality are kept. nil means DefaultQualityPreference, which is the default.
+func WithQualityPreference(qualityPreference []string) Option {
+	return func(o *options) error {
+		if qualityPreference == nil {
+			o.qualityPreference = DefaultQualityPreference
+			return nil
+		}
+		o.qualityPreference = qualityPreference
+		return nil
+	}
+}
+
+// WithRemuxPreference sets how remux releases are sorted among results of the same quality rank, see SortResults().
+// It must be one of the RemuxPreference... constants, the default is RemuxPreferenceNone.
+func WithRemuxPreference(remuxPreference string) Option {
+	return func(o *options) error {
+		if err := checkRemuxPreference(remuxPreference); err != nil {
+			return err
+		}
+		o.remuxPreference = remuxPreference
+		return nil
+	}
+}
+
+// WithSiteWeights sets trust weights of torrent sites, which are used in SortResults() to sort results with the same quality rank and seeders, with higher weights first.
+// The keys are the site names of GetMagnetSearchers() or "curated" for results that were added via SeedResults(). Sites without weight have a weight of 1, so by default all sites are equal.
+func WithSiteWeights(weights map[string]float64) Option {
+	return func(o *options) error {
+		for torrentSite, weight := range weights {
+			if torrentSite != curatedSite {
+				if err := checkTorrentSite(torrentSite); err != nil {
+					return err
+				}
+			}
+			if weight < 0 {
+				return fmt.Errorf("Weight of %v must not be negative: %v", torrentSite, weight)
+			}
+		}
+		o.siteWeights = weights
+		return nil
+	}
+}
+
+// WithScrapeBudget sets the max number of requests per minute to all torrent sites together, in addition to the rate limits of the single sites (see WithRateLimit()).
+// When the budget is exhausted, requests wait until they're allowed or the context is done. 0 means no limit, which is the default. See ScrapeBudgetStats() for how often requests had to wait.
+func WithScrapeBudget(requestsPerMinute int) Option {
+	return func(o *options) error {
+		if requestsPerMinute < 0 {
+			return fmt.Errorf("Scrape budget must not be negative: %v", requestsPerMinute)
+		}
+		o.scrapeBudget = requestsPerMinute
+		return nil
+	}
+}
+
+// WithErrorHistory sets the max number of recent errors that are kept per torrent site, see RecentErrors(). 0 disables the history, which is the default.
+func WithErrorHistory(size int) Option {
+	return func(o *options) error {
+		if size < 0 {
+			return fmt.Errorf("Error history size must not be negative: %v", size)
+		}
+		o.errHistorySize = size
+		return nil
+	}
+}
+
+// WithDegradedErrRate sets the error rate threshold for torrent sites, see OnSiteDegraded(). 0 disables the check, which is the default.
+func WithDegradedErrRate(errRate float64) Option {
+	return func(o *options) error {
+		o.degradedErrRate = errRate
+		return nil
+	}
+}
+
+// WithEstimatedBitrate makes the client set Result.EstimatedBitrate of the results of searches by IMDb ID.
+// This requires the movie's runtime from Cinemata, which is requested concurrently with the search and cached like movie names.
+func WithEstimatedBitrate(estimate bool) Option {
+	return func(o *options) error {
+		o.estimateBitrate = estimate
+		return nil
+	}
+}
+
+// WithTracer makes the client create root spans for searches with the tracer. Without it, spans are only created when the context already contains one.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(o *options) error {
+		o.tracer = tracer
+		return nil
+	}
+}
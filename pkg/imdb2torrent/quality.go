@@ -0,0 +1,469 @@
+package imdb2torrent
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sizeRegex matches sizes like "1.4 GiB" or "700MB". Torrent sites sometimes use a non-breaking space between number and unit.
+var sizeRegex = regexp.MustCompile(`(?i)([0-9]+(?:\.[0-9]+)?)[\s\x{00a0}]*([KMGT])i?B\b`)
+
+// sizeUnits maps the first letter of a size unit to its number of bytes.
+// Torrent sites aren't consistent in their use of SI and binary prefixes, so both are treated as binary.
+var sizeUnits = map[string]float64{
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// releaseTypes are the release types of pirated movies with a lower quality than the resolution suggests.
+// The tokens are matched against the upper case words of a torrent title.
+// See https://en.wikipedia.org/wiki/Pirated_movie_release_types
+var releaseTypes = []struct {
+	tokens []string
+	name   string
+}{
+	{[]string{"CAM", "HDCAM", "CAMRIP"}, "cam"},
+	{[]string{"TS", "HDTS", "TELESYNC", "PDVD"}, "telesync"},
+	{[]string{"TC", "HDTC", "TELECINE"}, "telecine"},
+	{[]string{"WP", "WORKPRINT"}, "workprint"},
+	{[]string{"SCR", "SCREENER", "DVDSCR", "BDSCR"}, "screener"},
+}
+
+// Sources of releases, as detected by parseSource().
+// YTS only distinguishes between SourceWeb and SourceBluRay.
+const (
+	// Downloaded from a streaming service without re-encoding
+	SourceWebDL = "web-dl"
+	// Re-encoded from a capture of a streaming service
+	SourceWebRip = "webrip"
+	// From a streaming service, but unknown whether it's a WEB-DL or WEBRip
+	SourceWeb = "web"
+	// Encoded from a Blu-ray, or a remux of it
+	SourceBluRay = "bluray"
+	// Re-encoded from another Blu-ray encode, usually with a lower quality
+	SourceBDRip = "bdrip"
+	// Captured from a TV broadcast
+	SourceHDTV = "hdtv"
+)
+
+// sources are the release sources with their tokens, which are matched against the upper case words of a torrent title, and for two-word tokens like in "WEB-DL" against the concatenation of two adjacent words.
+var sources = []struct {
+	tokens []string
+	name   string
+}{
+	{[]string{"WEBDL"}, SourceWebDL},
+	{[]string{"WEBRIP"}, SourceWebRip},
+	{[]string{"WEB"}, SourceWeb},
+	{[]string{"BLURAY", "BDREMUX", "BDMV"}, SourceBluRay},
+	{[]string{"BDRIP", "BRRIP"}, SourceBDRip},
+	{[]string{"HDTV", "HDTVRIP"}, SourceHDTV},
+}
+
+// nonGroupSuffixes are words that can follow the last dash of a torrent title without being a release group, like in "WEB-DL".
+var nonGroupSuffixes = map[string]struct{}{
+	"DL":    {},
+	"RIP":   {},
+	"DLRIP": {},
+	"HD":    {},
+	"RAY":   {},
+	"DTS":   {},
+	"AAC":   {},
+}
+
+// parseQuality returns the quality of a torrent based on its title, for example "1080p" or "1080p 10bit".
+// A magnet URL can be passed as well, because it contains the title.
+// It returns false if the torrent doesn't have one of the supported resolutions.
+func parseQuality(title string) (string, bool) {
+	resolution := ""
+	if strings.Contains(title, "720p") {
+		resolution = "720p"
+	} else if strings.Contains(title, "1080p") {
+		resolution = "1080p"
+	} else if strings.Contains(title, "2160p") {
+		resolution = "2160p"
+	} else {
+		return "", false
+	}
+	return formatQuality(resolution, parseBitDepth(title)), true
+}
+
+// QualityUnknown is the quality of results whose title doesn't contain any resolution.
+// They're only returned by FindMagnets() if the client is configured to keep them, see WithUnknownQuality().
+const QualityUnknown = "unknown"
+
+// unsupportedResolutions are resolutions below the supported ones. Results with them are always dropped, because their quality is known to be too low.
+var unsupportedResolutions = []string{"240p", "360p", "480p", "576p"}
+
+// parseQualityOrUnknown is like parseQuality(), but returns QualityUnknown instead of false if the title doesn't contain any resolution.
+// It still returns false for resolutions that aren't supported, like "480p".
+func parseQualityOrUnknown(title string) (string, bool) {
+	if quality, ok := parseQuality(title); ok {
+		return quality, true
+	}
+	for _, resolution := range unsupportedResolutions {
+		if strings.Contains(title, resolution) {
+			return "", false
+		}
+	}
+	return QualityUnknown, true
+}
+
+// formatQuality returns the canonical quality for the resolution and bit depth: The resolution first, followed by the space separated tokens of the other properties, like "1080p 10bit".
+// All torrent site clients must use it for Result.Quality, so that callers can match qualities without handling site specific variants.
+func formatQuality(resolution string, bitDepth int) string {
+	quality := resolution
+	if bitDepth == 10 {
+		quality += " 10bit"
+	}
+	return quality
+}
+
+// QualityLabel returns the quality with the annotations for users, for example "1080p 10bit (web)", "2160p (remux)" or "720p (⚠️cam)\n(⚠️guessed match)".
+func (r Result) QualityLabel() string {
+	label := r.Quality
+	if r.Source != "" {
+		label += " (" + r.Source + ")"
+	}
+	if r.Remux {
+		label += " (remux)"
+	}
+	if r.ReleaseType != "" {
+		label += " (⚠️" + r.ReleaseType + ")"
+	}
+	// The quality might later be used as title, as suggested by Stremio, so the hint must be part of it
+	if r.GuessedMatch {
+		label += "\n(⚠️guessed match)"
+	}
+	return label
+}
+
+// normalizeQuality moves the annotations of qualities in the format of older cache entries, like "1080p (web)" or "720p (⚠️cam)\n(⚠️guessed match)", into their own fields and returns the result with the canonical quality.
+// The bit depth must already be set, because older YTS results only have it in that field.
+func normalizeQuality(result Result) Result {
+	if !strings.ContainsAny(result.Quality, "(\n") {
+		return result
+	}
+	lines := strings.SplitN(result.Quality, "\n", 2)
+	if len(lines) == 2 && strings.Contains(lines[1], "guessed match") {
+		result.GuessedMatch = true
+	}
+	parts := strings.Split(lines[0], " (")
+	for _, annotation := range parts[1:] {
+		annotation = strings.TrimSuffix(annotation, ")")
+		if strings.HasPrefix(annotation, "⚠️") {
+			result.ReleaseType = strings.TrimPrefix(annotation, "⚠️")
+		} else {
+			result.Source = annotation
+		}
+	}
+	if fields := strings.Fields(parts[0]); len(fields) > 0 {
+		result.Quality = formatQuality(fields[0], result.BitDepth)
+	}
+	return result
+}
+
+// parseBitDepth returns the color bit depth of a torrent based on its title, which is 10 for titles with "10bit" and 8 otherwise.
+// A magnet URL can be passed as well, because it contains the title.
+func parseBitDepth(title string) int {
+	if strings.Contains(title, "10bit") {
+		return 10
+	}
+	return 8
+}
+
+// parseReleaseGroup returns the release group of a torrent based on its title, for example "SPARKS" for "Movie.2019.1080p.BluRay.x264-SPARKS".
+// A magnet URL can be passed as well, then its display name is used.
+// It returns an empty string if the title doesn't seem to contain a group.
+func parseReleaseGroup(title string) string {
+	if strings.HasPrefix(title, "magnet:") {
+		magnet, err := ParseMagnet(title)
+		if err != nil {
+			return ""
+		}
+		title = magnet.DisplayName
+	}
+	// Remove the file extension and trailing tags of the uploader, like in "Movie.2019.1080p.BluRay.x264-SPARKS[rarbg].mkv"
+	title = strings.TrimSpace(title)
+	for _, ext := range []string{".mkv", ".mp4", ".avi"} {
+		if strings.HasSuffix(strings.ToLower(title), ext) {
+			title = title[:len(title)-len(ext)]
+		}
+	}
+	for strings.HasSuffix(title, "]") {
+		i := strings.LastIndex(title, "[")
+		if i == -1 {
+			break
+		}
+		title = strings.TrimSpace(title[:i])
+	}
+
+	i := strings.LastIndex(title, "-")
+	if i == -1 {
+		return ""
+	}
+	group := strings.TrimSpace(title[i+1:])
+	if len(group) < 2 || len(group) > 20 {
+		return ""
+	}
+	onlyDigits := true
+	for _, r := range group {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return ""
+		}
+		if !unicode.IsDigit(r) {
+			onlyDigits = false
+		}
+	}
+	// Probably part of a date or the movie name
+	if onlyDigits {
+		return ""
+	}
+	if _, ok := nonGroupSuffixes[strings.ToUpper(group)]; ok {
+		return ""
+	}
+	return group
+}
+
+// ParseSize returns the number of bytes of the first size in the text, for example 1503238553 for "Size 1.4 GiB, ULed by foo".
+// It returns 0 if the text doesn't contain a size.
+func ParseSize(text string) int64 {
+	match := sizeRegex.FindStringSubmatch(text)
+	if match == nil {
+		return 0
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(number * sizeUnits[strings.ToUpper(match[2])])
+}
+
+// parseReleaseType returns the release type of the torrent based on its title, for example "cam", or an empty string if it's none of the low quality release types.
+// A magnet URL can be passed as well, because it contains the title.
+// Only the release tags are checked, so that movies like "Cam" (2018) aren't detected as cam releases.
+func parseReleaseType(title string) string {
+	words := titleWords(releaseTags(title))
+	for _, releaseType := range releaseTypes {
+		for _, word := range words {
+			for _, token := range releaseType.tokens {
+				if word == token {
+					return releaseType.name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// supportedSources returns the names of all sources that parseSource() can detect.
+func supportedSources() []string {
+	var result []string
+	for _, source := range sources {
+		result = append(result, source.name)
+	}
+	return result
+}
+
+// isSource returns true if the name is one of the Source... constants.
+func isSource(name string) bool {
+	for _, source := range sources {
+		if source.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSource returns the source of the release based on the torrent title, for example "web-dl" for "Movie.2019.1080p.WEB-DL.x264-GRP", or an empty string if it's unknown. See the Source... constants.
+// The title is searched from its end, because the source is part of the tags after the movie title, which can contain words like "Web" as well.
+// A magnet URL can be passed as well, because it contains the title.
+func parseSource(title string) string {
+	words := titleWords(title)
+	for i := len(words) - 1; i >= 0; i-- {
+		candidates := []string{words[i]}
+		// The concatenation first, so that "WEB-DL" isn't detected as "WEB"
+		if i+1 < len(words) {
+			candidates = []string{words[i] + words[i+1], words[i]}
+		}
+		for _, candidate := range candidates {
+			for _, source := range sources {
+				for _, token := range source.tokens {
+					if candidate == token {
+						return source.name
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// parseRemux returns true if the torrent title marks the release as remux, like "Movie.2019.1080p.BluRay.REMUX" or "Movie.2019.1080p.BDRemux", which contains the untouched video of the source instead of an encode.
+// A magnet URL can be passed as well, because it contains the title.
+func parseRemux(title string) bool {
+	for _, word := range titleWords(title) {
+		// Also matches variants like "BDREMUX" and "UHDREMUX"
+		if strings.HasSuffix(word, "REMUX") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProper returns true if the torrent title marks the release as PROPER, like "Movie.2019.1080p.PROPER.BluRay.x264-GRP", which is a fixed release of another group's faulty one.
+// A magnet URL can be passed as well, because it contains the title.
+func parseProper(title string) bool {
+	for _, word := range titleWords(title) {
+		if word == "PROPER" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRepack returns true if the torrent title marks the release as REPACK, like "Movie.2019.1080p.REPACK.BluRay.x264-GRP", which is a fixed release of the group's own faulty one.
+// A magnet URL can be passed as well, because it contains the title.
+func parseRepack(title string) bool {
+	for _, word := range titleWords(title) {
+		// Also matches numbered repacks like "REPACK2", but not words like "REPACKAGED"
+		if strings.HasPrefix(word, "REPACK") && strings.TrimLeft(word[len("REPACK"):], "0123456789") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isFixedRelease returns true if the result is a PROPER or REPACK release.
+func isFixedRelease(result Result) bool {
+	return result.Proper || result.Repack
+}
+
+// releaseTags returns the part of the torrent title after the movie title, starting with the year or resolution, for example "2017.1080p.BluRay.x264-SPARKS" for "It.2017.1080p.BluRay.x264-SPARKS".
+// For a magnet URL only its display name is used, because the other parameters like the trackers can contain anything.
+// It returns an empty string if the title doesn't contain a year or resolution, because then the release tags can't be told apart from the movie title.
+func releaseTags(title string) string {
+	if strings.HasPrefix(title, "magnet:") {
+		title = magnetDisplayName(title)
+	}
+	titlePart := torrentTitlePart(title, 0)
+	return title[strings.Index(title, titlePart)+len(titlePart):]
+}
+
+// titleWords returns the upper case words of the torrent title, which are separated by anything that's not a letter or digit.
+// A magnet URL can be passed as well, because it contains the title.
+func titleWords(title string) []string {
+	// Magnet URLs contain the title in escaped form
+	if unescapedTitle, err := url.QueryUnescape(title); err == nil {
+		title = unescapedTitle
+	}
+	return strings.FieldsFunc(strings.ToUpper(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Preference for remux releases among results of the same quality rank.
+// Remuxes have the untouched quality of their source, but are much bigger than encodes.
+const (
+	// Remuxes are sorted like other releases
+	RemuxPreferenceNone = "none"
+	// Remuxes are sorted before encodes
+	RemuxPreferencePrefer = "prefer"
+	// Remuxes are sorted after encodes
+	RemuxPreferenceAvoid = "avoid"
+)
+
+// checkRemuxPreference returns an error if the remux preference isn't one of the RemuxPreference... constants.
+func checkRemuxPreference(remuxPreference string) error {
+	switch remuxPreference {
+	case RemuxPreferenceNone, RemuxPreferencePrefer, RemuxPreferenceAvoid:
+		return nil
+	default:
+		return fmt.Errorf("Unknown remux preference: %v", remuxPreference)
+	}
+}
+
+// DefaultQualityPreference is the quality preference order of a client that's created without one, from most to least preferred.
+var DefaultQualityPreference = []string{"2160p", "1080p", "720p"}
+
+// checkQualityPreference returns an error if the client's quality preference order is empty, contains a quality that's not one of SupportedQualities() or contains a quality twice.
+// Excluded qualities are allowed, so that excluding a quality doesn't require changing the preference order as well.
+func (c Client) checkQualityPreference() error {
+	if len(c.qualityPreference) == 0 {
+		return fmt.Errorf("Empty quality preference order")
+	}
+	supportedQualities := c.SupportedQualities()
+	seen := map[string]struct{}{}
+	for _, quality := range c.qualityPreference {
+		supported := qualityIndex(quality) != -1 && c.qualityExcluded(quality)
+		for _, supportedQuality := range supportedQualities {
+			if quality == supportedQuality {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("Unsupported quality in quality preference order: %v. Supported qualities: %v", quality, strings.Join(supportedQualities, ", "))
+		}
+		if _, ok := seen[quality]; ok {
+			return fmt.Errorf("Duplicate quality in quality preference order: %v", quality)
+		}
+		seen[quality] = struct{}{}
+	}
+	return nil
+}
+
+// QualityRank returns the position of the quality in the client's quality preference order, with 0 being the most preferred one.
+// The quality can also be a label like "1080p 10bit (⚠️cam)", see Result.QualityLabel(). A quality that's not in the order, like "1080p 10bit" for the order "2160p,1080p", gets the rank of its resolution.
+// Qualities whose resolution isn't in the order either are ranked after all others.
+func (c Client) QualityRank(quality string) int {
+	// For example "1080p 10bit" for "1080p 10bit (web)\n(⚠️guessed match)"
+	quality = strings.SplitN(quality, "\n", 2)[0]
+	quality = strings.SplitN(quality, " (", 2)[0]
+	resolution := strings.SplitN(quality, " ", 2)[0]
+	resolutionRank := len(c.qualityPreference)
+	for i, preferredQuality := range c.qualityPreference {
+		if preferredQuality == quality {
+			return i
+		}
+		if preferredQuality == resolution && i < resolutionRank {
+			resolutionRank = i
+		}
+	}
+	return resolutionRank
+}
+
+// SortResults sorts the results in place by the client's quality preference order, results with the same rank by the client's remux preference, then PROPER and REPACK releases first, then by their number of seeders and then by the trust weight of their torrent site.
+func (c Client) SortResults(results []Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		rankI, rankJ := c.QualityRank(results[i].Quality), c.QualityRank(results[j].Quality)
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		if results[i].Remux != results[j].Remux && c.remuxPreference != RemuxPreferenceNone {
+			return results[i].Remux == (c.remuxPreference == RemuxPreferencePrefer)
+		}
+		// Fixed releases replace faulty ones, which often still have more seeders because they're older
+		if isFixedRelease(results[i]) != isFixedRelease(results[j]) {
+			return isFixedRelease(results[i])
+		}
+		if results[i].Seeders != results[j].Seeders {
+			return results[i].Seeders > results[j].Seeders
+		}
+		return c.siteWeight(results[i].Site) > c.siteWeight(results[j].Site)
+	})
+}
+
+// siteWeight returns the trust weight of the torrent site, see WithSiteWeights().
+func (c Client) siteWeight(torrentSite string) float64 {
+	if weight, ok := c.siteWeights[torrentSite]; ok {
+		return weight
+	}
+	return 1
+}
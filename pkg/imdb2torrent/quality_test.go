@@ -0,0 +1,206 @@
+package imdb2torrent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseRemux(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		expected bool
+	}{
+		{"REMUX", "Big.Buck.Bunny.2008.1080p.BluRay.REMUX.AVC.DTS-HD.MA.5.1-GRP", true},
+		{"lower case", "Big Buck Bunny 2008 1080p BluRay remux", true},
+		{"BDRemux", "Big.Buck.Bunny.2008.1080p.BDRemux", true},
+		{"UHD remux", "Big.Buck.Bunny.2008.2160p.UHD.BluRay.UHDRemux.HDR", true},
+		{"magnet URL", "magnet:?xt=urn:btih:" + testInfoHashV1 + "&dn=Big%20Buck%20Bunny%202008%201080p%20BluRay%20REMUX", true},
+		{"encode", "Big.Buck.Bunny.2008.1080p.BluRay.x264-GRP", false},
+		{"web release", "Big.Buck.Bunny.2008.2160p.WEB-DL.DDP5.1.H.265-GRP", false},
+		{"remux in movie title", "The.Remuxer.2008.1080p.BluRay.x264-GRP", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := parseRemux(tt.title); actual != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSortResultsRemuxPreference(t *testing.T) {
+	remux := Result{Title: "Big.Buck.Bunny.2008.1080p.BluRay.REMUX", Quality: "1080p", InfoHash: "1111111111111111111111111111111111111111", Remux: true}
+	encode := Result{Title: "Big.Buck.Bunny.2008.1080p.BluRay.x264", Quality: "1080p", InfoHash: "2222222222222222222222222222222222222222", Seeders: 20}
+	uhdEncode := Result{Title: "Big.Buck.Bunny.2008.2160p.BluRay.x265", Quality: "2160p", InfoHash: "3333333333333333333333333333333333333333", Seeders: 5}
+	tests := []struct {
+		name             string
+		remuxPreference  string
+		remuxSeeders     int
+		expectedOrdering []string
+	}{
+		// The seeders break the tie
+		{"none with fewer seeders", RemuxPreferenceNone, 10, []string{uhdEncode.InfoHash, encode.InfoHash, remux.InfoHash}},
+		{"none with more seeders", RemuxPreferenceNone, 30, []string{uhdEncode.InfoHash, remux.InfoHash, encode.InfoHash}},
+		{"prefer", RemuxPreferencePrefer, 10, []string{uhdEncode.InfoHash, remux.InfoHash, encode.InfoHash}},
+		{"avoid", RemuxPreferenceAvoid, 30, []string{uhdEncode.InfoHash, encode.InfoHash, remux.InfoHash}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, WithRemuxPreference(tt.remuxPreference))
+			// The remux preference only breaks ties of the quality rank
+			remux := remux
+			remux.Seeders = tt.remuxSeeders
+			results := []Result{remux, encode, uhdEncode}
+			client.SortResults(results)
+			var ordering []string
+			for _, result := range results {
+				ordering = append(ordering, result.InfoHash)
+			}
+			if strings.Join(ordering, ",") != strings.Join(tt.expectedOrdering, ",") {
+				t.Errorf("Expected order %v, got %v", tt.expectedOrdering, ordering)
+			}
+		})
+	}
+}
+
+func TestRemuxPreferenceValidation(t *testing.T) {
+	_, err := NewClient(context.Background(), WithTorrentCache(newTestCache(), 0, 0), WithCinemataCache(newTestCache(), 0), WithRemuxPreference("always"))
+	if err == nil {
+		t.Error("Expected an error for an unknown remux preference")
+	}
+}
+
+func TestParseProperRepack(t *testing.T) {
+	tests := []struct {
+		name           string
+		title          string
+		expectedProper bool
+		expectedRepack bool
+	}{
+		{"neither", "Big.Buck.Bunny.2008.1080p.BluRay.x264-GRP", false, false},
+		{"PROPER", "Big.Buck.Bunny.2008.1080p.PROPER.BluRay.x264-GRP", true, false},
+		{"REPACK", "Big.Buck.Bunny.2008.1080p.REPACK.BluRay.x264-GRP", false, true},
+		{"numbered REPACK", "Big.Buck.Bunny.2008.1080p.REPACK2.BluRay.x264-GRP", false, true},
+		{"both", "Big.Buck.Bunny.2008.1080p.PROPER.REPACK.BluRay.x264-GRP", true, true},
+		{"lower case with spaces", "Big Buck Bunny 2008 1080p proper repack BluRay", true, true},
+		{"magnet URL", "magnet:?xt=urn:btih:" + testInfoHashV1 + "&dn=Big%20Buck%20Bunny%202008%201080p%20PROPER%20BluRay", true, false},
+		{"part of a word", "Improper.Repackaged.Bunny.2008.1080p.BluRay.x264-GRP", false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := parseProper(tt.title); actual != tt.expectedProper {
+				t.Errorf("Expected PROPER %v, got %v", tt.expectedProper, actual)
+			}
+			if actual := parseRepack(tt.title); actual != tt.expectedRepack {
+				t.Errorf("Expected REPACK %v, got %v", tt.expectedRepack, actual)
+			}
+		})
+	}
+}
+
+func TestSortResultsFixedReleases(t *testing.T) {
+	faulty := Result{Quality: "1080p", InfoHash: "1111111111111111111111111111111111111111", Seeders: 50}
+	proper := Result{Quality: "1080p", InfoHash: "2222222222222222222222222222222222222222", Proper: true, Seeders: 10}
+	repack := Result{Quality: "1080p", InfoHash: "3333333333333333333333333333333333333333", Repack: true, Seeders: 20}
+	both := Result{Quality: "1080p", InfoHash: "4444444444444444444444444444444444444444", Proper: true, Repack: true, Seeders: 5}
+	uhdFaulty := Result{Quality: "2160p", InfoHash: "5555555555555555555555555555555555555555", Seeders: 1}
+	tests := []struct {
+		name             string
+		results          []Result
+		expectedOrdering []string
+	}{
+		{"no fixed releases", []Result{faulty, uhdFaulty}, []string{uhdFaulty.InfoHash, faulty.InfoHash}},
+		{"PROPER before more seeders", []Result{faulty, proper}, []string{proper.InfoHash, faulty.InfoHash}},
+		{"REPACK before more seeders", []Result{faulty, repack}, []string{repack.InfoHash, faulty.InfoHash}},
+		// Both markers don't rank higher than one, so the seeders decide
+		{"fixed releases by seeders", []Result{both, proper, repack, faulty}, []string{repack.InfoHash, proper.InfoHash, both.InfoHash, faulty.InfoHash}},
+		// The quality rank comes first
+		{"quality before fixed release", []Result{proper, uhdFaulty}, []string{uhdFaulty.InfoHash, proper.InfoHash}},
+	}
+	client := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := append([]Result(nil), tt.results...)
+			client.SortResults(results)
+			var ordering []string
+			for _, result := range results {
+				ordering = append(ordering, result.InfoHash)
+			}
+			if strings.Join(ordering, ",") != strings.Join(tt.expectedOrdering, ",") {
+				t.Errorf("Expected order %v, got %v", tt.expectedOrdering, ordering)
+			}
+		})
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"Big.Buck.Bunny.2008.1080p.WEB-DL.DDP5.1.H.264-GRP", SourceWebDL},
+		{"Big.Buck.Bunny.2008.1080p.WEBDL.x264-GRP", SourceWebDL},
+		{"Big Buck Bunny 2008 1080p WEB DL", SourceWebDL},
+		{"Big.Buck.Bunny.2008.1080p.AMZN.WEB-DL.DDP5.1-GRP", SourceWebDL},
+		{"Big.Buck.Bunny.2008.1080p.WEBRip.x264-GRP", SourceWebRip},
+		{"Big.Buck.Bunny.2008.1080p.WEB-Rip.x264-GRP", SourceWebRip},
+		{"Big.Buck.Bunny.2008.1080p.WEB.H264-GRP", SourceWeb},
+		{"Big.Buck.Bunny.2008.1080p.BluRay.x264-GRP", SourceBluRay},
+		{"Big.Buck.Bunny.2008.1080p.Blu-Ray.x264-GRP", SourceBluRay},
+		{"Big.Buck.Bunny.2008.1080p.BDRemux.AVC-GRP", SourceBluRay},
+		{"Big.Buck.Bunny.2008.1080p.BDMV-GRP", SourceBluRay},
+		{"Big.Buck.Bunny.2008.1080p.BDRip.x264-GRP", SourceBDRip},
+		{"Big.Buck.Bunny.2008.1080p.BRRip.x264-GRP", SourceBDRip},
+		{"Big.Buck.Bunny.2008.720p.HDTV.x264-GRP", SourceHDTV},
+		{"Big.Buck.Bunny.2008.720p.HDTVRip.x264-GRP", SourceHDTV},
+		// The tags after the movie title win
+		{"Web.of.Bunnies.2008.1080p.BluRay.x264-GRP", SourceBluRay},
+		{"magnet:?xt=urn:btih:" + testInfoHashV1 + "&dn=Big%20Buck%20Bunny%202008%201080p%20WEBRip", SourceWebRip},
+		{"Big.Buck.Bunny.2008.1080p.x264-GRP", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			if actual := parseSource(tt.title); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseReleaseType(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		expected string
+	}{
+		{"cam", "Big.Buck.Bunny.2008.HDCAM.x264-GRP", "cam"},
+		{"telesync", "Big Buck Bunny 2008 720p HDTS", "telesync"},
+		{"telecine", "Big.Buck.Bunny.2008.TC.XviD-GRP", "telecine"},
+		{"workprint", "Big.Buck.Bunny.2008.WORKPRINT-GRP", "workprint"},
+		{"screener", "Big.Buck.Bunny.2008.DVDSCR.x264-GRP", "screener"},
+		{"magnet", "magnet:?xt=urn:btih:" + testInfoHashV1 + "&dn=Big%20Buck%20Bunny%202008%20CAMRip", "cam"},
+		{"no release type", "Big.Buck.Bunny.2008.1080p.BluRay.x264-GRP", ""},
+		// Words of the movie title aren't release tags
+		{"movie named cam", "Cam.2018.1080p.WEB-DL.DDP5.1.H.264-GRP", ""},
+		{"movie title containing TS", "The.TS.Bunny.2008.1080p.BluRay.x264-GRP", ""},
+		{"movie title containing TC and WP", "TC.and.WP.2008.720p.WEBRip", ""},
+		// Without year or resolution the tags can't be told apart from the title
+		{"no year or resolution", "Big Buck Bunny CAM", ""},
+		// Only the display name of a magnet is checked, not for example the trackers
+		{"magnet trackers", "magnet:?xt=urn:btih:" + testInfoHashV1 + "&dn=Big.Buck.Bunny.2008.1080p.BluRay&tr=udp%3A%2F%2Fts.example%3A80&tr=udp%3A%2F%2Fcam.example%2Fannounce", ""},
+		{"magnet without display name", "magnet:?xt=urn:btih:" + testInfoHashV1 + "&tr=udp%3A%2F%2Fcam.example%3A80", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := parseReleaseType(tt.title); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package imdb2torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrNoActiveSites is returned by searches when all torrent sites are disabled, because their base URLs are empty.
+// Without it such a misconfiguration would look like there are just no torrents for any movie.
+var ErrNoActiveSites = errors.New("No active torrent site, all base URLs are empty")
+
+// ErrNoTorrentsFound is returned by FindMagnetsOrNotFound() when the search succeeded, but no torrents were found, or none were left after filtering.
+// Unlike other errors it means that there are no torrents for the movie yet, not that the search failed.
+var ErrNoTorrentsFound = errors.New("No torrents found")
+
+// Capabilities describe how a torrent site can be searched.
+type Capabilities struct {
+	// The site can be searched by IMDb ID, so its results are certain to be for the requested movie
+	IMDbID bool `json:"imdbID"`
+	// The site can be searched by title, which FindMagnetsByTitle() does
+	Title bool `json:"title"`
+	// Searches by IMDb ID require Cinemata to resolve the IMDb ID into the movie title, and their results are marked as guessed match
+	NeedsCinemata bool `json:"needsCinemata"`
+	// The site can be searched for episodes of TV series
+	Series bool `json:"series"`
+}
+
+// siteCapabilities are the capabilities of the torrent sites, keyed by the same site names as GetMagnetSearchers().
+// None of the sites is searched for TV series yet.
+var siteCapabilities = map[string]Capabilities{
+	"YTS":           {IMDbID: true, Title: true},
+	"TPB":           {IMDbID: true},
+	"1337x":         {Title: true, NeedsCinemata: true},
+	"ibit":          {IMDbID: true},
+	"SolidTorrents": {Title: true, NeedsCinemata: true},
+}
+
+// siteOrder is the order in which the results of the torrent sites are combined, which decides which duplicate is kept.
+var siteOrder = []string{"YTS", "TPB", "1337x", "SolidTorrents", "ibit"}
+
+// emptyRetrySites are the torrent sites that mark responses that look like a results page but don't lead to any torrents, see emptyPageMarker.
+// The other sites either have APIs (YTS and SolidTorrents) or, like TPB, render empty results pages that can't be told apart from a movie without torrents.
+var emptyRetrySites = map[string]struct{}{
+	"1337x": {},
+	"ibit":  {},
+}
+
+// SearcherCapabilities returns the capabilities of the torrent sites, keyed by the same site names as GetMagnetSearchers().
+// For example sites that need Cinemata can't be searched when Cinemata is down, and only sites with the title capability are used by FindMagnetsByTitle().
+func (c Client) SearcherCapabilities() map[string]Capabilities {
+	result := make(map[string]Capabilities, len(siteCapabilities))
+	for torrentSite, capabilities := range siteCapabilities {
+		result[torrentSite] = capabilities
+	}
+	return result
+}
+
+// siteMirrors returns the mirror lists of the torrent sites, keyed by the same site names as GetMagnetSearchers().
+func (c Client) siteMirrors() map[string]*mirrorList {
+	return map[string]*mirrorList{
+		"YTS":           c.ytsClient.mirrors,
+		"TPB":           c.tpbClient.mirrors,
+		"1337x":         c.leetxClient.mirrors,
+		"ibit":          c.ibitClient.mirrors,
+		"SolidTorrents": c.solidTorrentsClient.mirrors,
+	}
+}
+
+// siteDisabled returns true if the torrent site doesn't have a base URL, see WithBaseURL(). Pseudo sites like the curated results are never disabled.
+func (c Client) siteDisabled(torrentSite string) bool {
+	mirrors, ok := c.siteMirrors()[torrentSite]
+	return ok && len(mirrors.baseURLs) == 0
+}
+
+// ActiveSites returns the names of the torrent sites that are searched, which are the ones with a base URL, in alphabetical order.
+// A site is disabled by setting its base URL to an empty string via WithBaseURL().
+func (c Client) ActiveSites() []string {
+	var sites []string
+	for torrentSite, mirrors := range c.siteMirrors() {
+		if len(mirrors.baseURLs) > 0 {
+			sites = append(sites, torrentSite)
+		}
+	}
+	sort.Strings(sites)
+	return sites
+}
+
+// CheckSites sends a request to the base URL of each active torrent site with the site's own HTTP client and rate limiter, like its searches, and returns an error if none of them can be reached, so that a misconfiguration can be detected on startup.
+// Any HTTP response counts as reachable, because some sites respond to their root path with an error status or a Cloudflare challenge. Sites with mirrors are reachable if any mirror is.
+// It returns ErrNoActiveSites if all torrent sites are disabled.
+func (c Client) CheckSites(ctx context.Context) error {
+	activeSites := c.ActiveSites()
+	if len(activeSites) == 0 {
+		return ErrNoActiveSites
+	}
+
+	mirrorsBySite := c.siteMirrors()
+	errs := make([]error, len(activeSites))
+	wg := sync.WaitGroup{}
+	wg.Add(len(activeSites))
+	for i, torrentSite := range activeSites {
+		go func(i int, torrentSite string) {
+			defer wg.Done()
+			res, err := c.getSiteRoot(ctx, torrentSite, mirrorsBySite[torrentSite])
+			if err != nil {
+				errs[i] = fmt.Errorf("%v: %v", torrentSite, err)
+				return
+			}
+			res.Body.Close()
+		}(i, torrentSite)
+	}
+	wg.Wait()
+
+	var errMsgs []string
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+		errMsgs = append(errMsgs, err.Error())
+	}
+	return fmt.Errorf("Couldn't reach any torrent site: %v", strings.Join(errMsgs, "; "))
+}
+
+// getSiteRoot requests the root path of the torrent site with the HTTP client and rate limiter that the site's searcher uses, so that for example TPB is requested via its SOCKS5 proxy.
+func (c Client) getSiteRoot(ctx context.Context, torrentSite string, mirrors *mirrorList) (*http.Response, error) {
+	var httpClient *http.Client
+	var limiter *rate.Limiter
+	switch torrentSite {
+	case "YTS":
+		httpClient, limiter = c.ytsClient.httpClient, c.ytsClient.limiter
+	case "TPB":
+		httpClient, limiter = c.tpbClient.httpClient, c.tpbClient.limiter
+	case "1337x":
+		httpClient, limiter = c.leetxClient.httpClient, c.leetxClient.limiter
+	case "ibit":
+		// Like ibit's searches, wait for the limiter of the mirror that worked last
+		httpClient, limiter = c.ibitClient.httpClient, c.ibitClient.limiters[mirrors.currentIndex()]
+	case "SolidTorrents":
+		httpClient, limiter = c.solidTorrentsClient.httpClient, c.solidTorrentsClient.limiter
+	default:
+		return nil, fmt.Errorf("Unknown torrent site: %v", torrentSite)
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+	}
+	return mirrors.get(ctx, httpClient, "/")
+}
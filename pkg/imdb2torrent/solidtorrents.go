@@ -0,0 +1,179 @@
+package imdb2torrent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"golang.org/x/time/rate"
+
+	"github.com/doingodswork/deflix-stremio/pkg/cinemata"
+)
+
+var _ MagnetSearcher = (*solidTorrentsClient)(nil)
+
+// solidTorrentsClient searches the JSON API of Solid Torrents, which indexes torrent metadata from the DHT.
+// Its results contain the info_hash, seeders and size, so no HTML has to be scraped.
+type solidTorrentsClient struct {
+	mirrors        *mirrorList
+	httpClient     *http.Client
+	cache          *fastcache.Cache
+	cinemataClient cinemata.Client
+	cacheAge       time.Duration
+	cacheAgeJitter time.Duration
+	// Returns the current time for cache expiry. Only replaced in tests.
+	now func() time.Time
+	// Gzip cache entries
+	compressCache bool
+	// One of the TitleMatching... constants
+	titleMatching string
+	limiter       *rate.Limiter
+	// Include the movies of all years with a matching title instead of only the requested year
+	allYears bool
+}
+
+func newSolidTorrentsClient(ctx context.Context, baseURL string, timeout time.Duration, cache *fastcache.Cache, cinemataClient cinemata.Client, cacheAge, cacheAgeJitter time.Duration, now func() time.Time, compressCache bool, titleMatching string, rateLimit float64, allYears bool) solidTorrentsClient {
+	return solidTorrentsClient{
+		mirrors: newMirrorList(baseURL),
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		cache:          cache,
+		cinemataClient: cinemataClient,
+		cacheAge:       cacheAge,
+		cacheAgeJitter: cacheAgeJitter,
+		now:            now,
+		compressCache:  compressCache,
+		titleMatching:  titleMatching,
+		limiter:        newRateLimiter(rateLimit),
+		allYears:       allYears,
+	}
+}
+
+// Check searches Solid Torrents for torrents for the given IMDb ID.
+// Solid Torrents can only be searched by title, so it uses the Stremio Cinemata remote addon to get a movie name for the IMDb ID.
+// If no error occured, but there are just no torrents for the movie yet, an empty result and *no* error are returned.
+func (c solidTorrentsClient) Check(ctx context.Context, imdbID string) ([]Result, error) {
+	logFields := log.Fields{
+		"imdbID":      imdbID,
+		"torrentSite": "SolidTorrents",
+	}
+	logger := log.WithContext(ctx).WithFields(logFields)
+
+	// Check cache first
+	cacheKey := imdbID + "-SolidTorrents"
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
+	}
+
+	// Get movie name
+	movieName, movieYear, err := c.cinemataClient.GetMovieNameYear(ctx, imdbID)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get movie name via Cinemata for IMDb ID %v: %v", imdbID, err)
+	}
+
+	return c.check(ctx, logger, movieName, movieYear, cacheKey)
+}
+
+// checkTitle searches Solid Torrents for torrents for the given movie title and year (0 if unknown).
+// If no error occured, but there are just no torrents for the movie yet, an empty result and *no* error are returned.
+func (c solidTorrentsClient) checkTitle(ctx context.Context, title string, year int) ([]Result, error) {
+	logFields := log.Fields{
+		"title":       title,
+		"year":        year,
+		"torrentSite": "SolidTorrents",
+	}
+	logger := log.WithContext(ctx).WithFields(logFields)
+
+	// Check cache first
+	cacheKey := titleCacheKey(title, year) + "-SolidTorrents"
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
+	}
+
+	return c.check(ctx, logger, title, year, cacheKey)
+}
+
+// check searches Solid Torrents with the movie name and year and fills the cache with the results.
+// If the client is configured to include all years, the year isn't part of the search, so the results can belong to movies with the same title from other years.
+func (c solidTorrentsClient) check(ctx context.Context, logger *log.Entry, movieName string, movieYear int, cacheKey string) ([]Result, error) {
+	movieSearch := movieName
+	if movieYear != 0 && !c.allYears {
+		movieSearch += " " + strconv.Itoa(movieYear)
+	}
+	reqPath := "/api/v1/search?category=Video&sort=seeders&q=" + url.QueryEscape(movieSearch)
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+	}
+	res, err := c.mirrors.get(ctx, c.httpClient, reqPath)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't GET %v: %v", reqPath, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	}
+	resBody, err := readBody(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read response body: %v", err)
+	}
+
+	// Extract data from JSON
+	var results []Result
+	for _, torrent := range gjson.GetBytes(resBody, "results").Array() {
+		title := torrent.Get("title").String()
+		if !titleMatches(title, movieName, movieYear, c.titleMatching) {
+			continue
+		}
+		quality, ok := parseQualityOrUnknown(title)
+		if !ok {
+			continue
+		}
+
+		infoHash := strings.ToUpper(torrent.Get("infohash").String())
+		if infoHash == "" {
+			logger.WithField("torrentJSON", torrent.String()).Warn("Couldn't get info_hash from torrent JSON")
+			continue
+		}
+		magnet := torrent.Get("magnet").String()
+		if !strings.HasPrefix(magnet, "magnet:") {
+			magnet = "magnet:?xt=urn:btih:" + infoHash + "&dn=" + url.QueryEscape(title)
+		}
+
+		_, year := releaseTitleYear(title)
+		result := Result{
+			Title:   title,
+			Quality: quality,
+			// Like with 1337x the search is by title, so we cannot be 100% sure it's the correct movie.
+			GuessedMatch: true,
+			Year:         year,
+			Source:       parseSource(title),
+			ReleaseType:  parseReleaseType(title),
+			Remux:        parseRemux(title),
+			Proper:       parseProper(title),
+			Repack:       parseRepack(title),
+			InfoHash:     infoHash,
+			MagnetURL:    magnet,
+			Trackers:     magnetTrackers(magnet),
+			Group:        parseReleaseGroup(title),
+			BitDepth:     parseBitDepth(title),
+			Size:         torrent.Get("size").Int(),
+			Seeders:      int(torrent.Get("swarm.seeders").Int()),
+		}
+		logger.WithFields(log.Fields{"title": title, "quality": quality, "infoHash": infoHash, "magnet": magnet}).Trace("Found torrent")
+		results = append(results, result)
+	}
+
+	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
+	// Any actual errors would have returned earlier.
+	setCachedResults(ctx, c.cache, cacheKey, results, c.now, c.compressCache, logger)
+
+	return results, nil
+}
@@ -0,0 +1,35 @@
+package imdb2torrent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/doingodswork/deflix-stremio/pkg/cinemata"
+)
+
+func newTestSolidTorrentsClient(baseURL string) solidTorrentsClient {
+	return newSolidTorrentsClient(context.Background(), baseURL, time.Second, newTestCache(), cinemata.Client{}, time.Hour, 0, testNow, false, TitleMatchingNormalized, 0, false)
+}
+
+func TestSolidTorrentsCheckTitle(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handleFile(t, "/api/v1/search?category=Video&sort=seeders&q=Big+Buck+Bunny+2008", "solidtorrents_search.json")
+	client := newTestSolidTorrentsClient(server.URL)
+
+	results, err := client.checkTitle(context.Background(), "Big Buck Bunny", 2008)
+	if err != nil {
+		t.Fatalf("checkTitle() returned an error: %v", err)
+	}
+	// The sequel doesn't match the title
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v: %+v", len(results), results)
+	}
+	if results[0].InfoHash != "0000000000000000000000000000000000000002" || results[0].Quality != "1080p" || results[0].Seeders != 42 {
+		t.Errorf("Unexpected first result: %+v", results[0])
+	}
+	// Without magnet URL in the JSON one is created from the info hash
+	if results[1].MagnetURL == "" || results[1].Source != SourceWebRip {
+		t.Errorf("Unexpected second result: %+v", results[1])
+	}
+}
@@ -0,0 +1,79 @@
+package imdb2torrent
+
+import (
+	"sort"
+)
+
+// Comparators for FindMagnetsWith(). Each one returns true if a should be sorted before b.
+// They can be combined with SortedBy(), for example SortedBy(ByQuality, BySeeders).
+
+// BySeeders sorts results with more seeders first.
+func BySeeders(a, b Result) bool {
+	return a.Seeders > b.Seeders
+}
+
+// ByQuality sorts results with a higher quality first, for example "2160p" before "1080p 10bit" before "1080p".
+// To sort by the client's quality preference order instead, use Client.ByQualityPreference().
+func ByQuality(a, b Result) bool {
+	return qualityIndex(a.Quality) > qualityIndex(b.Quality)
+}
+
+// BySize sorts smaller results first. Results with unknown size are sorted last.
+func BySize(a, b Result) bool {
+	if a.Size == 0 || b.Size == 0 {
+		return a.Size != 0 && b.Size == 0
+	}
+	return a.Size < b.Size
+}
+
+// ByEstimatedBitrate sorts results with a higher estimated bitrate first. Results with unknown bitrate are sorted last. See WithEstimatedBitrate().
+func ByEstimatedBitrate(a, b Result) bool {
+	return a.EstimatedBitrate > b.EstimatedBitrate
+}
+
+// ByFixedRelease sorts PROPER and REPACK releases first, because they fix faulty releases.
+func ByFixedRelease(a, b Result) bool {
+	return isFixedRelease(a) && !isFixedRelease(b)
+}
+
+// ByQualityPreference sorts results by the client's quality preference order, see QualityRank().
+func (c Client) ByQualityPreference(a, b Result) bool {
+	return c.QualityRank(a.Quality) < c.QualityRank(b.Quality)
+}
+
+// BySiteWeight sorts results from torrent sites with a higher trust weight first, see WithSiteWeights().
+func (c Client) BySiteWeight(a, b Result) bool {
+	return c.siteWeight(a.Site) > c.siteWeight(b.Site)
+}
+
+// SortedBy combines the comparators into one: Results are sorted by the first comparator, results that are equal for it by the second one, and so on.
+func SortedBy(comparators ...func(a, b Result) bool) func(a, b Result) bool {
+	return func(a, b Result) bool {
+		for _, less := range comparators {
+			if less(a, b) {
+				return true
+			}
+			if less(b, a) {
+				return false
+			}
+		}
+		return false
+	}
+}
+
+// qualityIndex returns the position of the quality in supportedQualities, which are sorted from lowest to highest, or -1 for unsupported qualities.
+func qualityIndex(quality string) int {
+	for i, supportedQuality := range supportedQualities {
+		if supportedQuality == quality {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortResults sorts the results in place by the comparator, keeping the order of equal results.
+func sortResults(results []Result, less func(a, b Result) bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return less(results[i], results[j])
+	})
+}
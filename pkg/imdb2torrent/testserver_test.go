@@ -0,0 +1,107 @@
+package imdb2torrent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+)
+
+// fixtureServer is an HTTP server for testing the torrent site clients without network access.
+// It responds with the fixtures that the test registered for the requested paths and records all requests.
+// Requests for paths without fixture get a 404 response.
+type fixtureServer struct {
+	*httptest.Server
+	lock     *sync.Mutex
+	fixtures map[string]fixture
+	requests []string
+}
+
+type fixture struct {
+	status int
+	body   []byte
+}
+
+// newFixtureServer starts a fixture server that's closed when the test is finished.
+func newFixtureServer(t *testing.T) *fixtureServer {
+	t.Helper()
+	s := &fixtureServer{
+		lock:     &sync.Mutex{},
+		fixtures: map[string]fixture{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// handle registers the body for the path, which can contain a query string.
+// A request's path with query is looked up first, then its path alone.
+func (s *fixtureServer) handle(path string, body string) {
+	s.handleStatus(path, http.StatusOK, body)
+}
+
+// handleStatus registers the status code and body for the path, see handle().
+func (s *fixtureServer) handleStatus(path string, status int, body string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fixtures[path] = fixture{status: status, body: []byte(body)}
+}
+
+// handleFile registers the content of the file in the testdata directory as body for the path, see handle().
+func (s *fixtureServer) handleFile(t *testing.T, path string, fileName string) {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("testdata", fileName))
+	if err != nil {
+		t.Fatalf("Couldn't read fixture: %v", err)
+	}
+	s.handle(path, string(body))
+}
+
+// requested returns the paths with query of all requests so far, in the order in which they were received.
+func (s *fixtureServer) requested() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// requestCount returns how often the path with query was requested.
+func (s *fixtureServer) requestCount(path string) int {
+	count := 0
+	for _, requested := range s.requested() {
+		if requested == path {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *fixtureServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+	f, ok := s.fixtures[r.URL.RequestURI()]
+	if !ok {
+		f, ok = s.fixtures[r.URL.Path]
+	}
+	s.lock.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(f.status)
+	_, _ = w.Write(f.body)
+}
+
+// newTestCache returns a new cache for a single test. fastcache uses at least 32 MB, no matter which size is requested.
+func newTestCache() *fastcache.Cache {
+	return fastcache.New(32 * 1024 * 1024)
+}
+
+// testNow is a fixed time for clients in tests, so that cache entries never expire during a test.
+func testNow() time.Time {
+	return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,107 @@
+package imdb2torrent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Title matching strictness for torrent sites that are searched by movie title.
+// The torrent title is only compared up to the year or resolution, because the rest is about the release.
+const (
+	// The title must be the same as the movie title, only the separators between words (like "." and "_") can differ
+	TitleMatchingExact = "exact"
+	// The title must consist of the same words as the movie title, ignoring case and punctuation
+	TitleMatchingNormalized = "normalized"
+	// The title must contain the words of the movie title, ignoring case and punctuation
+	TitleMatchingContains = "contains"
+)
+
+// checkTitleMatching returns an error if the title matching strictness isn't one of the TitleMatching... constants.
+func checkTitleMatching(titleMatching string) error {
+	switch titleMatching {
+	case TitleMatchingExact, TitleMatchingNormalized, TitleMatchingContains:
+		return nil
+	default:
+		return fmt.Errorf("Unknown title matching strictness: %v", titleMatching)
+	}
+}
+
+// titleMatches returns true if the torrent title, for example "It.2017.1080p.BluRay.x264-SPARKS", belongs to the movie with the given title and year (0 if unknown).
+// Short titles like "It" or "Up" are contained in many torrent titles, so only TitleMatchingContains allows additional words.
+func titleMatches(torrentTitle, movieTitle string, movieYear int, titleMatching string) bool {
+	torrentTitle = torrentTitlePart(torrentTitle, movieYear)
+	switch titleMatching {
+	case TitleMatchingExact:
+		separatorReplacer := strings.NewReplacer(".", " ", "_", " ")
+		return strings.Join(strings.Fields(separatorReplacer.Replace(torrentTitle)), " ") == strings.Join(strings.Fields(movieTitle), " ")
+	case TitleMatchingContains:
+		return strings.Contains(" "+normalizeTitle(torrentTitle)+" ", " "+normalizeTitle(movieTitle)+" ")
+	default:
+		return normalizeTitle(torrentTitle) == normalizeTitle(movieTitle)
+	}
+}
+
+// torrentTitlePart returns the part of the torrent title before the year or resolution, which is usually the movie title.
+func torrentTitlePart(torrentTitle string, movieYear int) string {
+	end := len(torrentTitle)
+	i := 0
+	for _, word := range strings.FieldsFunc(torrentTitle, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		// Can't be the first word, because some movies are named after a year, like "1917"
+		i = strings.Index(torrentTitle[i:], word) + i
+		if i > 0 && isYearOrResolution(word, movieYear) {
+			end = i
+			break
+		}
+		i += len(word)
+	}
+	return strings.Trim(torrentTitle[:end], " .-_([")
+}
+
+// releaseTitleYear returns the movie title and year of a torrent title, for example "It" and 2017 for "It.2017.1080p.BluRay.x264-SPARKS".
+// The year is 0 if the torrent title doesn't contain one before the resolution.
+func releaseTitleYear(torrentTitle string) (string, int) {
+	titlePart := torrentTitlePart(torrentTitle, 0)
+	separatorReplacer := strings.NewReplacer(".", " ", "_", " ")
+	title := strings.Join(strings.Fields(separatorReplacer.Replace(titlePart)), " ")
+
+	rest := torrentTitle[strings.Index(torrentTitle, titlePart)+len(titlePart):]
+	for _, word := range strings.FieldsFunc(rest, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if !isYearOrResolution(word, 0) {
+			continue
+		}
+		// The first match is the year if there is one, otherwise the resolution
+		year, err := strconv.Atoi(word)
+		if err != nil {
+			return title, 0
+		}
+		return title, year
+	}
+	return title, 0
+}
+
+// isYearOrResolution returns true for words like "2017" or "1080p".
+func isYearOrResolution(word string, movieYear int) bool {
+	if movieYear != 0 && word == strconv.Itoa(movieYear) {
+		return true
+	}
+	if year, err := strconv.Atoi(word); err == nil && len(word) == 4 && year >= 1900 && year <= 2100 {
+		return true
+	}
+	switch strings.ToLower(word) {
+	case "480p", "720p", "1080p", "2160p":
+		return true
+	}
+	return false
+}
+
+// normalizeTitle returns the lower case words of the title, separated by a single space.
+// Apostrophes are removed, because torrent titles usually don't contain them, like "Oceans.Eleven" for "Ocean's Eleven".
+func normalizeTitle(title string) string {
+	title = strings.NewReplacer("'", "", "’", "").Replace(title)
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
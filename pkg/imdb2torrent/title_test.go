@@ -0,0 +1,42 @@
+package imdb2torrent
+
+import (
+	"testing"
+)
+
+func TestTitleMatches(t *testing.T) {
+	tests := []struct {
+		torrentTitle  string
+		movieTitle    string
+		movieYear     int
+		titleMatching string
+		expected      bool
+	}{
+		// Short titles are contained in many other titles
+		{"It.2017.1080p.BluRay.x264-SPARKS", "It", 2017, TitleMatchingNormalized, true},
+		{"It.Follows.2014.1080p.BluRay.x264-SPARKS", "It", 2017, TitleMatchingNormalized, false},
+		{"It.Follows.2014.1080p.BluRay.x264-SPARKS", "It", 2017, TitleMatchingContains, true},
+		{"Up.2009.720p.BluRay.x264", "Up", 2009, TitleMatchingNormalized, true},
+		{"Up.in.the.Air.2009.720p.BluRay.x264", "Up", 2009, TitleMatchingNormalized, false},
+		{"Up.in.the.Air.2009.720p.BluRay.x264", "Up", 2009, TitleMatchingContains, true},
+		{"Her (2013) [1080p] [YTS.AG]", "Her", 2013, TitleMatchingNormalized, true},
+		{"Her Smell (2018) [1080p] [YTS.AG]", "Her", 2013, TitleMatchingNormalized, false},
+		{"Whats.Up.Her.Way.2013.1080p.WEB", "Her", 2013, TitleMatchingContains, true},
+		// Case and punctuation
+		{"HER.2013.1080p.BluRay", "Her", 2013, TitleMatchingNormalized, true},
+		{"HER.2013.1080p.BluRay", "Her", 2013, TitleMatchingExact, false},
+		{"Her.2013.1080p.BluRay", "Her", 2013, TitleMatchingExact, true},
+		// Movie titles without year or resolution, like the ones of YTS' API
+		{"It", "It", 2017, TitleMatchingNormalized, true},
+		{"It Follows", "It", 2017, TitleMatchingNormalized, false},
+		// Movies named after a year
+		{"1917.2019.1080p.BluRay.x264", "1917", 2019, TitleMatchingNormalized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.titleMatching+"/"+tt.torrentTitle, func(t *testing.T) {
+			if actual := titleMatches(tt.torrentTitle, tt.movieTitle, tt.movieYear, tt.titleMatching); actual != tt.expected {
+				t.Errorf("Expected %v for movie %q (%v), got %v", tt.expected, tt.movieTitle, tt.movieYear, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package imdb2torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Typical .torrent files are a few hundred KB big, only torrents with a huge number of files are bigger
+const maxTorrentFileSize = 10 * 1024 * 1024
+
+// Torrent files only nest a few levels deep (the info dictionary's file list with its path lists), so deeper nesting is a broken or malicious file.
+// Without a limit a file of nothing but list starts would make the recursive decoder overflow the stack.
+const maxBencodeDepth = 64
+
+// TorrentFileToMagnet fetches the .torrent file from the given URL and turns it into a Result with a magnet URL.
+// The info_hash is calculated from the file's info dictionary and the file's announce list is used as the magnet URL's trackers.
+// Like with FindMagnets() the trackers of WithRequestTrackers() are added and the ones that aren't allowed by WithAllowedTrackerHosts() are removed.
+// Like for the torrent sites the Result's quality is parsed from the torrent's name, with QualityUnknown if the name doesn't contain it.
+// The request counts towards the scrape budget, and if the URL belongs to one of the torrent sites, towards the site's rate limit as well.
+func (c Client) TorrentFileToMagnet(ctx context.Context, torrentURL string) (Result, error) {
+	logger := log.WithContext(ctx).WithField("torrentURL", torrentURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", torrentURL, nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("Couldn't create request: %v", err)
+	}
+	if limiter := c.siteLimiter(req.URL); limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return Result{}, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+		}
+	}
+	if err := c.budget.wait(ctx); err != nil {
+		return Result{}, fmt.Errorf("Couldn't wait for scrape budget: %v", err)
+	}
+	recordRequestURL(ctx, torrentURL)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("Couldn't GET %v: %v", torrentURL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	}
+	// Read one byte more than allowed to detect files that are too big
+	torrentFile, err := ioutil.ReadAll(io.LimitReader(res.Body, maxTorrentFileSize+1))
+	if err != nil {
+		return Result{}, fmt.Errorf("Couldn't read response body: %v", err)
+	}
+	if len(torrentFile) > maxTorrentFileSize {
+		return Result{}, fmt.Errorf("Torrent file is bigger than %vMB", maxTorrentFileSize/1024/1024)
+	}
+
+	result, err := parseTorrentFile(torrentFile)
+	if err != nil {
+		return Result{}, fmt.Errorf("Couldn't parse torrent file: %v", err)
+	}
+	results := []Result{result}
+	c.applyTrackers(ctx, results)
+	result = results[0]
+	logger.WithFields(log.Fields{"title": result.Title, "infoHash": result.InfoHash, "magnet": result.MagnetURL}).Trace("Converted torrent file")
+	return result, nil
+}
+
+// siteLimiter returns the rate limiter of the torrent site whose base URL has the same host as the URL, or nil if the URL doesn't belong to any torrent site.
+func (c Client) siteLimiter(reqURL *url.URL) *rate.Limiter {
+	limiters := map[string][]*rate.Limiter{
+		"YTS":           {c.ytsClient.limiter},
+		"TPB":           {c.tpbClient.limiter},
+		"1337x":         {c.leetxClient.limiter},
+		"ibit":          c.ibitClient.limiters,
+		"SolidTorrents": {c.solidTorrentsClient.limiter},
+	}
+	for torrentSite, mirrors := range c.siteMirrors() {
+		for i, baseURL := range mirrors.baseURLs {
+			parsed, err := url.Parse(baseURL)
+			if err != nil || !strings.EqualFold(parsed.Host, reqURL.Host) {
+				continue
+			}
+			siteLimiters := limiters[torrentSite]
+			// ibit has a limiter per mirror, the other sites one for all mirrors
+			if i < len(siteLimiters) {
+				return siteLimiters[i]
+			}
+			return siteLimiters[0]
+		}
+	}
+	return nil
+}
+
+// parseTorrentFile decodes the bencoded torrent file and creates a Result from its info_hash, name and trackers.
+func parseTorrentFile(torrentFile []byte) (Result, error) {
+	d := bencodeDecoder{data: torrentFile}
+	if len(torrentFile) == 0 || torrentFile[0] != 'd' {
+		return Result{}, errors.New("Torrent file doesn't start with a dictionary")
+	}
+	d.pos++
+
+	var infoHash, title string
+	var trackers []string
+	for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+		key, err := d.decodeString()
+		if err != nil {
+			return Result{}, err
+		}
+		valueStart := d.pos
+		value, err := d.decode()
+		if err != nil {
+			return Result{}, err
+		}
+		switch key {
+		case "info":
+			info, ok := value.(map[string]interface{})
+			if !ok {
+				return Result{}, errors.New("\"info\" isn't a dictionary")
+			}
+			// The info_hash is the SHA-1 hash of the bencoded info dictionary, exactly as it's contained in the file
+			hash := sha1.Sum(d.data[valueStart:d.pos])
+			infoHash = strings.ToUpper(hex.EncodeToString(hash[:]))
+			title, _ = info["name"].(string)
+		case "announce":
+			if tracker, ok := value.(string); ok && tracker != "" {
+				trackers = appendUnique(trackers, tracker)
+			}
+		case "announce-list":
+			// A list of tiers, each tier being a list of trackers
+			tiers, _ := value.([]interface{})
+			for _, tier := range tiers {
+				tierTrackers, _ := tier.([]interface{})
+				for _, tierTracker := range tierTrackers {
+					if tracker, ok := tierTracker.(string); ok && tracker != "" {
+						trackers = appendUnique(trackers, tracker)
+					}
+				}
+			}
+		}
+	}
+	if infoHash == "" {
+		return Result{}, errors.New("Torrent file doesn't contain an \"info\" dictionary")
+	}
+	quality, ok := parseQualityOrUnknown(title)
+	if !ok {
+		return Result{}, fmt.Errorf("Torrent has an unsupported resolution: %v", title)
+	}
+
+	return Result{
+		Title:     title,
+		Quality:   quality,
+		InfoHash:  infoHash,
+		MagnetURL: BuildMagnet(infoHash, title, trackers),
+		Trackers:  trackers,
+		BitDepth:  parseBitDepth(title),
+		Source:    parseSource(title),
+		Remux:     parseRemux(title),
+		Proper:    parseProper(title),
+		Repack:    parseRepack(title),
+	}, nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, elem := range list {
+		if elem == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// bencodeDecoder decodes bencoded data, see https://wiki.theory.org/index.php/BitTorrentSpecification#Bencoding.
+// Strings are decoded as string, integers as int64, lists as []interface{} and dictionaries as map[string]interface{}.
+// Lists and dictionaries can be nested up to maxBencodeDepth levels.
+type bencodeDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (d *bencodeDecoder) decode() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, errors.New("Unexpected end of bencoded data")
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		return d.decodeInt()
+	case c == 'l':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		d.pos++
+		var list []interface{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			elem, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		return list, d.consumeEnd()
+	case c == 'd':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		d.pos++
+		dict := map[string]interface{}{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.decodeString()
+			if err != nil {
+				return nil, err
+			}
+			if dict[key], err = d.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return dict, d.consumeEnd()
+	case c >= '0' && c <= '9':
+		return d.decodeString()
+	default:
+		return nil, fmt.Errorf("Unexpected character %q at position %v of bencoded data", c, d.pos)
+	}
+}
+
+func (d *bencodeDecoder) decodeInt() (int64, error) {
+	// Skip "i"
+	d.pos++
+	endIndex := bytes.IndexByte(d.data[d.pos:], 'e')
+	if endIndex == -1 {
+		return 0, errors.New("Unexpected end of bencoded integer")
+	}
+	i, err := strconv.ParseInt(string(d.data[d.pos:d.pos+endIndex]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't parse bencoded integer: %v", err)
+	}
+	d.pos += endIndex + 1
+	return i, nil
+}
+
+func (d *bencodeDecoder) decodeString() (string, error) {
+	colonIndex := bytes.IndexByte(d.data[d.pos:], ':')
+	if colonIndex == -1 {
+		return "", errors.New("Unexpected end of bencoded string length")
+	}
+	length, err := strconv.Atoi(string(d.data[d.pos : d.pos+colonIndex]))
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("Couldn't parse bencoded string length at position %v", d.pos)
+	}
+	start := d.pos + colonIndex + 1
+	if length > len(d.data)-start {
+		return "", errors.New("Bencoded string length exceeds data")
+	}
+	d.pos = start + length
+	return string(d.data[start:d.pos]), nil
+}
+
+// enter increases the nesting depth for a list or dictionary and returns an error if it exceeds maxBencodeDepth.
+func (d *bencodeDecoder) enter() error {
+	if d.depth >= maxBencodeDepth {
+		return fmt.Errorf("Bencoded data is nested deeper than %v levels", maxBencodeDepth)
+	}
+	d.depth++
+	return nil
+}
+
+func (d *bencodeDecoder) leave() {
+	d.depth--
+}
+
+func (d *bencodeDecoder) consumeEnd() error {
+	if d.pos >= len(d.data) {
+		return errors.New("Unexpected end of bencoded data")
+	}
+	// Skip "e"
+	d.pos++
+	return nil
+}
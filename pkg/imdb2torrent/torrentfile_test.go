@@ -0,0 +1,172 @@
+package imdb2torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const testInfoDict = "d6:lengthi1024e4:name14:Big Buck Bunny12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+
+func testTorrentFile() string {
+	return "d8:announce25:udp://tracker.example:13713:announce-listll25:udp://tracker.example:137el23:udp://backup.example:80ee4:info" + testInfoDict + "e"
+}
+
+func TestTorrentFileToMagnet(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handle("/big-buck-bunny.torrent", testTorrentFile())
+	server.handle("/garbage.torrent", "<html>Not found</html>")
+	hash := sha1.Sum([]byte(testInfoDict))
+	expectedInfoHash := strings.ToUpper(hex.EncodeToString(hash[:]))
+
+	tests := []struct {
+		name      string
+		path      string
+		expectErr bool
+	}{
+		{"torrent file", "/big-buck-bunny.torrent", false},
+		{"not a torrent file", "/garbage.torrent", true},
+		{"missing file", "/missing.torrent", true},
+	}
+	client := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last := &lastRequest{lock: &sync.Mutex{}}
+			ctx := withLastRequest(context.Background(), last)
+			result, err := client.TorrentFileToMagnet(ctx, server.URL+tt.path)
+			if last.get() != server.URL+tt.path {
+				t.Errorf("Expected the request URL to be recorded, got %q", last.get())
+			}
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got: %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if result.InfoHash != expectedInfoHash || result.Title != "Big Buck Bunny" {
+				t.Errorf("Expected info hash %v and title from the file, got: %+v", expectedInfoHash, result)
+			}
+			if strings.Join(result.Trackers, ",") != "udp://tracker.example:137,udp://backup.example:80" {
+				t.Errorf("Expected the unique trackers of the file, got: %v", result.Trackers)
+			}
+			if expected := BuildMagnet(expectedInfoHash, "Big Buck Bunny", result.Trackers); result.MagnetURL != expected {
+				t.Errorf("Expected magnet URL %v, got %v", expected, result.MagnetURL)
+			}
+			if result.Quality != QualityUnknown {
+				t.Errorf("Expected quality %v for a name without resolution, got %v", QualityUnknown, result.Quality)
+			}
+		})
+	}
+}
+
+func TestParseTorrentFile(t *testing.T) {
+	torrentFile := func(name string) string {
+		return fmt.Sprintf("d4:infod4:name%v:%vee", len(name), name)
+	}
+	tests := []struct {
+		name            string
+		torrentFile     string
+		expectedQuality string
+		expectErr       bool
+	}{
+		{"resolution", torrentFile("Big.Buck.Bunny.2008.1080p.BluRay.x264"), "1080p", false},
+		{"resolution and bit depth", torrentFile("Big.Buck.Bunny.2008.2160p.10bit.WEB-DL"), "2160p 10bit", false},
+		{"no resolution", torrentFile("Big Buck Bunny"), QualityUnknown, false},
+		{"unsupported resolution", torrentFile("Big.Buck.Bunny.2008.480p.DVDRip"), "", true},
+		{"nesting at limit", "d4:infod4:name3:Foo4:deep" + strings.Repeat("l", maxBencodeDepth-1) + strings.Repeat("e", maxBencodeDepth-1) + "ee", QualityUnknown, false},
+		{"nesting too deep", "d4:infod4:name3:Foo4:deep" + strings.Repeat("l", maxBencodeDepth) + strings.Repeat("e", maxBencodeDepth) + "ee", "", true},
+		// Would overflow the stack without a depth limit
+		{"huge nesting", "d4:info" + strings.Repeat("l", maxTorrentFileSize), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseTorrentFile([]byte(tt.torrentFile))
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got: %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if result.Quality != tt.expectedQuality {
+				t.Errorf("Expected quality %v, got %v", tt.expectedQuality, result.Quality)
+			}
+		})
+	}
+}
+
+func TestTorrentFileToMagnetCanceled(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handle("/big-buck-bunny.torrent", testTorrentFile())
+	client := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.TorrentFileToMagnet(ctx, server.URL+"/big-buck-bunny.torrent"); err == nil {
+		t.Error("Expected an error for a canceled context")
+	}
+	if count := len(server.requested()); count != 0 {
+		t.Errorf("Expected no request, got %v", count)
+	}
+}
+
+func TestTorrentFileToMagnetScrapeBudget(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handle("/big-buck-bunny.torrent", testTorrentFile())
+	// One request per second
+	client := newTestClient(t, WithScrapeBudget(60))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.TorrentFileToMagnet(ctx, server.URL+"/big-buck-bunny.torrent"); err != nil {
+		t.Fatalf("Expected no error for the first request, got: %v", err)
+	}
+	if _, err := client.TorrentFileToMagnet(ctx, server.URL+"/big-buck-bunny.torrent"); err == nil {
+		t.Error("Expected an error, because the budget doesn't allow another request before the deadline")
+	}
+	if count := server.requestCount("/big-buck-bunny.torrent"); count != 1 {
+		t.Errorf("Expected 1 request, got %v", count)
+	}
+}
+
+func TestSiteLimiter(t *testing.T) {
+	client := newTestClient(t,
+		WithBaseURL("YTS", "https://yts.example"),
+		WithBaseURL("ibit", "https://ibit.example,https://ibit-mirror.example"))
+	tests := []struct {
+		name     string
+		url      string
+		expected *rate.Limiter
+	}{
+		{"site", "https://yts.example/torrent/download/1", client.ytsClient.limiter},
+		{"host case", "https://YTS.example/torrent/download/1", client.ytsClient.limiter},
+		{"first mirror", "https://ibit.example/torrent/1.torrent", client.ibitClient.limiters[0]},
+		{"second mirror", "https://ibit-mirror.example/torrent/1.torrent", client.ibitClient.limiters[1]},
+		{"other host", "https://itorrents.example/torrent/1.torrent", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqURL, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limiter := client.siteLimiter(reqURL)
+			if limiter != tt.expected {
+				t.Errorf("Expected limiter %p, got %p", tt.expected, limiter)
+			}
+		})
+	}
+}
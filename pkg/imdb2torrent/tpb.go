@@ -3,9 +3,11 @@ package imdb2torrent
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -15,22 +17,39 @@ import (
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/proxy"
 	"golang.org/x/net/publicsuffix"
+	"golang.org/x/time/rate"
 )
 
 var _ MagnetSearcher = (*tpbClient)(nil)
 
 type tpbClient struct {
-	baseURL    string
-	httpClient *http.Client
-	cache      *fastcache.Cache
-	cacheAge   time.Duration
+	mirrors        *mirrorList
+	httpClient     *http.Client
+	cache          *fastcache.Cache
+	cacheAge       time.Duration
+	cacheAgeJitter time.Duration
+	// Returns the current time for cache expiry. Only replaced in tests.
+	now func() time.Time
+	// Gzip cache entries
+	compressCache bool
+	// Also retry searches without results, not only timed out ones
+	retryEmpty bool
+	limiter    *rate.Limiter
 }
 
-func newTPBclient(ctx context.Context, baseURL, socksProxyAddr string, timeout time.Duration, cache *fastcache.Cache, cacheAge time.Duration) (tpbClient, error) {
+func newTPBclient(ctx context.Context, baseURL, socksProxyAddr, socksProxyUser, socksProxyPassword string, timeout time.Duration, cache *fastcache.Cache, cacheAge, cacheAgeJitter time.Duration, now func() time.Time, compressCache bool, retryEmpty bool, rateLimit float64) (tpbClient, error) {
 	// Using a SOCKS5 proxy allows us to make requests to TPB via the TOR network
 	var httpClient *http.Client
 	if socksProxyAddr != "" {
-		dialer, err := proxy.SOCKS5("tcp", socksProxyAddr, nil, proxy.Direct)
+		// Commercial proxy providers usually require authentication, TOR doesn't
+		var auth *proxy.Auth
+		if socksProxyUser != "" {
+			auth = &proxy.Auth{
+				User:     socksProxyUser,
+				Password: socksProxyPassword,
+			}
+		}
+		dialer, err := proxy.SOCKS5("tcp", socksProxyAddr, auth, proxy.Direct)
 		if err != nil {
 			return tpbClient{}, fmt.Errorf("Couldn't create SOCKS5 dialer: %v", err)
 		}
@@ -51,10 +70,15 @@ func newTPBclient(ctx context.Context, baseURL, socksProxyAddr string, timeout t
 		}
 	}
 	return tpbClient{
-		baseURL:    baseURL,
-		httpClient: httpClient,
-		cache:      cache,
-		cacheAge:   cacheAge,
+		mirrors:        newMirrorList(baseURL),
+		httpClient:     httpClient,
+		cache:          cache,
+		cacheAge:       cacheAge,
+		cacheAgeJitter: cacheAgeJitter,
+		now:            now,
+		compressCache:  compressCache,
+		retryEmpty:     retryEmpty,
+		limiter:        newRateLimiter(rateLimit),
 	}, nil
 }
 
@@ -62,8 +86,11 @@ func (c tpbClient) Check(ctx context.Context, imdbID string) ([]Result, error) {
 	return c.checkAttempts(ctx, imdbID, 1)
 }
 
-// check scrapes TPB to find torrents for the given IMDb ID.
+// checkAttempts scrapes TPB to find torrents for the given IMDb ID, with up to the given number of attempts.
 // TPB sometimes runs into a timeout, so let's allow multiple attempts *when a timeout occurs*.
+// If the client is configured to retry empty results, attempts without results are retried as well.
+// The results of the first successful attempt with results are returned, or an empty result if all successful attempts were empty.
+// An error of a later attempt doesn't discard the empty result of an earlier one, so an error is only returned if all attempts failed.
 // If no error occured, but there are just no torrents for the movie yet, an empty result and *no* error are returned.
 func (c tpbClient) checkAttempts(ctx context.Context, imdbID string, attempts int) ([]Result, error) {
 	logFields := log.Fields{
@@ -74,53 +101,139 @@ func (c tpbClient) checkAttempts(ctx context.Context, imdbID string, attempts in
 
 	// Check cache first
 	cacheKey := imdbID + "-TPB"
-	if torrentsGob, ok := c.cache.HasGet(nil, []byte(cacheKey)); ok {
-		torrentList, created, err := FromCacheEntry(ctx, torrentsGob)
-		if err != nil {
-			logger.WithError(err).Error("Couldn't decode torrent results")
-		} else if time.Since(created) < (c.cacheAge) {
-			logger.WithField("torrentCount", len(torrentList)).Debug("Hit cache for torrents, returning results")
-			return torrentList, nil
-		} else {
-			expiredSince := time.Since(created.Add(c.cacheAge))
-			logger.WithField("expiredSince", expiredSince).Debug("Hit cache for torrents, but entry is expired")
-		}
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
 	}
 
 	if attempts == 0 {
 		return nil, fmt.Errorf("Cannot check TPB with 0 attempts")
 	}
-	// "/0/7/207" suffix is: ? / sort by seeders / category "HD - Movies"
-	reqUrl := c.baseURL + "/search/" + imdbID + "/0/7/207"
-	res, err := c.httpClient.Get(reqUrl)
-	if err != nil {
-		// HTTP client errors are *always* `*url.Error`s
-		urlErr := err.(*url.Error)
-		if urlErr.Timeout() {
-			logger.Info("Ran into a timeout")
-			if attempts == 1 {
-				return nil, fmt.Errorf("All attempted requests to %v timed out", reqUrl)
+	var results []Result
+	succeeded := false
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var attemptResults []Result
+		var timedOut bool
+		attemptResults, timedOut, err = c.check(ctx, logger, imdbID)
+		if err == nil {
+			succeeded = true
+			results = attemptResults
+			if len(results) > 0 || !c.retryEmpty {
+				break
+			}
+			if attempt < attempts {
+				logger.Debug("Got no results, retrying...")
 			}
+			continue
+		}
+		if !timedOut {
+			break
+		}
+		logger.Info("Ran into a timeout")
+		if attempt < attempts {
 			// Just retrying again with the same HTTP client, which probably reuses the previous connection, doesn't work.
 			// Simple tests have shown that when a proper connection exists, all requests to TPB work, while when no proper connection exists all requests time out.
 			logger.Debug("Closing connections to TPB and retrying...")
 			c.httpClient.CloseIdleConnections()
-			return c.checkAttempts(ctx, imdbID, attempts-1)
-		} else {
-			return nil, fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)
 		}
 	}
+	if !succeeded {
+		return nil, err
+	}
+
+	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
+	// Any actual errors would have returned earlier.
+	setCachedResults(ctx, c.cache, cacheKey, results, c.now, c.compressCache, logger)
+
+	return results, nil
+}
+
+// check sends a single search request to TPB and scrapes the results.
+// It returns true if the request timed out, which is worth retrying.
+func (c tpbClient) check(ctx context.Context, logger *log.Entry, imdbID string) ([]Result, bool, error) {
+	// "/0/7/207" suffix is: ? / sort by seeders / category "HD - Movies"
+	reqPath := "/search/" + imdbID + "/0/7/207"
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, false, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+	}
+	res, err := c.mirrors.get(ctx, c.httpClient, reqPath)
+	if err != nil {
+		// HTTP client errors are *always* `*url.Error`s, but the mirror list can also fail before sending a request
+		if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
+			return nil, true, fmt.Errorf("Request to %v timed out: %v", reqPath, err)
+		}
+		return nil, false, fmt.Errorf("Couldn't GET %v: %v", reqPath, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	}
+
+	// Load the HTML document
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(res.Body, maxBodySize))
+	if err != nil {
+		return nil, false, fmt.Errorf("Couldn't load the HTML in goquery: %v", err)
+	}
+
+	return tpbResults(logger, doc), false, nil
+}
+
+// TPB categories for the top 100 listings, see Client.TopMoviesTPB()
+const (
+	TPBCategoryMovies    = "201"
+	TPBCategoryHDMovies  = "207"
+	TPBCategoryUHDMovies = "211"
+)
+
+// tpbTopCategoryRegex matches a TPB category like "207", optionally prefixed with "48h" for the top 100 of the last 48 hours.
+var tpbTopCategoryRegex = regexp.MustCompile(`^(48h)?[0-9]{3}$`)
+
+// top scrapes the top 100 torrents of the given TPB category, for example TPBCategoryHDMovies.
+// The listing isn't specific to a movie, so the results aren't filtered by title and the cache entry has its own key.
+func (c tpbClient) top(ctx context.Context, category string) ([]Result, error) {
+	if !tpbTopCategoryRegex.MatchString(category) {
+		return nil, fmt.Errorf("Invalid TPB category: %v", category)
+	}
+	logFields := log.Fields{
+		"category":    category,
+		"torrentSite": "TPB",
+	}
+	logger := log.WithContext(ctx).WithFields(logFields)
+
+	// Check cache first
+	cacheKey := "top-" + category + "-TPB"
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
+	}
+
+	reqPath := "/top/" + category
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+	}
+	res, err := c.mirrors.get(ctx, c.httpClient, reqPath)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't GET %v: %v", reqPath, err)
+	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("Bad GET response: %v", res.StatusCode)
 	}
 
 	// Load the HTML document
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(res.Body, maxBodySize))
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't load the HTML in goquery: %v", err)
 	}
+	results := tpbResults(logger, doc)
 
+	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
+	setCachedResults(ctx, c.cache, cacheKey, results, c.now, c.compressCache, logger)
+
+	return results, nil
+}
+
+// tpbResults scrapes the results from a TPB listing, like the search results or the top 100 of a category.
+func tpbResults(logger *log.Entry, doc *goquery.Document) []Result {
 	// Find the review items
 	// Note: Uses "double" and not "single" view!
 	var results []Result
@@ -132,25 +245,10 @@ func (c tpbClient) checkAttempts(ctx context.Context, imdbID string, attempts in
 		}
 		title = strings.TrimSpace(title)
 
-		quality := ""
-		if strings.Contains(title, "720p") {
-			quality = "720p"
-		} else if strings.Contains(title, "1080p") {
-			quality = "1080p"
-		} else if strings.Contains(title, "2160p") {
-			quality = "2160p"
-		} else {
+		quality, ok := parseQualityOrUnknown(title)
+		if !ok {
 			return
 		}
-		if strings.Contains(title, "10bit") {
-			quality += " 10bit"
-		}
-		// https://en.wikipedia.org/wiki/Pirated_movie_release_types
-		if strings.Contains(title, "HDCAM") {
-			quality += (" (⚠️cam)")
-		} else if strings.Contains(title, "HDTS") {
-			quality += (" (⚠️telesync)")
-		}
 
 		magnet, _ := s.Find(".detName").Next().Attr("href")
 		if !strings.HasPrefix(magnet, "magnet:") {
@@ -163,34 +261,37 @@ func (c tpbClient) checkAttempts(ctx context.Context, imdbID string, attempts in
 		infoHash = strings.TrimSuffix(infoHash, "&")
 		infoHash = strings.ToUpper(infoHash)
 
-		if infoHash == "" {
+		// BitTorrent v2 and hybrid torrents also have a v2 info hash, v2-only torrents *only* have that
+		infoHashV2 := magnetInfoHashV2(magnet)
+		if infoHash == "" && infoHashV2 == "" {
 			logger.WithField("magnet", magnet).Warn("Couldn't extract info_hash. Did the HTML change?")
 			return
 		}
 
 		result := Result{
-			Title:     title,
-			Quality:   quality,
-			InfoHash:  infoHash,
-			MagnetURL: magnet,
+			Title:       title,
+			Quality:     quality,
+			Source:      parseSource(title),
+			ReleaseType: parseReleaseType(title),
+			Remux:       parseRemux(title),
+			Proper:      parseProper(title),
+			Repack:      parseRepack(title),
+			InfoHash:    infoHash,
+			MagnetURL:   magnet,
+			Trackers:    magnetTrackers(magnet),
+			InfoHashV2:  infoHashV2,
+			Group:       parseReleaseGroup(title),
+			BitDepth:    parseBitDepth(title),
+			// For example "Uploaded 03-15 2019, Size 2.18 GiB, ULed by foo"
+			Size: ParseSize(s.Find(".detDesc").Text()),
+		}
+		// The columns are category, name and seeders
+		if seeders, err := strconv.Atoi(strings.TrimSpace(s.Find("td").Eq(2).Text())); err == nil {
+			result.Seeders = seeders
 		}
 		logger.WithFields(log.Fields{"title": title, "quality": quality, "infoHash": infoHash, "magnet": magnet}).Trace("Found torrent")
 		results = append(results, result)
 	})
 
-	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
-	// Any actual errors would have returned earlier.
-	if torrentsGob, err := NewCacheEntry(ctx, results); err != nil {
-		logger.WithError(err).WithField("cache", "torrent").Error("Couldn't create cache entry for torrents")
-	} else {
-		entrySize := strconv.Itoa(len(torrentsGob)/1024) + "KB"
-		if len(torrentsGob) > 64*1024 {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Warn("New cacheEntry is bigger than 64KB, which means it won't be stored in the cache when calling fastcache's Set() method. SetBig() (and GetBig()) must be used instead!")
-		} else {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Debug("Caching torrent results")
-		}
-		c.cache.Set([]byte(cacheKey), torrentsGob)
-	}
-
-	return results, nil
+	return results
 }
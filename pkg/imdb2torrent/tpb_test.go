@@ -0,0 +1,119 @@
+package imdb2torrent
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTPBclient(t *testing.T, baseURL string, retryEmpty bool) tpbClient {
+	t.Helper()
+	client, err := newTPBclient(context.Background(), baseURL, "", "", "", time.Second, newTestCache(), time.Hour, 0, testNow, false, retryEmpty, 0)
+	if err != nil {
+		t.Fatalf("Couldn't create TPB client: %v", err)
+	}
+	return client
+}
+
+func TestTPBCheck(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handleFile(t, "/search/tt1254207/0/7/207", "tpb_search.html")
+	client := newTestTPBclient(t, server.URL, false)
+
+	results, err := client.checkAttempts(context.Background(), "tt1254207", 1)
+	if err != nil {
+		t.Fatalf("checkAttempts() returned an error: %v", err)
+	}
+	// The 480p torrent is dropped
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v: %+v", len(results), results)
+	}
+	first := results[0]
+	if first.InfoHash != "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA" || first.Quality != "1080p" || first.Seeders != 42 || first.Group != "GRP" {
+		t.Errorf("Unexpected first result: %+v", first)
+	}
+	if len(first.Trackers) != 1 || first.Trackers[0] != "udp://tracker.example.org:1337/announce" {
+		t.Errorf("Unexpected trackers: %v", first.Trackers)
+	}
+	second := results[1]
+	if second.InfoHash != "BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB" || second.Quality != "2160p 10bit" || second.Source != SourceWebDL {
+		t.Errorf("Unexpected second result: %+v", second)
+	}
+}
+
+// TPB responses for the attempts in TestTPBCheckAttempts
+const (
+	tpbFound     = "results"
+	tpbEmpty     = "empty"
+	tpbTimeout   = "timeout"
+	tpbBadStatus = "bad status"
+)
+
+func TestTPBCheckAttempts(t *testing.T) {
+	searchPage, err := ioutil.ReadFile(filepath.Join("testdata", "tpb_search.html"))
+	if err != nil {
+		t.Fatalf("Couldn't read fixture: %v", err)
+	}
+	tests := []struct {
+		name             string
+		retryEmpty       bool
+		responses        []string
+		expectErr        bool
+		expectedResults  int
+		expectedRequests int
+	}{
+		{"first succeeds with results", true, []string{tpbFound, tpbFound, tpbFound}, false, 2, 1},
+		{"first empty without retrying empty results", false, []string{tpbEmpty, tpbFound, tpbFound}, false, 0, 1},
+		{"first empty second has results", true, []string{tpbEmpty, tpbFound, tpbFound}, false, 2, 2},
+		{"all empty", true, []string{tpbEmpty, tpbEmpty, tpbEmpty}, false, 0, 3},
+		{"first times out second has results", false, []string{tpbTimeout, tpbFound, tpbFound}, false, 2, 2},
+		{"first empty later ones time out", true, []string{tpbEmpty, tpbTimeout, tpbTimeout}, false, 0, 3},
+		{"all time out", false, []string{tpbTimeout, tpbTimeout, tpbTimeout}, true, 0, 3},
+		{"other errors aren't retried", false, []string{tpbBadStatus, tpbFound, tpbFound}, true, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock := &sync.Mutex{}
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				response := tt.responses[requests]
+				requests++
+				lock.Unlock()
+				switch response {
+				case tpbFound:
+					_, _ = w.Write(searchPage)
+				case tpbEmpty:
+					_, _ = w.Write([]byte(`<html><body><table id="searchResult"></table></body></html>`))
+				case tpbTimeout:
+					<-r.Context().Done()
+				case tpbBadStatus:
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer server.Close()
+			client := newTestTPBclient(t, server.URL, tt.retryEmpty)
+			client.httpClient.Timeout = 50 * time.Millisecond
+
+			results, err := client.checkAttempts(context.Background(), "tt1254207", len(tt.responses))
+			if tt.expectErr && err == nil {
+				t.Errorf("Expected an error, got: %+v", results)
+			} else if !tt.expectErr && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+			if len(results) != tt.expectedResults {
+				t.Errorf("Expected %v results, got %v: %+v", tt.expectedResults, len(results), results)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if requests != tt.expectedRequests {
+				t.Errorf("Expected %v requests, got %v", tt.expectedRequests, requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package imdb2torrent
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans below the client's root spans.
+const tracerName = "github.com/doingodswork/deflix-stremio/pkg/imdb2torrent"
+
+// traced runs the search in a root span created with the client's tracer.
+// Without tracer the search is run as is, so tracing doesn't cost anything when it's disabled.
+func (c Client) traced(ctx context.Context, spanName string, attrs []attribute.KeyValue, search func(context.Context) ([]Result, error)) ([]Result, error) {
+	if c.tracer == nil {
+		return search(ctx)
+	}
+	ctx, span := c.tracer.Start(ctx, spanName, trace.WithAttributes(attrs...))
+	results, err := search(ctx)
+	endSpan(span, len(results), err)
+	return results, err
+}
+
+// startSpan starts a child span of the span in ctx.
+// If ctx doesn't contain a span, because the client has no tracer, the returned span is a no-op.
+func startSpan(ctx context.Context, spanName string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, spanName, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the result count or error of a search and ends the span.
+func endSpan(span trace.Span, resultCount int, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("resultCount", resultCount))
+	}
+	span.End()
+}
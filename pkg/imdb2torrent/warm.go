@@ -0,0 +1,42 @@
+package imdb2torrent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Warm refreshes the cached results of the given IMDb IDs that are expired or expire within the refresh window (see WithRefreshWindow()), so that the next search for them is fast, for example after a restart with a persisted cache.
+// Only torrent sites with a cache entry for an IMDb ID are searched, IMDb IDs that were never searched aren't scraped for the first time. Curated results don't expire, so they're not refreshed.
+// The sites are searched one after another, so the requests are spread out and bounded by the rate limits and the scrape budget (see WithScrapeBudget()).
+// It returns the number of refreshed cache entries, and an error if the context is done before all IMDb IDs were handled. Failed refreshes are only logged.
+func (c Client) Warm(ctx context.Context, imdbIDs []string) (int, error) {
+	refreshed := 0
+	for _, rawIMDbID := range imdbIDs {
+		imdbID, err := CanonicalIMDbID(rawIMDbID)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).WithField("imdbID", rawIMDbID).Warn("Skipping invalid IMDb ID when warming cache")
+			continue
+		}
+		sites, _ := c.uncoalescedIMDbSiteSearches(imdbID, true)
+		for _, site := range sites {
+			if err := ctx.Err(); err != nil {
+				return refreshed, fmt.Errorf("Couldn't warm cache for all IMDb IDs: %v", err)
+			}
+			if c.siteDisabled(site.torrentSite) || !cacheEntryNeedsRefresh(ctx, c.cache, imdbID+"-"+site.torrentSite, c.cacheAge, c.cacheAgeJitter, c.refreshWindow, time.Now) {
+				continue
+			}
+			logger := log.WithContext(ctx).WithFields(log.Fields{"imdbID": imdbID, "torrentSite": site.torrentSite})
+			// The search writes the fresh results to the cache
+			if _, err := site.check(WithBypassCache(ctx)); err != nil {
+				logger.WithError(err).Warn("Couldn't refresh cached torrents when warming cache")
+				continue
+			}
+			logger.Debug("Refreshed cached torrents when warming cache")
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
@@ -3,15 +3,15 @@ package imdb2torrent
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/fastcache"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -29,20 +29,39 @@ var (
 var _ MagnetSearcher = (*ytsClient)(nil)
 
 type ytsClient struct {
-	baseURL    string
-	httpClient *http.Client
-	cache      *fastcache.Cache
-	cacheAge   time.Duration
+	mirrors        *mirrorList
+	httpClient     *http.Client
+	cache          *fastcache.Cache
+	cacheAge       time.Duration
+	cacheAgeJitter time.Duration
+	// Returns the current time for cache expiry. Only replaced in tests.
+	now func() time.Time
+	// Gzip cache entries
+	compressCache bool
+	// Keep only the best torrent per quality instead of all of them
+	collapseTorrents bool
+	// Use the movie details endpoint when the search endpoint doesn't find torrents for an IMDb ID
+	movieDetails bool
+	// How strictly the movie title must match in title searches, see WithTitleMatching()
+	titleMatching string
+	limiter       *rate.Limiter
 }
 
-func newYTSclient(ctx context.Context, baseURL string, timeout time.Duration, cache *fastcache.Cache, cacheAge time.Duration) ytsClient {
+func newYTSclient(ctx context.Context, baseURL string, timeout time.Duration, cache *fastcache.Cache, cacheAge, cacheAgeJitter time.Duration, now func() time.Time, compressCache bool, collapseTorrents, movieDetails bool, titleMatching string, rateLimit float64) ytsClient {
 	return ytsClient{
-		baseURL: baseURL,
+		mirrors: newMirrorList(baseURL),
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
-		cache:    cache,
-		cacheAge: cacheAge,
+		cache:            cache,
+		cacheAge:         cacheAge,
+		cacheAgeJitter:   cacheAgeJitter,
+		now:              now,
+		compressCache:    compressCache,
+		collapseTorrents: collapseTorrents,
+		movieDetails:     movieDetails,
+		titleMatching:    titleMatching,
+		limiter:          newRateLimiter(rateLimit),
 	}
 }
 
@@ -55,55 +74,84 @@ func (c ytsClient) Check(ctx context.Context, imdbID string) ([]Result, error) {
 	}
 	logger := log.WithContext(ctx).WithFields(logFields)
 
-	// Check cache first
-	cacheKey := imdbID + "-YTS"
-	if torrentsGob, ok := c.cache.HasGet(nil, []byte(cacheKey)); ok {
-		torrentList, created, err := FromCacheEntry(ctx, torrentsGob)
-		if err != nil {
-			logger.WithError(err).Error("Couldn't decode torrent results")
-		} else if time.Since(created) < (c.cacheAge) {
-			logger.WithField("torrentCount", len(torrentList)).Debug("Hit cache for torrents, returning results")
-			return torrentList, nil
-		} else {
-			expiredSince := time.Since(created.Add(c.cacheAge))
-			logger.WithField("expiredSince", expiredSince).Debug("Hit cache for torrents, but entry is expired")
-		}
+	return c.check(ctx, logger, imdbID, 0, true, imdbID+"-YTS")
+}
+
+// checkTitle uses YTS' API to find torrents for the given movie title and year (0 if unknown).
+// If no error occured, but there are just no torrents for the movie yet, an empty result and *no* error are returned.
+func (c ytsClient) checkTitle(ctx context.Context, title string, year int) ([]Result, error) {
+	logFields := log.Fields{
+		"title":       title,
+		"year":        year,
+		"torrentSite": "YTS",
 	}
+	logger := log.WithContext(ctx).WithFields(logFields)
 
-	url := c.baseURL + "/api/v2/list_movies.json?query_term=" + imdbID
-	res, err := c.httpClient.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("Couldn't GET %v: %v", url, err)
+	return c.check(ctx, logger, title, year, false, titleCacheKey(title, year)+"-YTS")
+}
+
+// check finds the torrents of the first movie that YTS' API returns for the query term.
+// If year isn't 0, the first movie of that year is used.
+// If the query term is an IMDb ID and the client is configured to do so, the movie details endpoint is used when the search endpoint fails or doesn't return any torrents.
+func (c ytsClient) check(ctx context.Context, logger *log.Entry, queryTerm string, year int, byIMDbID bool, cacheKey string) ([]Result, error) {
+	// Check cache first
+	if torrentList, ok := getCachedResults(ctx, c.cache, cacheKey, c.cacheAge, c.cacheAgeJitter, c.now, logger); ok {
+		return torrentList, nil
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+
+	movie, err := c.searchMovie(ctx, queryTerm, year, !byIMDbID)
+	if byIMDbID && c.movieDetails && (err != nil || len(movie.Get("torrents").Array()) == 0) {
+		logger.WithError(err).Debug("No torrents via YTS' search endpoint, trying its movie details endpoint")
+		if detailsMovie, detailsErr := c.getMovieDetails(ctx, queryTerm); detailsErr != nil {
+			logger.WithError(detailsErr).Debug("Couldn't get movie details")
+		} else {
+			movie, err = detailsMovie, nil
+		}
 	}
-	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't read response body: %v", err)
+		return nil, err
 	}
 
-	// Extract data from JSON
-	torrents := gjson.GetBytes(resBody, "data.movies.0.torrents").Array()
+	torrents := movie.Get("torrents").Array()
 	if len(torrents) == 0 {
 		// Nil slice is ok, because it can be checked with len()
 		return nil, nil
 	}
-	title := gjson.GetBytes(resBody, "data.movies.0.title").String()
+	if c.collapseTorrents {
+		torrents = collapseYTStorrents(torrents)
+	}
+	title := movie.Get("title").String()
 	var results []Result
 	for _, torrent := range torrents {
 		quality := torrent.Get("quality").String()
 		if quality == "720p" || quality == "1080p" || quality == "2160p" {
-			infoHash := torrent.Get("hash").String()
-			if infoHash == "" {
-				logger.WithField("torrentJSON", torrent.String()).Warn("Couldn't get info_hash from torrent JSON")
+			// The info hash is taken from the JSON directly, there's no magnet URL to parse it from
+			infoHash := strings.ToUpper(torrent.Get("hash").String())
+			if !isInfoHash(infoHash) {
+				logger.WithField("torrentJSON", torrent.String()).Warn("Couldn't get valid info_hash from torrent JSON")
+				continue
 			}
 			result := createMagnetURL(ctx, infoHash, title)
-			result.Quality = quality
-			ripType := torrent.Get("type").String()
-			if ripType != "" {
-				result.Quality += " (" + ripType + ")"
+			// YTS only has its own releases
+			result.Group = "YIFY"
+			result.Size = torrent.Get("size_bytes").Int()
+			result.Seeders = int(torrent.Get("seeds").Int())
+			// YTS' "peers" are all peers of the swarm, so they include the seeders.
+			// It can be lower than "seeds" when YTS updated both values at different times.
+			if leechers := int(torrent.Get("peers").Int()) - result.Seeders; leechers > 0 {
+				result.Leechers = leechers
+			}
+			// YTS doesn't mention the bit depth in the quality, but newer torrents have it in their own field
+			result.BitDepth = 8
+			if torrent.Get("bit_depth").Int() == 10 {
+				result.BitDepth = 10
+			}
+			result.Quality = formatQuality(quality, result.BitDepth)
+			result.Source = torrent.Get("type").String()
+			// A title search can't be sure that it's the right movie, so like the other title searches it's a guess
+			if !byIMDbID {
+				result.GuessedMatch = true
+				result.Year = int(movie.Get("year").Int())
 			}
 			logger.WithFields(log.Fields{"title": title, "quality": quality, "infoHash": infoHash, "magnet": result.MagnetURL}).Trace("Found torrent")
 			results = append(results, result)
@@ -112,30 +160,110 @@ func (c ytsClient) Check(ctx context.Context, imdbID string) ([]Result, error) {
 
 	// Fill cache, even if there are no results, because that's just the current state of the torrent site.
 	// Any actual errors would have returned earlier.
-	if torrentsGob, err := NewCacheEntry(ctx, results); err != nil {
-		logger.WithError(err).WithField("cache", "torrent").Error("Couldn't create cache entry for torrents")
-	} else {
-		entrySize := strconv.Itoa(len(torrentsGob)/1024) + "KB"
-		if len(torrentsGob) > 64*1024 {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Warn("New cacheEntry is bigger than 64KB, which means it won't be stored in the cache when calling fastcache's Set() method. SetBig() (and GetBig()) must be used instead!")
-		} else {
-			logger.WithField("cache", "torrent").WithField("entrySize", entrySize).Debug("Caching torrent results")
+	setCachedResults(ctx, c.cache, cacheKey, results, c.now, c.compressCache, logger)
+
+	return results, nil
+}
+
+// searchMovie returns the first movie of YTS' search endpoint for the query term, and if year isn't 0, the first movie of that year.
+// With matchTitle the query term is a movie title, and the movie's title must match it according to the client's title matching, because YTS also finds movies that only contain the query term, which for short titles like "It" can be any movie.
+// The returned movie doesn't exist if there's no match.
+func (c ytsClient) searchMovie(ctx context.Context, queryTerm string, year int, matchTitle bool) (gjson.Result, error) {
+	resBody, err := c.getAPI(ctx, "/api/v2/list_movies.json?query_term="+url.QueryEscape(queryTerm))
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	for _, m := range gjson.GetBytes(resBody, "data.movies").Array() {
+		if year != 0 && int(m.Get("year").Int()) != year {
+			continue
+		}
+		if matchTitle && !titleMatches(m.Get("title").String(), queryTerm, year, c.titleMatching) {
+			continue
 		}
-		c.cache.Set([]byte(cacheKey), torrentsGob)
+		return m, nil
 	}
+	return gjson.Result{}, nil
+}
 
-	return results, nil
+// getMovieDetails returns the movie for the IMDb ID from YTS' movie details endpoint.
+// The movie has the same torrent fields as the ones from the search endpoint.
+// The returned movie doesn't exist if YTS doesn't know it.
+func (c ytsClient) getMovieDetails(ctx context.Context, imdbID string) (gjson.Result, error) {
+	resBody, err := c.getAPI(ctx, "/api/v2/movie_details.json?imdb_id="+url.QueryEscape(imdbID))
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	movie := gjson.GetBytes(resBody, "data.movie")
+	// YTS responds with a movie with ID 0 for unknown IMDb IDs
+	if movie.Get("id").Int() == 0 {
+		return gjson.Result{}, nil
+	}
+	return movie, nil
+}
+
+// getAPI waits for the rate limiter, sends a GET request to the YTS API and returns the response body.
+func (c ytsClient) getAPI(ctx context.Context, reqPath string) ([]byte, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("Couldn't wait for rate limiter: %v", err)
+	}
+	res, err := c.mirrors.get(ctx, c.httpClient, reqPath)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't GET %v: %v", reqPath, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	}
+	resBody, err := readBody(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read response body: %v", err)
+	}
+	return resBody, nil
 }
 
+// collapseYTStorrents reduces the torrents that YTS returns for a movie to one per quality and bit depth, because an 8 bit and a 10 bit torrent are different qualities for the user.
+// Bluray rips are preferred over web rips, and for the same type the torrent with more seeders is preferred.
+// The order of the first occurrence of each quality is kept.
+func collapseYTStorrents(torrents []gjson.Result) []gjson.Result {
+	var result []gjson.Result
+	qualityIndexes := map[string]int{}
+	for _, torrent := range torrents {
+		bitDepth := 8
+		if torrent.Get("bit_depth").Int() == 10 {
+			bitDepth = 10
+		}
+		quality := formatQuality(torrent.Get("quality").String(), bitDepth)
+		if i, ok := qualityIndexes[quality]; !ok {
+			qualityIndexes[quality] = len(result)
+			result = append(result, torrent)
+		} else if isBetterYTStorrent(torrent, result[i]) {
+			result[i] = torrent
+		}
+	}
+	return result
+}
+
+func isBetterYTStorrent(a, b gjson.Result) bool {
+	typeRanks := map[string]int{
+		"bluray": 2,
+		"web":    1,
+	}
+	rankA := typeRanks[a.Get("type").String()]
+	rankB := typeRanks[b.Get("type").String()]
+	if rankA != rankB {
+		return rankA > rankB
+	}
+	return a.Get("seeds").Int() > b.Get("seeds").Int()
+}
+
+// createMagnetURL creates a result for the info hash of a YTS torrent, with a magnet URL that has the movie title as display name and the default trackers.
+// YTS' API only returns the info hash and a link to the .torrent file, so the magnet URL is always built by us, which also gives its results a known tracker list for merging.
 func createMagnetURL(ctx context.Context, infoHash, title string) Result {
 	result := Result{
 		InfoHash: infoHash,
 		Title:    title,
+		Trackers: append([]string(nil), trackers...),
 	}
-
-	result.MagnetURL = "magnet:?xt=urn:btih:" + infoHash + "&dn=" + url.QueryEscape(title)
-	for _, tracker := range trackers {
-		result.MagnetURL += "&tr" + tracker
-	}
+	result.MagnetURL = BuildMagnet(infoHash, title, trackers)
 	return result
 }
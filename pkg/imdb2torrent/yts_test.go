@@ -0,0 +1,139 @@
+package imdb2torrent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func newTestYTSclient(baseURL string) ytsClient {
+	return newYTSclient(context.Background(), baseURL, time.Second, newTestCache(), time.Hour, 0, testNow, false, false, false, TitleMatchingNormalized, 0)
+}
+
+func TestYTSCheck(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handleFile(t, "/api/v2/list_movies.json?query_term=tt1254207", "yts_list_movies.json")
+	client := newTestYTSclient(server.URL)
+
+	results, err := client.Check(context.Background(), "tt1254207")
+	if err != nil {
+		t.Fatalf("Check() returned an error: %v", err)
+	}
+	expected := []struct {
+		infoHash string
+		quality  string
+		source   string
+		seeders  int
+	}{
+		{"1111111111111111111111111111111111111111", "720p", SourceBluRay, 120},
+		{"2222222222222222222222222222222222222222", "1080p", SourceWeb, 80},
+		{"3333333333333333333333333333333333333333", "2160p 10bit", SourceBluRay, 40},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %v results, got %v: %+v", len(expected), len(results), results)
+	}
+	for i, e := range expected {
+		if results[i].InfoHash != e.infoHash || results[i].Quality != e.quality || results[i].Source != e.source || results[i].Seeders != e.seeders {
+			t.Errorf("Result %v: expected %+v, got %+v", i, e, results[i])
+		}
+		if results[i].GuessedMatch || results[i].Year != 0 {
+			t.Errorf("Result %v: expected no guessed match and year for an IMDb ID search, got %+v", i, results[i])
+		}
+		if results[i].Title != "Big Buck Bunny" {
+			t.Errorf("Result %v: expected title from the API, got %q", i, results[i].Title)
+		}
+	}
+
+	// The second search is answered from the cache
+	if _, err := client.Check(context.Background(), "tt1254207"); err != nil {
+		t.Fatalf("Second Check() returned an error: %v", err)
+	}
+	if count := len(server.requested()); count != 1 {
+		t.Errorf("Expected 1 request, got %v", count)
+	}
+}
+
+func TestYTSCheckBadResponse(t *testing.T) {
+	server := newFixtureServer(t)
+	client := newTestYTSclient(server.URL)
+
+	if _, err := client.Check(context.Background(), "tt1254207"); err == nil {
+		t.Error("Expected an error for a 404 response")
+	}
+}
+
+// ytsShortTitleMovies is a search response for the query term "It", which YTS also answers with movies that only contain it.
+const ytsShortTitleMovies = `{"status": "ok", "data": {"movies": [
+	{"title": "It Follows", "year": 2014, "torrents": [{"hash": "1111111111111111111111111111111111111111", "quality": "1080p", "type": "bluray", "seeds": 10, "peers": 12}]},
+	{"title": "It Comes at Night", "year": 2017, "torrents": [{"hash": "2222222222222222222222222222222222222222", "quality": "1080p", "type": "bluray", "seeds": 10, "peers": 12}]},
+	{"title": "It", "year": 2017, "torrents": [{"hash": "3333333333333333333333333333333333333333", "quality": "1080p", "type": "bluray", "seeds": 10, "peers": 12}]}
+]}}`
+
+func TestYTSCheckTitleShortTitle(t *testing.T) {
+	tests := []struct {
+		name          string
+		year          int
+		titleMatching string
+		expected      string
+	}{
+		{"normalized", 2017, TitleMatchingNormalized, "3333333333333333333333333333333333333333"},
+		{"normalized without year", 0, TitleMatchingNormalized, "3333333333333333333333333333333333333333"},
+		{"exact", 2017, TitleMatchingExact, "3333333333333333333333333333333333333333"},
+		{"contains", 2017, TitleMatchingContains, "2222222222222222222222222222222222222222"},
+		{"contains without year", 0, TitleMatchingContains, "1111111111111111111111111111111111111111"},
+		{"no movie of the year", 2020, TitleMatchingNormalized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFixtureServer(t)
+			server.handle("/api/v2/list_movies.json?query_term=It", ytsShortTitleMovies)
+			client := newYTSclient(context.Background(), server.URL, time.Second, newTestCache(), time.Hour, 0, testNow, false, false, false, tt.titleMatching, 0)
+
+			results, err := client.checkTitle(context.Background(), "It", tt.year)
+			if err != nil {
+				t.Fatalf("checkTitle() returned an error: %v", err)
+			}
+			if tt.expected == "" {
+				if len(results) != 0 {
+					t.Errorf("Expected no results, got: %+v", results)
+				}
+				return
+			}
+			if len(results) != 1 || results[0].InfoHash != tt.expected {
+				t.Fatalf("Expected the torrent %v, got: %+v", tt.expected, results)
+			}
+			if !results[0].GuessedMatch {
+				t.Error("Expected a guessed match for a title search")
+			}
+			if results[0].Year == 0 || (tt.year != 0 && results[0].Year != tt.year) {
+				t.Errorf("Expected the movie's year, got %v", results[0].Year)
+			}
+		})
+	}
+}
+
+func TestCollapseYTStorrents(t *testing.T) {
+	torrents := gjson.Parse(`[
+		{"hash": "A", "quality": "1080p", "type": "web", "seeds": 50},
+		{"hash": "B", "quality": "2160p", "type": "web", "bit_depth": 8, "seeds": 10},
+		{"hash": "C", "quality": "1080p", "type": "bluray", "seeds": 5},
+		{"hash": "D", "quality": "2160p", "type": "bluray", "bit_depth": 10, "seeds": 3},
+		{"hash": "E", "quality": "2160p", "type": "web", "bit_depth": 10, "seeds": 30},
+		{"hash": "F", "quality": "2160p", "type": "web", "bit_depth": 8, "seeds": 20}
+	]`).Array()
+
+	collapsed := collapseYTStorrents(torrents)
+
+	// 1080p: bluray beats web; 2160p 8bit: more seeders; 2160p 10bit: bluray beats web despite fewer seeders
+	expected := []string{"C", "F", "D"}
+	if len(collapsed) != len(expected) {
+		t.Fatalf("Expected %v torrents, got %v", len(expected), len(collapsed))
+	}
+	for i, hash := range expected {
+		if got := collapsed[i].Get("hash").String(); got != hash {
+			t.Errorf("Expected torrent %v at index %v, got %v", hash, i, got)
+		}
+	}
+}
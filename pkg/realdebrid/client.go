@@ -8,6 +8,8 @@ import (
 	"math/rand"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +19,9 @@ import (
 	"github.com/tidwall/gjson"
 )
 
+// Name is the short name of RealDebrid, for example for labeling streams when multiple debrid services are used.
+const Name = "RD"
+
 type Client struct {
 	httpClient *http.Client
 	// For API token validity
@@ -24,11 +29,15 @@ type Client struct {
 	// For info_hash instant availability
 	availabilityCache *fastcache.Cache
 	cacheAge          time.Duration
-	rdBaseURL         string
-	extraHeaders      map[string]string
+	// Max age of cache entries for info_hashes that aren't instantly available. 0 means they're not cached.
+	unavailableCacheAge time.Duration
+	rdBaseURL           string
+	extraHeaders        map[string]string
 }
 
-func NewClient(ctx context.Context, timeout time.Duration, tokenCache, availabilityCache *fastcache.Cache, cacheAge time.Duration, rdBaseURL string, extraHeaders []string) (Client, error) {
+// NewClient creates a RealDebrid client.
+// Instantly available info_hashes are cached for cacheAge, unavailable ones for unavailableCacheAge, which should be short, because RealDebrid can cache a torrent at any time. An unavailableCacheAge of 0 disables caching unavailable ones.
+func NewClient(ctx context.Context, timeout time.Duration, tokenCache, availabilityCache *fastcache.Cache, cacheAge, unavailableCacheAge time.Duration, rdBaseURL string, extraHeaders []string) (Client, error) {
 	// Precondition check
 	if rdBaseURL == "" {
 		return Client{}, errors.New("rdBaseURL parameter must not be empty")
@@ -54,14 +63,20 @@ func NewClient(ctx context.Context, timeout time.Duration, tokenCache, availabil
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
-		tokenCache:        tokenCache,
-		availabilityCache: availabilityCache,
-		cacheAge:          cacheAge,
-		rdBaseURL:         rdBaseURL,
-		extraHeaders:      extraHeaderMap,
+		tokenCache:          tokenCache,
+		availabilityCache:   availabilityCache,
+		cacheAge:            cacheAge,
+		unavailableCacheAge: unavailableCacheAge,
+		rdBaseURL:           rdBaseURL,
+		extraHeaders:        extraHeaderMap,
 	}, nil
 }
 
+// Name returns the short name of RealDebrid, see the Name constant.
+func (c Client) Name() string {
+	return Name
+}
+
 func (c Client) TestToken(ctx context.Context, apiToken string) error {
 	logger := log.WithContext(ctx).WithField("apiToken", apiToken)
 	logger.Debug("Testing token...")
@@ -111,15 +126,18 @@ func (c Client) CheckInstantAvailability(ctx context.Context, apiToken string, i
 
 	url := c.rdBaseURL + "/rest/1.0/torrents/instantAvailability"
 	// Only check the ones of which we don't know that they're valid (or which our knowledge that they're valid is more than 24 hours old).
-	// We don't cache unavailable ones, because that might change often!
+	// Unavailable ones are only cached for a short time, because that might change often!
 	var result []string
+	var requested []string
 	requestRequired := false
 	for _, infoHash := range infoHashes {
+		infoHash = strings.ToUpper(infoHash)
 		if availabilityGob, ok := c.availabilityCache.HasGet(nil, []byte(infoHash)); ok {
 			created, err := fromCacheEntry(ctx, availabilityGob)
 			if err != nil {
 				logger.WithError(err).WithField("infoHash", infoHash).Error("Couldn't decode availability cache entry")
 				requestRequired = true
+				requested = append(requested, infoHash)
 				url += "/" + infoHash
 			} else if time.Since(created) < (c.cacheAge) {
 				logger.WithField("infoHash", infoHash).Debug("Availability cached as valid")
@@ -131,10 +149,14 @@ func (c Client) CheckInstantAvailability(ctx context.Context, apiToken string, i
 				}
 				logger.WithFields(fields).Debug("Availability cached as valid, but entry is expired")
 				requestRequired = true
+				requested = append(requested, infoHash)
 				url += "/" + infoHash
 			}
+		} else if c.cachedAsUnavailable(ctx, logger, infoHash) {
+			logger.WithField("infoHash", infoHash).Debug("Availability cached as unavailable")
 		} else {
 			requestRequired = true
+			requested = append(requested, infoHash)
 			url += "/" + infoHash
 		}
 	}
@@ -153,28 +175,123 @@ func (c Client) CheckInstantAvailability(ctx context.Context, apiToken string, i
 					infoHash := key.String()
 					infoHash = strings.ToUpper(infoHash)
 					result = append(result, infoHash)
-					// Create cache entry
-					if availabilityGob, err := newCacheEntry(ctx); err != nil {
-						logger.WithError(err).Error("Couldn't encode availability cache entry")
-					} else {
-						c.availabilityCache.Set([]byte(infoHash), availabilityGob)
-					}
+					c.setAvailable(ctx, logger, infoHash)
 				}
 				return true
 			})
+			c.setUnavailable(ctx, logger, requested, result)
 		}
 	}
 	return result
 }
 
+// InvalidateAvailability removes the cached instant availability of the info_hashes, so that the next check asks RealDebrid again.
+// It's meant for info_hashes whose availability is known to have changed, for example because a user just added the torrent to RealDebrid.
+func (c Client) InvalidateAvailability(infoHashes ...string) {
+	for _, infoHash := range infoHashes {
+		infoHash = strings.ToUpper(infoHash)
+		c.availabilityCache.Del([]byte(infoHash))
+		c.availabilityCache.Del([]byte(unavailableCacheKey(infoHash)))
+	}
+}
+
+// setAvailable caches the info_hash as instantly available and removes a cache entry that marks it as unavailable.
+func (c Client) setAvailable(ctx context.Context, logger *log.Entry, infoHash string) {
+	c.availabilityCache.Del([]byte(unavailableCacheKey(infoHash)))
+	if availabilityGob, err := newCacheEntry(ctx); err != nil {
+		logger.WithError(err).Error("Couldn't encode availability cache entry")
+	} else {
+		c.availabilityCache.Set([]byte(infoHash), availabilityGob)
+	}
+}
+
+// setUnavailable removes the cache entry that marks the requested info_hashes that aren't in the available ones as available, because RealDebrid doesn't have them cached anymore.
+// If caching unavailable info_hashes is enabled, it caches them as unavailable.
+func (c Client) setUnavailable(ctx context.Context, logger *log.Entry, requested, available []string) {
+	availableSet := make(map[string]struct{}, len(available))
+	for _, infoHash := range available {
+		availableSet[infoHash] = struct{}{}
+	}
+	for _, infoHash := range requested {
+		if _, ok := availableSet[infoHash]; ok {
+			continue
+		}
+		c.availabilityCache.Del([]byte(infoHash))
+		if c.unavailableCacheAge <= 0 {
+			continue
+		}
+		if availabilityGob, err := newCacheEntry(ctx); err != nil {
+			logger.WithError(err).Error("Couldn't encode availability cache entry")
+		} else {
+			c.availabilityCache.Set([]byte(unavailableCacheKey(infoHash)), availabilityGob)
+		}
+	}
+}
+
+// cachedAsUnavailable returns true if the info_hash was cached as unavailable less than unavailableCacheAge ago.
+func (c Client) cachedAsUnavailable(ctx context.Context, logger *log.Entry, infoHash string) bool {
+	if c.unavailableCacheAge <= 0 {
+		return false
+	}
+	availabilityGob, ok := c.availabilityCache.HasGet(nil, []byte(unavailableCacheKey(infoHash)))
+	if !ok {
+		return false
+	}
+	created, err := fromCacheEntry(ctx, availabilityGob)
+	if err != nil {
+		logger.WithError(err).WithField("infoHash", infoHash).Error("Couldn't decode availability cache entry")
+		return false
+	}
+	return time.Since(created) < c.unavailableCacheAge
+}
+
+// unavailableCacheKey returns the availability cache key for an info_hash that's not instantly available.
+// Available info_hashes use the info_hash itself as key, which is kept for compatibility with persisted caches.
+func unavailableCacheKey(infoHash string) string {
+	return infoHash + "-unavailable"
+}
+
 func (c Client) GetStreamURL(ctx context.Context, magnetURL, apiToken string, remote bool) (string, error) {
 	logger := log.WithContext(ctx).WithField("apiToken", apiToken)
+	rdTorrentURL, torrentID, fileResults, err := c.addTorrent(ctx, logger, magnetURL, apiToken)
+	if err != nil {
+		return "", err
+	}
+	// TODO: Not required if we pass the instant available file ID from the availability check, but probably no huge performance implication
+	fileID, err := selectFileID(ctx, fileResults)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't find proper file in torrent: %v", err)
+	}
+	logger.Debug("Torrent info OK")
+
+	return c.downloadFile(ctx, logger, rdTorrentURL, torrentID, fileID, apiToken, remote)
+}
+
+// GetEpisodeStreamURL is like GetStreamURL, but for a torrent of a season pack, where the file of the given episode is selected instead of the biggest file.
+// The episode file is detected by its name, like "S01E02", "1x02" or, inside a season's directory, a leading episode number like "02 - Title.mkv".
+func (c Client) GetEpisodeStreamURL(ctx context.Context, infoHash string, season, episode int, apiToken string, remote bool) (string, error) {
+	logger := log.WithContext(ctx).WithFields(log.Fields{"apiToken": apiToken, "season": season, "episode": episode})
+	rdTorrentURL, torrentID, fileResults, err := c.addTorrent(ctx, logger, "magnet:?xt=urn:btih:"+infoHash, apiToken)
+	if err != nil {
+		return "", err
+	}
+	fileID, err := selectEpisodeFileID(ctx, fileResults, season, episode)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't find episode file in torrent: %v", err)
+	}
+	logger.WithField("fileID", fileID).Debug("Torrent info OK")
+
+	return c.downloadFile(ctx, logger, rdTorrentURL, torrentID, fileID, apiToken, remote)
+}
+
+// addTorrent adds the torrent to RealDebrid and returns the URL of its torrent info, its ID and files.
+func (c Client) addTorrent(ctx context.Context, logger *log.Entry, magnetURL, apiToken string) (string, string, []gjson.Result, error) {
 	logger.Debug("Adding torrent to RealDebrid...")
 	data := url.Values{}
 	data.Set("magnet", magnetURL)
 	resBytes, err := c.post(ctx, c.rdBaseURL+"/rest/1.0/torrents/addMagnet", apiToken, data)
 	if err != nil {
-		return "", fmt.Errorf("Couldn't add torrent to RealDebrid: %v", err)
+		return "", "", nil, fmt.Errorf("Couldn't add torrent to RealDebrid: %v", err)
 	}
 	logger.Debug("Finished adding torrent to RealDebrid")
 	rdTorrentURL := gjson.GetBytes(resBytes, "uri").String()
@@ -185,33 +302,31 @@ func (c Client) GetStreamURL(ctx context.Context, magnetURL, apiToken string, re
 	// Use configured base URL, which could be a proxy that we want to go through
 	rdTorrentURL, err = replaceURL(rdTorrentURL, c.rdBaseURL)
 	if err != nil {
-		return "", fmt.Errorf("Couldn't replace URL which was retrieved from an HTML link: %v", err)
+		return "", "", nil, fmt.Errorf("Couldn't replace URL which was retrieved from an HTML link: %v", err)
 	}
 	resBytes, err = c.get(ctx, rdTorrentURL, apiToken)
 	if err != nil {
-		return "", fmt.Errorf("Couldn't get torrent info from real-debrid.com: %v", err)
+		return "", "", nil, fmt.Errorf("Couldn't get torrent info from real-debrid.com: %v", err)
 	}
 	torrentID := gjson.GetBytes(resBytes, "id").String()
 	if torrentID == "" {
-		return "", errors.New("Couldn't get torrent info from real-debrid.com: response body doesn't contain \"id\" key")
+		return "", "", nil, errors.New("Couldn't get torrent info from real-debrid.com: response body doesn't contain \"id\" key")
 	}
 	fileResults := gjson.GetBytes(resBytes, "files").Array()
 	if len(fileResults) == 0 || (len(fileResults) == 1 && fileResults[0].Raw == "") {
-		return "", errors.New("Couldn't get torrent info from real-debrid.com: response body doesn't contain \"files\" key")
-	}
-	// TODO: Not required if we pass the instant available file ID from the availability check, but probably no huge performance implication
-	fileID, err := selectFileID(ctx, fileResults)
-	if err != nil {
-		return "", fmt.Errorf("Couldn't find proper file in torrent: %v", err)
+		return "", "", nil, errors.New("Couldn't get torrent info from real-debrid.com: response body doesn't contain \"files\" key")
 	}
-	logger.Debug("Torrent info OK")
+	return rdTorrentURL, torrentID, fileResults, nil
+}
 
+// downloadFile selects the file of the torrent that was added to RealDebrid, waits for RealDebrid to download it and returns the unrestricted link.
+func (c Client) downloadFile(ctx context.Context, logger *log.Entry, rdTorrentURL, torrentID, fileID, apiToken string, remote bool) (string, error) {
 	// Add torrent to RealDebrid downloads
 
 	logger.Debug("Adding torrent to RealDebrid downloads...")
-	data = url.Values{}
+	data := url.Values{}
 	data.Set("files", fileID)
-	_, err = c.post(ctx, c.rdBaseURL+"/rest/1.0/torrents/selectFiles/"+torrentID, apiToken, data)
+	_, err := c.post(ctx, c.rdBaseURL+"/rest/1.0/torrents/selectFiles/"+torrentID, apiToken, data)
 	if err != nil {
 		return "", fmt.Errorf("Couldn't add torrent to RealDebrid downloads: %v", err)
 	}
@@ -223,6 +338,7 @@ func (c Client) GetStreamURL(ctx context.Context, magnetURL, apiToken string, re
 	torrentStatus := ""
 	waitForDownloadSeconds := 5
 	waitedForDownloadSeconds := 0
+	var resBytes []byte
 	for torrentStatus != "downloaded" {
 		resBytes, err = c.get(ctx, rdTorrentURL, apiToken)
 		if err != nil {
@@ -391,6 +507,73 @@ func selectFileID(ctx context.Context, fileResults []gjson.Result) (string, erro
 	return strconv.FormatInt(fileID, 10), nil
 }
 
+// episodeRegexes match the season and episode number in file names like "Show.S01E02.1080p.mkv" or "Show 1x02.mkv".
+var episodeRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)s([0-9]{1,2})[ ._-]?e([0-9]{1,3})`),
+	regexp.MustCompile(`(?i)\b([0-9]{1,2})x([0-9]{1,3})\b`),
+}
+
+// seasonDirRegex matches a season's directory like "Season 1" or "S01" in a file path, in which files are sometimes only numbered by episode.
+var seasonDirRegex = regexp.MustCompile(`(?i)(?:season[ ._-]?|/s)([0-9]{1,2})/`)
+
+// leadingNumberRegex matches a leading episode number of a file name like "02 - Title.mkv" or "E02.mkv".
+var leadingNumberRegex = regexp.MustCompile(`(?i)^e?([0-9]{1,3})\b`)
+
+// selectEpisodeFileID returns the ID of the file of the given episode.
+// Only video files are considered. If multiple files match, the biggest one is selected, so that samples are skipped.
+func selectEpisodeFileID(ctx context.Context, fileResults []gjson.Result, season, episode int) (string, error) {
+	// Precondition check
+	if len(fileResults) == 0 {
+		return "", fmt.Errorf("Empty slice of files")
+	}
+
+	var fileID int64 // ID inside JSON starts with 1
+	var size int64
+	for _, res := range fileResults {
+		filePath := res.Get("path").String()
+		switch strings.ToLower(path.Ext(filePath)) {
+		case ".mkv", ".mp4", ".avi":
+		default:
+			continue
+		}
+		if !isEpisodeFile(filePath, season, episode) {
+			continue
+		}
+		if res.Get("bytes").Int() > size {
+			size = res.Get("bytes").Int()
+			fileID = res.Get("id").Int()
+		}
+	}
+
+	if fileID == 0 {
+		return "", fmt.Errorf("No file found for S%02dE%02d", season, episode)
+	}
+
+	return strconv.FormatInt(fileID, 10), nil
+}
+
+// isEpisodeFile returns true if the file path belongs to the given episode.
+func isEpisodeFile(filePath string, season, episode int) bool {
+	fileName := path.Base(filePath)
+	for _, episodeRegex := range episodeRegexes {
+		if match := episodeRegex.FindStringSubmatch(fileName); match != nil {
+			return atoi(match[1]) == season && atoi(match[2]) == episode
+		}
+	}
+	// Files that are only numbered by episode, in a directory of the season
+	if match := seasonDirRegex.FindStringSubmatch(filePath); match == nil || atoi(match[1]) != season {
+		return false
+	}
+	match := leadingNumberRegex.FindStringSubmatch(fileName)
+	return match != nil && atoi(match[1]) == episode
+}
+
+// atoi is strconv.Atoi for strings that are known to be numbers, like regex matches of digits.
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
 func replaceURL(origURL, newBaseURL string) (string, error) {
 	// Replace by configured URL, which could be a proxy that we want to go through
 	url, err := url.Parse(origURL)
@@ -0,0 +1,122 @@
+package realdebrid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+)
+
+const testInfoHash = "0123456789ABCDEF0123456789ABCDEF01234567"
+
+// availabilityServer is a stub of RealDebrid's instant availability endpoint, which reports the test info_hash as available or unavailable.
+type availabilityServer struct {
+	*httptest.Server
+	lock      *sync.Mutex
+	available bool
+	requests  int
+}
+
+func newAvailabilityServer(t *testing.T) *availabilityServer {
+	s := &availabilityServer{lock: &sync.Mutex{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/rest/1.0/torrents/instantAvailability/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests++
+		// RealDebrid responds with lower case info_hashes and an empty array for the ones it doesn't have cached
+		infoHash := strings.ToLower(testInfoHash)
+		if s.available {
+			w.Write([]byte(`{"` + infoHash + `": {"rd": [{"1": {"filename": "Big.Buck.Bunny.2008.1080p.mkv", "filesize": 1024}}]}}`))
+		} else {
+			w.Write([]byte(`{"` + infoHash + `": []}`))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *availabilityServer) setAvailable(available bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.available = available
+}
+
+func (s *availabilityServer) requestCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func TestCheckInstantAvailabilityTransitions(t *testing.T) {
+	server := newAvailabilityServer(t)
+	availabilityCache := fastcache.New(32 * 1024 * 1024)
+	// Available info_hashes expire immediately, so that each check asks RealDebrid again unless the info_hash is cached as unavailable
+	client, err := NewClient(context.Background(), time.Second, fastcache.New(32*1024*1024), availabilityCache, time.Nanosecond, time.Hour, server.URL, nil)
+	if err != nil {
+		t.Fatalf("Couldn't create client: %v", err)
+	}
+
+	steps := []struct {
+		name             string
+		available        bool
+		invalidate       bool
+		expectAvailable  bool
+		expectedRequests int
+	}{
+		{"available", true, false, true, 1},
+		{"unavailable", false, false, false, 2},
+		// Cached as unavailable, so RealDebrid isn't asked even though it has the torrent now
+		{"cached as unavailable", true, false, false, 2},
+		{"invalidated", true, true, true, 3},
+	}
+	for _, step := range steps {
+		server.setAvailable(step.available)
+		if step.invalidate {
+			client.InvalidateAvailability(testInfoHash)
+		}
+		result := client.CheckInstantAvailability(context.Background(), "token", testInfoHash)
+		if available := len(result) == 1 && result[0] == testInfoHash; available != step.expectAvailable {
+			t.Errorf("%v: Expected available=%v, got %v", step.name, step.expectAvailable, result)
+		}
+		if count := server.requestCount(); count != step.expectedRequests {
+			t.Errorf("%v: Expected %v requests in total, got %v", step.name, step.expectedRequests, count)
+		}
+		if cachedAvailable := availabilityCache.Has([]byte(testInfoHash)); cachedAvailable != step.expectAvailable {
+			t.Errorf("%v: Expected the available cache entry to exist: %v, got %v", step.name, step.expectAvailable, cachedAvailable)
+		}
+	}
+}
+
+func TestCheckInstantAvailabilityUnavailableNotCached(t *testing.T) {
+	server := newAvailabilityServer(t)
+	availabilityCache := fastcache.New(32 * 1024 * 1024)
+	client, err := NewClient(context.Background(), time.Second, fastcache.New(32*1024*1024), availabilityCache, time.Nanosecond, 0, server.URL, nil)
+	if err != nil {
+		t.Fatalf("Couldn't create client: %v", err)
+	}
+
+	server.setAvailable(true)
+	if result := client.CheckInstantAvailability(context.Background(), "token", testInfoHash); len(result) != 1 {
+		t.Fatalf("Expected the info_hash to be available, got %v", result)
+	}
+	server.setAvailable(false)
+	if result := client.CheckInstantAvailability(context.Background(), "token", testInfoHash); len(result) != 0 {
+		t.Errorf("Expected the info_hash to be unavailable, got %v", result)
+	}
+	// The stale available entry must be removed even when unavailable info_hashes aren't cached
+	if availabilityCache.Has([]byte(testInfoHash)) {
+		t.Error("Expected the available cache entry to be removed")
+	}
+	if availabilityCache.Has([]byte(unavailableCacheKey(testInfoHash))) {
+		t.Error("Expected no unavailable cache entry with an unavailableCacheAge of 0")
+	}
+}